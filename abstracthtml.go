@@ -0,0 +1,171 @@
+package main
+
+import (
+	"html"         // Package for HTML escaping
+	"regexp"       // Package for regular expressions
+	"strings"      // Package for string manipulation
+	"unicode/utf8" // Package for UTF-8 decoding
+)
+
+// Markers the cleaner leaves in place of formatting when producing HTML.
+// They are private-use runes, removed from the input first, so no wikitext
+// can forge one; everything between them is escaped on output.
+const (
+	markBold    = '\uE000' // Toggles <b>
+	markItalic  = '\uE001' // Toggles <i>
+	markSub     = '\uE002' // Toggles <sub>
+	markSup     = '\uE003' // Toggles <sup>
+	markLink    = '\uE004' // Starts a link: markLink href markLabel label markLinkEnd
+	markLabel   = '\uE005' // Ends a link's href
+	markLinkEnd = '\uE006' // Ends a link
+)
+
+// markRe matches any marker, for stripping them from input and plain text
+var markRe = regexp.MustCompile("[\uE000-\uE006]")
+
+// entityMarkRe matches character references that would decode to a marker
+var entityMarkRe = regexp.MustCompile(`&#(?:[xX]0*[eE]00[0-6]|0*(?:5734[4-9]|57350));?`)
+
+// inlineTagRe matches the raw HTML formatting tags kept in HTML abstracts;
+// their attributes are dropped, as is every other tag
+var inlineTagRe = regexp.MustCompile(`(?i)<\s*/?\s*(b|strong|i|em|sub|sup)\b[^<>]*>`)
+
+// inlineTagMarks maps an allowed tag to its marker
+var inlineTagMarks = map[string]string{
+	"b": string(markBold), "strong": string(markBold),
+	"i": string(markItalic), "em": string(markItalic),
+	"sub": string(markSub), "sup": string(markSup),
+}
+
+// markTags turns wiki quotes and allowed HTML tags into markers
+func markTags(text string) string {
+	text = quotesRe.ReplaceAllStringFunc(text, func(q string) string {
+		switch len(q) {
+		case 2:
+			return string(markItalic)
+		case 3:
+			return string(markBold)
+		case 4:
+			return "'" + string(markBold) // An apostrophe before bold text
+		}
+		return strings.Repeat("'", len(q)-5) + string(markBold) + string(markItalic)
+	})
+	return inlineTagRe.ReplaceAllStringFunc(text, func(tag string) string {
+		return inlineTagMarks[strings.ToLower(inlineTagRe.FindStringSubmatch(tag)[1])]
+	})
+}
+
+// markLinkText wraps a rendered link label with its href
+func markLinkText(href, label string) string {
+	if label == "" {
+		return ""
+	}
+	return string(markLink) + markRe.ReplaceAllString(href, "") + string(markLabel) + label + string(markLinkEnd)
+}
+
+// htmlAbstract returns the first paragraph of the lead as safe HTML: bold,
+// italics, sub/superscripts and links survive, everything else is escaped text
+func (c *cleaner) htmlAbstract(text, base string) string {
+	mc := &cleaner{maxDepth: c.maxDepth, markup: true, clock: c.clock, render: c.render, dropRefs: c.dropRefs}
+	for _, para := range paragraphRe.Split(mc.clean(leadSection(text)), -1) {
+		para = tidyPunctuation(collapseSpace(para))
+		if strings.TrimSpace(markRe.ReplaceAllString(para, "")) != "" {
+			return renderMarked(para, base, c.caseSensitive)
+		}
+	}
+	return ""
+}
+
+// renderMarked converts marked text to HTML. Elements are kept properly
+// nested: closing one that is not innermost closes and reopens those inside
+// it, and anything left open at the end is closed. Wiki link targets are
+// normalized by the case rule of the dump (see SiteInfo).
+func renderMarked(s, base string, caseSensitive bool) string {
+	type elem struct {
+		mark       rune   // Marker that opened it
+		open, shut string // Tags
+	}
+	var b strings.Builder
+	var stack []elem
+	closeFrom := func(i int) []elem {
+		if i >= len(stack) {
+			return nil
+		}
+		above := append([]elem(nil), stack[i+1:]...)
+		for j := len(stack) - 1; j >= i; j-- {
+			b.WriteString(stack[j].shut)
+		}
+		stack = stack[:i]
+		return above
+	}
+	reopen := func(els []elem) {
+		for _, e := range els {
+			b.WriteString(e.open)
+			stack = append(stack, e)
+		}
+	}
+	find := func(mark rune) int {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].mark == mark {
+				return i
+			}
+		}
+		return -1
+	}
+	tags := map[rune]string{markBold: "b", markItalic: "i", markSub: "sub", markSup: "sup"}
+
+	for len(s) > 0 {
+		i := strings.IndexFunc(s, func(r rune) bool { return r >= markBold && r <= markLinkEnd })
+		if i < 0 {
+			b.WriteString(html.EscapeString(s))
+			break
+		}
+		b.WriteString(html.EscapeString(s[:i]))
+		mark, size := utf8.DecodeRuneInString(s[i:])
+		s = s[i+size:]
+		switch mark {
+		case markBold, markItalic, markSub, markSup:
+			if at := find(mark); at >= 0 {
+				reopen(closeFrom(at)) // Toggled off
+				continue
+			}
+			tag := tags[mark]
+			e := elem{mark, "<" + tag + ">", "</" + tag + ">"}
+			b.WriteString(e.open)
+			stack = append(stack, e)
+		case markLink:
+			href, rest, ok := strings.Cut(s, string(markLabel))
+			if !ok {
+				continue // A link without a label renders as its text
+			}
+			s = rest
+			if at := find(markLink); at >= 0 {
+				reopen(closeFrom(at)) // Links do not nest
+			}
+			e := elem{markLink, `<a href="` + html.EscapeString(linkHref(href, base, caseSensitive)) + `">`, "</a>"}
+			b.WriteString(e.open)
+			stack = append(stack, e)
+		case markLinkEnd:
+			if at := find(markLink); at >= 0 {
+				reopen(closeFrom(at))
+			}
+		}
+	}
+	closeFrom(0)
+	return emptyElems.Replace(b.String())
+}
+
+// emptyElems removes the empty elements reopening can leave, as after bold italics closed by five quotes
+var emptyElems = strings.NewReplacer("<b></b>", "", "<i></i>", "", "<sub></sub>", "", "<sup></sup>", "")
+
+// linkHref resolves a marked link target: external links were already
+// filtered to http(s) URLs by replaceExternalLinks, anything else is a wiki page
+func linkHref(target, base string, caseSensitive bool) string {
+	switch {
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return target
+	case strings.HasPrefix(target, "//"):
+		return "https:" + target
+	}
+	return pageURL(base, normalizeTitle(target, caseSensitive))
+}
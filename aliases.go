@@ -0,0 +1,104 @@
+package main
+
+import (
+	"slices"  // Package for matching the title's forms
+	"strings" // Package for string manipulation
+)
+
+// extractAliases returns the terms bolded in the first paragraph of the
+// lead, Bar and Baz in
+//
+//	'''Foo''', also known as '''Bar''' or '''Baz''', is ...
+//
+// cleaned to plain text, trimmed and without repeats. The title itself is left
+// out whatever its case, also without its namespace prefix or its
+// disambiguation as in "Mercury (planet)" or "Springfield, Illinois";
+// bold inside templates, references and comments does not count.
+func (c *cleaner) extractAliases(p *page) []string {
+	title := p.Title
+	if _, rest, ok := strings.Cut(title, ":"); ok && p.NS != 0 {
+		title = rest
+	}
+	names := []string{title}
+	for _, sep := range []string{" (", ", "} {
+		if base, _, ok := strings.Cut(title, sep); ok {
+			names = append(names, base)
+		}
+	}
+	isTitle := func(s string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(s, name) })
+	}
+
+	lead := commentRe.ReplaceAllString(leadSection(p.Revision.Text), "")
+	lead = nowikiRe.ReplaceAllString(lead, "")
+	lead = stripTables(stripTemplates(stripRefs(lead)))
+	first := ""
+	for _, para := range paragraphRe.Split(lead, -1) {
+		if strings.TrimSpace(c.clean(para)) != "" {
+			first = para
+			break
+		}
+	}
+	var aliases []string
+	seen := map[string]bool{}
+	for _, run := range boldRuns(first) {
+		alias := strings.Trim(tidyPunctuation(collapseSpace(c.clean(run))), " ,;:")
+		if alias == "" || seen[alias] || isTitle(alias) {
+			continue
+		}
+		seen[alias] = true
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// boldRuns returns the wikitext between the bold markers of s, following
+// MediaWiki on runs of apostrophes:
+//
+//	'       an apostrophe
+//	''      italics, ignored
+//	'''     bold
+//	''''    an apostrophe, then bold
+//	'''''   bold and italics together
+//
+// Bold left open ends with the line, and that run is dropped as it was
+// probably not meant as a name.
+func boldRuns(s string) []string {
+	var runs []string
+	var run strings.Builder
+	bold := false
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\n':
+			bold = false
+			run.Reset()
+			i++
+		case s[i] == '\'':
+			n := 1
+			for i+n < len(s) && s[i+n] == '\'' {
+				n++
+			}
+			i += n
+			if n < 3 {
+				if n == 1 && bold {
+					run.WriteByte('\'')
+				}
+				continue
+			}
+			if n == 4 && bold {
+				run.WriteByte('\'')
+			}
+			if bold {
+				runs = append(runs, run.String())
+				run.Reset()
+			}
+			bold = !bold
+		default:
+			if bold {
+				run.WriteByte(s[i])
+			}
+			i++
+		}
+	}
+	return runs
+}
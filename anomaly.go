@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"os"      // Package for OS functions (standard error)
+	"sort"    // Package for ordering the summary
+	"strings" // Package for string manipulation
+)
+
+// exitAnomaly is the exit status of a -fail-on-anomaly run that found dump anomalies
+const exitAnomaly = 5
+
+// anomalyLogLimit is how many anomalies of one kind are logged; the rest are only counted
+const anomalyLogLimit = 20
+
+// Anomaly kinds, as counted in the summary and the stats file
+const (
+	anomalyOutOfOrder  = "out-of-order id"
+	anomalyDuplicateID = "duplicate id"
+	anomalyNoTitle     = "missing title"
+	anomalyNoRevision  = "missing revision"
+	anomalyAfterRoot   = "page after </mediawiki>"
+	anomalyNoRootEnd   = "missing </mediawiki>"
+	anomalyLenient     = "lenient decode"
+	anomalyLongTitle   = "over-long title"
+)
+
+// anomalies watches the page stream for signs of a broken or partial dump:
+// page IDs going backwards or repeating, pages without a <title> or
+// <revision>, titles longer than MediaWiki allows, a <mediawiki> element that ends before the pages do or not at
+// all, and pages only the lenient decoder could read. It costs a comparison
+// per page plus a bit per page ID, kept in chunks so that sparse IDs stay cheap.
+type anomalies struct {
+	counts     map[string]int     // Anomalies per kind
+	maxID      int64              // Highest page ID so far
+	maxTitle   string             // Title of that page
+	ids        map[int64][]uint64 // Page IDs seen, as bitmaps of idChunk IDs
+	rootClosed bool               // </mediawiki> was read
+}
+
+// idChunk is the number of page IDs one bitmap of anomalies.ids covers
+const idChunk = 1 << 16
+
+// add counts one anomaly of kind, logging it with its context while there are few of that kind
+func (a *anomalies) add(kind, context string) {
+	if a.counts == nil {
+		a.counts = map[string]int{}
+	}
+	if a.counts[kind]++; a.counts[kind] <= anomalyLogLimit {
+		fmt.Fprintf(os.Stderr, "anomaly: %s: %s\n", kind, context)
+		if a.counts[kind] == anomalyLogLimit {
+			fmt.Fprintf(os.Stderr, "anomaly: %s: %d logged, the rest are only counted\n", kind, anomalyLogLimit)
+		}
+	}
+}
+
+// page checks a decoded page at offset off of the decompressed stream;
+// whole is false for a page skipped after its <id>, whose revision was not read
+func (a *anomalies) page(p *page, off int64, whole bool) {
+	where := fmt.Sprintf("page ID %d %q at offset %d", p.ID, forDisplay(p.Title), off)
+	if a.rootClosed {
+		a.add(anomalyAfterRoot, where)
+	}
+	if strings.TrimSpace(p.Title) == "" {
+		a.add(anomalyNoTitle, fmt.Sprintf("page ID %d at offset %d", p.ID, off))
+	}
+	if whole && !p.hasRevision {
+		a.add(anomalyNoRevision, where)
+	}
+	if len(p.Title) > maxTitleBytes {
+		a.add(anomalyLongTitle, fmt.Sprintf("%s has %d bytes, above MediaWiki's %d", where, len(p.Title), maxTitleBytes))
+	}
+	if p.ID <= 0 {
+		return
+	}
+	chunk, bit := p.ID/idChunk, p.ID%idChunk
+	if a.ids == nil {
+		a.ids = map[int64][]uint64{}
+	}
+	bits := a.ids[chunk]
+	if bits == nil {
+		bits = make([]uint64, idChunk/64)
+		a.ids[chunk] = bits
+	}
+	switch {
+	case bits[bit/64]&(1<<(bit%64)) != 0:
+		a.add(anomalyDuplicateID, where+" repeats an earlier page ID")
+	case p.ID < a.maxID:
+		a.add(anomalyOutOfOrder, fmt.Sprintf("%s comes after page ID %d %q", where, a.maxID, forDisplay(a.maxTitle)))
+	}
+	bits[bit/64] |= 1 << (bit % 64)
+	if p.ID > a.maxID {
+		a.maxID, a.maxTitle = p.ID, p.Title
+	}
+}
+
+// total is the number of anomalies found
+func (a *anomalies) total() int {
+	n := 0
+	for _, c := range a.counts {
+		n += c
+	}
+	return n
+}
+
+// summary lists the counts per kind, most frequent first
+func (a *anomalies) summary() string {
+	kinds := make([]string, 0, len(a.counts))
+	for k := range a.counts {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if a.counts[kinds[i]] != a.counts[kinds[j]] {
+			return a.counts[kinds[i]] > a.counts[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", a.counts[k], k)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exitError is the error a finished run ends with: a cut-off stream first,
+// then, with -fail-on-anomaly, any anomaly
+func (st *stats) exitError(cfg *config) error {
+	if err := st.incomplete(); err != nil {
+		return err
+	}
+	if n := st.Anomalies.total(); n > 0 && cfg.FailOnAnomaly {
+		return &exitCodeError{
+			code: exitAnomaly,
+			err:  fmt.Errorf("%d dump anomalies found (-fail-on-anomaly): %s", n, st.Anomalies.summary()),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/xml" // Package for XML decoding errors
+	"errors"       // Package for error inspection
+	"fmt"          // Package for formatted I/O
+	"sort"         // Package for sorting slices
+	"strconv"      // Package for string conversions
+	"strings"      // Package for string manipulation
+)
+
+// exitErrorBudget is the exit status of a run aborted by its error budget
+const exitErrorBudget = 3
+
+// errorBudget decides when skipped, undecodable pages mean the dump itself is bad
+type errorBudget struct {
+	MaxErrors int     `json:"max_errors"`     // Abort once more pages than this fail (-1: no limit)
+	MaxRate   float64 `json:"max_error_rate"` // Abort once this fraction of pages fails (1: no limit)
+	MinSample int     `json:"min_sample"`     // Pages seen before the rate is checked
+}
+
+// defaultMinSample keeps one early failure from tripping a small -max-error-rate
+const defaultMinSample = 1000
+
+// exceeded reports whether the errors recorded in st are over budget
+func (b errorBudget) exceeded(st *stats) bool {
+	if b.MaxErrors >= 0 && st.DecodeErrors > b.MaxErrors {
+		return true
+	}
+	seen := st.Pages + st.DecodeErrors
+	return seen >= b.MinSample && st.errorRate() > b.MaxRate
+}
+
+// errorRate is the fraction of pages that failed to decode
+func (st *stats) errorRate() float64 {
+	seen := st.Pages + st.DecodeErrors
+	if seen == 0 {
+		return 0
+	}
+	return float64(st.DecodeErrors) / float64(seen)
+}
+
+// recordError counts a skipped page under its error kind
+func (st *stats) recordError(err error) {
+	st.DecodeErrors++
+	if st.ErrorKinds == nil {
+		st.ErrorKinds = map[string]int{}
+	}
+	st.ErrorKinds[errorKind(err)]++
+}
+
+// errorKind reduces an error to a label without page-specific values, so that
+// identical failures group together
+func errorKind(err error) string {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return numErr.Func + ": " + numErr.Err.Error()
+	}
+	var kindErr *pageError
+	if errors.As(err, &kindErr) {
+		return kindErr.kind
+	}
+	var xmlErr xml.UnmarshalError
+	if errors.As(err, &xmlErr) {
+		return string(xmlErr)
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// pageError is a page that decoded but is unusable
+type pageError struct {
+	kind string // Error kind, e.g. "missing title"
+}
+
+func (e *pageError) Error() string { return e.kind }
+
+// topErrorKinds lists the n most common error kinds with their counts
+func (st *stats) topErrorKinds(n int) string {
+	kinds := make([]string, 0, len(st.ErrorKinds))
+	for kind := range st.ErrorKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(a, b int) bool {
+		if st.ErrorKinds[kinds[a]] != st.ErrorKinds[kinds[b]] {
+			return st.ErrorKinds[kinds[a]] > st.ErrorKinds[kinds[b]]
+		}
+		return kinds[a] < kinds[b]
+	})
+	var parts []string
+	for _, kind := range kinds[:min(n, len(kinds))] {
+		parts = append(parts, fmt.Sprintf("%s ×%d", kind, st.ErrorKinds[kind]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// errBudgetExceeded builds the error that aborts an over-budget run
+func errBudgetExceeded(st *stats) error {
+	return &exitCodeError{
+		code: exitErrorBudget,
+		err: fmt.Errorf("error budget exceeded: %d of %d pages failed to decode (%.2f%%): %s",
+			st.DecodeErrors, st.Pages+st.DecodeErrors, 100*st.errorRate(), st.topErrorKinds(3)),
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import "time" // Package for cleanup timing (-top-n)
+
+// docBuilder turns a page that passed the filters into its doc. It reads
+// nothing but the page and the cleaner it is given, so the -workers pool runs
+// it concurrently and serve runs it on live wikitext; what it finds is
+// counted by the caller.
+type docBuilder struct {
+	cfg     *config        // Extraction settings
+	base    string         // Page URL base of the language
+	bounds  [4]int         // -classify length class bounds of the language
+	timed   bool           // Measure the cleanup time (-top-n)
+	infobox string         // -extract-infobox, normalized as a template name
+	cache   *abstractCache // Results of earlier runs (-cache); nil without
+}
+
+// newDocBuilder returns the builder of cfg's pages in lang
+func newDocBuilder(cfg *config, lang string, timed bool) *docBuilder {
+	bounds, ok := cfg.LengthBounds[lang]
+	if !ok {
+		bounds = cfg.LengthBounds["default"]
+	}
+	return &docBuilder{cfg: cfg, base: cfg.Project.base(lang), bounds: bounds, timed: timed, infobox: templateName(cfg.ExtractInfobox)}
+}
+
+// build returns the doc of p: from -cache when the revision is there, or
+// else cleaned anew and stored for the next run
+func (b *docBuilder) build(c *cleaner, p *page) *pageResult {
+	if b.cache == nil || p.Revision.ID <= 0 {
+		return b.clean(c, p)
+	}
+	if cr, ok := b.cache.get(p.Revision.ID); ok {
+		return b.fromCache(p, cr)
+	}
+	r := b.clean(c, p)
+	if !r.timedOut { // A timed-out result depends on the machine's speed, not the page
+		doc := r.doc
+		doc.Offset, doc.StreamOffset, doc.StreamIndex, doc.SourceFile, doc.SourceOffset = nil, nil, nil, "", nil
+		b.cache.put(p.Revision.ID, &cachedResult{Doc: doc, Empty: r.empty, LowScore: r.lowScore, Target: r.target})
+	}
+	return r
+}
+
+// fromCache rebuilds the result of p from a cached one, filling in what
+// depends on where the page is and not on its revision
+func (b *docBuilder) fromCache(p *page, cr *cachedResult) *pageResult {
+	cfg := b.cfg
+	r := &pageResult{p: p, doc: cr.Doc, empty: cr.Empty, lowScore: cr.LowScore, target: cr.Target}
+	if r.empty || r.lowScore {
+		return r
+	}
+	doc := &r.doc
+	doc.ID, doc.Title, doc.URL = p.ID, p.Title, pageURL(b.base, p.Title)
+	if cfg.WithOffset {
+		doc.Offset = &p.Offset
+		if cfg.Streams != nil {
+			doc.StreamOffset = &p.StreamOffset
+		}
+	}
+	if cfg.WithStreamIndex {
+		doc.StreamIndex = &p.StreamIndex
+	}
+	if cfg.WithProvenance && p.Source != nil {
+		doc.SourceFile, doc.SourceOffset = p.Source.File, &p.SourceOffset
+	}
+	if cfg.ValidateURLs {
+		r.badURL = checkPageURL(doc.URL)
+	}
+	return r
+}
+
+// clean cleans p with c and builds its doc, with the optional extractions
+func (b *docBuilder) clean(c *cleaner, p *page) *pageResult {
+	cfg, r := b.cfg, &pageResult{p: p}
+
+	// 1. Extract the abstract, either naively or with markup removed
+	var started time.Time
+	if b.timed {
+		started = cfg.NowFunc()
+	}
+	lead := p.Revision.Text
+	if cfg.Project.lead != nil {
+		lead = cfg.Project.lead(lead)
+	}
+	abstract := naiveAbstract(lead, cfg.Paragraphs)
+	if cfg.LegacyAbstracts {
+		abstract = naiveAbstract(p.Revision.Text, 1) // The whole text, whatever the project's lead
+	} else if cfg.AbstractMode == "exintro" {
+		abstract = c.exintroAbstract(lead)
+	} else if cfg.Plain {
+		abstract = c.plainAbstract(lead)
+	}
+	if len(abstract) == 0 && !c.expired() {
+		r.empty = true
+		return r // Skip pages with empty abstracts
+	}
+
+	// 2. Build the doc, adding the optional extractions
+	r.doc = Doc{
+		ID:       p.ID,
+		Title:    p.Title,
+		URL:      pageURL(b.base, p.Title),
+		Abstract: abstract,
+	}
+	doc := &r.doc
+	if cfg.WithOffset {
+		doc.Offset = &p.Offset
+		if cfg.Streams != nil {
+			doc.StreamOffset = &p.StreamOffset
+		}
+	}
+	if cfg.WithStreamIndex {
+		doc.StreamIndex = &p.StreamIndex
+	}
+	if cfg.AbstractHTML {
+		doc.AbstractHTML = c.htmlAbstract(lead, b.base)
+	}
+	if cfg.WithProvenance && p.Source != nil { // serve's live pages have no input file
+		doc.SourceFile, doc.SourceOffset = p.Source.File, &p.SourceOffset
+	}
+	if cfg.ValidateURLs {
+		r.badURL = checkPageURL(doc.URL)
+	}
+	if cfg.Wikidata != "" {
+		r.target = redirectTarget(p)
+	}
+	if cfg.ExtractIPA {
+		doc.IPA = extractIPA(c.templates(leadSection(p.Revision.Text)))
+	}
+	if cfg.ExtractAliases {
+		doc.Aliases = c.extractAliases(p)
+	}
+	if cfg.ExtractDates {
+		doc.BirthDate, doc.DeathDate = extractDates(c.templates(p.Revision.Text))
+	}
+	if cfg.ExtractInfobox != "" {
+		doc.Infobox = c.extractInfobox(c.templates(p.Revision.Text), b.infobox, cfg.Plain)
+	}
+	if cfg.EnrichSummary {
+		doc.ShortDescription = shortDescription(c.templates(p.Revision.Text))
+	}
+	if cfg.ExtractRefs {
+		doc.References = c.extractRefs(leadSection(p.Revision.Text))
+	}
+	if cfg.Score || cfg.MinScore > 0 {
+		score := scorePage(c, p, c.templates(p.Revision.Text))
+		if score < cfg.MinScore && !c.expired() {
+			r.lowScore = true
+			return r
+		}
+		if cfg.Score {
+			doc.Score = &score
+		}
+	}
+
+	if cfg.Classify {
+		ts := measureText(c.articleText(p.Revision.Text))
+		grade := readability(ts)
+		doc.LengthClass, doc.Readability = lengthClass(ts.Words, b.bounds), &grade
+	}
+
+	// 3. Fall back to the naive abstract when cleanup ran out of time
+	// and left nothing usable
+	if c.expired() {
+		r.timedOut = true
+		r.doc = Doc{ID: p.ID, Title: p.Title, URL: doc.URL, Abstract: naiveAbstract(p.Revision.Text, cfg.Paragraphs)}
+		if r.doc.Abstract == "" {
+			r.empty = true
+			return r
+		}
+	}
+	if len(cfg.ReplaceRules) > 0 {
+		if doc.Abstract = applyReplaceRules(cfg.ReplaceRules, doc.Abstract); doc.Abstract == "" {
+			r.empty = true
+			return r
+		}
+	}
+	if cfg.SentencesArray {
+		doc.Sentences = splitSentences(doc.Abstract)
+	}
+	if cfg.Fingerprint {
+		doc.Fingerprint = fingerprint(doc.Abstract, cfg.ShingleWords)
+	}
+	if b.timed {
+		r.cleanup = cfg.NowFunc().Sub(started)
+	}
+	return r
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"           // Package for buffered cache writes
+	"crypto/sha256"   // Package for fingerprinting the executable
+	"encoding/binary" // Package for record and index headers
+	"encoding/json"   // Package for cached results
+	"fmt"             // Package for formatted I/O
+	"hash/fnv"        // Package for the pipeline hash
+	"io"              // Package for I/O primitives
+	"os"              // Package for OS functions (file access)
+	"path/filepath"   // Package for cache file paths
+	"sort"            // Package for eviction order
+	"sync"            // Package for sharing the cache between workers
+)
+
+// Files of a -cache directory
+const (
+	cacheDataFile  = "abstracts.data"  // Records, appended as pages are cleaned
+	cacheIndexFile = "abstracts.index" // Where each record is and when it was last used, rewritten on close
+)
+
+// cacheIndexMagic starts the index file; a change of layout changes it
+const cacheIndexMagic = "fswcidx1"
+
+// cacheRecordHeader is the size of a record's header: revision ID, pipeline hash, payload length
+const cacheRecordHeader = 8 + 8 + 4
+
+// cacheKey identifies a cached result. A revision ID fixes a page's title
+// and text; the pipeline hash stands for every setting the cleanup reads and
+// for the executable itself, so changing either misses instead of reusing
+// stale results.
+type cacheKey struct {
+	rev  int64  // Revision ID
+	conf uint64 // Pipeline hash (cachePipelineHash)
+}
+
+// cacheEntry locates one record in the data file
+type cacheEntry struct {
+	off  int64  // Offset of the payload
+	n    uint32 // Payload length
+	used uint32 // Last run that wrote or read it, for eviction
+}
+
+// cachedResult is what build found for a page, less what its position in
+// the dump gives: title, URL, offsets and URL validity are filled in again
+type cachedResult struct {
+	Doc      Doc    `json:"doc"`                 // The doc as build left it
+	Empty    bool   `json:"empty,omitempty"`     // The abstract came out empty
+	LowScore bool   `json:"low_score,omitempty"` // The page scored below -min-score
+	Target   string `json:"target,omitempty"`    // Redirect target, for -wikidata
+}
+
+// abstractCache is the -cache store: build results keyed by revision ID, so a
+// rerun over the same dump skips the cleanup of every page it has seen. The
+// data file only grows during a run; on close, a cache past -cache-max-mb is
+// compacted to the most recently used records, down to 90% of the cap.
+type abstractCache struct {
+	dir   string // Cache directory
+	conf  uint64 // Pipeline hash of this run
+	limit int64  // Size cap in bytes (0: none)
+
+	mu      sync.Mutex               // Guards the fields below; workers share the cache
+	f       *os.File                 // Data file
+	w       *bufio.Writer            // Appends to the data file
+	size    int64                    // Data file size, buffered bytes included
+	flushed int64                    // Data file size on disk
+	index   map[cacheKey]*cacheEntry // Every record
+	run     uint32                   // Number of this run
+	hits    int                      // Pages served from the cache
+	misses  int                      // Pages cleaned and stored
+	closed  bool                     // close has run
+}
+
+// openAbstractCache opens or creates the cache in cfg.Cache
+func openAbstractCache(cfg *config) (*abstractCache, error) {
+	conf, err := cachePipelineHash(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("-cache: %w", err)
+	}
+	if err := os.MkdirAll(cfg.Cache, 0o755); err != nil {
+		return nil, fmt.Errorf("-cache: %w", err)
+	}
+	ac := &abstractCache{dir: cfg.Cache, conf: conf, limit: cfg.CacheMaxMB << 20, index: map[cacheKey]*cacheEntry{}}
+	if ac.f, err = os.OpenFile(filepath.Join(ac.dir, cacheDataFile), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return nil, fmt.Errorf("-cache: %w", err)
+	}
+	fi, err := ac.f.Stat()
+	if err != nil {
+		ac.f.Close()
+		return nil, fmt.Errorf("-cache: %w", err)
+	}
+	ac.size = fi.Size()
+	if !ac.readIndex() {
+		// No index, or one from a run that did not close: read the records themselves
+		if err := ac.scan(); err != nil {
+			ac.f.Close()
+			return nil, fmt.Errorf("-cache: %w", err)
+		}
+	}
+	ac.run++
+	ac.flushed = ac.size
+	if _, err := ac.f.Seek(ac.size, io.SeekStart); err != nil {
+		ac.f.Close()
+		return nil, fmt.Errorf("-cache: %w", err)
+	}
+	ac.w = bufio.NewWriterSize(ac.f, 1<<16)
+	return ac, nil
+}
+
+// readIndex loads the index file, reporting whether it matches the data file
+func (ac *abstractCache) readIndex() bool {
+	data, err := os.ReadFile(filepath.Join(ac.dir, cacheIndexFile))
+	if err != nil || len(data) < 28 || string(data[:8]) != cacheIndexMagic {
+		return false
+	}
+	le := binary.LittleEndian
+	size, run, count := int64(le.Uint64(data[8:])), le.Uint32(data[16:]), le.Uint64(data[20:])
+	if size != ac.size || uint64(len(data)-28) != count*32 {
+		return false
+	}
+	for b := data[28:]; len(b) >= 32; b = b[32:] {
+		k := cacheKey{rev: int64(le.Uint64(b)), conf: le.Uint64(b[8:])}
+		ac.index[k] = &cacheEntry{off: int64(le.Uint64(b[16:])), n: le.Uint32(b[24:]), used: le.Uint32(b[28:])}
+	}
+	ac.run = run
+	return true
+}
+
+// scan rebuilds the index from the data file, cutting off a record a crash
+// left half written. The records it finds count as unused.
+func (ac *abstractCache) scan() error {
+	r := bufio.NewReaderSize(io.NewSectionReader(ac.f, 0, ac.size), 1<<16)
+	le := binary.LittleEndian
+	hdr := make([]byte, cacheRecordHeader)
+	var off int64
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			break
+		}
+		k := cacheKey{rev: int64(le.Uint64(hdr)), conf: le.Uint64(hdr[8:])}
+		n := le.Uint32(hdr[16:])
+		if _, err := r.Discard(int(n)); err != nil {
+			break
+		}
+		ac.index[k] = &cacheEntry{off: off + cacheRecordHeader, n: n}
+		off += cacheRecordHeader + int64(n)
+	}
+	if off == ac.size {
+		return nil
+	}
+	ac.size = off
+	return ac.f.Truncate(off)
+}
+
+// get returns the cached result of revision rev, if there is one
+func (ac *abstractCache) get(rev int64) (*cachedResult, bool) {
+	ac.mu.Lock()
+	e := ac.index[cacheKey{rev, ac.conf}]
+	if e == nil {
+		ac.mu.Unlock()
+		return nil, false
+	}
+	if e.off+int64(e.n) > ac.flushed {
+		// Written earlier in this run and still buffered
+		if err := ac.w.Flush(); err != nil {
+			ac.mu.Unlock()
+			return nil, false
+		}
+		ac.flushed = ac.size
+	}
+	e.used = ac.run
+	off, n := e.off, e.n
+	ac.mu.Unlock()
+
+	payload := make([]byte, n)
+	if _, err := ac.f.ReadAt(payload, off); err != nil {
+		return nil, false
+	}
+	var cr cachedResult
+	if err := json.Unmarshal(payload, &cr); err != nil {
+		return nil, false
+	}
+	ac.mu.Lock()
+	ac.hits++
+	ac.mu.Unlock()
+	return &cr, true
+}
+
+// put stores the result of revision rev; a failed write only costs the entry
+func (ac *abstractCache) put(rev int64, cr *cachedResult) {
+	payload, err := json.Marshal(cr)
+	if err != nil {
+		return
+	}
+	hdr := make([]byte, cacheRecordHeader)
+	le := binary.LittleEndian
+	le.PutUint64(hdr, uint64(rev))
+	le.PutUint64(hdr[8:], ac.conf)
+	le.PutUint32(hdr[16:], uint32(len(payload)))
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.misses++
+	if ac.closed {
+		return
+	}
+	if _, err := ac.w.Write(hdr); err != nil {
+		return
+	}
+	if _, err := ac.w.Write(payload); err != nil {
+		return
+	}
+	ac.index[cacheKey{rev, ac.conf}] = &cacheEntry{off: ac.size + cacheRecordHeader, n: uint32(len(payload)), used: ac.run}
+	ac.size += cacheRecordHeader + int64(len(payload))
+}
+
+// counts returns the hits and misses so far
+func (ac *abstractCache) counts() (hits, misses int) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.hits, ac.misses
+}
+
+// close flushes the data file, evicts past the size cap and writes the
+// index; later calls do nothing
+func (ac *abstractCache) close() error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.closed {
+		return nil
+	}
+	ac.closed = true
+	err := ac.w.Flush()
+	if err == nil && ac.limit > 0 && ac.size > ac.limit {
+		err = ac.compact()
+	}
+	if err == nil {
+		err = ac.writeIndex()
+	}
+	if cerr := ac.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("-cache: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the data file with the most recently used records that
+// fit in 90% of the cap, newest first within a run
+func (ac *abstractCache) compact() error {
+	keys := make([]cacheKey, 0, len(ac.index))
+	for k := range ac.index {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := ac.index[keys[i]], ac.index[keys[j]]
+		if a.used != b.used {
+			return a.used > b.used
+		}
+		return a.off > b.off
+	})
+	budget := ac.limit / 10 * 9
+	kept := keys[:0]
+	for _, k := range keys {
+		if budget -= cacheRecordHeader + int64(ac.index[k].n); budget < 0 {
+			break
+		}
+		kept = append(kept, k)
+	}
+	sort.Slice(kept, func(i, j int) bool { return ac.index[kept[i]].off < ac.index[kept[j]].off })
+
+	tmp, err := os.CreateTemp(ac.dir, cacheDataFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Gone already once renamed
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	w := bufio.NewWriterSize(tmp, 1<<16)
+	index := make(map[cacheKey]*cacheEntry, len(kept))
+	var off int64
+	for _, k := range kept {
+		e := ac.index[k]
+		if _, err := io.Copy(w, io.NewSectionReader(ac.f, e.off-cacheRecordHeader, cacheRecordHeader+int64(e.n))); err != nil {
+			tmp.Close()
+			return err
+		}
+		index[k] = &cacheEntry{off: off + cacheRecordHeader, n: e.n, used: e.used}
+		off += cacheRecordHeader + int64(e.n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(ac.dir, cacheDataFile)); err != nil {
+		tmp.Close()
+		return err
+	}
+	ac.f.Close()
+	ac.f, ac.index, ac.size = tmp, index, off
+	return nil
+}
+
+// writeIndex records the index and the data file size it describes
+func (ac *abstractCache) writeIndex() error {
+	le := binary.LittleEndian
+	data := make([]byte, 28, 28+32*len(ac.index))
+	copy(data, cacheIndexMagic)
+	le.PutUint64(data[8:], uint64(ac.size))
+	le.PutUint32(data[16:], ac.run)
+	le.PutUint64(data[20:], uint64(len(ac.index)))
+	var b [32]byte
+	for k, e := range ac.index {
+		le.PutUint64(b[:], uint64(k.rev))
+		le.PutUint64(b[8:], k.conf)
+		le.PutUint64(b[16:], uint64(e.off))
+		le.PutUint32(b[24:], e.n)
+		le.PutUint32(b[28:], e.used)
+		data = append(data, b[:]...)
+	}
+	name := filepath.Join(ac.dir, cacheIndexFile)
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// cachePipelineHash hashes the settings build and the cleaner read, and the
+// running executable, into the key of every cached result
+func cachePipelineHash(cfg *config) (uint64, error) {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "project=%s lang=%s plain=%t depth=%d render=%q collapse-refs=%t html=%t ipa=%t dates=%t short-desc=%t refs=%t score=%t min-score=%d classify=%t bounds=%v sentences=%t fingerprint=%t shingles=%d wikidata=%t\n",
+		cfg.Project.host, cfg.Lang, cfg.Plain, cfg.MaxDepth, []string(cfg.RenderTemplates), cfg.CollapseReferences,
+		cfg.AbstractHTML, cfg.ExtractIPA, cfg.ExtractDates, cfg.EnrichSummary, cfg.ExtractRefs,
+		cfg.Score, cfg.MinScore, cfg.Classify, cfg.LengthBounds, cfg.SentencesArray, cfg.Fingerprint, cfg.ShingleWords, cfg.Wikidata != "")
+	fmt.Fprintf(h, "paragraphs=%d preserve-paragraphs=%t infobox=%q\n", cfg.Paragraphs, cfg.PreserveParagraphs, cfg.ExtractInfobox)
+	fmt.Fprintf(h, "abstract-mode=%s exsentences=%d exchars=%d\n", cfg.AbstractMode, cfg.ExSentences, cfg.ExChars)
+	if cfg.ExtractAliases {
+		fmt.Fprintf(h, "aliases\n")
+	}
+	if cfg.LegacyAbstracts {
+		fmt.Fprintf(h, "legacy-abstracts\n")
+	}
+	for _, line := range cfg.RenderMapLines {
+		fmt.Fprintf(h, "templates-as-text=%q\n", line)
+	}
+	for _, r := range cfg.ReplaceRules {
+		fmt.Fprintf(h, "replace=%q\n", r.line)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the executable to version the cache: %w", err)
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return 0, err
+	}
+	h.Write(sum.Sum(nil))
+	return h.Sum64(), nil
+}
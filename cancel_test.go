@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"           // Package for the run's context
+	"errors"            // Package for checking the wrapped error
+	"fmt"               // Package for formatted I/O
+	"net/http"          // Package for the stalling servers
+	"net/http/httptest" // Package for serving the sample
+	"os"                // Package for OS functions (file access)
+	"path/filepath"     // Package for the output paths
+	"strings"           // Package for building the huge page
+	"sync/atomic"       // Package for counting docs across workers
+	"testing"           // Package for tests
+	"time"              // Package for the time bounds
+
+	"go.uber.org/goleak" // Package for checking that no goroutine outlives the run
+)
+
+// cancelBound is how long a run may take to return once its context is
+// cancelled; each case would otherwise run for many times longer
+const cancelBound = 2 * time.Second
+
+// runCancelled runs the extraction of args with a context that cancel ends,
+// and checks that the run returns context.Canceled, wrapped, within
+// cancelBound of the cancel. setup may adjust the config and cancel itself;
+// otherwise the context is cancelled after after.
+func runCancelled(t *testing.T, args []string, after time.Duration, setup func(cfg *config, cancel func())) {
+	t.Helper()
+	cfg, err := parseFlags(append(args, "-o", filepath.Join(t.TempDir(), "out.jsonl")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	var cancelled atomic.Int64 // UnixNano of the cancel
+	cancel := func() {
+		cancelled.CompareAndSwap(0, time.Now().UnixNano())
+		cancelCtx()
+	}
+	cfg.Ctx = ctx
+	if setup != nil {
+		setup(cfg, cancel)
+	}
+	if after > 0 {
+		timer := time.AfterFunc(after, cancel)
+		defer timer.Stop()
+	}
+	err = run(cfg)
+	returned := time.Now()
+	httpClient.CloseIdleConnections()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("the run returned %v, want context.Canceled", err)
+	}
+	if at := cancelled.Load(); at == 0 || returned.Sub(time.Unix(0, at)) > cancelBound {
+		t.Errorf("the run returned %v after the cancel, want within %v", returned.Sub(time.Unix(0, at)), cancelBound)
+	}
+}
+
+// stallingServer serves the first stream of the sample, its siteinfo and
+// first 100 pages, and then holds the body open until the client goes away
+func stallingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	sample, err := os.ReadFile(goldenSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(sample)))
+		w.Write(sample[:12091]) // Up to the second stream of pages (see the index)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+// TestCancelDuringDownload cancels a run waiting on a dump body that stalls
+// after its first stream
+func TestCancelDuringDownload(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := stallingServer(t)
+	defer srv.Close()
+	var docs atomic.Int64
+	runCancelled(t, []string{"-url", srv.URL + "/simplewiki-sample.xml.bz2", "-plain", "-format", "jsonl"}, 0,
+		func(cfg *config, cancel func()) {
+			cfg.Middleware = append(cfg.Middleware, func(*Doc) (bool, error) {
+				if docs.Add(1) == 90 { // The first stream is all but read, and the next one stalls
+					time.AfterFunc(100*time.Millisecond, cancel)
+				}
+				return true, nil
+			})
+		})
+}
+
+// TestCancelDuringDecode cancels a run from its page loop, serially and
+// with workers, so that the cancel lands between two pages
+func TestCancelDuringDecode(t *testing.T) {
+	for _, workers := range []string{"1", "4"} {
+		t.Run("workers="+workers, func(t *testing.T) {
+			defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+			var docs atomic.Int64
+			runCancelled(t, []string{"-input", goldenSample, "-plain", "-format", "jsonl", "-workers", workers}, 0,
+				func(cfg *config, cancel func()) {
+					cfg.Middleware = append(cfg.Middleware, func(*Doc) (bool, error) {
+						if docs.Add(1) == 3 {
+							cancel()
+						}
+						return true, nil
+					})
+				})
+			if n := docs.Load(); n >= 150 {
+				t.Errorf("%d docs reached the writer after the cancel at the third", n)
+			}
+		})
+	}
+}
+
+// hugePageDump writes a dump holding one page whose wikitext takes seconds
+// to clean, and returns its path
+func hugePageDump(t *testing.T) string {
+	t.Helper()
+	text := strings.Repeat("Word [[link|text]] and {{tmpl|a=b}} '''bold''' &lt;ref&gt;x&lt;/ref&gt; ", 400_000)
+	dump := `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11">
+  <page>
+    <title>Huge</title>
+    <ns>0</ns>
+    <id>1</id>
+    <revision><id>1</id><text>` + text + `</text></revision>
+  </page>
+</mediawiki>
+`
+	path := filepath.Join(t.TempDir(), "huge.xml")
+	if err := os.WriteFile(path, []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCancelDuringCleanup cancels a run while it cleans a page of 30 MB,
+// which takes some seconds when left to finish
+func TestCancelDuringCleanup(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	runCancelled(t, []string{"-input", hugePageDump(t), "-plain", "-format", "jsonl"}, 500*time.Millisecond, nil)
+}
+
+// TestCleanerStopsOnCancel checks that the cleaner itself watches a
+// context a caller cancels, without -timeout or -page-timeout
+func TestCleanerStopsOnCancel(t *testing.T) {
+	cfg, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg.Ctx = ctx
+	c := newCleaner(cfg)
+	cancel()
+	c.startPage()
+	start := time.Now()
+	c.plainAbstract(strings.Repeat("Word [[link|text]] and {{tmpl|a=b}} '''bold''' <ref>x</ref> ", 300_000))
+	if elapsed := time.Since(start); !c.expired() || elapsed > 500*time.Millisecond {
+		t.Errorf("cleaning after the cancel took %v (expired: %v)", elapsed, c.expired())
+	}
+}
+
+// TestCancelDuringSinkFlush cancels a run whose Elasticsearch _bulk request
+// gets no answer
+func TestCancelDuringSinkFlush(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	runCancelled(t, []string{"-input", goldenSample, "-plain", "-es-url", srv.URL, "-es-batch", "10"}, 300*time.Millisecond, nil)
+}
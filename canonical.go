@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"          // Package for buffered run files
+	"bytes"          // Package for re-encoding records
+	"cmp"            // Package for comparing page IDs
+	"container/heap" // Package for merging the sorted runs
+	"encoding/gob"   // Package for the run records
+	"encoding/json"  // Package for JSON encoding
+	"fmt"            // Package for formatted I/O
+	"io"             // Package for I/O primitives
+	"os"             // Package for OS functions (file access)
+	"slices"         // Package for sorting a run
+	"strings"        // Package for comparing titles
+)
+
+// canonicalRunDocs is how many docs -canonical sorts in memory before it
+// spills them to the workdir as one sorted run
+const canonicalRunDocs = 50_000
+
+// canonicalCompare orders docs for -canonical: by title, compared as bytes
+// (Unicode code point order, independent of any locale), then by page ID
+func canonicalCompare(a, b *Doc) int {
+	if c := strings.Compare(a.Title, b.Title); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// canonicalJSON encodes v as -canonical JSON: object keys sorted, no
+// insignificant whitespace, numbers as encoding/json writes them, and only
+// the escapes JSON requires (see the README for the full list)
+func canonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding into maps and encoding them again sorts every object's keys;
+	// UseNumber keeps each number as it was written
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(tree); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalJSONLWriter emits one canonical JSON object per line; the
+// sortWriter in front of it supplies the docs in title order
+type canonicalJSONLWriter struct {
+	w io.Writer // Destination stream
+}
+
+func (c *canonicalJSONLWriter) WriteDoc(doc *Doc) error {
+	line, err := canonicalJSON(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Doc: %w", err)
+	}
+	_, err = c.w.Write(line)
+	return err
+}
+
+// WriteSiteInfo writes the siteinfo record in the canonical encoding too
+func (c *canonicalJSONLWriter) WriteSiteInfo(s *SiteInfo) error {
+	line, err := canonicalJSON(struct {
+		Type string `json:"_type"`
+		*SiteInfo
+	}{"siteinfo", s})
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(line)
+	return err
+}
+
+func (c *canonicalJSONLWriter) Close() error {
+	return nil
+}
+
+// sortWriter hands the docs to the next writer in canonicalCompare order.
+// Docs are sorted in memory canonicalRunDocs at a time; a larger output is
+// spilled to the workdir as sorted runs of gob records, which Close merges.
+type sortWriter struct {
+	next docWriter  // Writer the sorted docs go to
+	work *workdir   // Scratch space of the runs
+	docs []Doc      // Docs of the run being collected
+	runs []*os.File // Sorted runs spilled so far
+}
+
+func newSortWriter(next docWriter, cfg *config) *sortWriter {
+	return &sortWriter{next: next, work: cfg.Work}
+}
+
+func (s *sortWriter) WriteDoc(doc *Doc) error {
+	s.docs = append(s.docs, *doc)
+	if len(s.docs) == canonicalRunDocs {
+		return s.spill()
+	}
+	return nil
+}
+
+// WriteSiteInfo passes the siteinfo record straight through, ahead of the docs
+func (s *sortWriter) WriteSiteInfo(si *SiteInfo) error {
+	if sw, ok := s.next.(siteInfoWriter); ok {
+		return sw.WriteSiteInfo(si)
+	}
+	return nil
+}
+
+// sortRun sorts the docs collected so far
+func (s *sortWriter) sortRun() {
+	slices.SortStableFunc(s.docs, func(a, b Doc) int { return canonicalCompare(&a, &b) })
+}
+
+// spill writes the collected docs to the workdir as one sorted run
+func (s *sortWriter) spill() error {
+	s.sortRun()
+	f, err := s.work.create("canonical-*.run")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for i := range s.docs {
+		if err := enc.Encode(&s.docs[i]); err != nil {
+			return fmt.Errorf("failed to write sort run: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write sort run: %w", err)
+	}
+	s.docs = s.docs[:0]
+	return nil
+}
+
+// Close writes every doc in order, then closes the next writer
+func (s *sortWriter) Close() error {
+	var err error
+	if len(s.runs) == 0 {
+		s.sortRun()
+		for i := range s.docs {
+			if err = s.next.WriteDoc(&s.docs[i]); err != nil {
+				break
+			}
+		}
+	} else if err = s.spill(); err == nil {
+		err = s.merge()
+	}
+	for _, f := range s.runs {
+		f.Close()
+	}
+	s.docs, s.runs = nil, nil
+	if err != nil {
+		return err
+	}
+	return s.next.Close()
+}
+
+// merge writes the docs of all runs in order, holding one doc per run
+func (s *sortWriter) merge() error {
+	h := &runHeap{}
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read sort run: %w", err)
+		}
+		r := &sortRun{dec: gob.NewDecoder(bufio.NewReader(f)), seq: i}
+		if ok, err := r.advance(); err != nil {
+			return err
+		} else if ok {
+			heap.Push(h, r)
+		}
+	}
+	for h.Len() > 0 {
+		r := (*h)[0]
+		if err := s.next.WriteDoc(&r.doc); err != nil {
+			return err
+		}
+		ok, err := r.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// sortRun is one spilled run being merged, with its smallest unwritten doc
+type sortRun struct {
+	dec *gob.Decoder // Records of the run
+	doc Doc          // Next doc of the run
+	seq int          // Position among the runs, which breaks ties in spill order
+}
+
+// advance reads the next doc of the run; ok is false at its end
+func (r *sortRun) advance() (ok bool, err error) {
+	r.doc = Doc{}
+	if err := r.dec.Decode(&r.doc); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	return true, nil
+}
+
+// runHeap orders the runs being merged by their next doc
+type runHeap []*sortRun
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if c := canonicalCompare(&h[i].doc, &h[j].doc); c != 0 {
+		return c < 0
+	}
+	return h[i].seq < h[j].seq
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*sortRun)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"           // Package for ordering the report
+	"encoding/json" // Package for the JSON report
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"os"            // Package for OS functions (standard output)
+	"slices"        // Package for sorting the report
+)
+
+// censusConfig holds the settings of the census subcommand
+type censusConfig struct {
+	Top         int      // Categories listed, most pages first
+	JSON        string   // File the JSON report goes to; "" prints only the text
+	ExtractArgs []string // Extract flags given after "--", naming the dump
+}
+
+// censusNamespace counts the pages of one namespace
+type censusNamespace struct {
+	Key       int    `json:"key"`       // Namespace number
+	Name      string `json:"name"`      // Its prefix in the siteinfo; "" for articles
+	Pages     int    `json:"pages"`     // Pages, redirects included
+	Redirects int    `json:"redirects"` // Of those, redirects
+	Bytes     int64  `json:"bytes"`     // Decompressed size of their <page> elements
+}
+
+// censusCount is one named count of the report
+type censusCount struct {
+	Name  string `json:"name"`  // Category, kind or content model
+	Pages int    `json:"pages"` // Pages counted under it
+}
+
+// censusReport is what census finds in a dump. Kinds and categories are
+// counted over the articles: the namespace 0 pages that are not redirects.
+type censusReport struct {
+	Input      string            `json:"input"`      // The dump read
+	Pages      int               `json:"pages"`      // Pages read
+	Redirects  int               `json:"redirects"`  // Of those, redirects
+	Bytes      int64             `json:"bytes"`      // Decompressed size of the pages
+	Namespaces []censusNamespace `json:"namespaces"` // Per namespace, by number
+	Kinds      []censusCount     `json:"kinds"`      // Articles per pageKind, most first
+	Models     []censusCount     `json:"models"`     // Pages per content model, most first
+	Categories []censusCount     `json:"categories"` // The -top categories with most articles
+	Truncated  bool              `json:"truncated"`  // The stream ended before </mediawiki>
+}
+
+// censusCommand streams a dump once and reports what it holds, for choosing
+// the filters of the real run
+func censusCommand(args []string) error {
+	ccfg := &censusConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki census", flag.ContinueOnError)
+	fs.IntVar(&ccfg.Top, "top", 50, "list this many categories, those with most articles first")
+	fs.StringVar(&ccfg.JSON, "json", "", "also write the report as JSON to this `file`")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	ccfg.ExtractArgs = fs.Args()
+	if ccfg.Top < 0 {
+		err := fmt.Errorf("-top must not be negative")
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	cfg, err := parseFlags(ccfg.ExtractArgs)
+	if err != nil {
+		return err
+	}
+	in, err := openInput(cfg)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	report, err := takeCensus(in, cfg, ccfg.Top)
+	if err != nil {
+		return err
+	}
+	report.Input = cfg.Input
+	if report.Input == "" {
+		report.Input = cfg.URL
+	}
+	report.print(os.Stdout)
+	if ccfg.JSON != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(ccfg.JSON, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("failed to write census: %w", err)
+		}
+	}
+	return report.incomplete()
+}
+
+// takeCensus counts the pages of r. Every namespace is counted, whatever
+// -namespaces says; templates are only parsed for the articles.
+func takeCensus(r io.Reader, cfg *config, top int) (*censusReport, error) {
+	st := &stats{}
+	c := newCleaner(cfg)
+	rep := &censusReport{}
+	namespaces := map[int]*censusNamespace{}
+	kinds, models, categories := map[string]int{}, map[string]int{}, map[string]int{}
+	var prefixes []string // Category prefixes, once the siteinfo is read
+	err := scanPages(r, cfg, st, func(p *page) error {
+		if prefixes == nil {
+			prefixes = categoryPrefixes(st.SiteInfo)
+		}
+		ns := namespaces[p.NS]
+		if ns == nil {
+			ns = &censusNamespace{Key: p.NS}
+			namespaces[p.NS] = ns
+		}
+		redirect := redirectOf(p) != ""
+		ns.Pages++
+		ns.Bytes += p.Length
+		rep.Pages++
+		rep.Bytes += p.Length
+		if redirect {
+			ns.Redirects++
+			rep.Redirects++
+		}
+		if p.Revision.Model != "" {
+			models[p.Revision.Model]++
+		}
+		if p.NS != 0 || redirect {
+			return nil
+		}
+		c.startPage()
+		kinds[pageKind(p.Title, c.templates(p.Revision.Text))]++
+		for _, cat := range pageCategories(p.Revision.Text, prefixes, st.SiteInfo.caseSensitive()) {
+			categories[cat]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rep.Truncated = st.Truncated
+
+	names := map[int]string{}
+	if st.SiteInfo != nil {
+		for _, ns := range st.SiteInfo.Namespaces {
+			names[ns.Key] = ns.Name
+		}
+	}
+	for _, ns := range namespaces {
+		ns.Name = names[ns.Key]
+		rep.Namespaces = append(rep.Namespaces, *ns)
+	}
+	slices.SortFunc(rep.Namespaces, func(a, b censusNamespace) int { return cmp.Compare(a.Key, b.Key) })
+	rep.Kinds, rep.Models = sortedCounts(kinds), sortedCounts(models)
+	if rep.Categories = sortedCounts(categories); len(rep.Categories) > top {
+		rep.Categories = rep.Categories[:top]
+	}
+	return rep, nil
+}
+
+// sortedCounts lists counts most pages first, then by name
+func sortedCounts(counts map[string]int) []censusCount {
+	out := make([]censusCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, censusCount{name, n})
+	}
+	slices.SortFunc(out, func(a, b censusCount) int {
+		if c := cmp.Compare(b.Pages, a.Pages); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return out
+}
+
+// print writes the report as aligned text
+func (rep *censusReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Census of %s: %d pages (%d redirects), %s decompressed.\n", rep.Input, rep.Pages, rep.Redirects, formatBytes(rep.Bytes))
+	fmt.Fprintf(w, "\nNamespaces:\n  %6s  %-20s %10s %10s %12s\n", "key", "name", "pages", "redirects", "size")
+	for _, ns := range rep.Namespaces {
+		name := ns.Name
+		if name == "" && ns.Key == 0 {
+			name = "(articles)"
+		}
+		fmt.Fprintf(w, "  %6d  %-20s %10d %10d %12s\n", ns.Key, forDisplay(name), ns.Pages, ns.Redirects, formatBytes(ns.Bytes))
+	}
+	section := func(title string, counts []censusCount) {
+		fmt.Fprintf(w, "\n%s:\n", title)
+		if len(counts) == 0 {
+			fmt.Fprintln(w, "  (none)")
+		}
+		for _, c := range counts {
+			fmt.Fprintf(w, "  %10d  %s\n", c.Pages, forDisplay(c.Name))
+		}
+	}
+	section("Articles by kind", rep.Kinds)
+	section("Content models", rep.Models)
+	section(fmt.Sprintf("Top %d categories of articles", len(rep.Categories)), rep.Categories)
+	if rep.Truncated {
+		fmt.Fprintln(w, "\nWARNING: the dump stream ended without </mediawiki>; the counts cover only the pages read before the cut.")
+	}
+}
+
+// incomplete is the error of a census over a cut-off stream
+func (rep *censusReport) incomplete() error {
+	return (&stats{Truncated: rep.Truncated, Pages: rep.Pages}).incomplete()
+}
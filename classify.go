@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json" // Package for the boundaries file
+	"fmt"           // Package for formatted I/O
+	"math"          // Package for rounding
+	"os"            // Package for OS functions (file access)
+	"strings"       // Package for string manipulation
+	"unicode"       // Package for rune classification
+)
+
+// lengthClasses are the buckets, shortest first
+var lengthClasses = []string{"stub", "short", "medium", "long", "very-long"}
+
+// defaultLengthBounds are the word counts at which each class after "stub" starts
+var defaultLengthBounds = map[string][4]int{
+	"default": {150, 500, 1500, 5000},
+	"simple":  {100, 300, 900, 3000},
+}
+
+// loadLengthBounds reads a JSON object of language -> four ascending word
+// counts, e.g. {"de": [120, 400, 1200, 4000]}, layered over the defaults
+func loadLengthBounds(path string) (map[string][4]int, error) {
+	bounds := make(map[string][4]int, len(defaultLengthBounds))
+	for lang, b := range defaultLengthBounds {
+		bounds[lang] = b
+	}
+	if path == "" {
+		return bounds, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read length classes: %w", err)
+	}
+	var custom map[string][4]int
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid length classes file %s: %w", path, err)
+	}
+	for lang, b := range custom {
+		for i := 1; i < len(b); i++ {
+			if b[i] <= b[i-1] {
+				return nil, fmt.Errorf("length classes for %q must be ascending: %v", lang, b)
+			}
+		}
+		bounds[lang] = b
+	}
+	return bounds, nil
+}
+
+// lengthClass buckets a word count using the language's bounds
+func lengthClass(words int, bounds [4]int) string {
+	for i, b := range bounds {
+		if words < b {
+			return lengthClasses[i]
+		}
+	}
+	return lengthClasses[len(lengthClasses)-1]
+}
+
+// textStats are the counts classification and readability are computed from
+type textStats struct {
+	Words     int // Space-separated words, with each CJK character counted as one
+	Sentences int // splitSentences result, at least 1 for non-empty text
+	Syllables int // Vowel groups across Latin-script words
+	Letters   int // Letters of any script
+	Latin     int // Letters in the Latin script
+}
+
+// measureText counts words, sentences, and syllables in plain text
+func measureText(text string) textStats {
+	var st textStats
+	for _, word := range strings.Fields(text) {
+		cjk := 0
+		for _, r := range word {
+			if unicode.IsLetter(r) {
+				st.Letters++
+				if unicode.Is(unicode.Latin, r) {
+					st.Latin++
+				}
+			}
+			if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+				cjk++
+			}
+		}
+		st.Words += max(cjk, 1)
+		st.Syllables += syllables(word)
+	}
+	st.Sentences = max(len(splitSentences(text)), 1)
+	return st
+}
+
+// syllables approximates the syllable count of a Latin-script word by counting vowel groups
+func syllables(word string) int {
+	word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }))
+	n, prevVowel := 0, false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouyàáâäèéêëìíîïòóôöùúûü", r)
+		if vowel && !prevVowel {
+			n++
+		}
+		prevVowel = vowel
+	}
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && n > 1 {
+		n-- // Silent final e
+	}
+	return max(n, 1)
+}
+
+// readability returns the Flesch-Kincaid grade level for mostly Latin-script
+// text, and otherwise a character-based proxy (letters per sentence / 10) on a
+// comparable scale. The result is rounded to one decimal.
+func readability(st textStats) float64 {
+	if st.Words == 0 {
+		return 0
+	}
+	var grade float64
+	if st.Latin*2 >= st.Letters {
+		grade = 0.39*float64(st.Words)/float64(st.Sentences) + 11.8*float64(st.Syllables)/float64(st.Words) - 15.59
+	} else {
+		grade = float64(st.Letters) / float64(st.Sentences) / 10
+	}
+	return math.Round(grade*10) / 10
+}
+
+// articleText returns a page's whole plain text, headings included as words
+func (c *cleaner) articleText(text string) string {
+	return strings.ReplaceAll(c.clean(text), "==", " ")
+}
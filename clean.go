@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context" // Package for ending cleanup with the run
+	"html"    // Package for HTML entity unescaping
+	"regexp"  // Package for regular expressions
+	"strings" // Package for string manipulation
+	"time"    // Package for the per-page deadline
+)
+
+var (
+	commentRe    = regexp.MustCompile(`(?s)<!--.*?(-->|$)`)                                      // HTML comments, including an unterminated trailing one
+	nowikiRe     = regexp.MustCompile(`(?is)<nowiki\s*>(.*?)</\s*nowiki\s*>`)                    // Literal text that must survive cleanup
+	quotesRe     = regexp.MustCompile(`'{2,}`)                                                   // Bold and italic markers
+	breakTagRe   = regexp.MustCompile(`(?i)<\s*(br|p)\b[^>]*>`)                                  // Tags that separate words
+	htmlTagRe    = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9]*\b[^<>]*>`)                        // Any remaining HTML-like tag
+	magicWordRe  = regexp.MustCompile(`__[A-Z]+__`)                                              // Behaviour switches like __NOTOC__
+	paragraphRe  = regexp.MustCompile(`\n[ \t\r\f\v\x{a0}]*\n`)                                  // Blank lines separating paragraphs
+	emptyParenRe = regexp.MustCompile(`\(\s*([,;]\s*)*\)`)                                       // Parentheses emptied by template removal
+	parenPunctRe = regexp.MustCompile(`\(\s*[,;]\s*`)                                            // "(; born ..." left behind by a removed template
+	spacePunctRe = regexp.MustCompile(`\s+([,.;:])`)                                             // Space stranded before punctuation
+	headingRe    = regexp.MustCompile(`(?m)^=+[^=\n].*?=+[ \t]*$`)                               // Section headings
+	interwikiRe  = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]+)*$`)                                  // Interlanguage link prefixes
+	droppedLinks = map[string]bool{"file": true, "image": true, "category": true, "media": true} // Link namespaces that never render inline
+)
+
+// dropBlockRes match tag blocks that never contribute prose, one pattern per tag
+var dropBlockRes = tagBlockRes("gallery", "syntaxhighlight", "source", "timeline", "imagemap", "score", "graph")
+
+// tagBlockRes compiles a <tag ...>...</tag> matcher for each tag name
+func tagBlockRes(tags ...string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(tags))
+	for i, tag := range tags {
+		res[i] = regexp.MustCompile(`(?is)<` + tag + `\b[^>]*>.*?</\s*` + tag + `\s*>`)
+	}
+	return res
+}
+
+// nowikiEscaper turns markup characters into entities that survive cleanup
+var nowikiEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "{", "&#123;", "}", "&#125;", "[", "&#91;", "]", "&#93;", "'", "&#39;", "|", "&#124;", "_", "&#95;")
+
+// leadSection returns the text before the first section heading
+func leadSection(text string) string {
+	if loc := headingRe.FindStringIndex(text); loc != nil {
+		return text[:loc[0]]
+	}
+	return text
+}
+
+// defaultMaxDepth bounds {{ and [[ nesting; real articles stay far below it
+const defaultMaxDepth = 40
+
+// cleaner turns wikitext into plain text under a set of options
+type cleaner struct {
+	maxDepth int                         // Deepest [[ / {{ nesting parsed; deeper regions are dropped or left unparsed
+	markup   bool                        // Leave formatting and links as markers for htmlAbstract
+	clock    *pageClock                  // Per-page deadline (-page-timeout); nil for none
+	render   map[string]templateRenderer // Templates rendered as text rather than removed (-render-template)
+	dropRefs bool                        // Remove <ref> citations with their content (-collapse-references)
+
+	paragraphs int  // Paragraphs per plain abstract (-paragraphs); 0 means 1
+	keepBreaks bool // Join them with a blank line rather than a space (-preserve-paragraphs)
+
+	exSentences int // Sentences an exintro abstract keeps (-exsentences); 0 keeps them all
+	exChars     int // Characters an exintro abstract is cut after (-exchars); 0 keeps them all
+
+	caseSensitive bool // Link targets keep their first letter, as the dump's siteinfo says
+}
+
+// pageClock is the processing deadline of the current page, shared by a
+// cleaner and the markup cleaner derived from it
+type pageClock struct {
+	now      func() time.Time // Clock
+	timeout  time.Duration    // Budget per page
+	deadline time.Time        // End of the current page's budget
+	expired  bool             // The current page ran past its deadline
+	ctx      context.Context  // The run's; once it ends, so does every page (-timeout, a signal)
+	calls    int              // tick calls, to sample the clock
+}
+
+// newCleaner builds the cleaner described by cfg
+func newCleaner(cfg *config) *cleaner {
+	c := &cleaner{maxDepth: cfg.MaxDepth, render: cfg.TemplateRenderers, dropRefs: cfg.CollapseReferences || cfg.AbstractMode == "exintro",
+		paragraphs: cfg.Paragraphs, keepBreaks: cfg.PreserveParagraphs, exSentences: cfg.ExSentences, exChars: cfg.ExChars}
+	// The run's context can end by -timeout, a signal or its caller's cancel
+	if cfg.PageTimeout > 0 || cfg.Timeout > 0 || cfg.ctx().Done() != nil {
+		c.clock = &pageClock{now: cfg.NowFunc, timeout: cfg.PageTimeout, ctx: cfg.ctx()}
+	}
+	return c
+}
+
+// clone returns a cleaner with the same settings and a clock of its own, for another goroutine
+func (c *cleaner) clone() *cleaner {
+	d := *c
+	if c.clock != nil {
+		k := *c.clock
+		d.clock = &k
+	}
+	return &d
+}
+
+// startPage gives the next page a fresh budget
+func (c *cleaner) startPage() {
+	if k := c.clock; k != nil {
+		k.deadline, k.expired = k.now().Add(k.timeout), false
+	}
+}
+
+// expired reports whether the current page ran past its deadline or the
+// run ended. Once it has, every pass bails out early and the page's results
+// are meaningless.
+func (c *cleaner) expired() bool {
+	k := c.clock
+	if k != nil && !k.expired && (k.timeout > 0 && k.now().After(k.deadline) || k.ctx.Err() != nil) {
+		k.expired = true
+	}
+	return k != nil && k.expired
+}
+
+// tick is expired for per-byte loops: it reads the clock every 4096th call
+func (c *cleaner) tick() bool {
+	k := c.clock
+	if k == nil {
+		return false
+	}
+	if k.calls++; k.calls&4095 != 0 {
+		return k.expired
+	}
+	return c.expired()
+}
+
+// plainAbstract returns the first paragraphs of prose in the lead with wiki
+// markup removed. Whitespace is collapsed within each paragraph; between them
+// it becomes a blank line with -preserve-paragraphs and a space otherwise.
+func (c *cleaner) plainAbstract(text string) string {
+	var paras []string
+	for _, para := range paragraphRe.Split(c.clean(leadSection(text)), -1) {
+		if para = tidyPunctuation(collapseSpace(para)); para != "" {
+			if paras = append(paras, para); len(paras) >= c.paragraphs {
+				break
+			}
+		}
+	}
+	if c.keepBreaks {
+		return strings.Join(paras, "\n\n")
+	}
+	return strings.Join(paras, " ")
+}
+
+// naiveAbstract returns everything up to the n-th blank line, markup included
+func naiveAbstract(text string, n int) string {
+	n = max(n, 1)
+	parts := strings.SplitN(text, "\n\n", n+1)
+	return strings.TrimSpace(strings.Join(parts[:min(n, len(parts))], "\n\n"))
+}
+
+// clean strips templates, tables, links, and formatting from wikitext.
+// It accepts arbitrary input: invalid UTF-8 is replaced up front so the result
+// is always valid UTF-8, unbalanced markup is tolerated rather than rejected,
+// and every pass scans the text left to right without backtracking.
+func (c *cleaner) clean(text string) string {
+	// 1. Coerce the input to valid UTF-8, then drop comments and blocks that never contribute prose
+	text = strings.ToValidUTF8(text, "\uFFFD")
+	if c.markup {
+		text = markRe.ReplaceAllString(text, "")
+		text = entityMarkRe.ReplaceAllString(text, "")
+	}
+	text = commentRe.ReplaceAllString(text, "")
+	for _, re := range dropBlockRes {
+		if c.expired() {
+			return ""
+		}
+		text = re.ReplaceAllString(text, "")
+	}
+	if c.dropRefs {
+		text = stripRefs(text)
+	}
+
+	// 2. Escape nowiki contents so the markup passes below leave them alone
+	text = nowikiRe.ReplaceAllStringFunc(text, func(m string) string {
+		return nowikiEscaper.Replace(nowikiRe.FindStringSubmatch(m)[1])
+	})
+
+	// 3. Remove structural markup, innermost constructs first
+	if c.expired() {
+		return ""
+	}
+	if c.render != nil {
+		text = c.renderTemplates(text)
+	} else {
+		text = stripTemplates(text)
+	}
+	text = stripTables(text)
+	text = c.replaceLinks(text)
+	text = c.replaceExternalLinks(text)
+
+	// 4. Remove inline formatting and HTML-like tags, keeping their content
+	if c.expired() {
+		return ""
+	}
+	if c.markup {
+		text = markTags(text)
+	}
+	text = quotesRe.ReplaceAllString(text, "")
+	text = breakTagRe.ReplaceAllString(text, " ")
+	if c.expired() {
+		return ""
+	}
+	text = htmlTagRe.ReplaceAllString(text, "")
+	text = magicWordRe.ReplaceAllString(text, "")
+
+	// 5. Decode character entities, which also restores nowiki literals
+	return html.UnescapeString(text)
+}
+
+// stripTemplates removes every {{...}} invocation, tracking nesting depth
+func stripTemplates(s string) string {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i += 2
+		case depth > 0 && strings.HasPrefix(s[i:], "}}"):
+			depth--
+			i += 2
+		case depth == 0:
+			b.WriteByte(s[i])
+			i++
+		default:
+			i++
+		}
+	}
+	return b.String()
+}
+
+// stripRefs removes <ref>...</ref> citations with their content, and
+// self-closing <ref name=... /> ones. A closing tag only counts outside the
+// templates of the body, so {{cite ...}} parameters holding markup do not end
+// it early; a ref left open drops its opening tag only.
+func stripRefs(s string) string {
+	var b strings.Builder
+	for {
+		i := refOpen(s)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		tagEnd := strings.IndexByte(s[i:], '>')
+		if tagEnd < 0 {
+			return b.String() // Cut off inside the tag itself
+		}
+		tagEnd += i + 1
+		if strings.HasSuffix(strings.TrimRight(s[i:tagEnd-1], " \t\n"), "/") {
+			s = s[tagEnd:] // <ref name="x" />
+			continue
+		}
+		if end := refClose(s[tagEnd:]); end >= 0 {
+			s = s[tagEnd+end:]
+		} else {
+			s = s[tagEnd:]
+		}
+	}
+}
+
+// refOpen returns where the first <ref> or <ref ...> tag of s starts, or -1
+func refOpen(s string) int {
+	for off := 0; ; {
+		i := strings.IndexByte(s[off:], '<')
+		if i < 0 {
+			return -1
+		}
+		i += off
+		if len(s) > i+4 && strings.EqualFold(s[i:i+4], "<ref") && strings.ContainsRune(" \t\n>/", rune(s[i+4])) {
+			return i
+		}
+		off = i + 1 // <references />, <refname>, ...
+	}
+}
+
+// refClose returns the end of the </ref> closing a ref body, skipping over
+// templates, or -1 when there is none
+func refClose(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+		case depth > 0 && strings.HasPrefix(s[i:], "}}"):
+			depth--
+			i++
+		case depth == 0 && s[i] == '<' && len(s) >= i+6 && strings.EqualFold(s[i:i+5], "</ref"):
+			if j := strings.IndexByte(s[i:], '>'); j >= 0 && strings.TrimSpace(s[i+5:i+j]) == "" {
+				return i + j + 1
+			}
+		}
+	}
+	return -1
+}
+
+// stripTables removes {| ... |} tables, which start and end at line beginnings
+func stripTables(s string) string {
+	if !strings.Contains(s, "{|") {
+		return s
+	}
+	var kept []string
+	depth := 0
+	for _, line := range strings.Split(s, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "{|"):
+			depth++
+		case depth > 0 && strings.HasPrefix(trimmed, "|}"):
+			depth--
+		case depth == 0:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// replaceLinks renders [[target|label]] links as their visible text. Links are
+// resolved inside-out on a stack, so a caption's nested links are rendered once
+// before the enclosing link decides whether it is shown at all. A link nested
+// deeper than maxDepth is treated as malformed and its outermost link dropped.
+func (c *cleaner) replaceLinks(s string) string {
+	stack := []*strings.Builder{{}}
+	for i := 0; i < len(s); i++ {
+		if c.tick() {
+			return ""
+		}
+		top := stack[len(stack)-1]
+		switch {
+		case strings.HasPrefix(s[i:], "[["):
+			if len(stack) > c.maxDepth {
+				i = skipNested(s, i, len(stack)-1, "[[", "]]") - 1
+				stack = stack[:1]
+				continue
+			}
+			stack = append(stack, &strings.Builder{})
+			i++
+		case len(stack) > 1 && strings.HasPrefix(s[i:], "]]"):
+			stack = stack[:len(stack)-1]
+			label := renderLink(top.String())
+			if c.markup {
+				target, _, _ := strings.Cut(top.String(), "|")
+				label = markLinkText(strings.TrimPrefix(strings.TrimSpace(target), ":"), label)
+			}
+			stack[len(stack)-1].WriteString(label)
+			i++
+		default:
+			top.WriteByte(s[i])
+		}
+	}
+	// Unbalanced openers: keep their text, drop the brackets
+	for len(stack) > 1 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		stack[len(stack)-1].WriteString(top.String())
+	}
+	return stack[0].String()
+}
+
+// skipNested returns the offset just past the point where the nesting that is
+// depth levels deep at offset i (which holds another opener) fully closes, or len(s)
+func skipNested(s string, i, depth int, open, close string) int {
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open)
+		case strings.HasPrefix(s[i:], close):
+			depth--
+			i += len(close)
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(s)
+}
+
+// renderLink returns the visible text of a link body such as "Target|label"
+// whose nested links have already been rendered
+func renderLink(body string) string {
+	target, label, piped := strings.Cut(body, "|")
+	target = strings.TrimSpace(target)
+	visible := !strings.HasPrefix(target, ":") // A leading colon forces an inline link
+	target = strings.TrimPrefix(target, ":")
+	if prefix, _, ok := strings.Cut(target, ":"); ok && visible {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if droppedLinks[prefix] || interwikiRe.MatchString(prefix) {
+			return ""
+		}
+	}
+	if !piped {
+		return target
+	}
+	if label == "" {
+		// Pipe trick: [[Paris, France|]] renders as "Paris"
+		if idx := strings.IndexAny(target, ",("); idx > 0 {
+			return strings.TrimSpace(target[:idx])
+		}
+		return target
+	}
+	return label
+}
+
+// replaceExternalLinks renders [http://example.org label] as its label
+func (c *cleaner) replaceExternalLinks(s string) string {
+	var b strings.Builder
+	for !c.tick() {
+		i := strings.Index(s, "[")
+		if i < 0 {
+			break
+		}
+		rest := s[i+1:]
+		if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") && !strings.HasPrefix(rest, "//") {
+			b.WriteString(s[:i+1])
+			s = rest
+			continue
+		}
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		if href, label, ok := strings.Cut(rest[:end], " "); ok && c.markup {
+			b.WriteString(markLinkText(href, strings.TrimSpace(label)))
+		} else if ok {
+			b.WriteString(strings.TrimSpace(label))
+		}
+		s = rest[end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// tidyPunctuation removes the empty brackets and stray separators removed markup leaves behind
+func tidyPunctuation(s string) string {
+	s = emptyParenRe.ReplaceAllString(s, "")
+	s = parenPunctRe.ReplaceAllString(s, "(")
+	s = spacePunctRe.ReplaceAllString(s, "$1")
+	return collapseSpace(s)
+}
+
+// collapseSpace joins runs of whitespace (including newlines) into single spaces
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
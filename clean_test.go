@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"      // Package for building nested markup
+	"testing"      // Package for tests
+	"time"         // Package for the time bound
+	"unicode/utf8" // Package for checking the output encoding
+)
+
+// fuzzCleaners are the cleaners FuzzCleanAbstract runs an input through:
+// the default one, and one rendering templates and dropping references
+func fuzzCleaners(t testing.TB) []*cleaner {
+	var cleaners []*cleaner
+	for _, args := range [][]string{
+		{},
+		{"-templates-as-text", "sample/render-map.txt", "-collapse-references"},
+	} {
+		cfg, err := parseFlags(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cleaners = append(cleaners, newCleaner(cfg))
+	}
+	return cleaners
+}
+
+// FuzzCleanAbstract feeds arbitrary wikitext to the cleaner: it must not
+// panic, must return valid UTF-8 whatever the input's encoding, and must
+// stay roughly linear in time however deep the markup nests. The seed
+// corpus is in testdata/fuzz/FuzzCleanAbstract.
+func FuzzCleanAbstract(f *testing.F) {
+	cleaners := fuzzCleaners(f)
+	f.Fuzz(func(t *testing.T, text string) {
+		limit := time.Second + time.Duration(len(text))*10*time.Microsecond
+		for _, c := range cleaners {
+			c.startPage()
+			start := time.Now()
+			abstract := c.plainAbstract(text)
+			if elapsed := time.Since(start); elapsed > limit {
+				t.Errorf("cleaning %d bytes took %v", len(text), elapsed)
+			}
+			if !utf8.ValidString(abstract) {
+				t.Errorf("abstract is not valid UTF-8: %q", abstract)
+			}
+		}
+	})
+}
+
+// TestCleanAbstractDeepNesting checks that deeply nested {{ and [[, balanced
+// or not, are cleaned in bounded time: each level must not rescan the text
+func TestCleanAbstractDeepNesting(t *testing.T) {
+	const depth = 100_000
+	for name, text := range map[string]string{
+		"templates":     strings.Repeat("{{a|", depth) + "x" + strings.Repeat("}}", depth) + " Lead.",
+		"links":         strings.Repeat("[[", depth) + "a|b" + strings.Repeat("]]", depth) + " Lead.",
+		"mixed":         strings.Repeat("{{a|[[b|", depth) + "x" + strings.Repeat("]]}}", depth) + " Lead.",
+		"open-template": strings.Repeat("{{", depth) + " Lead.",
+		"open-link":     strings.Repeat("[[", depth) + " Lead.",
+		"close-only":    strings.Repeat("}}]]", depth) + " Lead.",
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, c := range fuzzCleaners(t) {
+				c.startPage()
+				start := time.Now()
+				abstract := c.plainAbstract(text)
+				if elapsed := time.Since(start); elapsed > 5*time.Second {
+					t.Errorf("cleaning %d bytes took %v", len(text), elapsed)
+				}
+				if !utf8.ValidString(abstract) {
+					t.Errorf("abstract is not valid UTF-8")
+				}
+			}
+		})
+	}
+}
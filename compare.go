@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"          // Package for buffered partition files
+	"compress/bzip2" // Package for .bz2 inputs
+	"compress/gzip"  // Package for .gz inputs
+	"container/heap" // Package for keeping the worst mismatches
+	"encoding/json"  // Package for partition records and the JSON summary
+	"encoding/xml"   // Package for XML abstract files
+	"flag"           // Package for command-line flag parsing
+	"fmt"            // Package for formatted I/O
+	"hash/fnv"       // Package for partitioning titles
+	"io"             // Package for I/O primitives
+	"net/url"        // Package for unescaping page URLs
+	"os"             // Package for OS functions (file access)
+	"strings"        // Package for string manipulation
+	"time"           // Package for the workdir name
+	"unicode"        // Package for tokenizing
+)
+
+// compareConfig holds the settings of the compare-abstracts subcommand
+type compareConfig struct {
+	Ours       string // Our output (XML or JSONL)
+	Official   string // Wikimedia's abstract dump, e.g. enwiki-latest-abstract.xml.gz
+	Metric     string // "jaccard" or "levenshtein"
+	Worst      int    // Mismatches to print
+	JSON       string // Write the summary as JSON to this file ("-": stdout)
+	Partitions int    // Hash partitions the titles are spread over
+	Workdir    string // Directory for the partition files
+}
+
+// compareSummary is the result of a comparison; it is also the -json record
+type compareSummary struct {
+	Ours           string            `json:"ours"`            // Our output file
+	Official       string            `json:"official"`        // Official dump file
+	Metric         string            `json:"metric"`          // Similarity metric
+	OursOnly       int               `json:"ours_only"`       // Titles only in our output
+	OfficialOnly   int               `json:"official_only"`   // Titles only in the official dump
+	Matched        int               `json:"matched"`         // Titles in both
+	Exact          int               `json:"exact"`           // Matched titles with identical abstracts
+	ExactRate      float64           `json:"exact_rate"`      // Exact over Matched
+	MeanSimilarity float64           `json:"mean_similarity"` // Average similarity over Matched
+	Histogram      [10]int           `json:"histogram"`       // Matched titles per similarity tenth; 1.0 counts in the last
+	Worst          []compareMismatch `json:"worst"`           // Least similar pairs, worst first
+}
+
+// compareMismatch is one pair of differing abstracts
+type compareMismatch struct {
+	Title      string  `json:"title"`      // Joined title
+	Similarity float64 `json:"similarity"` // Similarity of the two abstracts
+	Ours       string  `json:"ours"`       // Our abstract
+	Official   string  `json:"official"`   // Official abstract
+}
+
+// compareCommand quantifies how close our abstracts are to Wikimedia's own
+func compareCommand(args []string) error {
+	cfg := &compareConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki compare-abstracts", flag.ContinueOnError)
+	fs.StringVar(&cfg.Ours, "ours", "", "our output `file` (.xml or .jsonl, optionally .gz/.bz2)")
+	fs.StringVar(&cfg.Official, "official", "", "official abstract dump `file`, e.g. enwiki-latest-abstract.xml.gz")
+	fs.StringVar(&cfg.Metric, "metric", "jaccard", "similarity: jaccard (word sets) or levenshtein (edit distance over the first 1000 characters)")
+	fs.IntVar(&cfg.Worst, "worst", 10, "print this many least similar pairs")
+	fs.StringVar(&cfg.JSON, "json", "", "also write the summary as JSON to this `file` (- for stdout)")
+	fs.IntVar(&cfg.Partitions, "partitions", 64, "hash partitions spilled to disk; memory holds one partition of our output at a time")
+	fs.StringVar(&cfg.Workdir, "workdir", "", "`dir` for the partition files (default: full-stream-wiki-<runid> under the temp dir)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	var err error
+	switch {
+	case cfg.Ours == "" || cfg.Official == "":
+		err = fmt.Errorf("compare-abstracts needs -ours and -official")
+	case cfg.Metric != "jaccard" && cfg.Metric != "levenshtein":
+		err = fmt.Errorf("unknown -metric %q (want jaccard or levenshtein)", cfg.Metric)
+	case cfg.Partitions < 1 || cfg.Worst < 0:
+		err = fmt.Errorf("-partitions must be positive and -worst not negative")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+
+	sum, err := compareAbstracts(cfg)
+	if err != nil {
+		return err
+	}
+	sum.print(os.Stdout)
+	if cfg.JSON == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	if cfg.JSON == "-" {
+		_, err = fmt.Printf("%s\n", data)
+		return err
+	}
+	return os.WriteFile(cfg.JSON, append(data, '\n'), 0o644)
+}
+
+// compareAbstracts joins the two files on title through hash partitions on
+// disk, so only one partition of our output is ever held in memory
+func compareAbstracts(cfg *compareConfig) (sum *compareSummary, err error) {
+	work := newWorkdir(cfg.Workdir, time.Now())
+	defer func() { err = work.finish(err) }()
+
+	// 1. Spread both files over the partitions by title hash
+	ours, err := partitionAbstracts(cfg.Ours, "ours", cfg.Partitions, work)
+	if err != nil {
+		return nil, err
+	}
+	official, err := partitionAbstracts(cfg.Official, "official", cfg.Partitions, work)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Join each partition in memory
+	sum = &compareSummary{Ours: cfg.Ours, Official: cfg.Official, Metric: cfg.Metric}
+	similarity := jaccard
+	if cfg.Metric == "levenshtein" {
+		similarity = levenshteinSimilarity
+	}
+	worst := &mismatchHeap{}
+	total := 0.0
+	for i := range ours {
+		mine := map[string]string{}
+		if err := readPartition(ours[i], func(title string, rec abstractRecord) { mine[title] = rec.Abstract }); err != nil {
+			return nil, err
+		}
+		err := readPartition(official[i], func(title string, rec abstractRecord) {
+			theirs := rec.Abstract
+			abstract, ok := mine[title]
+			if !ok {
+				sum.OfficialOnly++
+				return
+			}
+			delete(mine, title)
+			sum.Matched++
+			a, b := strings.Join(strings.Fields(abstract), " "), strings.Join(strings.Fields(theirs), " ")
+			s := 1.0
+			if a == b {
+				sum.Exact++
+			} else {
+				s = similarity(a, b)
+			}
+			total += s
+			sum.Histogram[min(int(s*10), 9)]++
+			if s < 1 && cfg.Worst > 0 {
+				heap.Push(worst, compareMismatch{title, s, abstract, theirs})
+				if worst.Len() > cfg.Worst {
+					heap.Pop(worst)
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		sum.OursOnly += len(mine)
+	}
+
+	// 3. Summarize
+	if sum.Matched > 0 {
+		sum.ExactRate = float64(sum.Exact) / float64(sum.Matched)
+		sum.MeanSimilarity = total / float64(sum.Matched)
+	}
+	sum.Worst = make([]compareMismatch, worst.Len())
+	for i := len(sum.Worst) - 1; i >= 0; i-- {
+		sum.Worst[i] = heap.Pop(worst).(compareMismatch)
+	}
+	return sum, nil
+}
+
+// partitionAbstracts writes the join key and record of every doc in path to
+// the partition file its key hashes to, returning the file names
+func partitionAbstracts(path, side string, n int, work *workdir) ([]string, error) {
+	files := make([]*os.File, n)
+	bufs := make([]*bufio.Writer, n)
+	encs := make([]*json.Encoder, n)
+	names := make([]string, n)
+	for i := range files {
+		f, err := work.create(fmt.Sprintf("%s-%03d-*.jsonl", side, i))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files[i], bufs[i], names[i] = f, bufio.NewWriter(f), f.Name()
+		encs[i] = json.NewEncoder(bufs[i])
+	}
+	err := readAbstracts(path, func(key string, rec abstractRecord) error {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return encs[h.Sum32()%uint32(n)].Encode(partitionRecord{key, rec})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range bufs {
+		if err := b.Flush(); err != nil {
+			return nil, fmt.Errorf("failed to write partition: %w", err)
+		}
+		if err := files[i].Close(); err != nil {
+			return nil, fmt.Errorf("failed to write partition: %w", err)
+		}
+	}
+	return names, nil
+}
+
+// partitionRecord is one line of a partition file
+type partitionRecord struct {
+	Key string `json:"key"` // Join key, from abstractRecord.key
+	abstractRecord
+}
+
+// readPartition calls fn for every join key and record in a partition file
+func readPartition(name string, fn func(key string, rec abstractRecord)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec partitionRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read partition: %w", err)
+		}
+		fn(rec.Key, rec.abstractRecord)
+	}
+}
+
+// abstractRecord is a <doc> of our XML output or the official dump, or a JSONL line
+type abstractRecord struct {
+	Title        string `xml:"title" json:"title"`                                     // Page title; "Wikipedia: Title" in the official dump
+	URL          string `xml:"url" json:"url"`                                         // Page URL
+	Abstract     string `xml:"abstract" json:"abstract"`                               // Abstract text
+	SourceFile   string `xml:"source_file,omitempty" json:"source_file,omitempty"`     // Provenance of a -with-provenance output, carried along by diff
+	SourceOffset *int64 `xml:"source_offset,omitempty" json:"source_offset,omitempty"` // Ditto
+}
+
+// readAbstracts calls fn with the join key and record of every doc in an
+// abstract file: JSONL by extension, XML <doc> elements otherwise
+func readAbstracts(path string, fn func(key string, rec abstractRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	r, name, err := decompressByName(f, path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson") {
+		dec := json.NewDecoder(r)
+		for {
+			var rec abstractRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if err := fn(rec.key(), rec); err != nil {
+				return err
+			}
+		}
+	}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "doc" {
+			var rec abstractRecord
+			if err := dec.DecodeElement(&rec, &start); err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if err := fn(rec.key(), rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decompressByName undoes the .gz or .bz2 compression named by path and
+// returns the lowercased name without that extension
+func decompressByName(f io.Reader, path string) (io.Reader, string, error) {
+	r := io.Reader(bufio.NewReader(f))
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, name, err
+		}
+		return gz, strings.TrimSuffix(name, ".gz"), nil
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r), strings.TrimSuffix(name, ".bz2"), nil
+	}
+	return r, name, nil
+}
+
+// key is the title both files agree on: the one in the page URL when there
+// is one, since the official dump prefixes its titles with the site name
+func (rec abstractRecord) key() string {
+	if _, page, ok := strings.Cut(rec.URL, "/wiki/"); ok {
+		if title, err := url.PathUnescape(page); err == nil {
+			return normalizeTitle(title, false)
+		}
+	}
+	return normalizeTitle(rec.Title, false)
+}
+
+// jaccard is the overlap of the two texts' lowercase word sets
+func jaccard(a, b string) float64 {
+	words := func(s string) map[string]bool {
+		set := map[string]bool{}
+		for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			set[w] = true
+		}
+		return set
+	}
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	shared := 0
+	for w := range wa {
+		if wb[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
+
+// levenshteinLimit bounds the characters compared per abstract, keeping each pair O(limit²)
+const levenshteinLimit = 1000
+
+// levenshteinSimilarity is one minus the edit distance over the longer length,
+// on the first levenshteinLimit characters of each text
+func levenshteinSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	ra, rb = ra[:min(len(ra), levenshteinLimit)], rb[:min(len(rb), levenshteinLimit)]
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// mismatchHeap keeps the least similar pairs seen: a max-heap on similarity,
+// so the most similar of them is dropped first
+type mismatchHeap []compareMismatch
+
+func (h mismatchHeap) Len() int           { return len(h) }
+func (h mismatchHeap) Less(i, j int) bool { return h[i].Similarity > h[j].Similarity }
+func (h mismatchHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mismatchHeap) Push(x any)        { *h = append(*h, x.(compareMismatch)) }
+func (h *mismatchHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// print writes the human-readable summary
+func (s *compareSummary) print(w io.Writer) {
+	pct := func(n, of int) float64 {
+		if of == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(of)
+	}
+	fmt.Fprintf(w, "Titles: %d in both, %d only in %s, %d only in %s.\n", s.Matched, s.OursOnly, s.Ours, s.OfficialOnly, s.Official)
+	fmt.Fprintf(w, "Exact matches: %d (%.1f%%). Mean %s similarity: %.3f.\n", s.Exact, 100*s.ExactRate, s.Metric, s.MeanSimilarity)
+	fmt.Fprintln(w, "Similarity:")
+	for i, n := range s.Histogram {
+		fmt.Fprintf(w, "  %.1f-%.1f %8d %5.1f%%\n", float64(i)/10, float64(i+1)/10, n, pct(n, s.Matched))
+	}
+	if len(s.Worst) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Least similar:")
+	clip := func(t string) string {
+		if r := []rune(t); len(r) > 160 {
+			return string(r[:160]) + "…"
+		}
+		return t
+	}
+	for _, m := range s.Worst {
+		fmt.Fprintf(w, "  %.3f %s\n    ours:     %s\n    official: %s\n", m.Similarity, m.Title, clip(m.Ours), clip(m.Official))
+	}
+}
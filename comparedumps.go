@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"         // Package for comparing outputs
+	"encoding/json" // Package for reading titles back
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"slices"        // Package for ordering the divergent titles
+	"strconv"       // Package for the -workers argument
+	"strings"       // Package for string manipulation
+	"time"          // Package for timing the runs
+)
+
+// compareDumpsConfig holds the settings of the compare-dumps subcommand
+type compareDumpsConfig struct {
+	Workers         int      // -workers of the parallel runs
+	PrefetchStreams int      // -prefetch-streams of the prefetch run, with -index
+	Show            int      // Divergent titles listed per run
+	Workdir         string   // Directory for the outputs of the runs
+	ExtractArgs     []string // Extract flags given after "--", naming the fixture
+}
+
+// dumpRun is one way compare-dumps extracts the fixture
+type dumpRun struct {
+	name  string   // Name in the report
+	flags []string // Flags added to the extract flags
+	exact bool     // The output must equal the serial one byte for byte, not only as a set of docs
+}
+
+// compareDumpsCommand extracts one small dump serially and through the
+// parallel paths, and fails when an output differs from the serial one. It
+// is a check of the concurrency features for CI and development, not listed
+// with the other subcommands.
+func compareDumpsCommand(args []string) error {
+	ccfg := &compareDumpsConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki compare-dumps", flag.ContinueOnError)
+	fs.IntVar(&ccfg.Workers, "workers", 4, "goroutines of the parallel runs")
+	fs.IntVar(&ccfg.PrefetchStreams, "prefetch-streams", 2, "streams the prefetch run decompresses ahead, when the extract flags give -index")
+	fs.IntVar(&ccfg.Show, "show", 10, "divergent titles listed per run")
+	fs.StringVar(&ccfg.Workdir, "workdir", "", "`dir` for the outputs of the runs (default: full-stream-wiki-<runid> under the temp dir)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	ccfg.ExtractArgs = fs.Args()
+	base, err := parseFlags(ccfg.ExtractArgs)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ccfg.Workers < 2 || ccfg.PrefetchStreams < 1 || ccfg.Show < 0:
+		err = fmt.Errorf("-workers must be at least 2, -prefetch-streams positive and -show not negative")
+	case base.Input == "" || len(base.Langs) > 1:
+		err = fmt.Errorf("compare-dumps needs the -input of one local dump after \"--\", so that every run reads the same pages")
+	case base.Workers != 1 || base.Ordered || base.PrefetchStreams != 0:
+		err = fmt.Errorf("compare-dumps sets -workers, -ordered and -prefetch-streams itself; leave them out of the extract flags")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+
+	workers := strconv.Itoa(ccfg.Workers)
+	runs := []dumpRun{
+		{"serial", nil, true},
+		{"ordered", []string{"-workers", workers, "-ordered"}, true},
+	}
+	if !orderDependent(base) {
+		runs = append(runs, dumpRun{"unordered", []string{"-workers", workers}, false})
+	}
+	if base.Index != "" {
+		runs = append(runs, dumpRun{"prefetch", []string{"-workers", workers, "-ordered", "-prefetch-streams", strconv.Itoa(ccfg.PrefetchStreams)}, true})
+	}
+	return compareDumps(ccfg, runs)
+}
+
+// orderDependent reports whether the set of docs itself depends on the
+// order workers finish in, so that only ordered runs can be compared
+func orderDependent(cfg *config) bool {
+	return cfg.Slug && cfg.SlugCollisions == "suffix" || cfg.Skip > 0 || cfg.Limit > 0 || cfg.SampleK > 0
+}
+
+// compareDumps performs the runs and reports each against the first. The
+// outputs are JSONL, whatever -format the extract flags give.
+func compareDumps(ccfg *compareDumpsConfig, runs []dumpRun) (err error) {
+	work := newWorkdir(ccfg.Workdir, time.Now())
+	defer func() { err = work.finish(err) }()
+
+	outputs := make([][]byte, len(runs))
+	elapsed := make([]time.Duration, len(runs))
+	for i, r := range runs {
+		f, err := work.create(r.name + "-*.jsonl")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		args := append(append(append([]string{}, ccfg.ExtractArgs...), "-format", "jsonl", "-o", f.Name()), r.flags...)
+		cfg, err := parseFlags(args)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Run %d of %d, %s %s:\n", i+1, len(runs), r.name, strings.Join(r.flags, " "))
+		started := time.Now()
+		if err := run(cfg); err != nil {
+			return fmt.Errorf("%s run: %w", r.name, err)
+		}
+		elapsed[i] = time.Since(started)
+		if outputs[i], err = os.ReadFile(f.Name()); err != nil {
+			return err
+		}
+	}
+
+	serial := bytes.SplitAfter(outputs[0], []byte("\n"))
+	docs := len(serial) - 1
+	fmt.Printf("\n%-10s %d docs in %s\n", runs[0].name+":", docs, elapsed[0].Round(time.Millisecond))
+	diverged := 0
+	for i, r := range runs[1:] {
+		i++
+		speedup := float64(elapsed[0]) / float64(max(elapsed[i], 1))
+		fmt.Printf("%-10s %s, %.2fx the serial speed: ", r.name+":", elapsed[i].Round(time.Millisecond), speedup)
+		if r.exact && bytes.Equal(outputs[i], outputs[0]) {
+			fmt.Println("identical")
+			continue
+		}
+		d := divergence(serial, bytes.SplitAfter(outputs[i], []byte("\n")), r.exact, ccfg.Show)
+		if d == "" {
+			fmt.Println("the same docs")
+			continue
+		}
+		diverged++
+		fmt.Println("DIVERGES:", d)
+	}
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d parallel runs differ from the serial run", diverged, len(runs)-1)
+	}
+	return nil
+}
+
+// divergence describes how the docs of a parallel run differ from the
+// serial ones, naming the titles involved, or is "" when they are the same
+// docs in an order the run may choose (exact is false). At most show titles
+// are named per kind of difference.
+func divergence(serial, other [][]byte, exact bool, show int) string {
+	count := map[string]int{}
+	for _, line := range serial {
+		count[string(line)]++
+	}
+	for _, line := range other {
+		count[string(line)]--
+	}
+	missing, extra := map[string]bool{}, map[string]bool{}
+	for line, n := range count {
+		if n > 0 {
+			missing[docTitle(line)] = true
+		} else if n < 0 {
+			extra[docTitle(line)] = true
+		}
+	}
+	var changed, gone, added []string
+	for title := range missing {
+		if extra[title] {
+			changed = append(changed, title)
+		} else {
+			gone = append(gone, title)
+		}
+	}
+	for title := range extra {
+		if !missing[title] {
+			added = append(added, title)
+		}
+	}
+	if len(changed)+len(gone)+len(added) == 0 {
+		if !exact {
+			return ""
+		}
+		for i := range serial {
+			if !bytes.Equal(serial[i], other[i]) {
+				return fmt.Sprintf("the same docs in another order; doc %d is %q where the serial run has %q",
+					i+1, forDisplay(docTitle(string(other[i]))), forDisplay(docTitle(string(serial[i]))))
+			}
+		}
+	}
+	var parts []string
+	for _, group := range []struct {
+		what   string
+		titles []string
+	}{{"changed", changed}, {"missing", gone}, {"extra", added}} {
+		if len(group.titles) > 0 {
+			slices.Sort(group.titles)
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", len(group.titles), group.what, titleList(group.titles, show)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// titleList quotes the first show titles
+func titleList(titles []string, show int) string {
+	var quoted []string
+	for _, t := range titles[:min(len(titles), show)] {
+		quoted = append(quoted, strconv.Quote(forDisplay(t)))
+	}
+	if len(titles) > show {
+		quoted = append(quoted, fmt.Sprintf("and %d more", len(titles)-show))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// docTitle is the title of a JSONL doc; a record without one, such as the
+// -siteinfo-record line, is named after its position in the stream
+func docTitle(line string) string {
+	var doc struct {
+		Title string `json:"title"`
+		Type  string `json:"_type"`
+	}
+	if json.Unmarshal([]byte(line), &doc) != nil {
+		return "(unreadable line)"
+	}
+	if doc.Title == "" {
+		return "(" + doc.Type + " record)"
+	}
+	return doc.Title
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"strconv" // Package for parsing the numbers
+	"strings" // Package for string manipulation
+)
+
+// coordinate is a position in decimal degrees, north and east positive
+type coordinate struct {
+	Lat float64 // Latitude, -90 to 90
+	Lon float64 // Longitude, -180 to 180
+}
+
+// parseCoord reads the position of a {{coord}} invocation. Handled forms:
+//
+//	{{coord|48.8566|2.3522}}                signed decimal degrees
+//	{{coord|27.9881|N|86.9250|E}}           decimal degrees with hemispheres
+//	{{coord|48|51|N|2|21|E}}                degrees and minutes
+//	{{coord|48|51|24|N|2|21|08|E}}          degrees, minutes and seconds
+//
+// Named parameters and the "type:city"-style ones after the position are
+// ignored. ok is false for anything else or a position off the globe.
+func parseCoord(t template) (pos coordinate, ok bool) {
+	if t.Name != "Coord" {
+		return coordinate{}, false
+	}
+	var args []string
+	for _, a := range t.positional() {
+		if !strings.Contains(a, ":") {
+			args = append(args, a)
+		}
+	}
+	ns := -1
+	for i := 1; i < len(args) && i <= 3; i++ {
+		if args[i] == "N" || args[i] == "S" {
+			ns = i
+			break
+		}
+	}
+	if ns < 0 {
+		if len(args) < 2 {
+			return coordinate{}, false
+		}
+		lat, err1 := strconv.ParseFloat(args[0], 64)
+		lon, err2 := strconv.ParseFloat(args[1], 64)
+		if err1 != nil || err2 != nil {
+			return coordinate{}, false
+		}
+		pos = coordinate{lat, lon}
+	} else {
+		ew := -1
+		for j := ns + 2; j < len(args) && j <= ns+4; j++ {
+			if args[j] == "E" || args[j] == "W" {
+				ew = j
+				break
+			}
+		}
+		if ew < 0 {
+			return coordinate{}, false
+		}
+		lat, ok1 := degrees(args[:ns])
+		lon, ok2 := degrees(args[ns+1 : ew])
+		if !ok1 || !ok2 {
+			return coordinate{}, false
+		}
+		if args[ns] == "S" {
+			lat = -lat
+		}
+		if args[ew] == "W" {
+			lon = -lon
+		}
+		pos = coordinate{lat, lon}
+	}
+	if pos.Lat < -90 || pos.Lat > 90 || pos.Lon < -180 || pos.Lon > 180 {
+		return coordinate{}, false
+	}
+	return pos, true
+}
+
+// degrees adds up degrees, minutes and seconds given without a sign
+func degrees(parts []string) (float64, bool) {
+	total, scale := 0.0, 1.0
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || scale < 1 && v >= 60 {
+			return 0, false
+		}
+		total += v / scale
+		scale *= 60
+	}
+	return total, true
+}
+
+// extractCoords returns the position of every {{coord}} among templates, in text order
+func extractCoords(templates []template) []coordinate {
+	var out []coordinate
+	for _, t := range templates {
+		if pos, ok := parseCoord(t); ok {
+			out = append(out, pos)
+		}
+	}
+	return out
+}
+
+// bbox is the -bbox rectangle. A MinLon above MaxLon is a box across the
+// antimeridian, e.g. 50,170,70,-170 for the Bering Strait.
+type bbox struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+// parseBBox reads "minLat,minLon,maxLat,maxLon" in decimal degrees
+func parseBBox(s string) (*bbox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("-bbox: want minLat,minLon,maxLat,maxLon, got %q", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("-bbox: %q is not a number", p)
+		}
+		v[i] = f
+	}
+	b := &bbox{v[0], v[1], v[2], v[3]}
+	switch {
+	case b.MinLat < -90 || b.MaxLat > 90:
+		return nil, fmt.Errorf("-bbox: latitudes must be within -90 and 90")
+	case b.MinLon < -180 || b.MinLon > 180 || b.MaxLon < -180 || b.MaxLon > 180:
+		return nil, fmt.Errorf("-bbox: longitudes must be within -180 and 180")
+	case b.MinLat > b.MaxLat:
+		return nil, fmt.Errorf("-bbox: minLat %g is above maxLat %g", b.MinLat, b.MaxLat)
+	}
+	return b, nil
+}
+
+// contains reports whether pos lies in the box, edges included
+func (b *bbox) contains(pos coordinate) bool {
+	if pos.Lat < b.MinLat || pos.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return pos.Lon >= b.MinLon && pos.Lon <= b.MaxLon
+	}
+	return pos.Lon >= b.MinLon || pos.Lon <= b.MaxLon
+}
+
+// keep reports whether any {{coord}} of a page falls inside the box,
+// counting geotagged pages and those passing into st. Coordinates in
+// comments or <nowiki> do not count.
+func (b *bbox) keep(c *cleaner, text string, st *stats) bool {
+	if !strings.Contains(strings.ToLower(text), "{{coord") {
+		return false
+	}
+	text = commentRe.ReplaceAllString(text, "")
+	text = nowikiRe.ReplaceAllString(text, "")
+	coords := extractCoords(c.templates(text))
+	if len(coords) == 0 {
+		return false
+	}
+	st.GeoTagged++
+	for _, pos := range coords {
+		if b.contains(pos) {
+			st.GeoPassed++
+			return true
+		}
+	}
+	return false
+}
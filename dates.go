@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"strconv" // Package for string conversions
+)
+
+// dateTemplates maps the handled date templates to what they record.
+// The positional parameters are year|month|day throughout; month and day
+// may be omitted. The "and age" forms of death dates carry the birth date
+// in parameters 4-6, used when no birth template is present.
+//
+//	{{birth date|1952|3|11}}                        {{bda|...}}, {{dob|...}}
+//	{{birth date and age|1952|3|11|df=y}}
+//	{{birth year and age|1952}}                     year (and month) only
+//	{{death date|2001|5|11}}
+//	{{death date and age|2001|5|11|1952|3|11}}      {{dda|...}}
+//	{{death year and age|2001|1952}}                years only
+var dateTemplates = map[string]struct {
+	death bool // Records a death rather than a birth
+	age   bool // Death form whose next parameters give the birth
+	years bool // Only years are given positionally (death year and age)
+}{
+	"Birth date":         {},
+	"Birth date and age": {},
+	"Bda":                {},
+	"Dob":                {},
+	"Birth year and age": {},
+	"Death date":         {death: true},
+	"Death date and age": {death: true, age: true},
+	"Dda":                {death: true, age: true},
+	"Death year and age": {death: true, age: true, years: true},
+}
+
+// extractDates returns the ISO 8601 birth and death dates ("1952-03-11",
+// or "1952-03"/"1952" when that is all the template gives) of the first
+// birth and death date templates in the article; either may be empty
+func extractDates(ts []template) (birth, death string) {
+	fallback := "" // Birth date given by a death date and age template
+	for _, t := range ts {
+		kind, ok := dateTemplates[t.Name]
+		if !ok {
+			continue
+		}
+		args := t.positional()
+		switch {
+		case !kind.death:
+			if birth == "" {
+				birth = isoDate(args)
+			}
+		case death == "" && kind.years:
+			death = isoDate(args[:min(len(args), 1)])
+			if len(args) > 1 {
+				fallback = isoDate(args[1:2])
+			}
+		case death == "":
+			death = isoDate(args[:min(len(args), 3)])
+			if kind.age && len(args) > 3 {
+				fallback = isoDate(args[3:])
+			}
+		}
+	}
+	if birth == "" {
+		birth = fallback
+	}
+	return birth, death
+}
+
+// isoDate formats year, month and day parameters, stopping at the first
+// that is missing or out of range; a bad year gives no date at all
+func isoDate(args []string) string {
+	limits := []int{9999, 12, 31}
+	out := ""
+	for i, a := range args[:min(len(args), 3)] {
+		n, err := strconv.Atoi(a)
+		if err != nil || n < 1 || n > limits[i] {
+			break
+		}
+		if i == 0 {
+			out = fmt.Sprintf("%04d", n)
+		} else {
+			out += fmt.Sprintf("-%02d", n)
+		}
+	}
+	return out
+}
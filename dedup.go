@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"      // Package for formatted I/O
+	"hash/fnv" // Package for the Bloom filter's hash
+	"math"     // Package for Bloom filter sizing
+)
+
+// titleSet remembers titles already written so duplicates can be dropped
+type titleSet interface {
+	// addNew records title and reports whether it was not seen before
+	addNew(title string) bool
+}
+
+// newTitleSet builds the -dedup-mode strategy: "exact" keeps every title in
+// memory, "bloom" keeps a fixed-size bit array sized for cfg.DedupExpected titles
+func newTitleSet(cfg *config) (titleSet, error) {
+	switch cfg.DedupMode {
+	case "exact":
+		return exactSet{}, nil
+	case "bloom":
+		return newBloomSet(cfg.DedupExpected, cfg.DedupFPRate), nil
+	}
+	return nil, fmt.Errorf("unknown -dedup-mode %q (want exact or bloom)", cfg.DedupMode)
+}
+
+// exactSet is a plain map; memory grows with the number of titles
+type exactSet map[string]struct{}
+
+func (s exactSet) addNew(title string) bool {
+	if _, ok := s[title]; ok {
+		return false
+	}
+	s[title] = struct{}{}
+	return true
+}
+
+// bloomSet is a Bloom filter. It never lets a duplicate through, but with
+// probability about fpRate it mistakes a new title for a seen one and drops it.
+type bloomSet struct {
+	bits []uint64 // Bit array
+	m    uint64   // Number of bits
+	k    uint64   // Bits set per title
+}
+
+// newBloomSet sizes a filter for n titles at false-positive rate p
+func newBloomSet(n int, p float64) *bloomSet {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomSet{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// addNew sets the title's k bits, derived from two FNV hashes by double hashing
+func (b *bloomSet) addNew(title string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	h2 := h.Sum64() | 1
+	fresh := false
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			fresh = true
+			b.bits[word] |= mask
+		}
+	}
+	return fresh
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"         // Package for buffered output
+	"encoding/json" // Package for change records
+	"encoding/xml"  // Package for telling dumps from abstract files
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"hash/fnv"      // Package for content hashes
+	"io"            // Package for I/O primitives
+	"os"            // Package for OS functions (file access)
+	"sort"          // Package for ordering removed titles
+	"strings"       // Package for string manipulation
+	"time"          // Package for the workdir name
+)
+
+// diffConfig holds the settings of the diff subcommand
+type diffConfig struct {
+	Old         string   // Earlier dump or output
+	New         string   // Later dump or output
+	Output      string   // JSONL file of changes
+	Partitions  int      // Hash partitions the titles are spread over
+	Workdir     string   // Directory for the partition and extracted files
+	ExtractArgs []string // Extract flags given after "--", for dump inputs
+}
+
+// Change kinds, as in the change field
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// changeRecord is one line of the diff output. Removed articles carry no
+// abstract or hash, as the new side has none.
+type changeRecord struct {
+	Change   string `json:"change"`             // added, removed or modified
+	Title    string `json:"title"`              // Join key: the title from the URL, else the title
+	URL      string `json:"url,omitempty"`      // Page URL, from the new side unless removed
+	Abstract string `json:"abstract,omitempty"` // New abstract
+	Hash     string `json:"hash,omitempty"`     // Content hash of the new abstract
+
+	SourceFile   string `json:"source_file,omitempty"`   // The new doc's -with-provenance fields, as they are
+	SourceOffset *int64 `json:"source_offset,omitempty"` // Ditto
+}
+
+// diffSummary counts what diff found
+type diffSummary struct {
+	Added, Removed, Modified, Unchanged int // Titles per outcome
+	Duplicates                          int // Later docs of a title repeated on one side, ignored
+}
+
+// diffCommand writes the articles added, removed or modified between two
+// dumps or outputs, for incremental indexing
+func diffCommand(args []string) error {
+	cfg := &diffConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki diff", flag.ContinueOnError)
+	fs.StringVar(&cfg.Old, "old", "", "earlier dump or output `file` (.xml or .jsonl, optionally .gz/.bz2)")
+	fs.StringVar(&cfg.New, "new", "", "later dump or output `file`")
+	fs.StringVar(&cfg.Output, "o", "", "JSONL `file` the changes are written to")
+	fs.IntVar(&cfg.Partitions, "partitions", 64, "hash partitions spilled to disk; memory holds one partition of -old at a time")
+	fs.StringVar(&cfg.Workdir, "workdir", "", "`dir` for the partition files and extracted dumps (default: full-stream-wiki-<runid> under the temp dir)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	cfg.ExtractArgs = fs.Args()
+	var err error
+	switch {
+	case cfg.Old == "" || cfg.New == "" || cfg.Output == "":
+		err = fmt.Errorf("diff needs -old, -new and -o")
+	case cfg.Partitions < 1:
+		err = fmt.Errorf("-partitions must be positive")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+
+	sum, err := diffAbstracts(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Changes: %d added, %d removed, %d modified (%d unchanged) written to %s.\n",
+		sum.Added, sum.Removed, sum.Modified, sum.Unchanged, cfg.Output)
+	if sum.Duplicates > 0 {
+		fmt.Printf("Ignored %d later docs of titles repeated on one side.\n", sum.Duplicates)
+	}
+	return nil
+}
+
+// diffAbstracts joins the two sides on title through hash partitions on
+// disk, as compare-abstracts does: only the titles, URLs and content hashes
+// of one partition of -old are held in memory at a time
+func diffAbstracts(cfg *diffConfig) (sum *diffSummary, err error) {
+	work := newWorkdir(cfg.Workdir, time.Now())
+	defer func() { err = work.finish(err) }()
+
+	// 1. Extract the abstracts of dump inputs, then spread both sides over the partitions
+	var parts [2][]string
+	for i, side := range []struct{ name, path string }{{"old", cfg.Old}, {"new", cfg.New}} {
+		path, err := abstractsOf(cfg, side.name, side.path, work)
+		if err != nil {
+			return nil, err
+		}
+		if parts[i], err = partitionAbstracts(path, side.name, cfg.Partitions, work); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Create(cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	// 2. Join each partition in memory
+	type oldDoc struct {
+		hash uint64 // Content hash of the old abstract
+		url  string // Old page URL
+		seen bool   // A new doc had this title
+	}
+	sum = &diffSummary{}
+	for i := range parts[0] {
+		old := map[string]*oldDoc{}
+		err := readPartition(parts[0][i], func(title string, rec abstractRecord) {
+			if old[title] != nil {
+				sum.Duplicates++
+				return
+			}
+			old[title] = &oldDoc{hash: contentHash(rec.Abstract), url: rec.URL}
+		})
+		if err != nil {
+			return nil, err
+		}
+		added := map[string]bool{}
+		var werr error
+		err = readPartition(parts[1][i], func(title string, doc abstractRecord) {
+			if werr != nil {
+				return
+			}
+			h := contentHash(doc.Abstract)
+			rec := changeRecord{Title: title, URL: doc.URL, Abstract: doc.Abstract, Hash: fmt.Sprintf("%016x", h),
+				SourceFile: doc.SourceFile, SourceOffset: doc.SourceOffset}
+			switch o := old[title]; {
+			case added[title] || o != nil && o.seen:
+				sum.Duplicates++
+				return
+			case o == nil:
+				added[title] = true
+				sum.Added++
+				rec.Change = changeAdded
+			case o.hash != h:
+				o.seen = true
+				sum.Modified++
+				rec.Change = changeModified
+			default:
+				o.seen = true
+				sum.Unchanged++
+				return
+			}
+			werr = enc.Encode(rec)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if werr != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", cfg.Output, werr)
+		}
+
+		// What the new side lacks is removed, in title order so the output is reproducible
+		var removed []string
+		for title, o := range old {
+			if !o.seen {
+				removed = append(removed, title)
+			}
+		}
+		sort.Strings(removed)
+		for _, title := range removed {
+			sum.Removed++
+			if err := enc.Encode(changeRecord{Change: changeRemoved, Title: title, URL: old[title].url}); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", cfg.Output, err)
+			}
+		}
+	}
+
+	// 3. Finish the output
+	if err := buf.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", cfg.Output, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", cfg.Output, err)
+	}
+	return sum, nil
+}
+
+// contentHash is the FNV-1a hash of an abstract with its whitespace
+// collapsed, the same normalization compare-abstracts counts exact matches by
+func contentHash(abstract string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(strings.Fields(abstract), " ")))
+	return h.Sum64()
+}
+
+// abstractsOf returns path if it is an abstract file, or else extracts the
+// dump it holds to JSONL in the workdir with the flags after "--"
+func abstractsOf(cfg *diffConfig, side, path string, work *workdir) (string, error) {
+	dump, err := isDump(path)
+	if err != nil || !dump {
+		return path, err
+	}
+	f, err := work.create(side + "-*.jsonl")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	args := append(append([]string{}, cfg.ExtractArgs...), "-input", path, "-format", "jsonl", "-o", f.Name())
+	ecfg, err := parseFlags(args)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Extracting %s for -%s:\n", path, side)
+	if err := run(ecfg); err != nil {
+		return "", fmt.Errorf("extracting %s: %w", path, err)
+	}
+	return f.Name(), nil
+}
+
+// isDump tells a MediaWiki dump, whose root is <mediawiki>, from an abstract
+// file; JSONL files are never dumps
+func isDump(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	r, name, err := decompressByName(f, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson") {
+		return false, nil
+	}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local == "mediawiki", nil
+		}
+	}
+}
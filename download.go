@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"         // Package for reading checksum listings
+	"context"       // Package for request contexts
+	"crypto/md5"    // Package for MD5 checksums
+	"crypto/sha1"   // Package for SHA-1 checksums
+	"encoding/hex"  // Package for hex encoding
+	"encoding/json" // Package for the resume state file
+	"errors"        // Package for error values
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"hash"          // Package for hash interfaces
+	"io"            // Package for I/O primitives
+	"net/http"      // Package for HTTP client functionality
+	"os"            // Package for OS functions (file access)
+	"path"          // Package for URL path manipulation
+	"strconv"       // Package for string conversions
+	"strings"       // Package for string manipulation
+	"sync"          // Package for synchronization
+	"sync/atomic"   // Package for lock-free counters
+	"time"          // Package for progress timing
+)
+
+// downloadConfig holds the settings of the download subcommand
+type downloadConfig struct {
+	URL         string          // Dump URL
+	Output      string          // Destination file
+	Connections int             // Parallel ranged connections
+	ChunkSize   int64           // Bytes per ranged request
+	Checksum    string          // Expected "sha1:HEX" or "md5:HEX"; empty looks it up
+	NoVerify    bool            // Skip checksum verification
+	Extract     bool            // Run extract on the finished file
+	ExtractArgs []string        // Extract flags given after "--"
+	Auth        credentials     // Dump server credentials
+	Network     networkOptions  // -offline and -log-requests
+	Schedule    scheduleOptions // Off-peak window the transfer waits for
+	Options                     // Clock and retry sleeps
+}
+
+// downloadState is the sidecar file that makes an interrupted download resumable
+type downloadState struct {
+	URL       string `json:"url"`        // Source URL the ranges belong to, redacted
+	Size      int64  `json:"size"`       // Total size in bytes
+	ChunkSize int64  `json:"chunk_size"` // Bytes per chunk
+	Done      []bool `json:"done"`       // Completed chunks
+}
+
+// downloadCommand fetches a dump to disk with parallel ranged requests
+func downloadCommand(args []string) error {
+	cfg := &downloadConfig{Options: defaultOptions()}
+	fs := flag.NewFlagSet("full-stream-wiki download", flag.ContinueOnError)
+	fs.StringVar(&cfg.URL, "url", "", "dump URL (default: latest multistream dump for -project and -lang)")
+	lang := fs.String("lang", "en", "wiki language code used for the default dump URL")
+	project := fs.String("project", "wikipedia", "Wikimedia project used for the default dump URL (see extract -project)")
+	fs.StringVar(&cfg.Output, "o", "", "destination file (default: the URL's file name)")
+	fs.IntVar(&cfg.Connections, "connections", 2, "parallel ranged connections (dumps.wikimedia.org allows few per client)")
+	chunkMB := fs.Int64("chunk-mb", 64, "size of each ranged request in MiB")
+	fs.StringVar(&cfg.Checksum, "checksum", "", "expected `sha1:HEX` or `md5:HEX` (default: looked up in the dump's sha1sums file)")
+	fs.BoolVar(&cfg.NoVerify, "no-verify", false, "skip checksum verification")
+	authFlags(fs, &cfg.Auth)
+	networkFlags(fs, &cfg.Network)
+	scheduleFlags(fs, &cfg.Schedule)
+	fs.BoolVar(&cfg.Extract, "extract", false, "run extract on the finished file; extract flags follow \"--\"")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	cfg.Auth.fillFromEnv()
+	cfg.Network.install()
+	if cfg.URL == "" {
+		p, err := lookupProject(*project)
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			return &usageError{err}
+		}
+		cfg.URL = p.dumpURL(*lang, true)
+	}
+	if err := cfg.Auth.check(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	if err := cfg.Auth.fillFromNetrc(cfg.URL); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	if cfg.Output == "" {
+		cfg.Output = path.Base(cfg.URL)
+	}
+	if cfg.Connections < 1 || *chunkMB < 1 {
+		err := errors.New("-connections and -chunk-mb must be positive")
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	if err := cfg.Schedule.check(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	cfg.ChunkSize = *chunkMB << 20
+	cfg.ExtractArgs = fs.Args()
+
+	cfg.Schedule.wait(cfg.Options)
+	if err := download(cfg); err != nil {
+		return err
+	}
+	if !cfg.Extract {
+		return nil
+	}
+	ecfg, err := parseFlags(append(cfg.ExtractArgs, "-input", cfg.Output))
+	if err != nil {
+		return err
+	}
+	return run(ecfg)
+}
+
+// download performs the transfer, resumes from the sidecar state, and verifies the result
+func download(cfg *downloadConfig) error {
+	// 1. Probe the size and range support with a one-byte ranged request
+	size, ranged, err := probeRanges(cfg)
+	if err != nil {
+		return err
+	}
+
+	// 2. Transfer, in parallel ranges when the server allows it
+	if ranged {
+		err = downloadRanges(cfg, size)
+	} else {
+		fmt.Fprintln(os.Stderr, "server does not support range requests; using a single connection")
+		err = downloadSingle(cfg, size)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 3. Verify the finished file
+	if cfg.NoVerify {
+		fmt.Fprintf(os.Stderr, "Downloaded %s (checksum not verified).\n", cfg.Output)
+		return nil
+	}
+	return verifyDownload(cfg)
+}
+
+// probeRanges reports the resource size and whether byte ranges are honoured
+func probeRanges(cfg *downloadConfig) (size int64, ranged bool, err error) {
+	req, err := newRequest(context.Background(), http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := sendAuthed(req, cfg.Auth)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to reach %s: %w", redactURL(cfg.URL), err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-0/12345
+		_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if size, err = strconv.ParseInt(total, 10, 64); !ok || err != nil {
+			return 0, false, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		return size, true, nil
+	case http.StatusOK:
+		return resp.ContentLength, false, nil
+	default:
+		return 0, false, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+}
+
+// downloadRanges fetches fixed-size chunks over parallel connections into a sparse file
+func downloadRanges(cfg *downloadConfig, size int64) error {
+	// 1. Load or start the resume state
+	statePath := cfg.Output + ".state"
+	st := loadDownloadState(statePath, cfg.URL, size, cfg.ChunkSize)
+	var pending []int
+	already := size // Bytes already on disk from an earlier run
+	for i, done := range st.Done {
+		if !done {
+			pending = append(pending, i)
+			already -= min(int64(i+1)*st.ChunkSize, size) - int64(i)*st.ChunkSize
+		}
+	}
+	if len(pending) < len(st.Done) {
+		fmt.Fprintf(os.Stderr, "resuming: %d of %d chunks already complete\n", len(st.Done)-len(pending), len(st.Done))
+	}
+
+	// 2. Open the destination and size it up front so chunks can land anywhere
+	f, err := os.OpenFile(cfg.Output, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("failed to size output file: %w", err)
+	}
+
+	// 3. Fan the pending chunks out to the connections
+	chunks := make(chan int)
+	counters := make([]atomic.Int64, cfg.Connections)
+	var mu sync.Mutex // Guards st and the state file
+	var firstErr error
+	var wg sync.WaitGroup
+	for c := 0; c < cfg.Connections; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := range chunks {
+				start := int64(i) * st.ChunkSize
+				end := min(start+st.ChunkSize, size) - 1
+				err := fetchRange(cfg, f, start, end, &counters[c])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					st.Done[i] = true
+					if err := saveDownloadState(statePath, st); err != nil && firstErr == nil {
+						firstErr = err
+					}
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+
+	// 4. Report per-connection and aggregate throughput while the work runs
+	stopProgress := startProgress(&cfg.Options, counters, already, size)
+	for _, i := range pending {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		chunks <- i
+	}
+	close(chunks)
+	wg.Wait()
+	stopProgress()
+	if firstErr != nil {
+		return fmt.Errorf("download interrupted (rerun to resume): %w", firstErr)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	return os.Remove(statePath)
+}
+
+// fetchRange copies bytes [start, end] of the dump into f, retrying transient
+// failures after the server's Retry-After or a growing backoff
+func fetchRange(cfg *downloadConfig, f *os.File, start, end int64, counter *atomic.Int64) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			cfg.Sleep(retryWait(err, time.Duration(attempt)*2*time.Second))
+		}
+		var written int64
+		written, err = fetchRangeOnce(cfg, f, start, end, counter)
+		if err == nil {
+			return nil
+		}
+		counter.Add(-written) // The chunk is refetched from its start
+	}
+	return err
+}
+
+// fetchRangeOnce performs one ranged request for bytes [start, end]
+func fetchRangeOnce(cfg *downloadConfig, f *os.File, start, end int64, counter *atomic.Int64) (int64, error) {
+	req, err := newRequest(context.Background(), http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := sendAuthed(req, cfg.Auth)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, throttled(resp, cfg.NowFunc(), fmt.Errorf("range %d-%d: %w: %s", start, end, ErrBadStatus, resp.Status))
+	}
+	w := &countingWriter{w: io.NewOffsetWriter(f, start), n: counter}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, end-start+1))
+	if err == nil && n != end-start+1 {
+		err = fmt.Errorf("range %d-%d: short body (%d bytes)", start, end, n)
+	}
+	return n, err
+}
+
+// downloadSingle streams the whole resource over one connection
+func downloadSingle(cfg *downloadConfig, size int64) error {
+	resp, err := httpGet(context.Background(), cfg.URL, cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to download dump: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+	f, err := os.Create(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+	counters := make([]atomic.Int64, 1)
+	stopProgress := startProgress(&cfg.Options, counters, 0, size)
+	_, err = io.Copy(&countingWriter{w: f, n: &counters[0]}, resp.Body)
+	stopProgress()
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	return f.Close()
+}
+
+// countingWriter adds every written byte to a shared counter
+type countingWriter struct {
+	w io.Writer     // Destination
+	n *atomic.Int64 // Running total
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// startProgress reports transfer progress until the returned function is called,
+// which prints the final figures before returning
+func startProgress(opts *Options, counters []atomic.Int64, already, size int64) (stop func()) {
+	stopc, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		reportProgress(opts, counters, already, size, stopc)
+	}()
+	return func() {
+		close(stopc)
+		<-done
+	}
+}
+
+// reportProgress prints per-connection and aggregate throughput every second until stop closes
+func reportProgress(opts *Options, counters []atomic.Int64, already, size int64, stop <-chan struct{}) {
+	tick, stopTick := opts.Ticker(time.Second)
+	defer stopTick()
+	last := make([]int64, len(counters))
+	lastAt := opts.NowFunc()
+	for {
+		final := false
+		select {
+		case <-stop:
+			final = true
+		case <-tick:
+		}
+		// Rates are per second of elapsed time, which a final report cuts short
+		now := opts.NowFunc()
+		secs := max(now.Sub(lastAt).Seconds(), 0.001)
+		lastAt = now
+		var b strings.Builder
+		var total, rate int64
+		for i := range counters {
+			n := counters[i].Load()
+			fmt.Fprintf(&b, " c%d %s/s", i+1, humanBytes(int64(float64(n-last[i])/secs)))
+			rate += n - last[i]
+			total += n
+			last[i] = n
+		}
+		rate = int64(float64(rate) / secs)
+		done := already + total
+		pct := 100.0
+		if size > 0 {
+			pct = 100 * float64(min(done, size)) / float64(size)
+		}
+		fmt.Fprintf(os.Stderr, "\r%5.1f%% %s/s total |%s ", pct, humanBytes(rate), b.String())
+		if final {
+			fmt.Fprintln(os.Stderr)
+			return
+		}
+	}
+}
+
+// humanBytes formats a byte count with a binary unit
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// loadDownloadState reads the sidecar state, starting fresh when it describes
+// another transfer. The state holds the URL redacted, as it is kept on disk.
+func loadDownloadState(path, url string, size, chunkSize int64) *downloadState {
+	url = redactURL(url)
+	var st downloadState
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &st) == nil &&
+		st.URL == url && st.Size == size && st.ChunkSize > 0 {
+		return &st
+	}
+	n := (size + chunkSize - 1) / chunkSize
+	return &downloadState{URL: url, Size: size, ChunkSize: chunkSize, Done: make([]bool, n)}
+}
+
+// saveDownloadState atomically replaces the sidecar state
+func saveDownloadState(path string, st *downloadState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save download state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// verifyDownload compares the file against the expected or published checksum
+func verifyDownload(cfg *downloadConfig) error {
+	// 1. Determine the expected digest
+	algo, want, ok := strings.Cut(cfg.Checksum, ":")
+	if cfg.Checksum == "" {
+		var err error
+		algo, want, err = publishedChecksum(cfg.URL, cfg.Auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Downloaded %s (checksum not verified: %v).\n", cfg.Output, err)
+			return nil
+		}
+	} else if !ok {
+		return fmt.Errorf("invalid -checksum %q (want sha1:HEX or md5:HEX)", cfg.Checksum)
+	}
+	var h hash.Hash
+	switch algo {
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+
+	// 2. Hash the file and compare
+	f, err := os.Open(cfg.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", cfg.Output, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("%w for %s: %s is %s, want %s (delete the file to start over)", ErrChecksumMismatch, cfg.Output, algo, got, want)
+	}
+	fmt.Fprintf(os.Stderr, "Downloaded %s (%s verified).\n", cfg.Output, algo)
+	return nil
+}
+
+// publishedChecksum looks up a dump file's SHA-1 in the sha1sums listing Wikimedia
+// publishes next to it, e.g. enwiki-latest-sha1sums.txt
+func publishedChecksum(url string, creds credentials) (algo, sum string, err error) {
+	name := path.Base(url)
+	prefix, _, ok := strings.Cut(name, "-pages-")
+	if !ok {
+		return "", "", fmt.Errorf("no checksum listing known for %s", name)
+	}
+	resp, err := httpGet(context.Background(), strings.TrimSuffix(url, name)+prefix+"-sha1sums.txt", creds)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("checksum listing: %w: %s", ErrBadStatus, resp.Status)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		// Each line is "<hex>  <file name>"
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return "sha1", fields[0], nil
+		}
+	}
+	return "", "", fmt.Errorf("%s not listed in the checksum file", name)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"       // Package for request cancellation
+	"encoding/json" // Package for the summary response
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"net/http"      // Package for HTTP client functionality
+	"net/url"       // Package for escaping titles
+	"strings"       // Package for string manipulation
+	"time"          // Package for the rate limit
+)
+
+// summaryTimeout bounds one REST summary request, so a stalled call costs one doc its enrichment, not the run
+const summaryTimeout = 10 * time.Second
+
+// summaryEnricher fills Doc.Image and Doc.ShortDescription from the REST
+// page/summary endpoint (-enrich-summary), one request per doc at no more
+// than -enrich-rate requests a second. A failed request leaves the doc as it is.
+type summaryEnricher struct {
+	endpoint string          // Summary URL the escaped title is appended to
+	every    time.Duration   // Minimum spacing of requests
+	next     time.Time       // Earliest time of the next request
+	client   *http.Client    // Client with summaryTimeout
+	opts     *Options        // Clock and Sleep for the rate limit
+	ctx      context.Context // The run's, ending requests on -timeout
+	requests int             // Requests sent
+	failed   int             // Requests that failed
+}
+
+// summaryResponse is the part of a page/summary response the enricher reads
+type summaryResponse struct {
+	Description string `json:"description"` // Short description, from Wikidata or {{Short description}}
+	Thumbnail   struct {
+		Source string `json:"source"` // Thumbnail URL of the lead image
+	} `json:"thumbnail"`
+}
+
+func newSummaryEnricher(cfg *config) *summaryEnricher {
+	endpoint := cfg.EnrichEndpoint
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(cfg.Project.base(cfg.Lang), "/wiki/") + "/api/rest_v1/page/summary/"
+	}
+	return &summaryEnricher{
+		endpoint: endpoint,
+		every:    time.Duration(float64(time.Second) / cfg.EnrichRate),
+		client:   newHTTPClient(summaryTimeout),
+		opts:     &cfg.Options,
+		ctx:      cfg.ctx(),
+	}
+}
+
+// enrich fills whichever of the doc's image and short description are still missing
+func (e *summaryEnricher) enrich(doc *Doc) {
+	if doc.Image != "" && doc.ShortDescription != "" {
+		return
+	}
+	e.requests++
+	s, err := e.fetch(doc.Title)
+	if err != nil {
+		e.failed++
+		return
+	}
+	if doc.Image == "" {
+		doc.Image = s.Thumbnail.Source
+	}
+	if doc.ShortDescription == "" {
+		doc.ShortDescription = s.Description
+	}
+}
+
+// fetch waits for the rate limit and requests the summary of title
+func (e *summaryEnricher) fetch(title string) (*summaryResponse, error) {
+	now := e.opts.NowFunc()
+	if wait := e.next.Sub(now); wait > 0 {
+		e.opts.Sleep(wait)
+		now = e.next
+	}
+	e.next = now.Add(e.every)
+
+	req, err := newRequest(e.ctx, http.MethodGet, e.endpoint+url.PathEscape(strings.ReplaceAll(title, " ", "_")), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if wait, ok := retryAfter(resp, now); ok {
+		e.next = now.Add(max(wait, e.every)) // Hold the following requests back as asked
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+	var s summaryResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors" // Package for error values
+)
+
+// Sentinel errors for the failure modes callers may want to tell apart with
+// errors.Is; the errors returned wrap them with the details.
+var (
+	ErrBadStatus        = errors.New("bad status")                             // A server answered with an unexpected HTTP status
+	ErrTruncatedStream  = errors.New("dump stream ended without </mediawiki>") // The dump was cut off mid-document
+	ErrChecksumMismatch = errors.New("checksum mismatch")                      // A downloaded dump does not match its checksum
+	ErrCancelled        = errors.New("interrupted")                            // The run was stopped by SIGINT/SIGTERM
+	ErrOffline          = errors.New("network access disabled by -offline")    // A request was made under -offline
+)
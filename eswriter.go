@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"         // Package for building request bodies
+	"context"       // Package for request cancellation
+	"encoding/json" // Package for JSON encoding/decoding
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"net/http"      // Package for HTTP client functionality
+	"os"            // Package for OS functions (standard streams)
+	"strconv"       // Package for string conversions
+	"strings"       // Package for string manipulation
+	"time"          // Package for retry backoff
+)
+
+// esAttempts bounds how often a batch, or an item the cluster rejected as
+// temporarily unavailable, is sent before the run fails
+const esAttempts = 4
+
+// esWriter indexes docs into Elasticsearch through the _bulk API, keyed by page ID
+type esWriter struct {
+	url      string          // <es-url>/_bulk
+	index    string          // Target index
+	batch    []*Doc          // Docs waiting for the next request
+	size     int             // Docs per request
+	opts     *Options        // Sleep between retries
+	ctx      context.Context // The run's; ending it aborts the request in flight
+	rejected int             // Docs refused permanently (mapping errors and the like)
+}
+
+func newESWriter(_ io.Writer, cfg *config) (docWriter, error) {
+	return &esWriter{
+		url:   strings.TrimSuffix(cfg.ESURL, "/") + "/_bulk",
+		index: cfg.ESIndex,
+		size:  cfg.ESBatch,
+		opts:  &cfg.Options,
+		ctx:   cfg.ctx(),
+	}, nil
+}
+
+func (e *esWriter) WriteDoc(doc *Doc) error {
+	d := *doc
+	e.batch = append(e.batch, &d)
+	if len(e.batch) < e.size {
+		return nil
+	}
+	return e.flush()
+}
+
+// Close sends the final partial batch
+func (e *esWriter) Close() error {
+	err := e.flush()
+	if e.rejected > 0 {
+		fmt.Fprintf(os.Stderr, "warning: Elasticsearch rejected %d docs\n", e.rejected)
+	}
+	return err
+}
+
+// bulkResponse is the part of a _bulk reply needed to find failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"` // Whether any item failed
+	Items  []map[string]struct {
+		Status int             `json:"status"` // Per-item HTTP status
+		Error  json.RawMessage `json:"error"`  // Failure reason, absent on success
+	} `json:"items"`
+}
+
+// flush sends the batch, resending only the items that failed with a
+// retryable status (429 or 5xx) until they succeed or attempts run out
+func (e *esWriter) flush() error {
+	pending := e.batch
+	e.batch = e.batch[:0:0]
+	var err error // Of the last attempt, whose Retry-After sets the wait
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			e.opts.Sleep(retryWait(err, time.Duration(attempt-1)*time.Second))
+		}
+		var resp *bulkResponse
+		resp, err = e.send(pending)
+		if cerr := e.ctx.Err(); cerr != nil {
+			return fmt.Errorf("elasticsearch bulk request of %d docs abandoned: %w", len(pending), cerr) // Not worth a retry
+		}
+		if err != nil {
+			if attempt == esAttempts {
+				return fmt.Errorf("elasticsearch bulk request: %w", err)
+			}
+			continue // The whole batch is resent
+		}
+		if !resp.Errors {
+			return nil
+		}
+		if len(resp.Items) != len(pending) {
+			return fmt.Errorf("elasticsearch bulk response has %d items for %d docs", len(resp.Items), len(pending))
+		}
+		var retry []*Doc
+		for i, item := range resp.Items {
+			for _, result := range item { // One entry, keyed by the action name
+				switch {
+				case result.Status < 300:
+				case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+					retry = append(retry, pending[i])
+				default:
+					e.rejected++
+					fmt.Fprintf(os.Stderr, "warning: Elasticsearch rejected %q: %s\n", pending[i].Title, result.Error)
+				}
+			}
+		}
+		if len(retry) > 0 && attempt == esAttempts {
+			return fmt.Errorf("elasticsearch kept refusing %d docs after %d attempts", len(retry), esAttempts)
+		}
+		pending = retry
+	}
+	return nil
+}
+
+// send posts one bulk request; a non-2xx reply is an error for the whole batch
+func (e *esWriter) send(docs []*Doc) (*bulkResponse, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, doc := range docs {
+		action := map[string]map[string]string{"index": {"_index": e.index, "_id": strconv.FormatInt(doc.ID, 10)}}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	req, err := newRequest(e.ctx, http.MethodPost, e.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, throttled(resp, e.opts.NowFunc(), fmt.Errorf("%w: %s: %s", ErrBadStatus, resp.Status, bytes.TrimSpace(msg)))
+	}
+	var br bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, fmt.Errorf("invalid bulk response: %w", err)
+	}
+	return &br, nil
+}
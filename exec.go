@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"       // Package for line-oriented reading
+	"context"     // Package for killing the child on -timeout
+	"errors"      // Package for error inspection
+	"fmt"         // Package for formatted I/O
+	"io"          // Package for I/O primitives
+	"os"          // Package for OS functions (standard streams)
+	"os/exec"     // Package for running child processes
+	"os/signal"   // Package for signal notification
+	"sync/atomic" // Package for lock-free flags
+	"syscall"     // Package for signal numbers
+)
+
+// errInterrupted is returned by writes once SIGINT/SIGTERM has been received
+var errInterrupted = fmt.Errorf("%w by signal", ErrCancelled)
+
+// exitCodeError carries the exit status the process should terminate with
+type exitCodeError struct {
+	code int   // Process exit status
+	err  error // Underlying cause
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// execSink streams output into the stdin of a child command
+type execSink struct {
+	command     string          // Command line, for messages
+	cmd         *exec.Cmd       // Running child
+	stdin       io.WriteCloser  // Pipe into the child
+	stderrDone  chan struct{}   // Closed once the child's stderr is drained
+	sigs        chan os.Signal  // SIGINT/SIGTERM deliveries to forward
+	interrupted atomic.Bool     // Set when a signal arrived
+	ctx         context.Context // The run's; when it ends, the child is killed
+	stopKill    func() bool     // Cancels the kill once the child is reaped
+}
+
+// startExec spawns command in its own process group with stdin piped from
+// us; the group is killed when ctx ends before the child exits
+func startExec(ctx context.Context, command string) (*execSink, error) {
+	cmd := shellCommand(command)
+	cmd.Stdout = os.Stdout
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("exec %q: %w", command, err)
+	}
+	s := &execSink{
+		command:    command,
+		cmd:        cmd,
+		stdin:      stdin,
+		stderrDone: make(chan struct{}),
+		sigs:       make(chan os.Signal, 1),
+		ctx:        ctx,
+	}
+	s.stopKill = context.AfterFunc(ctx, func() {
+		if !signalled(ctx) { // A signal reaches the child below instead
+			signalGroup(cmd.Process, syscall.SIGKILL)
+		}
+	})
+
+	// Pass the child's stderr through, prefixed so it is distinguishable from ours
+	go func() {
+		defer close(s.stderrDone)
+		sc := bufio.NewScanner(stderr)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			fmt.Fprintf(os.Stderr, "[exec] %s\n", sc.Text())
+		}
+	}()
+
+	// Forward SIGINT/SIGTERM to the child's process group and stop writing
+	signal.Notify(s.sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range s.sigs {
+			s.interrupted.Store(true)
+			signalGroup(cmd.Process, sig)
+		}
+	}()
+	return s, nil
+}
+
+func (s *execSink) Write(p []byte) (int, error) {
+	if s.interrupted.Load() || signalled(s.ctx) {
+		return 0, errInterrupted
+	}
+	n, err := s.stdin.Write(p)
+	if cerr := s.ctx.Err(); cerr != nil && !signalled(s.ctx) {
+		return n, fmt.Errorf("exec %q killed: %w", s.command, cerr)
+	}
+	if err != nil {
+		return n, fmt.Errorf("exec %q stopped reading: %w", s.command, err)
+	}
+	return n, nil
+}
+
+// Close ends the child's input and waits for it, failing on a non-zero exit
+func (s *execSink) Close() error {
+	s.stdin.Close()
+	err := s.wait()
+	if s.interrupted.Load() || signalled(s.ctx) {
+		return &exitCodeError{code: 130, err: errInterrupted}
+	}
+	if cerr := s.ctx.Err(); cerr != nil && err != nil {
+		return fmt.Errorf("exec %q killed: %w", s.command, cerr)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &exitCodeError{
+			code: exitErr.ExitCode(),
+			err:  fmt.Errorf("exec %q: %w", s.command, err),
+		}
+	}
+	return err
+}
+
+// Abort kills the child's process group after a failure on our side. When the
+// child had already exited with a status of its own, that status wins.
+func (s *execSink) Abort(cause error) error {
+	signalGroup(s.cmd.Process, syscall.SIGKILL)
+	s.stdin.Close()
+	s.wait()
+	if code := s.cmd.ProcessState.ExitCode(); code > 0 {
+		return &exitCodeError{code: code, err: cause}
+	}
+	return cause
+}
+
+// wait reaps the child after its stderr has been fully relayed
+func (s *execSink) wait() error {
+	<-s.stderrDone
+	err := s.cmd.Wait()
+	s.stopKill()
+	signal.Stop(s.sigs)
+	close(s.sigs)
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"      // Package for OS process handles
+	"os/exec" // Package for running child processes
+)
+
+// shellCommand runs command through the Windows command interpreter
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// signalGroup stops the child; without process groups only a kill is available
+func signalGroup(p *os.Process, sig os.Signal) {
+	p.Kill()
+}
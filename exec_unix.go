@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"      // Package for OS process handles
+	"os/exec" // Package for running child processes
+	"syscall" // Package for process groups and signals
+)
+
+// shellCommand runs command through sh in a new process group
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// signalGroup delivers sig to every process in p's process group
+func signalGroup(p *os.Process, sig os.Signal) {
+	if s, ok := sig.(syscall.Signal); ok {
+		syscall.Kill(-p.Pid, s)
+	}
+}
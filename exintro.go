@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"      // Package for string manipulation
+	"unicode"      // Package for word characters
+	"unicode/utf8" // Package for counting characters
+)
+
+// abstractModes are the values -abstract-mode accepts
+var abstractModes = []string{"first-paragraph", "exintro"}
+
+// exintroAbstract approximates what the TextExtracts API returns for
+// prop=extracts&exintro&explaintext: the whole lead, cleaned, with
+// references removed (the cleaner drops them in this mode) and each
+// paragraph on its own, separated by one blank line. -exsentences or
+// -exchars then shorten it as the API parameters of the same names do.
+func (c *cleaner) exintroAbstract(text string) string {
+	var paras []string
+	for _, para := range paragraphRe.Split(c.clean(leadSection(text)), -1) {
+		if para = tidyPunctuation(collapseSpace(para)); para != "" {
+			paras = append(paras, para)
+		}
+	}
+	switch {
+	case c.exSentences > 0:
+		paras = firstSentences(paras, c.exSentences)
+	case c.exChars > 0:
+		return truncateChars(strings.Join(paras, "\n\n"), c.exChars)
+	}
+	return strings.Join(paras, "\n\n")
+}
+
+// firstSentences keeps the first n sentences of paras, which may end
+// inside a paragraph
+func firstSentences(paras []string, n int) []string {
+	var out []string
+	for _, para := range paras {
+		sentences := splitSentences(para)
+		if len(sentences) >= n {
+			return append(out, strings.Join(sentences[:n], " "))
+		}
+		out = append(out, para)
+		n -= len(sentences)
+	}
+	return out
+}
+
+// truncateChars cuts text after n characters, at the end of the word the
+// cut falls in, and marks the cut with an ellipsis, as TextExtracts does
+// for exchars. Text of at most n characters is returned as is.
+func truncateChars(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	cut := 0
+	for i := 0; i < n; i++ {
+		_, size := utf8.DecodeRuneInString(text[cut:])
+		cut += size
+	}
+	for cut < len(text) {
+		r, size := utf8.DecodeRuneInString(text[cut:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		cut += size
+	}
+	if cut == len(text) {
+		return text
+	}
+	return strings.TrimRightFunc(text[:cut], unicode.IsSpace) + "…"
+}
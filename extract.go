@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bufio"        // Package for buffered output
+	"encoding/xml" // Package for XML encoding/decoding
+	"errors"       // Package for error inspection
+	"fmt"          // Package for formatted I/O
+	"io"           // Package for I/O primitives
+	"os"           // Package for OS functions (standard streams)
+	"strings"      // Package for string manipulation
+)
+
+// stats counts what happened to the pages of one run
+type stats struct {
+	Pages          int             // <page> elements decoded
+	Filtered       int             // Pages dropped by namespace, redirect, template or -bbox filters
+	Empty          int             // Pages whose abstract came out empty
+	LowScore       int             // Pages below -min-score
+	Classes        map[string]int  // Written docs per length class (-classify)
+	TemplateHits   map[string]int  // Pages matched per -has-template/-not-template rule
+	GeoTagged      int             // Pages -bbox found a {{coord}} in
+	GeoPassed      int             // Of those, pages with one inside the box
+	DecodeErrors   int             // Pages skipped because they could not be decoded
+	ErrorKinds     map[string]int  // DecodeErrors per error kind
+	Duplicates     int             // Pages dropped by -dedup as already seen
+	InvalidURLs    int             // Docs whose URL failed -validate-urls
+	Vetoed         int             // Docs a DocMiddleware vetoed
+	ChainErrors    int             // Docs skipped as a DocMiddleware failed on them
+	QIDMatched     int             // Docs given a wikidata_id
+	QIDUnmatched   int             // Docs whose title is not in the -wikidata mapping
+	OutOfRange     int             // Pages outside -min-id/-max-id
+	ShardPages     int             // Pages in -min-id/-max-id that belong to this run's -shard-index
+	Truncated      bool            // The stream ended before </mediawiki>
+	TimedOut       int             // Pages whose cleanup ran past -page-timeout
+	Written        int             // Docs handed to the writer
+	Skipped        int             // Docs passing every filter dropped by -skip
+	LimitReached   bool            // The run stopped at -limit
+	Sampled        int             // Docs of those kept by -sample-k
+	Enriched       int             // Docs -enrich-summary requested a summary for
+	CacheHits      int             // Pages -cache had the result of
+	CacheMisses    int             // Pages cleaned and added to -cache
+	Top            *outliers       // Top -top-n pages by size and cleanup time (nil: not tracked)
+	Ages           *revisionAges   // Revision ages of written docs (nil: -revision-age not set)
+	EnrichFailed   int             // Of those, requests that failed
+	OutputCapped   bool            // The run stopped at -max-output-bytes
+	SimilarChecked int             // -similarity candidate pairs scored
+	SimilarPairs   int             // Of those, pairs reaching the threshold
+	SiteInfo       *SiteInfo       // The dump's <siteinfo>, once read
+	DumpVersion    string          // Export schema version of the <mediawiki> root
+	NoNS           int             // Pages without <ns>, their namespace taken from the title
+	Anomalies      anomalies       // Signs of a broken or partial dump
+	Products       []productRecord // Side products of -products, with their counts
+}
+
+// errLimitReached ends the scan once -limit docs are written; extract turns
+// it into a normal end with st.LimitReached set
+var errLimitReached = errors.New("wrote -limit docs")
+
+// exitIncomplete is the exit status of a run whose dump stream was cut off
+const exitIncomplete = 4
+
+// isTruncation reports whether err means the stream stopped mid-document,
+// as a truncated download does, rather than containing malformed XML
+func isTruncation(err error) bool {
+	var syntaxErr *xml.SyntaxError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF"
+}
+
+// incomplete returns the error a run ends with when its stream was cut off
+func (st *stats) incomplete() error {
+	if !st.Truncated {
+		return nil
+	}
+	return &exitCodeError{
+		code: exitIncomplete,
+		err:  fmt.Errorf("%w after %d pages; output is incomplete", ErrTruncatedStream, st.Pages),
+	}
+}
+
+// scanPages decodes every <page> element in r and hands it to fn. Pages that
+// cannot be decoded are skipped and counted until they exceed cfg.Budget. A
+// page that is not well-formed is decoded again leniently (see lenientPage);
+// malformed XML outside pages still stops the scan, as nothing after it can be trusted.
+// A stream that is cut off ends the scan normally with st.Truncated set.
+func scanPages(r io.Reader, cfg *config, st *stats, fn func(p *page) error) error {
+	// 1. Initialize the XML decoder to read from the decompressed stream
+	rr := newByteRecorder(r)
+	dec := xml.NewDecoder(rr)
+	var base int64 // Stream offset of the decoder's first byte, past lenient pages
+	offset := func() int64 { return base + dec.InputOffset() }
+	truncated := func() error {
+		st.Truncated = true
+		st.Anomalies.add(anomalyNoRootEnd, fmt.Sprintf("stream cut off after %d pages", st.Pages))
+		return nil
+	}
+	// cancelled is the error of a -timeout or a signal, which may also
+	// surface as a failed read of the aborted download
+	cancelled := func() error {
+		if cfg.ctx().Err() == nil {
+			return nil
+		}
+		return cfg.ctxErr(fmt.Sprintf("after %d pages", st.Pages))
+	}
+	rooted := false       // The <mediawiki> root has been checked
+	var schema dumpSchema // Its namespace, prefix and version
+
+	// 2. Loop through tokens until EOF
+	for {
+		off := offset() // Where a <page> token would start
+		rr.mark(dec.InputOffset())
+		tok, err := dec.Token()
+		if cerr := cancelled(); err != nil && cerr != nil {
+			return cerr
+		}
+		if err == io.EOF && !rooted {
+			return errors.New("not a MediaWiki dump: the input has no root element")
+		}
+		if err == io.EOF {
+			if !st.Anomalies.rootClosed {
+				st.Anomalies.add(anomalyNoRootEnd, fmt.Sprintf("stream ended after %d pages", st.Pages))
+			}
+			return nil // End of file
+		}
+		if isTruncation(err) {
+			return truncated()
+		}
+		if err != nil && !rooted {
+			return fmt.Errorf("not a MediaWiki dump: %w", err)
+		}
+		if err != nil {
+			return fmt.Errorf("XML token error: %w", err)
+		}
+
+		// 3. Check the root, keep the first <siteinfo> and filter for start elements named <page>
+		start, ok := tok.(xml.StartElement)
+		if ok && start.Name.Local == "mediawiki" && st.Anomalies.rootClosed && len(cfg.Inputs) > 1 {
+			if schema, err = checkRoot(start); err != nil { // The next -input part, perhaps of another version
+				return err
+			}
+			rr.schema = schema
+			st.Anomalies.rootClosed = false
+			continue
+		}
+		if ok && !rooted {
+			if schema, err = checkRoot(start); err != nil {
+				return err
+			}
+			st.DumpVersion, rr.schema = schema.version, schema
+			rooted = true
+			continue
+		}
+		if ok && schema.is(start.Name, "siteinfo") && st.SiteInfo == nil {
+			st.SiteInfo = &SiteInfo{}
+			err := dec.DecodeElement(st.SiteInfo, &start)
+			if isTruncation(err) {
+				return truncated()
+			}
+			if err != nil {
+				return fmt.Errorf("failed to decode siteinfo: %w", err)
+			}
+			continue
+		}
+		if end, isEnd := tok.(xml.EndElement); isEnd && schema.is(end.Name, "mediawiki") {
+			st.Anomalies.rootClosed = true
+		}
+		if !ok || !schema.is(start.Name, "page") {
+			continue // Not a <page> start element
+		}
+
+		// 4. Decode the <page> element, skipping its text when the ID is out of range
+		if cfg.stopped() {
+			return &exitCodeError{code: 130, err: errInterrupted}
+		}
+		if err := cancelled(); err != nil {
+			return err
+		}
+		p, inRange, err := decodePage(dec, cfg, schema)
+		if cerr := cancelled(); err != nil && cerr != nil {
+			return cerr
+		}
+		if isTruncation(err) {
+			return truncated() // The page being read is lost
+		}
+		end := offset()
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			strictErr := err
+			var length int64
+			p, length, base, err = rr.lenientPage()
+			if isTruncation(err) {
+				return truncated()
+			}
+			dec, end = xml.NewDecoder(rr), off+length
+			if err != nil {
+				err = &pageError{kind: "malformed XML"}
+			} else {
+				inRange = cfg.idInRange(p.ID) && cfg.Shard.owns(p)
+				st.Anomalies.add(anomalyLenient, fmt.Sprintf("page ID %d %q at offset %d: %v", p.ID, forDisplay(p.Title), off, strictErr))
+			}
+		}
+		if err == nil {
+			st.Anomalies.page(p, off, inRange)
+		}
+		if err == nil && strings.TrimSpace(p.Title) == "" {
+			err = &pageError{kind: "missing title"}
+		}
+		if err == nil && !p.hasNS {
+			p.NS = st.SiteInfo.namespaceOf(p.Title) // As in exports of old schema versions
+			st.NoNS++
+		}
+		if err != nil {
+			st.recordError(err)
+			if cfg.Budget.exceeded(st) {
+				return errBudgetExceeded(st)
+			}
+			continue
+		}
+		p.Offset, p.Length = off, end-off
+		if cfg.Streams != nil { // Here, as the tracker forgets what lies before off
+			p.StreamOffset, p.StreamIndex = cfg.Streams.lookup(off)
+			if !cfg.Multistream {
+				p.StreamIndex = 0
+			}
+		}
+		if cfg.Sources != nil {
+			p.Source, p.SourceOffset = cfg.Sources.locate(off)
+			p.Source.Pages++
+		}
+		st.Pages++
+		cfg.Profiler.page(st.Pages)
+		if cfg.Shard != nil && cfg.idInRange(p.ID) && cfg.Shard.owns(p) {
+			st.ShardPages++
+		}
+		if !inRange {
+			if !cfg.idInRange(p.ID) {
+				st.OutOfRange++
+			}
+			st.Filtered++
+			continue
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// decodePage reads the children of a <page> whose start tag was just read.
+// Dumps give the page <id> before its revisions, so a page outside
+// -min-id/-max-id or another -shard-index is skipped without its text being
+// decoded. Children outside the dump's namespace are skipped.
+func decodePage(dec *xml.Decoder, cfg *config, schema dumpSchema) (p *page, inRange bool, err error) {
+	p = &page{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return p, cfg.Shard.owns(p), nil // </page>; children are consumed whole below
+		case xml.StartElement:
+			name := t.Name.Local
+			if t.Name.Space != schema.space {
+				name = "" // Foreign markup
+			}
+			switch name {
+			case "title":
+				err = dec.DecodeElement(&p.Title, &t)
+			case "ns":
+				err = dec.DecodeElement(&p.NS, &t)
+				p.hasNS = true
+			case "id":
+				// The title comes first in dumps, so the shard is known here too
+				if err = dec.DecodeElement(&p.ID, &t); err == nil && (!cfg.idInRange(p.ID) || p.Title != "" && !cfg.Shard.owns(p)) {
+					return p, false, dec.Skip()
+				}
+			case "redirect":
+				err = dec.DecodeElement(&p.Redirect, &t)
+			case "revision":
+				err = dec.DecodeElement(&p.Revision, &t)
+				p.hasRevision = true
+			default:
+				err = dec.Skip()
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+}
+
+// idInRange reports whether a page ID lies within -min-id and -max-id
+func (cfg *config) idInRange(id int64) bool {
+	return id >= cfg.MinID && (cfg.MaxID == 0 || id <= cfg.MaxID)
+}
+
+// namespaceFilter reports whether a page's namespace is selected by cfg
+func namespaceFilter(cfg *config) func(ns int) bool {
+	keep := make(map[int]bool, len(cfg.Namespaces))
+	for _, ns := range cfg.Namespaces {
+		keep[ns] = true
+	}
+	return func(ns int) bool { return len(keep) == 0 || keep[ns] }
+}
+
+// extract streams pages from r, turns them into docs, and writes them to w
+func extract(r io.Reader, cfg *config, w docWriter) (*stats, error) {
+	st := &stats{}
+	if cfg.TopN > 0 {
+		st.Top = newOutliers(cfg.TopN)
+	}
+	if cfg.RevisionAge {
+		st.Ages = newRevisionAges(cfg)
+	}
+	c := newCleaner(cfg)
+	inNS := namespaceFilter(cfg)
+	tf := newTemplateFilter(cfg)
+	var qids qidIndex
+	defer func() {
+		if qids != nil {
+			qids.Close()
+		}
+	}()
+	observers, err := newObservers(cfg)
+	if err != nil {
+		return st, err
+	}
+	defer func() {
+		for _, o := range observers {
+			o.close()
+		}
+	}()
+	// begin runs before the first page, when the siteinfo has set the title case rule
+	begun := false
+	begin := func() error {
+		begun = true
+		if err := useSiteInfo(cfg, st.SiteInfo, c, w); err != nil {
+			return err
+		}
+		for _, o := range observers {
+			if b, ok := o.(interface{ begin(s *SiteInfo) }); ok {
+				b.begin(st.SiteInfo)
+			}
+		}
+		if cfg.Wikidata != "" {
+			var err error
+			if qids, err = openQIDIndex(cfg.Wikidata, cfg.WikidataOnDisk, cfg.TitleKey.wikidataKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var seen titleSet
+	if cfg.Dedup {
+		var err error
+		if seen, err = newTitleSet(cfg); err != nil {
+			return st, err
+		}
+	}
+	var slugs *slugger
+	if cfg.Slug {
+		slugs = newSlugger(cfg)
+	}
+	var summaries *summaryEnricher
+	if cfg.EnrichSummary {
+		summaries = newSummaryEnricher(cfg)
+	}
+	var offsets *offsetWriter
+	if cfg.Offsets != "" {
+		var err error
+		if offsets, err = createOffsetWriter(cfg.Offsets, cfg.MaxFieldBytes); err != nil {
+			return st, err
+		}
+		defer offsets.Close()
+	}
+	var similar *similarityFinder
+	if cfg.Similarity != "" {
+		var err error
+		if similar, err = newSimilarityFinder(cfg); err != nil {
+			return st, err
+		}
+		defer similar.Close()
+	}
+	builder := newDocBuilder(cfg, cfg.Lang, st.Top != nil)
+	if cfg.Cache != "" {
+		var err error
+		if builder.cache, err = openAbstractCache(cfg); err != nil {
+			return st, err
+		}
+		defer builder.cache.close()
+	}
+	build := builder.build
+	chain := docChain(cfg)
+
+	// post counts what build found, in the order the original single pass
+	// did, and hands the doc to the output writer
+	post := func(r *pageResult) error {
+		p, doc := r.p, &r.doc
+		if r.empty && !r.timedOut {
+			st.Empty++
+			return nil
+		}
+		if slugs != nil {
+			if slug := slugs.next(p.Title); !r.timedOut {
+				doc.Slug = slug
+			}
+		}
+		if r.badURL != nil {
+			st.InvalidURLs++
+			fmt.Fprintf(os.Stderr, "warning: bad URL %q for %q: %v\n", forDisplay(doc.URL), forDisplay(doc.Title), r.badURL)
+			if cfg.DropInvalidURLs {
+				return nil
+			}
+		}
+		if qids != nil {
+			// A redirect has no item of its own, so it borrows its target's
+			qid, ok := qids.lookup(cfg.TitleKey.wikidataKey(p.Title))
+			if !ok && r.target != "" {
+				qid, ok = qids.lookup(cfg.TitleKey.wikidataKey(r.target))
+			}
+			if ok {
+				if !r.timedOut {
+					doc.WikidataID = qid
+				}
+				st.QIDMatched++
+			} else {
+				st.QIDUnmatched++
+			}
+		}
+		if r.lowScore {
+			st.LowScore++
+			return nil
+		}
+		if r.timedOut && cfg.ctx().Err() != nil {
+			return cfg.ctxErr(fmt.Sprintf("cleaning %q", p.Title))
+		}
+		if r.timedOut {
+			st.TimedOut++
+			fmt.Fprintf(os.Stderr, "warning: %q exceeded -page-timeout; writing its naive abstract\n", forDisplay(p.Title))
+			if r.empty {
+				st.Empty++
+				return nil
+			}
+		}
+		if st.Skipped < cfg.Skip {
+			st.Skipped++
+			return nil
+		}
+		if st.Top != nil {
+			st.Top.Cleanup.offer(p.Title, p.ID, r.cleanup.Microseconds())
+			st.Top.OutputSize.offer(p.Title, p.ID, docBytes(doc))
+		}
+		if st.Ages != nil {
+			if days, ok := st.Ages.add(p); ok && cfg.RevisionAgeField {
+				doc.RevisionAgeDays = &days
+			}
+		}
+		if summaries != nil {
+			summaries.enrich(doc)
+		}
+		if cfg.TruncateTitles {
+			doc.Title = capField(doc.Title, maxTitleBytes)
+		}
+		if !applyChain(chain, doc, st) {
+			return nil
+		}
+		if err := w.WriteDoc(doc); err != nil {
+			return fmt.Errorf("failed to write doc: %w", err)
+		}
+		st.Written++
+		if p.Source != nil {
+			p.Source.Records++
+		}
+		if offsets != nil {
+			if err := offsets.write(p); err != nil {
+				return err
+			}
+		}
+		if similar != nil {
+			if err := similar.add(doc); err != nil {
+				return err
+			}
+		}
+		if doc.LengthClass != "" {
+			if st.Classes == nil {
+				st.Classes = map[string]int{}
+			}
+			st.Classes[doc.LengthClass]++
+		}
+		if cfg.outputFull(st) {
+			return errOutputCapped
+		}
+		if cfg.Limit > 0 && st.Written >= cfg.Limit {
+			st.LimitReached = true
+			return errLimitReached
+		}
+		return nil
+	}
+
+	var pool *workerPool
+	err = scanPages(r, cfg, st, func(p *page) error {
+		if !begun {
+			if err := begin(); err != nil {
+				return err
+			}
+			if cfg.Workers > 1 {
+				pool = newWorkerPool(cfg, c, build, post) // After begin, which sets the cleaner's case rule
+			}
+		}
+
+		if st.Top != nil {
+			st.Top.RawText.offer(p.Title, p.ID, int64(len(p.Revision.Text)))
+		}
+
+		for _, o := range observers {
+			if err := o.observe(p); err != nil {
+				return err
+			}
+		}
+		if cfg.Products["redirects"] && inNS(p.NS) && redirectOf(p) != "" {
+			st.Filtered++ // Written to the redirects product instead
+			return nil
+		}
+
+		// 1. Apply the cheap namespace and redirect filters
+		if !inNS(p.NS) || cfg.SkipRedirects && p.Redirect != nil {
+			st.Filtered++
+			return nil
+		}
+		c.startPage()
+		if tf.active() && !tf.keep(c, p.Revision.Text, st) {
+			st.Filtered++
+			return nil
+		}
+		if cfg.BBox != nil && !cfg.BBox.keep(c, p.Revision.Text, st) {
+			st.Filtered++
+			return nil
+		}
+		if seen != nil && !seen.addNew(cfg.TitleKey.of(p.Title)) {
+			st.Duplicates++
+			return nil
+		}
+		if pool != nil {
+			return pool.submit(p)
+		}
+		return post(build(c, p))
+	})
+	if pool != nil {
+		if err == nil {
+			err = pool.finish()
+		} else {
+			pool.abort()
+		}
+	}
+	if errors.Is(err, errOutputCapped) || errors.Is(err, errLimitReached) {
+		err = nil
+	}
+	if err == nil && !begun {
+		err = begin() // A dump without pages still has its siteinfo
+	}
+	if err == nil && offsets != nil {
+		err = offsets.Close()
+	}
+	if similar != nil {
+		st.SimilarChecked, st.SimilarPairs = similar.checked, similar.written
+		if err == nil {
+			err = similar.Close()
+		}
+	}
+	if summaries != nil {
+		st.Enriched, st.EnrichFailed = summaries.requests, summaries.failed
+	}
+	if builder.cache != nil {
+		st.CacheHits, st.CacheMisses = builder.cache.counts()
+		if cerr := builder.cache.close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, o := range observers {
+		if cerr := o.close(); err == nil {
+			err = cerr
+		}
+		st.Products = append(st.Products, o.record())
+	}
+	observers = nil
+	return st, err
+}
+
+// offsetWriter records where each emitted doc's <page> lies in the
+// decompressed dump, one "id<TAB>title<TAB>offset<TAB>length" row per doc
+type offsetWriter struct {
+	f        *os.File      // Offsets file
+	buf      *bufio.Writer // Buffered rows
+	maxField int           // -max-field-bytes
+}
+
+// createOffsetWriter creates the -offsets file and writes its header row
+func createOffsetWriter(path string, maxField int) (*offsetWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offsets file: %w", err)
+	}
+	w := &offsetWriter{f: f, buf: bufio.NewWriter(f), maxField: maxField}
+	w.buf.WriteString("id\ttitle\toffset\tlength\n")
+	return w, nil
+}
+
+// write appends the row of one page
+func (w *offsetWriter) write(p *page) error {
+	if _, err := fmt.Fprintf(w.buf, "%d\t%s\t%d\t%d\n", p.ID, capField(p.Title, w.maxField), p.Offset, p.Length); err != nil {
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the rows; closing twice is harmless
+func (w *offsetWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	if err != nil {
+		return fmt.Errorf("failed to write offsets: %w", err)
+	}
+	return nil
+}
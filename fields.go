@@ -0,0 +1,45 @@
+package main
+
+import (
+	"unicode/utf8" // Package for cutting at character boundaries
+)
+
+// maxTitleBytes is MediaWiki's limit on the length of a page title, in
+// bytes of UTF-8. A longer title is reported as a dump anomaly and, with
+// -truncate-titles, cut to this length in the docs.
+const maxTitleBytes = 255
+
+// fieldCutMark ends a value cut short by capField
+const fieldCutMark = "…"
+
+// capField cuts s to at most n bytes, fieldCutMark included, at a
+// character boundary. n of 0 or less leaves s as it is.
+func capField(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	cut := n - len(fieldCutMark)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + fieldCutMark
+}
+
+// displayRunes is how many characters of a title go into a message meant
+// for a terminal
+const displayRunes = 100
+
+// forDisplay shortens s to displayRunes characters for a terminal message,
+// marking the cut; messages then stay on one screen line or two whatever
+// the dump holds
+func forDisplay(s string) string {
+	if utf8.RuneCountInString(s) <= displayRunes {
+		return s
+	}
+	cut := 0
+	for i := 0; i < displayRunes; i++ {
+		_, size := utf8.DecodeRuneInString(s[cut:])
+		cut += size
+	}
+	return s[:cut] + fieldCutMark
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt" // Package for formatted I/O
+)
+
+// simHash returns the 64-bit SimHash of text (-fingerprint): every word
+// shingle, as wordShingles makes them, votes on each bit with its own hash,
+// and a bit is set where most shingles have it set. Texts sharing most of
+// their shingles differ in few bits, so the Hamming distance between two
+// fingerprints estimates how different their abstracts are. ok is false for
+// a text without words.
+func simHash(text string, words int) (fp uint64, ok bool) {
+	shingles := wordShingles(text, words)
+	if len(shingles) == 0 {
+		return 0, false
+	}
+	var votes [64]int
+	for sh := range shingles {
+		h := mix64(sh) // As in signature: FNV alone leaves the bits of short shingles correlated
+		for bit := range votes {
+			if h&(1<<bit) != 0 {
+				votes[bit]++
+			} else {
+				votes[bit]--
+			}
+		}
+	}
+	for bit, v := range votes {
+		if v > 0 {
+			fp |= 1 << bit
+		}
+	}
+	return fp, true
+}
+
+// fingerprint is the doc's "fingerprint" field: the SimHash as 16 hex digits, or "" for a text without words
+func fingerprint(text string, words int) string {
+	fp, ok := simHash(text, words)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%016x", fp)
+}
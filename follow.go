@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"       // Package for ending the wait on a signal or -timeout
+	"encoding/json" // Package for dumpstatus.json
+	"errors"        // Package for error inspection
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"net/http"      // Package for HTTP status codes
+	"net/url"       // Package for URL parsing
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for file path manipulation
+	"strconv"       // Package for string conversions
+	"strings"       // Package for string manipulation
+	"time"          // Package for the grace period
+)
+
+// followPoll is how often -follow looks for new bytes at the end of the file
+const followPoll = time.Second
+
+// followReader reads a dump file that another process is still writing: at
+// the end of the file it waits for more bytes instead of reporting EOF, until
+// one of the completion conditions says the download is over. As the
+// decompressor never sees an early EOF, a bzip2 stream whose tail has not
+// been written yet is simply read once it has.
+type followReader struct {
+	f        *os.File        // Growing dump file
+	grace    time.Duration   // Complete once the file has not grown for this long (0: never)
+	sentinel string          // Complete once this file exists
+	size     int64           // Complete once this many bytes are read (0: unknown)
+	opts     *Options        // Clock and sleep used while waiting
+	ctx      context.Context // Ends the wait, on a signal or -timeout
+	read     int64           // Bytes read so far
+	grew     time.Time       // When the last bytes were read
+	final    bool            // A condition was met; the next EOF is the real one
+}
+
+// newFollowReader follows f with the completion conditions of cfg
+func newFollowReader(f *os.File, cfg *config) *followReader {
+	return &followReader{
+		f:        f,
+		grace:    cfg.FollowGrace,
+		sentinel: cfg.FollowSentinel,
+		size:     cfg.ExpectedSize,
+		opts:     &cfg.Options,
+		ctx:      cfg.ctx(),
+		grew:     cfg.NowFunc(),
+	}
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		r.read += int64(n)
+		if n > 0 {
+			r.grew = r.opts.NowFunc()
+			return n, nil
+		}
+		if err != io.EOF {
+			return 0, err
+		}
+		if r.final {
+			return 0, io.EOF
+		}
+		// Read once more after a condition is met, as bytes may have
+		// landed between the EOF above and the check
+		if r.complete() {
+			r.final = true
+			continue
+		}
+		if r.ctx.Err() != nil {
+			return 0, context.Cause(r.ctx)
+		}
+		r.opts.Sleep(followPoll)
+	}
+}
+
+// complete reports whether the writer of the file has finished
+func (r *followReader) complete() bool {
+	if r.size > 0 && r.read >= r.size {
+		return true
+	}
+	if r.sentinel != "" {
+		if _, err := os.Stat(r.sentinel); err == nil {
+			return true
+		}
+	}
+	return r.grace > 0 && r.opts.NowFunc().Sub(r.grew) >= r.grace
+}
+
+// parseExpectedSize reads -expected-size: a byte count, or "dumpstatus" to
+// look the size of the input up in the dumpstatus.json of its dump run
+// once the run starts
+func parseExpectedSize(spec string) (n int64, fromStatus bool, err error) {
+	switch spec {
+	case "":
+		return 0, false, nil
+	case "dumpstatus":
+		return 0, true, nil
+	}
+	n, err = strconv.ParseInt(strings.ReplaceAll(spec, "_", ""), 10, 64)
+	if err != nil || n < 1 {
+		return 0, false, fmt.Errorf("-expected-size must be a positive byte count or \"dumpstatus\", got %q", spec)
+	}
+	return n, false, nil
+}
+
+// dumpStatus is the part of a dump run's dumpstatus.json that lists its files
+type dumpStatus struct {
+	Jobs map[string]struct {
+		Files map[string]struct {
+			Size int64 `json:"size"` // File size in bytes
+		} `json:"files"`
+	} `json:"jobs"`
+}
+
+// dumpStatusSize fetches the size of the -input file from the dumpstatus.json
+// of its run. The run is read off the file name, e.g.
+// enwiki-20240601-pages-articles.xml.bz2 is in <host>/enwiki/20240601/, the
+// host being that of -url; "latest" names do not say which run they are.
+func dumpStatusSize(cfg *config) (int64, error) {
+	name := filepath.Base(cfg.Input)
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) < 3 || len(parts[1]) != 8 || strings.Trim(parts[1], "0123456789") != "" {
+		return 0, fmt.Errorf("-expected-size dumpstatus needs a dated dump file name such as enwiki-20240601-pages-articles.xml.bz2, not %q", name)
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -url: %w", err)
+	}
+	statusURL := u.Scheme + "://" + u.Host + "/" + parts[0] + "/" + parts[1] + "/dumpstatus.json"
+	resp, err := httpGet(cfg.ctx(), statusURL, cfg.Auth)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch dumpstatus: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("dumpstatus: %w: %s", ErrBadStatus, resp.Status)
+	}
+	var status dumpStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("failed to parse dumpstatus: %w", err)
+	}
+	for _, job := range status.Jobs {
+		if f, ok := job.Files[name]; ok && f.Size > 0 {
+			return f.Size, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not listed in %s", name, redactURL(statusURL))
+}
+
+// checkFollowInput rejects -follow setups that could never finish
+func checkFollowInput(cfg *config) error {
+	if cfg.Input == "" {
+		return errors.New("-follow needs a local -input file")
+	}
+	if cfg.FollowGrace <= 0 && cfg.FollowSentinel == "" && cfg.ExpectedSize == 0 && !cfg.SizeFromStatus {
+		return errors.New("-follow needs a way to tell the download is complete: -follow-grace, -follow-sentinel or -expected-size")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Package golden compares whole-run outputs with checked-in golden files,
+// for the tests that run the pipeline over the sample dump. A difference is
+// reported as the first differing record, a <doc> element of XML or a line
+// of the other formats, rather than as raw bytes; binary formats such as
+// Parquet are reported by the offset of the first differing byte.
+package golden
+
+import (
+	"bytes"         // Package for comparing outputs
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for the golden file's directory
+	"regexp"        // Package for splitting XML into docs
+	"strings"       // Package for string manipulation
+	"testing"       // Package for reporting to the test
+	"unicode/utf8"  // Package for telling binary outputs apart
+)
+
+// docRe matches one <doc> element of the XML output
+var docRe = regexp.MustCompile(`(?s)<doc>.*?</doc>`)
+
+// Records splits an output into records: <doc> elements when name ends in
+// .xml, lines otherwise
+func Records(data []byte, name string) []string {
+	text := strings.ToValidUTF8(string(data), "�")
+	if strings.HasSuffix(name, ".xml") {
+		if docs := docRe.FindAllString(text, -1); docs != nil {
+			return docs
+		}
+		return []string{text}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// FirstDifference describes the first record where got and want differ, or
+// returns "" when they are the same bytes. name is the golden file's name,
+// whose extension says how to split records.
+func FirstDifference(got, want []byte, name string) string {
+	if bytes.Equal(got, want) {
+		return ""
+	}
+	if !utf8.Valid(want) {
+		i := 0
+		for i < min(len(got), len(want)) && got[i] == want[i] {
+			i++
+		}
+		return fmt.Sprintf("binary outputs differ from byte %d (%d bytes, want %d)", i, len(got), len(want))
+	}
+	g, w := Records(got, name), Records(want, name)
+	for i := range min(len(g), len(w)) {
+		if g[i] != w[i] {
+			return fmt.Sprintf("record %d differs:\n  got:  %s\n  want: %s", i+1, clip(g[i]), clip(w[i]))
+		}
+	}
+	if len(g) != len(w) {
+		return fmt.Sprintf("%d records, want %d", len(g), len(w))
+	}
+	return "records match but the bytes around them differ (header, trailer or whitespace)"
+}
+
+// clip shortens a record for the report
+func clip(s string) string {
+	if len(s) > 500 {
+		return strings.ToValidUTF8(s[:500], "") + "…"
+	}
+	return s
+}
+
+// Check compares got with the golden file at path and fails t on a
+// difference. With update, it writes got to path instead, for a change of
+// the output that is intended; the diff of the golden files then shows it.
+func Check(t testing.TB, got []byte, path string, update bool) {
+	t.Helper()
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated %s", path)
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if diff := FirstDifference(got, want, filepath.Base(path)); diff != "" {
+		t.Errorf("against %s: %s\nif the change is intended, rerun with -update and review the diff", path, diff)
+	}
+}
@@ -0,0 +1,41 @@
+package golden
+
+import (
+	"os"            // Package for reading the written file back
+	"path/filepath" // Package for the golden file's path
+	"strings"       // Package for string manipulation
+	"testing"       // Package for tests
+)
+
+// TestFirstDifference checks that a difference names the first record that
+// differs, in the splitting of the file's format
+func TestFirstDifference(t *testing.T) {
+	xml := "<documents>\n<doc>\n<title>A</title>\n</doc>\n<doc>\n<title>B</title>\n</doc>\n</documents>\n"
+	for _, c := range []struct {
+		name, got, want, file, report string
+	}{
+		{"same", "a\nb\n", "a\nb\n", "x.jsonl", ""},
+		{"line", "a\nb\nc\n", "a\nB\nc\n", "x.jsonl", "record 2 differs:\n  got:  b\n  want: B"},
+		{"doc", strings.Replace(xml, "B", "C", 1), xml, "x.xml", "record 2 differs:\n  got:  <doc>\n<title>C</title>\n</doc>"},
+		{"fewer", "a\n", "a\nb\n", "x.csv", "1 records, want 2"},
+		{"trailer", xml + "\n", xml, "x.xml", "records match but the bytes around them differ"},
+		{"binary", "PAR1\x00\x01\xff", "PAR1\x00\x02\xff", "x.parquet", "binary outputs differ from byte 5 (7 bytes, want 7)"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := FirstDifference([]byte(c.got), []byte(c.want), c.file); !strings.HasPrefix(got, c.report) || (c.report == "") != (got == "") {
+				t.Errorf("FirstDifference = %q, want it to start with %q", got, c.report)
+			}
+		})
+	}
+}
+
+// TestCheckUpdate checks that -update writes the golden file that a later
+// comparison then passes against
+func TestCheckUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden", "out.jsonl")
+	Check(t, []byte("a\n"), path, true)
+	if data, err := os.ReadFile(path); err != nil || string(data) != "a\n" {
+		t.Fatalf("golden file holds %q, %v", data, err)
+	}
+	Check(t, []byte("a\n"), path, false)
+}
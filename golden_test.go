@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"           // Package for reading the index
+	"bytes"           // Package for in-memory buffers
+	"compress/bzip2"  // Package for reading the bzip2 sample part
+	"compress/gzip"   // Package for reading .gz outputs
+	"crypto/md5"      // Package for the ZIM checksum
+	"crypto/sha1"     // Package for the manifest checksums
+	"encoding/binary" // Package for decoding the ZIM layout
+	"encoding/hex"    // Package for printing checksums
+	"encoding/json"   // Package for reading the JSON outputs back
+	"flag"            // Package for the -update flag
+	"fmt"             // Package for formatted I/O
+	"html"            // Package for unescaping the ZIM pages
+	"io"              // Package for I/O primitives
+	"os"              // Package for OS functions (file access)
+	"os/exec"         // Package for running the tool without a subcommand
+	"path/filepath"   // Package for the output paths
+	"regexp"          // Package for finding the abstract in a ZIM page
+	"slices"          // Package for order checks
+	"strconv"         // Package for the index offsets
+	"strings"         // Package for string manipulation
+	"testing"         // Package for tests
+
+	"github.com/AhmedOthman94/full-stream-wiki-golang/golden" // Package for comparing with the golden files
+	"github.com/ulikunitz/xz"                                 // Package for reading ZIM clusters back
+)
+
+// update rewrites the golden files instead of comparing with them:
+// go test -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite the golden files in sample/golden from the outputs of TestGolden")
+
+// The inputs of the golden runs, all in sample/
+const (
+	goldenSample  = "sample/simplewiki-sample.xml.bz2" // The dump embedded for -demo
+	goldenIndex   = "sample/simplewiki-sample-index.txt"
+	goldenDir     = "sample/golden"
+	extremesDump  = "sample/extremes.xml.bz2"           // Over-long titles and lines (see gen_extremes.py)
+	prefixedDump  = "sample/schemas/prefixed.xml.bz2"   // The sample with mw: prefixes (see gen_schemas.py)
+	prefixedIndex = "sample/schemas/prefixed-index.txt" // Its own multistream index
+	export05Dump  = "sample/schemas/export-0.5.xml.bz2" // The sample as a schema 0.5 export, without <ns>
+)
+
+// goldenParts is the sample split by gen_parts.py into a .bz2 part and a plain .xml part
+var goldenParts = []string{"sample/parts/simplewiki-sample-part1.xml.bz2", "sample/parts/simplewiki-sample-part2.xml"}
+
+// goldenCase is one run of the tool over the sample. The args are extract
+// flags after -input of the sample, which a later -input replaces, and -o
+// of a file in the test's directory; or a subcommand and its flags, followed
+// by -input of the sample. "{out}" in args is that output file and "{dir}"
+// the directory.
+type goldenCase struct {
+	name   string
+	args   []string
+	golden string // Golden file in sample/golden the output must equal
+}
+
+// goldenCases each have a golden file of their own, which -update rewrites
+var goldenCases = []goldenCase{
+	{"default", nil, "default.xml"},
+	{"plain", []string{"-plain"}, "plain.xml"},
+	{"jsonl", []string{"-plain", "-format", "jsonl"}, "jsonl.jsonl"},
+	{"metadata", []string{"-plain", "-format", "jsonl", "-extract-dates", "-extract-ipa", "-extract-refs",
+		"-slug", "-score", "-classify", "-fingerprint", "-extract-infobox", "Infobox person"}, "metadata.jsonl"},
+	{"exintro", []string{"-abstract-mode", "exintro", "-format", "jsonl"}, "exintro.jsonl"},
+	{"exintro-exsentences", []string{"-abstract-mode", "exintro", "-exsentences", "2", "-format", "jsonl"}, "exintro-exsentences.jsonl"},
+	{"exintro-exchars", []string{"-abstract-mode", "exintro", "-exchars", "80", "-format", "jsonl"}, "exintro-exchars.jsonl"},
+	{"bbox", []string{"-bbox", "35,-10,70,40", "-format", "jsonl"}, "bbox.jsonl"},
+	{"sentences", []string{"-plain", "-format", "jsonl", "-sentences-array"}, "sentences.jsonl"},
+	// Cat bolds two names besides its title; XML repeats <alias>
+	{"aliases", []string{"-plain", "-extract-aliases"}, "aliases.xml"},
+	{"templates-as-text", []string{"-plain", "-format", "jsonl", "-templates-as-text", "sample/render-map.txt"}, "templates-as-text.jsonl"},
+	{"redirects", []string{"-redirects-only", "-format", "csv"}, "redirects.csv"},
+	{"ntriples", []string{"-plain", "-format", "ntriples"}, "ntriples.nt"},
+	{"jsonld", []string{"-plain", "-format", "jsonld"}, "jsonld.jsonld"},
+	// Snappy pages and every column type; TestParquetRoundTrip reads the format back
+	{"parquet", []string{"-plain", "-format", "parquet", "-sentences-array", "-score", "-classify"}, "parquet.parquet"},
+	// -canonical output is covered by the compatibility promise in the README
+	{"canonical", []string{"-plain", "-format", "jsonl", "-canonical", "-siteinfo-record", "-extract-dates",
+		"-slug", "-score", "-classify"}, "canonical.jsonl"},
+	{"shard-0-of-2", []string{"-plain", "-shard-count", "2", "-shard-index", "0"}, "shard-0-of-2.xml"},
+	{"shard-1-of-2", []string{"-plain", "-shard-count", "2", "-shard-index", "1"}, "shard-1-of-2.xml"},
+	{"census", []string{"census", "-top", "20", "-json", "{out}", "--"}, "census.json"},
+	// The sample is three bzip2 streams, the siteinfo header and two of pages;
+	// the name lacks "multistream", so it is said to be one
+	{"stream-index", []string{"-multistream", "yes", "-plain", "-format", "jsonl", "-with-offset", "-with-stream-index"}, "stream-index.jsonl"},
+	// The built-in middlewares, in their fixed order: trim, then prefix, then the constant field
+	{"middleware", []string{"-plain", "-format", "jsonl", "-trim-field", "abstract=80", "-trim-field", "title=8",
+		"-title-prefix", "simple:", "-set-field", "source=simplewiki-sample"}, "middleware.jsonl"},
+	{"provenance", []string{"-input", strings.Join(goldenParts, ","), "-plain", "-format", "jsonl", "-with-provenance"}, "provenance.jsonl"},
+	{"namespaces-0", []string{"-plain", "-format", "jsonl", "-namespaces", "0"}, "namespaces-0.jsonl"},
+	// Page 1852 ends the first stream of pages, so the read stops before </mediawiki>
+	{"index-first-stream", []string{"-plain", "-format", "jsonl", "-index", goldenIndex, "-max-id", "1852"}, "index-first-stream.jsonl"},
+	// Over-long titles and lines pass through by default
+	{"extremes", []string{"-input", extremesDump, "-plain"}, "extremes.xml"},
+	{"extremes-redirects", []string{"-input", extremesDump, "-redirects-only", "-format", "csv"}, "extremes-redirects.csv"},
+	{"extremes-capped", []string{"-input", extremesDump, "-redirects-only", "-format", "csv", "-max-field-bytes", "64"}, "extremes-capped.csv"},
+	{"extremes-truncate-titles", []string{"-input", extremesDump, "-plain", "-format", "jsonl", "-truncate-titles"}, "extremes-truncate-titles.jsonl"},
+}
+
+// sameAsCases are runs that must give exactly the output of another case
+var sameAsCases = []goldenCase{
+	{"plain-workers", []string{"-plain", "-workers", "4", "-ordered"}, "plain.xml"},
+	// The original binary's output, which TestBareInvocation also pins for a run without a subcommand
+	{"legacy-abstracts", []string{"-legacy-abstracts"}, "default.xml"},
+	{"canonical-workers", []string{"-plain", "-format", "jsonl", "-canonical", "-siteinfo-record", "-extract-dates",
+		"-slug", "-score", "-classify", "-workers", "4"}, "canonical.jsonl"},
+	{"middleware-workers", []string{"-plain", "-format", "jsonl", "-trim-field", "abstract=80", "-trim-field", "title=8",
+		"-title-prefix", "simple:", "-set-field", "source=simplewiki-sample", "-workers", "4", "-ordered"}, "middleware.jsonl"},
+	{"jsonl-gzip", []string{"-plain", "-format", "jsonl", "-o", "{dir}/jsonl.jsonl.gz"}, "jsonl.jsonl"},
+	{"plain-prefetch", []string{"-plain", "-index", goldenIndex, "-prefetch-streams", "2", "-workers", "2", "-ordered"}, "plain.xml"},
+	// The export schema's dialects: prefixed elements, and titles standing in for <ns>
+	{"prefixed", []string{"-input", prefixedDump, "-plain", "-format", "jsonl"}, "jsonl.jsonl"},
+	{"prefixed-namespaces-0", []string{"-input", prefixedDump, "-plain", "-format", "jsonl", "-namespaces", "0"}, "namespaces-0.jsonl"},
+	{"prefixed-index", []string{"-input", prefixedDump, "-plain", "-format", "jsonl", "-index", prefixedIndex,
+		"-max-id", "1852", "-prefetch-streams", "2", "-workers", "2", "-ordered"}, "index-first-stream.jsonl"},
+	{"export-0.5-namespaces-0", []string{"-input", export05Dump, "-plain", "-format", "jsonl", "-namespaces", "0"}, "namespaces-0.jsonl"},
+}
+
+// TestMain runs the tool itself when TestBareInvocation starts the test
+// binary with FULL_STREAM_WIKI_MAIN set, so that main's handling of a run
+// without a subcommand is what gets checked
+func TestMain(m *testing.M) {
+	if os.Getenv("FULL_STREAM_WIKI_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTool runs the tool in process over the sample, the way goldenCase
+// describes, and returns the output
+func runTool(t *testing.T, args []string, dir, out string) []byte {
+	t.Helper()
+	args = slices.Clone(args)
+	for i := range args {
+		args[i] = strings.NewReplacer("{out}", out, "{dir}", dir).Replace(args[i])
+	}
+	var err error
+	if len(args) > 0 && commands[args[0]] != nil {
+		err = commands[args[0]](append(args[1:], "-input", goldenSample))
+	} else {
+		args = append([]string{"-input", goldenSample}, args...)
+		if i := slices.Index(args, "-o"); i >= 0 {
+			out = args[i+1]
+		} else {
+			args = append(args, "-o", out)
+		}
+		err = extractCommand(args)
+	}
+	if err != nil {
+		t.Fatalf("%q: %v", args, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasSuffix(out, ".gz") {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return data
+}
+
+// TestGolden runs the complete pipeline over the sample once per supported
+// flag combination and compares each output byte for byte with its golden
+// file. Runs that must not change the output, such as -workers 4 -ordered,
+// .gz compression or the other export schema dialects, are compared with the
+// golden file of the case they match. After an intended change,
+// go test -run TestGolden -update rewrites the files.
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runTool(t, c.args, dir, filepath.Join(dir, c.golden))
+			golden.Check(t, got, filepath.Join(goldenDir, c.golden), *update)
+		})
+	}
+	if *update {
+		return
+	}
+	for _, c := range sameAsCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runTool(t, c.args, dir, filepath.Join(dir, c.name+filepath.Ext(c.golden)))
+			golden.Check(t, got, filepath.Join(goldenDir, c.golden), false)
+		})
+	}
+}
+
+// TestBareInvocation runs the tool the way cron jobs written for the
+// original did, with no subcommand and no -o, and checks that abstracts.xml
+// in the working directory is the default output and that a one-line
+// deprecation notice went to standard error. Only -input is added, so the
+// run stays offline.
+func TestBareInvocation(t *testing.T) {
+	sample, err := filepath.Abs(goldenSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-input", sample)
+	cmd.Dir, cmd.Env = dir, append(os.Environ(), "FULL_STREAM_WIKI_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v: %s", err, stderr.Bytes())
+	}
+	var notices []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if strings.Contains(line, "deprecated") {
+			notices = append(notices, line)
+		}
+	}
+	if len(notices) != 1 || !strings.Contains(notices[0], "full-stream-wiki extract") {
+		t.Errorf("want one deprecation notice naming the extract subcommand, got %q", notices)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "abstracts.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden.Check(t, got, filepath.Join(goldenDir, "default.xml"), false)
+}
+
+// TestParallelMatchesSerial runs the hidden compare-dumps subcommand, which
+// extracts the sample serially and with -workers, unordered and with
+// -prefetch-streams, and fails when a parallel output differs from the
+// serial one
+func TestParallelMatchesSerial(t *testing.T) {
+	err := compareDumpsCommand([]string{"-workdir", t.TempDir(), "--", "-input", goldenSample, "-plain",
+		"-index", goldenIndex, "-extract-dates", "-extract-aliases", "-score", "-classify"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readJSONLDocs reads a JSONL output back as docs
+func readJSONLDocs(t *testing.T, data []byte) []Doc {
+	t.Helper()
+	var docs []Doc
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		var doc Doc
+		if err := json.Unmarshal(line, &doc); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// TestStreamIndexMatchesIndex extracts the sample with -with-stream-index
+// and checks each doc against the multistream index: its stream_offset is
+// the offset the index gives its title, and its stream_index the position
+// of that offset among the distinct offsets of the index, counting from 1
+// as the header stream is 0. Read as a single-stream dump, every doc is in
+// stream 0.
+func TestStreamIndexMatchesIndex(t *testing.T) {
+	f, err := os.Open(goldenIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	offsets := map[string]int64{}
+	var streams []int64
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		off, rest, _ := strings.Cut(sc.Text(), ":")
+		_, title, _ := strings.Cut(rest, ":")
+		n, err := strconv.ParseInt(off, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets[title] = n
+		if len(streams) == 0 || streams[len(streams)-1] != n {
+			streams = append(streams, n)
+		}
+	}
+	dir := t.TempDir()
+	for _, mode := range []string{"yes", "no"} {
+		out := runTool(t, []string{"-multistream", mode, "-plain", "-format", "jsonl", "-with-offset", "-with-stream-index"},
+			dir, filepath.Join(dir, "stream-index-"+mode+".jsonl"))
+		for _, doc := range readJSONLDocs(t, out) {
+			want := 0
+			if mode == "yes" {
+				want = slices.Index(streams, offsets[doc.Title]) + 1
+			}
+			if doc.StreamOffset == nil || doc.StreamIndex == nil || *doc.StreamOffset != offsets[doc.Title] || *doc.StreamIndex != want {
+				t.Fatalf("-multistream %s: %q is in stream %v at %v, want %d at %d",
+					mode, doc.Title, doc.StreamIndex, doc.StreamOffset, want, offsets[doc.Title])
+			}
+		}
+	}
+}
+
+// TestProvenanceOffsets reads the two sample parts in one -with-provenance
+// run and checks that each doc's source_offset is where its page starts in
+// its source_file, and that the -manifest lists both parts with their SHA-1
+// and record counts
+func TestProvenanceOffsets(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.json")
+	out := runTool(t, []string{"-input", strings.Join(goldenParts, ","), "-plain", "-format", "jsonl",
+		"-with-provenance", "-manifest", manifest}, dir, filepath.Join(dir, "provenance.jsonl"))
+	texts, sums := map[string][]byte{}, map[string]string{}
+	for _, p := range goldenParts {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha1.Sum(raw)
+		sums[p], texts[p] = hex.EncodeToString(sum[:]), raw
+		if strings.HasSuffix(p, ".bz2") {
+			if texts[p], err = io.ReadAll(bzip2.NewReader(bytes.NewReader(raw))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	written := map[string]int{}
+	for _, doc := range readJSONLDocs(t, out) {
+		text := texts[doc.SourceFile]
+		want := "<page>\n    <title>" + escape.Replace(doc.Title) + "</title>"
+		if doc.SourceOffset == nil || *doc.SourceOffset > int64(len(text)) || !bytes.HasPrefix(text[*doc.SourceOffset:], []byte(want)) {
+			t.Fatalf("%q: no <page> of that title at %s:%v", doc.Title, doc.SourceFile, doc.SourceOffset)
+		}
+		written[doc.SourceFile]++
+	}
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Sources []inputSource `json:"sources"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	var got, want []string
+	for _, s := range m.Sources {
+		got = append(got, fmt.Sprintf("%s %s %d", s.File, s.SHA1, s.Records))
+	}
+	for _, p := range goldenParts {
+		want = append(want, fmt.Sprintf("%s %s %d", p, sums[p], written[p]))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("manifest sources %q, want %q", got, want)
+	}
+}
+
+// zimEntry is an entry of a ZIM archive as readZIM reads it back
+type zimEntry struct {
+	title, mime string
+	content     []byte
+}
+
+// readZIM parses a ZIM archive the way a reader does: the header, the MIME
+// type list, the directory through the URL pointer list and the clusters,
+// checking the layout as it goes. It returns the entries by namespace and
+// path, e.g. "A/Cat", and their keys in title pointer order.
+func readZIM(data []byte) (map[string]zimEntry, []string, error) {
+	le := binary.LittleEndian
+	if len(data) < 96 || le.Uint32(data) != 72173914 || le.Uint16(data[4:]) != 5 || le.Uint16(data[6:]) != 0 || le.Uint64(data[56:]) != 80 {
+		return nil, nil, fmt.Errorf("not a version 5.0 ZIM header")
+	}
+	entries, clusters := uint64(le.Uint32(data[24:])), uint64(le.Uint32(data[28:]))
+	urlPos, titlePos, clusterPos, checksumPos := le.Uint64(data[32:]), le.Uint64(data[40:]), le.Uint64(data[48:]), le.Uint64(data[72:])
+	if checksumPos != uint64(len(data)-16) {
+		return nil, nil, fmt.Errorf("checksum at %d of %d bytes", checksumPos, len(data))
+	}
+	if sum := md5.Sum(data[:checksumPos]); !bytes.Equal(sum[:], data[checksumPos:]) {
+		return nil, nil, fmt.Errorf("the MD5 checksum at %d does not match", checksumPos)
+	}
+	list, ok := bytes.CutSuffix(data[80:urlPos], []byte{0, 0})
+	if !ok {
+		return nil, nil, fmt.Errorf("the MIME type list does not end in an empty string")
+	}
+	mimes := strings.Split(string(list), "\x00")
+	var blobs [][][]byte
+	for i := range clusters {
+		start, end := le.Uint64(data[clusterPos+8*i:]), checksumPos
+		if i+1 < clusters {
+			end = le.Uint64(data[clusterPos+8*(i+1):])
+		}
+		if data[start] != 4 {
+			return nil, nil, fmt.Errorf("cluster at %d: compression %d, want 4 (xz)", start, data[start])
+		}
+		r, err := xz.NewReader(bytes.NewReader(data[start+1 : end]))
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		var cluster [][]byte
+		for off := uint32(0); off+4 < le.Uint32(raw); off += 4 {
+			cluster = append(cluster, raw[le.Uint32(raw[off:]):le.Uint32(raw[off+4:])])
+		}
+		blobs = append(blobs, cluster)
+	}
+	found, keys := map[string]zimEntry{}, []string{}
+	for i := range entries {
+		ptr := le.Uint64(data[urlPos+8*i:])
+		mime, param, ns := le.Uint16(data[ptr:]), data[ptr+2], data[ptr+3]
+		cluster, blob := le.Uint32(data[ptr+8:]), le.Uint32(data[ptr+12:])
+		fields := bytes.SplitN(data[ptr+16:], []byte{0}, 3)
+		if param != 0 || int(mime) >= len(mimes) || int(cluster) >= len(blobs) || int(blob) >= len(blobs[cluster]) {
+			return nil, nil, fmt.Errorf("entry at %d: parameter length %d, MIME type %d, blob %d/%d", ptr, param, mime, cluster, blob)
+		}
+		key, title := string(ns)+"/"+string(fields[0]), string(fields[1])
+		if title == "" {
+			title = string(fields[0])
+		}
+		keys = append(keys, key)
+		found[key] = zimEntry{title, mimes[mime], blobs[cluster][blob]}
+	}
+	if !slices.IsSorted(keys) {
+		return nil, nil, fmt.Errorf("the URL pointer list is not in namespace and URL order")
+	}
+	byTitle := make([]string, entries)
+	for i := range entries {
+		byTitle[i] = keys[le.Uint32(data[titlePos+4*i:])]
+	}
+	titleKey := func(k string) string { return k[:2] + found[k].title }
+	if !slices.IsSortedFunc(byTitle, func(a, b string) int { return strings.Compare(titleKey(a), titleKey(b)) }) {
+		return nil, nil, fmt.Errorf("the title pointer list is not in namespace and title order")
+	}
+	return found, byTitle, nil
+}
+
+// zimAbstractRe finds the abstract paragraph of a ZIM page
+var zimAbstractRe = regexp.MustCompile(`(?s)<p>(.*?)</p>`)
+
+// TestZIMArchive writes the sample as a ZIM archive and reads it back with
+// readZIM: every doc of the jsonl golden file must be an A/ page of its
+// title that holds its abstract, in an archive that has the metadata
+// readers show
+func TestZIMArchive(t *testing.T) {
+	dir := t.TempDir()
+	data := runTool(t, []string{"-plain", "-format", "zim"}, dir, filepath.Join(dir, "abstracts.zim"))
+	found, _, err := readZIM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Title", "Language", "Date", "Name", "Creator", "Publisher", "Description"} {
+		if _, ok := found["M/"+name]; !ok {
+			t.Errorf("no M/%s metadata", name)
+		}
+	}
+	if lang := string(found["M/Language"].content); lang != "eng" {
+		t.Errorf("M/Language is %q, want eng", lang)
+	}
+	want, err := os.ReadFile(filepath.Join(goldenDir, "jsonl.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := readJSONLDocs(t, want)
+	for _, doc := range docs {
+		e, ok := found["A/"+zimPath(doc.Title)]
+		if !ok || e.title != doc.Title || e.mime != "text/html" {
+			t.Fatalf("no text/html A/ page titled %q (got %q, %q)", doc.Title, e.title, e.mime)
+		}
+		m := zimAbstractRe.FindSubmatch(e.content)
+		if m == nil || html.UnescapeString(string(m[1])) != doc.Abstract {
+			t.Errorf("%q: the page does not hold the abstract", doc.Title)
+		}
+	}
+	if len(found) != len(docs)+7 {
+		t.Errorf("%d entries, want %d docs and 7 metadata entries", len(found), len(docs))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "math" // Package for the logarithmic buckets
+
+// histogramGrowth is the ratio between the bounds of consecutive buckets:
+// quantiles come out within 1% of the true value
+const histogramGrowth = 1.01
+
+// histogram is a distribution of non-negative values in logarithmic buckets.
+// Memory grows with the logarithm of the largest value, not with the number
+// of values, so it can take every page of a dump. Values below 1 share the
+// first bucket.
+type histogram struct {
+	counts []int64  // Values per bucket; bucket i > 0 holds [g^(i-1), g^i)
+	n      int64    // Values added
+	max    *float64 // Largest value added; nil before the first
+}
+
+// bucket is the index of the bucket holding v
+func (h *histogram) bucket(v float64) int {
+	if v < 1 {
+		return 0
+	}
+	return int(math.Log(v)/math.Log(histogramGrowth)) + 1
+}
+
+// add records v; negative values count as 0
+func (h *histogram) add(v float64) {
+	v = max(v, 0)
+	i := h.bucket(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.n++
+	if h.max == nil || v > *h.max {
+		h.max = &v
+	}
+}
+
+// count is the number of values added
+func (h *histogram) count() int64 { return h.n }
+
+// quantile returns the value below which a fraction q of the values lie,
+// e.g. 0.5 for the median; 0 when the histogram is empty
+func (h *histogram) quantile(q float64) float64 {
+	if h.n == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.n)))
+	rank = min(max(rank, 1), h.n)
+	var seen int64
+	for i, c := range h.counts {
+		if seen += c; seen < rank {
+			continue
+		}
+		if i == 0 {
+			return 0
+		}
+		// The geometric middle of the bucket, but never past the largest value
+		mid := math.Pow(histogramGrowth, float64(i)-0.5)
+		return min(mid, *h.max)
+	}
+	return *h.max
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"  // Package for request cancellation
+	"errors"   // Package for error inspection
+	"flag"     // Package for command-line flag parsing
+	"fmt"      // Package for formatted I/O
+	"io"       // Package for I/O primitives
+	"net/http" // Package for HTTP client functionality
+	"net/url"  // Package for URL parsing
+	"os"       // Package for OS functions (environment)
+	"regexp"   // Package for checking header names
+	"slices"   // Package for the headers kept on redirects
+	"strconv"  // Package for Retry-After seconds
+	"strings"  // Package for string manipulation
+	"sync"     // Package for logging a response once
+	"time"     // Package for client timeouts and request durations
+)
+
+// userAgent identifies the tool to Wikimedia, whose policy requires a descriptive agent
+const userAgent = "full-stream-wiki-golang/1.0 (+https://github.com/AhmedOthman94/full-stream-wiki-golang)"
+
+// httpClient sends every outbound request of the tool, so that -offline and
+// -log-requests (see networkOptions.install) cover all of them
+var httpClient = &http.Client{CheckRedirect: sameHostHeaders}
+
+// newHTTPClient returns a client with its own timeout sharing httpClient's transport
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: httpClient.Transport, CheckRedirect: sameHostHeaders, Timeout: timeout}
+}
+
+// redirectHeaders are the headers a redirect to another host keeps; net/http
+// copies every other one, the -http-header ones included
+var redirectHeaders = []string{"User-Agent", "Accept", "Accept-Encoding", "Content-Type", "Range", "If-Range"}
+
+// sameHostHeaders is the redirect policy of every client: a redirect to
+// another host, even a subdomain, drops all headers but redirectHeaders, so
+// that credentials only ever reach the host they were given for
+func sameHostHeaders(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host == via[0].URL.Host {
+		return nil
+	}
+	for name := range req.Header {
+		if !slices.Contains(redirectHeaders, name) {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// networkOptions are the -offline and -log-requests settings
+type networkOptions struct {
+	Offline     bool // Refuse every outbound request
+	LogRequests bool // Log every outbound request to stderr
+}
+
+// networkFlags registers -offline and -log-requests
+func networkFlags(fs *flag.FlagSet, n *networkOptions) {
+	fs.BoolVar(&n.Offline, "offline", false, "make no network requests at all; anything that would need one fails with an error naming it")
+	fs.BoolVar(&n.LogRequests, "log-requests", false, "log every outbound request's method, URL, status, bytes and duration to stderr")
+}
+
+// install sets httpClient's transport for the options
+func (n networkOptions) install() {
+	var t http.RoundTripper = http.DefaultTransport
+	if n.Offline {
+		t = offlineTransport{}
+	}
+	if n.LogRequests {
+		t = loggingTransport{t}
+	}
+	httpClient.Transport = t
+}
+
+// offlineTransport fails every request (-offline)
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, fmt.Errorf("%w: refusing %s %s", ErrOffline, req.Method, redactURL(req.URL.String()))
+}
+
+// loggingTransport logs each request once its response body is closed, when
+// the bytes received are known (-log-requests)
+type loggingTransport struct {
+	next http.RoundTripper // Transport doing the work
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	target := redactURL(req.URL.String())
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "request: %s %s failed after %v: %v\n", req.Method, target, time.Since(started).Round(time.Millisecond), err)
+		return nil, err
+	}
+	body := &loggedBody{ReadCloser: resp.Body}
+	body.log = func() {
+		fmt.Fprintf(os.Stderr, "request: %s %s %d %s in %v\n", req.Method, target, resp.StatusCode, formatBytes(body.n), time.Since(started).Round(time.Millisecond))
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// loggedBody counts a response body and logs its request when closed
+type loggedBody struct {
+	io.ReadCloser
+	n    int64     // Bytes read
+	log  func()    // Writes the log line
+	once sync.Once // Close may be called more than once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	b.once.Do(b.log)
+	return b.ReadCloser.Close()
+}
+
+// newRequest builds an outbound request carrying the tool's standard headers;
+// cancelling ctx aborts it, the reading of its response body included
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// maxRetryAfter caps the wait a Retry-After header can impose, so that a
+// far-off date does not stall a run for days
+const maxRetryAfter = 5 * time.Minute
+
+// retryAfter returns the wait a 429 or 503 response asks for in its
+// Retry-After header, given as seconds or as an HTTP date, capped at
+// maxRetryAfter; ok is false for other responses and unreadable headers
+func retryAfter(resp *http.Response, now time.Time) (wait time.Duration, ok bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	h := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if secs, err := strconv.ParseInt(h, 10, 64); err == nil && secs >= 0 {
+		wait = time.Duration(min(secs, int64(maxRetryAfter/time.Second))) * time.Second
+	} else if at, err := http.ParseTime(h); err == nil {
+		wait = max(at.Sub(now), 0)
+	} else {
+		return 0, false
+	}
+	return min(wait, maxRetryAfter), true
+}
+
+// throttledError is a failed response that said when to try again
+type throttledError struct {
+	wait time.Duration // Retry-After, capped
+	err  error         // The failure
+}
+
+func (e *throttledError) Error() string { return fmt.Sprintf("%v (retry after %v)", e.err, e.wait) }
+func (e *throttledError) Unwrap() error { return e.err }
+
+// throttled attaches resp's Retry-After wait to err, if resp gives one
+func throttled(resp *http.Response, now time.Time, err error) error {
+	if wait, ok := retryAfter(resp, now); ok {
+		return &throttledError{wait, err}
+	}
+	return err
+}
+
+// retryWait is the pause before retrying after err: the server's Retry-After
+// when it sent one, the caller's backoff otherwise
+func retryWait(err error, backoff time.Duration) time.Duration {
+	var t *throttledError
+	if errors.As(err, &t) {
+		return t.wait
+	}
+	return backoff
+}
+
+// httpGet performs a GET with the standard headers and creds
+func httpGet(ctx context.Context, url string, creds credentials) (*http.Response, error) {
+	req, err := newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sendAuthed(req, creds)
+}
+
+// sendAuthed sends req with creds through httpClient, keeping credentials
+// in the query out of the error, where net/http would quote the whole URL
+func sendAuthed(req *http.Request, creds credentials) (*http.Response, error) {
+	creds.apply(req)
+	resp, err := httpClient.Do(req)
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = redactURL(ue.URL)
+	}
+	return resp, err
+}
+
+// credentials authenticate requests to a protected dump mirror. They only
+// ever travel in request headers: String hides them from any message that
+// formats a config, and sameHostHeaders drops them on cross-host redirects.
+type credentials struct {
+	User    string        // Basic auth user (-auth-user, -http-basic)
+	Pass    string        // Basic auth password (-auth-pass, -http-basic)
+	Bearer  string        // Bearer token (-auth-bearer)
+	Netrc   bool          // Look the user and password up in $NETRC or ~/.netrc (-netrc)
+	Headers []headerValue // Extra headers (-http-header)
+	bad     error         // A malformed -http-basic or -http-header, see check
+}
+
+// apply sets the Authorization header, the token taking precedence, then
+// the -http-header headers, which win over both
+func (c credentials) apply(req *http.Request) {
+	switch {
+	case c.Bearer != "":
+		req.Header.Set("Authorization", "Bearer "+c.Bearer)
+	case c.User != "" || c.Pass != "":
+		req.SetBasicAuth(c.User, c.Pass)
+	}
+	for _, h := range c.Headers {
+		req.Header.Set(h.name, h.value)
+	}
+}
+
+func (c credentials) String() string {
+	if c.User == "" && c.Pass == "" && c.Bearer == "" && len(c.Headers) == 0 {
+		return "none"
+	}
+	return "[redacted]"
+}
+
+func (c credentials) GoString() string { return c.String() }
+
+// headerValue is one -http-header
+type headerValue struct {
+	name  string // Canonical header name
+	value string // Header value, a secret as far as messages go
+}
+
+// headerNameRe is an HTTP header field name (an RFC 9110 token)
+var headerNameRe = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// parseHeader reads one "Name: value" -http-header; its error never quotes
+// the value
+func parseHeader(s string) (headerValue, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name, value = http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+	switch {
+	case !ok || !headerNameRe.MatchString(name):
+		return headerValue{}, errors.New(`-http-header wants "Name: value"`)
+	case strings.ContainsAny(value, "\r\n\x00"):
+		return headerValue{}, fmt.Errorf("-http-header %s: the value holds a line break or NUL", name)
+	case slices.Contains(redirectHeaders, name) || name == "Host":
+		return headerValue{}, fmt.Errorf("-http-header %s: the tool sets that header itself", name)
+	}
+	return headerValue{name, value}, nil
+}
+
+// authFlags registers -auth-user, -auth-pass, -auth-bearer, -http-basic,
+// -netrc and -http-header
+func authFlags(fs *flag.FlagSet, c *credentials) {
+	fs.StringVar(&c.User, "auth-user", "", "basic auth user for the dump server")
+	fs.StringVar(&c.Pass, "auth-pass", "", "basic auth password for the dump server (default $FSW_AUTH_PASS)")
+	fs.StringVar(&c.Bearer, "auth-bearer", "", "bearer token for the dump server (default $FSW_AUTH_BEARER)")
+	// The two below never fail while parsing, as flag would quote the
+	// secret in its message; check reports them instead
+	fs.Func("http-basic", "basic auth `user:pass` for the dump server; a bare user takes the password from $FSW_AUTH_PASS", func(s string) error {
+		if c.User, c.Pass, _ = strings.Cut(s, ":"); c.User == "" && c.bad == nil {
+			c.bad = errors.New("-http-basic wants user:pass or user")
+		}
+		return nil
+	})
+	fs.BoolVar(&c.Netrc, "netrc", false, "take the dump server's basic auth from $NETRC or ~/.netrc, by host")
+	fs.Func("http-header", "send this `\"Name: value\"` header with every dump server request, e.g. \"Authorization: Bearer ...\" (repeatable)", func(s string) error {
+		h, err := parseHeader(s)
+		if err != nil && c.bad == nil {
+			c.bad = err
+		}
+		if err == nil {
+			c.Headers = append(c.Headers, h)
+		}
+		return nil
+	})
+}
+
+// check reports a malformed -http-basic or -http-header
+func (c *credentials) check() error { return c.bad }
+
+// fillFromEnv takes secrets not given as flags from FSW_AUTH_PASS and
+// FSW_AUTH_BEARER, which keeps them out of ps output. It runs after flag
+// parsing so that -h never prints them as defaults.
+func (c *credentials) fillFromEnv() {
+	if c.Pass == "" {
+		c.Pass = os.Getenv("FSW_AUTH_PASS")
+	}
+	if c.Bearer == "" {
+		c.Bearer = os.Getenv("FSW_AUTH_BEARER")
+	}
+}
+
+// secretParamRe matches the names of query parameters that usually carry
+// credentials, such as token, access_token, api_key, sig or X-Amz-Signature
+var secretParamRe = regexp.MustCompile(`(?i)(token|key|secret|passw|auth|sig|credential)`)
+
+// redactURL hides the password of a URL with user info, and the values of
+// query parameters that look like credentials, for messages and manifests
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if q := u.Query(); len(q) > 0 {
+		redacted := false
+		for name := range q {
+			if secretParamRe.MatchString(name) {
+				q.Set(name, "xxxxx")
+				redacted = true
+			}
+		}
+		if redacted {
+			u.RawQuery = q.Encode()
+		}
+	}
+	return u.Redacted()
+}
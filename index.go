@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"          // Package for reading the index line by line
+	"compress/bzip2" // Package for the .bz2 index and the selected streams
+	"errors"         // Package for error handling
+	"fmt"            // Package for formatted I/O
+	"io"             // Package for I/O primitives
+	"net/http"       // Package for fetching a remote index
+	"os"             // Package for OS functions (file access)
+	"regexp"         // Package for finding the root tag
+	"strconv"        // Package for offsets and page IDs
+	"strings"        // Package for string manipulation
+)
+
+// streamRange is a run of adjacent multistream streams, [off, end) in the compressed dump
+type streamRange struct {
+	off, end int64 // Compressed byte range; end is 0 for "to the end of the dump"
+}
+
+// streamSelection is what an index picks out of a multistream dump
+type streamSelection struct {
+	header int64         // Offset of the first indexed stream, ending the siteinfo header
+	ranges []streamRange // Runs of streams holding a page inside [MinID, MaxID]
+	single []streamRange // The streams of ranges one by one, for -prefetch-streams
+	picked int           // Streams in ranges
+	total  int           // Streams listed in the index
+}
+
+// readStreamIndex reads a multistream index ("offset:page_id:title" lines,
+// usually the -index .txt.bz2 published next to the dump) and selects the
+// streams holding a page inside [cfg.MinID, cfg.MaxID]
+func readStreamIndex(cfg *config) (*streamSelection, error) {
+	var raw io.ReadCloser
+	if strings.HasPrefix(cfg.Index, "http://") || strings.HasPrefix(cfg.Index, "https://") {
+		resp, err := httpGet(cfg.ctx(), cfg.Index, cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download index: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to download index: %w: %s", ErrBadStatus, resp.Status)
+		}
+		raw = resp.Body
+	} else {
+		f, err := os.Open(cfg.Index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open index: %w", err)
+		}
+		raw = f
+	}
+	defer raw.Close()
+	var r io.Reader = raw
+	if strings.HasSuffix(cfg.Index, ".bz2") {
+		r = bzip2.NewReader(r)
+	}
+
+	// Streams follow each other in the index; a stream is wanted when any of
+	// its pages is, and a wanted stream directly after another extends its run
+	sel := &streamSelection{}
+	current, wanted, prevWanted := int64(-1), false, false
+	flush := func(next int64) {
+		if current < 0 {
+			return
+		}
+		sel.total++
+		if wanted {
+			sel.picked++
+			sel.single = append(sel.single, streamRange{current, next})
+			if last := len(sel.ranges) - 1; prevWanted && last >= 0 {
+				sel.ranges[last].end = next
+			} else {
+				sel.ranges = append(sel.ranges, streamRange{current, next})
+			}
+		}
+		prevWanted = wanted
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		offText, rest, ok1 := strings.Cut(sc.Text(), ":")
+		idText, _, ok2 := strings.Cut(rest, ":")
+		off, err1 := strconv.ParseInt(offText, 10, 64)
+		id, err2 := strconv.ParseInt(idText, 10, 64)
+		if !ok1 || !ok2 || err1 != nil || err2 != nil || off < current {
+			return nil, fmt.Errorf("index line %d: want offset:page_id:title in stream order, got %q", line, sc.Text())
+		}
+		if off != current {
+			flush(off)
+			if current < 0 {
+				sel.header = off
+			}
+			current, wanted = off, false
+		}
+		wanted = wanted || cfg.idInRange(id)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	flush(0) // The last stream runs to the trailer at the end of the dump
+	if current < 0 {
+		return nil, fmt.Errorf("index %s lists no pages", redactURL(cfg.Index))
+	}
+	return sel, nil
+}
+
+// openIndexed returns the decompressed dump restricted to the streams the
+// index selects: the siteinfo header, each selected stream, and the closing
+// </mediawiki>, which comes along when the last stream is selected. section
+// reads a compressed byte range of the dump, whose size is size. With
+// -prefetch-streams the streams are decompressed concurrently instead, one
+// by one, and Close stops the decompressors.
+func openIndexed(cfg *config, size int64, section func(off, end int64) io.Reader) (io.ReadCloser, error) {
+	sel, err := readStreamIndex(cfg)
+	if err != nil {
+		return nil, err
+	}
+	stream := func(off, end int64) io.Reader {
+		return bzip2.NewReader(progressReader{section(off, end), cfg.Progress})
+	}
+	selected := sel.header
+	closed := false
+	for _, rg := range sel.ranges {
+		if rg.end == 0 {
+			rg.end, closed = size, true
+		}
+		selected += rg.end - rg.off
+	}
+	trailer := &rootTrailer{}
+	if closed {
+		trailer.r = strings.NewReader("")
+	}
+	cfg.Progress.size = selected
+	fmt.Fprintf(os.Stderr, "Index selects %d of %d streams (%s of %s).\n", sel.picked, sel.total, formatBytes(selected), formatBytes(size))
+	if cfg.PrefetchStreams > 0 {
+		streams := append([]streamRange{{0, sel.header}}, sel.single...)
+		for i := range streams {
+			if streams[i].end == 0 {
+				streams[i].end = size
+			}
+		}
+		p := newStreamPrefetcher(cfg, streams, func(off, end int64) io.Reader { return bzip2.NewReader(section(off, end)) })
+		return readCloser{io.MultiReader(trailer.watch(p), trailer), p}, nil
+	}
+	parts := []io.Reader{trailer.watch(stream(0, sel.header))}
+	for _, rg := range sel.ranges {
+		if rg.end == 0 {
+			rg.end = size
+		}
+		parts = append(parts, stream(rg.off, rg.end))
+	}
+	return io.NopCloser(io.MultiReader(append(parts, trailer)...)), nil
+}
+
+// rootTagRe finds the <mediawiki> start tag and its prefix, as in <mw:mediawiki
+var rootTagRe = regexp.MustCompile(`<([\w.-]+:)?mediawiki[\s>]`)
+
+// rootTrailer is the </mediawiki> ending an -index selection that leaves out
+// the last stream. It is written with the prefix the dump's root tag has, so
+// that it closes <mw:mediawiki> too; watch shows it the header stream.
+type rootTrailer struct {
+	head []byte    // Start of the header stream
+	r    io.Reader // The end tag, made on the first Read
+}
+
+// watch passes r through, keeping its first bytes, where the root tag is
+func (t *rootTrailer) watch(r io.Reader) io.Reader {
+	return headReader{r, t}
+}
+
+func (t *rootTrailer) Read(b []byte) (int, error) {
+	if t.r == nil {
+		prefix := ""
+		if m := rootTagRe.FindSubmatch(t.head); m != nil {
+			prefix = string(m[1])
+		}
+		t.r = strings.NewReader("</" + prefix + "mediawiki>\n")
+	}
+	return t.r.Read(b)
+}
+
+// headReader feeds a rootTrailer the first bytes of the header stream
+type headReader struct {
+	r io.Reader
+	t *rootTrailer
+}
+
+func (h headReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	if keep := min(n, 4096-len(h.t.head)); keep > 0 {
+		h.t.head = append(h.t.head, b[:keep]...)
+	}
+	return n, err
+}
+
+// openIndexedInput opens the -index selection of the -input file or, through
+// range requests, of the -url dump. It returns nil when the server cannot
+// serve ranges, after saying so, and the dump is streamed whole instead.
+func openIndexedInput(cfg *config) (io.ReadCloser, error) {
+	cfg.Progress = &inputProgress{}
+	if cfg.Input != "" {
+		f, err := os.Open(cfg.Input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		r, err := openIndexed(cfg, info.Size(), func(off, end int64) io.Reader { return io.NewSectionReader(f, off, end-off) })
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{r, closeBoth{r, f}}, nil
+	}
+	remote, err := newRemoteReaderAt(cfg.ctx(), cfg.URL, cfg.Auth, cfg.RangeBlock, cfg.RangeCache)
+	if errors.Is(err, errNoRanges) {
+		fmt.Fprintf(os.Stderr, "warning: %s: %v; reading the whole dump instead of the streams -index selects\n", redactURL(cfg.URL), err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openIndexed(cfg, remote.Size(), remote.section)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json" // Package for the Parquet column
+	"encoding/xml"  // Package for XML encoding
+	"sort"          // Package for ordering the XML parameters
+	"strings"       // Package for string manipulation
+)
+
+// infobox holds the named parameters of one infobox. It encodes as
+// <infobox><param name="capital">Paris</param>...</infobox> in XML, sorted by
+// name, and as an object in JSON.
+type infobox map[string]string
+
+func (ib infobox) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type param struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	}
+	params := make([]param, 0, len(ib))
+	for name, value := range ib {
+		params = append(params, param{name, value})
+	}
+	sort.Slice(params, func(a, b int) bool { return params[a].Name < params[b].Name })
+	return e.EncodeElement(struct {
+		Param []param `xml:"param"`
+	}{params}, start)
+}
+
+// UnmarshalXML reads the <param> elements back, for lookup and random
+func (ib *infobox) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Param []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"param"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*ib = make(infobox, len(v.Param))
+	for _, p := range v.Param {
+		(*ib)[p.Name] = p.Value
+	}
+	return nil
+}
+
+// json is the infobox as a JSON object, for formats without nested fields
+func (ib infobox) json() string {
+	if len(ib) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(map[string]string(ib))
+	return string(data)
+}
+
+// extractInfobox returns the named parameters of the first invocation of the
+// template called name, e.g. "Infobox country": capital, population and so on.
+// Values are the wikitext with comments removed and whitespace collapsed, so
+// values spanning lines come out on one. With -plain they are cleaned like
+// the abstract, except that a value made only of templates, such as a
+// {{birth date|...}}, keeps its wikitext rather than coming out empty.
+// Positional parameters and empty values are left out; a repeated name
+// keeps its last value, as MediaWiki does.
+func (c *cleaner) extractInfobox(ts []template, name string, plain bool) infobox {
+	for _, t := range ts {
+		if !strings.EqualFold(t.Name, name) {
+			continue
+		}
+		ib := infobox{}
+		for _, p := range t.Params {
+			key, value, ok := splitNamed(p)
+			if !ok || key == "" {
+				continue
+			}
+			value = collapseSpace(commentRe.ReplaceAllString(value, ""))
+			if plain {
+				if cleaned := tidyPunctuation(collapseSpace(c.clean(value))); cleaned != "" {
+					value = cleaned
+				}
+			}
+			if value != "" {
+				ib[key] = value
+			}
+		}
+		if len(ib) == 0 {
+			return nil
+		}
+		return ib
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings" // Package for string manipulation
+)
+
+// ipacLabels are IPAc-* positional parameters that label a transcription rather than spell it
+var ipacLabels = map[string]bool{"lang": true, "local": true, "pron": true, "also": true, "US": true, "UK": true}
+
+// extractIPA returns the first pronunciation among the lead section's templates.
+// Handled templates (first match wins, in text order):
+//
+//	{{IPA-fr|paʁi|...}}       language-specific form; the first parameter is the transcription
+//	{{IPA|fr|paʁi}}           generic form with a leading language code
+//	{{IPA|/ˈæpəl/}}           generic form without a language code
+//	{{IPAc-en|ˈ|æ|p|əl}}      segmented form; segments are joined and wrapped in slashes
+//
+// {{respell|PAIR|ee}} is used as a fallback, joined with hyphens, when no IPA is present.
+func extractIPA(lead []template) string {
+	respell := ""
+	for _, t := range lead {
+		args := t.positional()
+		switch {
+		case strings.HasPrefix(t.Name, "IPAc-"):
+			var segs []string
+			for _, a := range args {
+				if !ipacLabels[a] {
+					segs = append(segs, a)
+				}
+			}
+			if len(segs) > 0 {
+				return "/" + strings.Join(segs, "") + "/"
+			}
+		case strings.HasPrefix(t.Name, "IPA-") && t.Name != "IPA-all":
+			if len(args) > 0 && args[0] != "" {
+				return args[0]
+			}
+		case t.Name == "IPA":
+			if len(args) > 1 && interwikiRe.MatchString(args[0]) {
+				return args[1]
+			}
+			if len(args) > 0 && args[0] != "" {
+				return args[0]
+			}
+		case t.Name == "Respell" && respell == "" && len(args) > 0:
+			respell = strings.Join(args, "-")
+		}
+	}
+	return respell
+}
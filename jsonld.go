@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json" // Package for JSON encoding
+	"io"            // Package for I/O primitives
+)
+
+// jsonldDoc is one doc as a schema.org Article in JSON-LD
+type jsonldDoc struct {
+	Context  json.RawMessage `json:"@context"`         // The shared context, see jsonldContext
+	ID       string          `json:"@id"`              // The page URL, the subject of the ntriples writer too
+	Type     string          `json:"@type"`            // Always "Article"
+	Name     string          `json:"name"`             // Page title
+	URL      string          `json:"url"`              // Page URL
+	Abstract string          `json:"abstract"`         // Abstract text
+	SameAs   string          `json:"sameAs,omitempty"` // Wikidata entity IRI (-wikidata)
+}
+
+// jsonldContext is the inline context of every object: schema.org terms,
+// IRI-valued url and sameAs, and the -lang literal language. It is inline so
+// that RDF tooling can expand the output without fetching schema.org.
+func jsonldContext(lang string) json.RawMessage {
+	ctx := map[string]any{
+		"@vocab": "https://schema.org/",
+		"url":    map[string]string{"@type": "@id"},
+		"sameAs": map[string]string{"@type": "@id"},
+	}
+	if lang != "" {
+		ctx["@language"] = lang
+	}
+	data, _ := json.Marshal(ctx)
+	return data
+}
+
+// jsonldWriter emits one self-contained JSON-LD object per line, so the
+// output streams like JSONL and each line expands on its own
+type jsonldWriter struct {
+	enc     *json.Encoder   // Encoder bound to the destination stream
+	context json.RawMessage // Encoded once, repeated on every line
+}
+
+func newJSONLDWriter(w io.Writer, cfg *config) (docWriter, error) {
+	lang := cfg.Lang
+	if lang == "simple" {
+		lang = "en" // Simple English is English, as in ntriples
+	}
+	if !langTagRe.MatchString(lang) {
+		lang = ""
+	}
+	return &jsonldWriter{enc: newJSONEncoder(w, cfg), context: jsonldContext(lang)}, nil
+}
+
+func (j *jsonldWriter) WriteDoc(doc *Doc) error {
+	iri, err := ntIRI(doc.URL)
+	if err != nil {
+		return err // An @id must be an absolute IRI too
+	}
+	iri = iri[1 : len(iri)-1] // The ntriples subject, so both formats expand to the same triples
+	ld := jsonldDoc{Context: j.context, ID: iri, Type: "Article", Name: doc.Title, URL: iri, Abstract: doc.Abstract}
+	if doc.WikidataID != "" {
+		ld.SameAs = wikidataEntity + doc.WikidataID
+	}
+	return j.enc.Encode(ld)
+}
+
+func (j *jsonldWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings" // Package for string manipulation
+)
+
+// Page kinds reported by pageKind
+const (
+	kindArticle        = "article"
+	kindStub           = "stub"
+	kindList           = "list"
+	kindDisambiguation = "disambiguation"
+)
+
+// disambiguationTemplates mark disambiguation and set-index pages
+var disambiguationTemplates = map[string]bool{
+	"Disambiguation": true, "Disambig": true, "Dab": true, "Disamb": true, "Hndis": true, "Geodis": true,
+	"Given name": true, "Surname": true, "Set index article": true, "Sia": true, "Shipindex": true,
+}
+
+// pageKind classifies a page from its title and the templates it uses
+func pageKind(title string, templates []template) string {
+	if strings.HasSuffix(title, "(disambiguation)") {
+		return kindDisambiguation
+	}
+	stub := false
+	for _, t := range templates {
+		if disambiguationTemplates[t.Name] {
+			return kindDisambiguation
+		}
+		if strings.HasSuffix(strings.ToLower(t.Name), "stub") {
+			stub = true
+		}
+	}
+	switch {
+	case strings.HasPrefix(title, "List of ") || strings.HasPrefix(title, "Lists of "):
+		return kindList
+	case stub:
+		return kindStub
+	}
+	return kindArticle
+}
+
+// shortDescription returns the {{Short description}} text, if the page has one
+func shortDescription(templates []template) string {
+	for _, t := range templates {
+		if t.Name == "Short description" {
+			if args := t.positional(); len(args) > 0 && !strings.EqualFold(args[0], "none") {
+				return args[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"        // Package for buffered input
+	"bytes"        // Package for byte slice manipulation
+	"encoding/xml" // Package for the lenient decoder
+	"io"           // Package for I/O primitives
+	"strings"      // Package for string manipulation
+)
+
+// byteRecorder feeds the strict decoder one byte at a time, which keeps the
+// decoder from buffering ahead, and keeps the bytes of the current page so a
+// page the strict decoder rejects can be decoded again leniently
+type byteRecorder struct {
+	r      *bufio.Reader // Decompressed dump
+	prefix []byte        // Bytes served before r: the root tag and what followed the last lenient page
+	schema dumpSchema    // The dump's, once its root is read
+	rec    []byte        // Bytes served since the last mark
+	read   int64         // Bytes served to the current decoder, prefix included
+	n      int64         // Bytes consumed from r
+}
+
+func newByteRecorder(r io.Reader) *byteRecorder {
+	return &byteRecorder{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+func (b *byteRecorder) ReadByte() (byte, error) {
+	var c byte
+	if len(b.prefix) > 0 {
+		c, b.prefix = b.prefix[0], b.prefix[1:]
+	} else {
+		var err error
+		if c, err = b.r.ReadByte(); err != nil {
+			return 0, err
+		}
+		b.n++
+	}
+	b.rec = append(b.rec, c)
+	b.read++
+	return c, nil
+}
+
+func (b *byteRecorder) Read(p []byte) (int, error) {
+	for i := range p {
+		c, err := b.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		p[i] = c
+	}
+	return len(p), nil
+}
+
+// mark starts recording at the decoder's offset consumed, keeping the bytes
+// it has read past that
+func (b *byteRecorder) mark(consumed int64) {
+	keep := int(b.read - consumed)
+	b.rec = b.rec[:copy(b.rec, b.rec[len(b.rec)-keep:])]
+}
+
+// lenientPage decodes again the page recorded since the last mark, which the
+// strict decoder rejected: it reads on to </page>, replaces the characters
+// XML forbids, and decodes with Strict off and the HTML entities known, so a
+// stray & or control byte no longer costs the page. It returns the page's
+// length in the stream and, for the strict decoder resuming after it, the
+// stream offset of the decoder's first byte. A stream ending before </page>
+// returns io.ErrUnexpectedEOF.
+func (b *byteRecorder) lenientPage() (p *page, length, base int64, err error) {
+	endTag := "</" + b.schema.prefix + "page>"
+	end := bytes.Index(b.rec, []byte(endTag))
+	for end < 0 {
+		if _, err := b.ReadByte(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, 0, err
+		}
+		if bytes.HasSuffix(b.rec, []byte(endTag)) {
+			end = len(b.rec) - len(endTag)
+		}
+	}
+	end += len(endTag)
+	raw := strings.ToValidUTF8(string(b.rec[:end]), "\uFFFD")
+	raw = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return ' '
+		}
+		return r
+	}, raw)
+
+	// The strict decoder starts over right after </page>, with the bytes
+	// already read, in a root of its own so that the dump's closing
+	// </mediawiki> has a start tag and its elements keep their namespace
+	leftover, root := b.rec[end:], b.schema.rootTag()
+	base = b.n - int64(len(b.prefix)+len(leftover)) - int64(len(root))
+	b.prefix = append(append([]byte(root), leftover...), b.prefix...)
+	b.rec, b.read = b.rec[:0], 0
+
+	dec := xml.NewDecoder(strings.NewReader(raw))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	p = &page{}
+	if err := dec.Decode(p); err != nil {
+		return nil, int64(end), base, err
+	}
+	p.hasRevision = strings.Contains(raw, "<"+b.schema.prefix+"revision")
+	p.hasNS = strings.Contains(raw, "<"+b.schema.prefix+"ns>")
+	return p, int64(end), base, nil
+}
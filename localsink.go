@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"  // Package for error inspection
+	"fmt"     // Package for formatted I/O
+	"io"      // Package for I/O primitives
+	"net"     // Package for Unix socket connections
+	"os"      // Package for OS functions (file modes)
+	"syscall" // Package for the broken pipe error numbers
+)
+
+// localSink is a -o that names a Unix socket or FIFO: the docs stream to
+// the consumer process reading it, without a file on disk
+type localSink struct {
+	w    io.WriteCloser // Connection or pipe
+	kind string         // "unix socket" or "FIFO", for messages
+	path string         // The -o path
+}
+
+// Kinds of local sink
+const (
+	sinkSocket = "unix socket"
+	sinkFIFO   = "FIFO"
+)
+
+// localSinkKind tells whether path is a Unix socket or a FIFO; "" means
+// neither, and the output is an ordinary file
+func localSinkKind(path string) string {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return "" // Missing files are created as usual
+	case info.Mode()&os.ModeSocket != 0:
+		return sinkSocket
+	case info.Mode()&os.ModeNamedPipe != 0:
+		return sinkFIFO
+	}
+	return ""
+}
+
+// openLocalSink connects to the socket or opens the FIFO at path. Opening a
+// FIFO waits until a consumer opens it for reading.
+func openLocalSink(path, kind string) (*localSink, error) {
+	var w io.WriteCloser
+	var err error
+	if kind == sinkSocket {
+		w, err = net.Dial("unix", path)
+	} else {
+		w, err = os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output %s: %w", kind, err)
+	}
+	return &localSink{w: w, kind: kind, path: path}, nil
+}
+
+func (s *localSink) Write(b []byte) (int, error) {
+	n, err := s.w.Write(b)
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return n, fmt.Errorf("the consumer of %s %s disconnected: %w", s.kind, s.path, err)
+	}
+	return n, err
+}
+
+func (s *localSink) Close() error { return s.w.Close() }
@@ -1,112 +1,1211 @@
-package main
-
-import (
-	"compress/bzip2" // Package for bzip2 decompression
-	"encoding/xml"   // Package for XML encoding/decoding
-	"fmt"            // Package for formatted I/O
-	"io"             // Package for I/O primitives
-	"net/http"       // Package for HTTP client functionality
-	"os"             // Package for OS functions (file creation)
-	"strings"        // Package for string manipulation
-)
-
-// Doc represents the <doc> element in the output XML
-type Doc struct {
-	XMLName  xml.Name `xml:"doc"`      // XML element name
-	Title    string   `xml:"title"`    // Title of the page
-	URL      string   `xml:"url"`      // URL of the wiki page
-	Abstract string   `xml:"abstract"` // First paragraph of the page
-}
-
-func main() {
-	// 1. Define the URL of the compressed Wikipedia dump
-	url := "https://dumps.wikimedia.org/enwiki/latest/enwiki-latest-pages-articles-multistream.xml.bz2"
-
-	// 2. Send an HTTP GET request to download the compressed data
-	resp, err := http.Get(url)
-	if err != nil {
-		panic(fmt.Errorf("failed to download dump: %w", err))
-	}
-	defer resp.Body.Close() // Ensure the response body is closed
-
-	// 3. Verify a successful HTTP response
-	if resp.StatusCode != http.StatusOK {
-		panic(fmt.Errorf("bad status: %s", resp.Status))
-	}
-
-	// 4. Create a bzip2 reader to decompress on-the-fly
-	bzReader := bzip2.NewReader(resp.Body)
-
-	// 5. Create the output file for the abstracts XML
-	out, err := os.Create("abstracts.xml")
-	if err != nil {
-		panic(fmt.Errorf("failed to create output file: %w", err))
-	}
-	defer out.Close() // Ensure the output file is closed
-
-	// 6. Write the XML header and opening <documents> tag
-	fmt.Fprintf(out, xml.Header)
-	fmt.Fprintln(out, "<documents>")
-
-	// 7. Initialize the XML decoder to read from the decompressed stream
-	dec := xml.NewDecoder(bzReader)
-
-	// 8. Loop through tokens until EOF
-	for {
-		tok, err := dec.Token()
-		if err == io.EOF {
-			break // End of file
-		}
-		if err != nil {
-			panic(fmt.Errorf("XML token error: %w", err))
-		}
-
-		// 9. Filter for start elements named <page>
-		start, ok := tok.(xml.StartElement)
-		if !ok || start.Name.Local != "page" {
-			continue // Not a <page> start element
-		}
-
-		// 10. Decode the entire <page> element into a temporary struct
-		var p struct {
-			Title    string `xml:"title"` // Page title
-			Revision struct {
-				Text string `xml:"text"` // Page content
-			} `xml:"revision"`
-		}
-		if err := dec.DecodeElement(&p, &start); err != nil {
-			panic(fmt.Errorf("failed to decode page element: %w", err))
-		}
-
-		// 11. Split the page text at the first blank line to get the abstract
-		parts := strings.SplitN(p.Revision.Text, "\n\n", 2)
-		abstract := strings.TrimSpace(parts[0])
-		if len(abstract) == 0 {
-			continue // Skip pages with empty abstracts
-		}
-
-		// 12. Construct the URL for the wiki page from its title
-		wikiTitle := strings.ReplaceAll(p.Title, " ", "_")
-		pageURL := "https://en.wikipedia.org/wiki/" + wikiTitle
-
-		// 13. Create a Doc instance and marshal it to indented XML
-		doc := Doc{
-			Title:    p.Title,
-			URL:      pageURL,
-			Abstract: abstract,
-		}
-		output, err := xml.MarshalIndent(doc, "  ", "    ")
-		if err != nil {
-			panic(fmt.Errorf("failed to marshal Doc: %w", err))
-		}
-
-		// 14. Write the marshaled <doc> element to the output file
-		fmt.Fprintln(out, string(output))
-	}
-
-	// 15. Write the closing </documents> tag
-	fmt.Fprintln(out, "</documents>")
-
-	// 16. Notify the user that processing is done
-	fmt.Println("Done! abstracts.xml is ready.")
-}
+package main
+
+import (
+	"bufio"         // Package for buffered output
+	"compress/gzip" // Package for gzip output
+	"context"       // Package for the -timeout of the run
+	"errors"        // Package for error inspection
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"math/rand/v2"  // Package for the -seed source
+	"os"            // Package for OS functions (file creation)
+	"slices"        // Package for checking flag values against lists
+	"strconv"       // Package for string conversions
+	"strings"       // Package for string manipulation
+	"sync/atomic"   // Package for the output byte count
+	"time"          // Package for durations
+)
+
+// config holds the settings of one extraction run
+type config struct {
+	URL                 string                      // Dump URL to stream from
+	Input               string                      // Local dump file, used instead of URL when set
+	Inputs              []string                    // The part files of a comma-separated -input, read in order as one dump
+	Follow              bool                        // Keep reading -input as another process appends to it
+	FollowGrace         time.Duration               // -follow ends once the input has not grown for this long
+	FollowSentinel      string                      // -follow ends once this file exists
+	ExpectedSize        int64                       // -follow ends once this many input bytes are read
+	SizeFromStatus      bool                        // Look ExpectedSize up in the run's dumpstatus.json
+	Multistream         bool                        // The dump is the multistream variant (see isMultistream)
+	Index               string                      // Multistream index selecting the streams to read by -min-id/-max-id
+	PrefetchStreams     int                         // Decompressed -index streams held ahead of the parser (0: decompress serially)
+	RangeBlock          int64                       // Bytes per cached block of -index range requests
+	RangeCache          int64                       // Memory for cached blocks of -index range requests
+	Lang                string                      // Wiki language code (e.g. "en", "simple")
+	Langs               []string                    // Every language of a -lang list, each run on its own dump
+	LangJobs            int                         // Languages processed at once (-lang-jobs)
+	Project             wikiProject                 // Wikimedia project of the dump (-project)
+	Output              string                      // Output file path
+	Format              string                      // Output format name (see writerFactories)
+	Compression         string                      // Output compression implied by -o, e.g. "gzip" for .gz
+	GzipLevel           int                         // gzip.NewWriterLevel level for .gz output
+	MaxOutputBytes      int64                       // Stop once the output reaches this many bytes (0: no cap)
+	Skip                int                         // Docs passing every filter that are dropped before any is written
+	Limit               int                         // Stop after writing this many docs (0: no limit)
+	MaxOutputMeasure    string                      // Whether -max-output-bytes counts "uncompressed" or "compressed" bytes
+	OutputSize          func() int64                // Output produced so far as MaxOutputMeasure counts it, set up by run
+	ESURL               string                      // Elasticsearch base URL to index into instead of -o
+	ESIndex             string                      // Elasticsearch index name
+	ESBatch             int                         // Docs per _bulk request
+	ParquetRowGroup     int                         // Rows buffered per Parquet row group
+	ParquetCompression  string                      // Parquet page codec (see parquetCodecs)
+	Template            string                      // Per-doc text/template file (-format template)
+	TemplateHeader      string                      // Template rendered once before the docs
+	TemplateFooter      string                      // Template rendered once after the docs
+	TemplatePerDoc      string                      // Path template giving each doc its own file
+	Templates           *docTemplates               // Parsed templates, loaded by parseFlags
+	MinID               int64                       // Lowest page ID processed
+	MaxID               int64                       // Highest page ID processed (0: no limit)
+	Shard               *shardSpec                  // Keep only the pages of one -shard-index (nil: all)
+	Workers             int                         // Goroutines building docs (-workers; 1: none)
+	Ordered             bool                        // Write docs in dump order despite -workers
+	ReorderBuffer       int                         // Docs -ordered holds while waiting for a slow page
+	Namespaces          []int                       // Namespaces to keep; empty keeps every page
+	SkipRedirects       bool                        // Drop redirect pages
+	AbstractHTML        bool                        // Also emit the abstract as sanitized HTML
+	Plain               bool                        // Strip wiki markup from abstracts
+	SentencesArray      bool                        // Also emit the abstract as an array of sentences
+	Quickstart          bool                        // Use the simplewiki dump with beginner-friendly defaults
+	Demo                bool                        // Read the embedded sample dump instead of downloading
+	Exec                string                      // Command whose stdin receives the output instead of a file
+	ExtractIPA          bool                        // Capture the first IPA pronunciation into Doc.IPA
+	ExtractRefs         bool                        // Emit the external URLs cited in the lead
+	ExtractDates        bool                        // Emit birth and death dates from date templates
+	ExtractAliases      bool                        // Emit the names bolded in the lead besides the title
+	ExtractInfobox      string                      // Emit the parameters of this infobox template
+	Slug                bool                        // Emit a URL-safe slug of each title
+	SlugOptions         SlugOptions                 // How slugs spell non-Latin scripts (-slug-scripts)
+	SlugCollisions      string                      // "suffix" numbers repeated slugs, "allow" leaves them
+	WithOffset          bool                        // Emit where each doc\'s page lies in the dump
+	WithStreamIndex     bool                        // Emit the ordinal of the bzip2 stream holding each doc's page
+	WithProvenance      bool                        // Emit the input file of each doc and its offset there
+	Score               bool                        // Emit the heuristic quality score
+	MinScore            int                         // Drop pages scoring below this
+	MaxDepth            int                         // Deepest template/link nesting the cleaner parses
+	CollapseReferences  bool                        // Remove <ref> citations and their content during cleanup
+	Paragraphs          int                         // Lead paragraphs per abstract
+	PreserveParagraphs  bool                        // Join -plain paragraphs with a blank line instead of a space
+	LegacyAbstracts     bool                        // Take the abstract as the original binary did: the page text up to its first blank line
+	AbstractMode        string                      // What the abstract holds: first-paragraph or exintro
+	ExSentences         int                         // Sentences an exintro abstract keeps (0: all)
+	ExChars             int                         // Characters an exintro abstract is cut after (0: all)
+	PageTimeout         time.Duration               // Cleanup budget per page (0: none)
+	Timeout             time.Duration               // Budget of the whole run (0: none)
+	Ctx                 context.Context             // Ends with -timeout: requests, the page loop, cleanup and -exec stop, set up by run
+	Cache               string                      // Directory of cleaned results keyed by revision ID (-cache)
+	CacheMaxMB          int64                       // -cache size cap in MiB (0: none)
+	RedirectsOnly       bool                        // Emit the redirect graph instead of abstracts
+	NoEscapeHTML        bool                        // Write <, > and & literally in JSON output
+	Canonical           bool                        // Sort the docs by title and write canonical JSON (-canonical)
+	Wikidata            string                      // title<TAB>QID mapping file
+	WikidataOnDisk      bool                        // Read the mapping back from disk instead of holding its titles in memory
+	EnrichSummary       bool                        // Fill short_description and image from the REST page/summary endpoint
+	EnrichRate          float64                     // Summary requests per second
+	EnrichEndpoint      string                      // Summary URL prefix (default: the -lang wiki\'s /api/rest_v1/page/summary/)
+	ValidateURLs        bool                        // Check every constructed page URL
+	DropInvalidURLs     bool                        // Skip docs whose URL fails the check
+	Dedup               bool                        // Drop pages whose title was already seen
+	SampleK             int                         // Keep a uniform random sample of this many docs (0: all)
+	DedupMode           string                      // "exact" (map) or "bloom" (bounded memory)
+	DedupExpected       int                         // Titles the Bloom filter is sized for
+	DedupFPRate         float64                     // Bloom filter false-positive rate
+	TitleKey            titleKey                    // How titles are keyed for -dedup and -wikidata
+	HasTemplates        stringList                  // Keep only pages invoking one of these templates
+	NotTemplates        stringList                  // Drop pages invoking any of these templates
+	BBox                *bbox                       // Keep only pages with a {{coord}} inside this box (nil: no geo filter)
+	RenderTemplates     stringList                  // -render-template rules
+	TemplatesAsText     string                      // File mapping template names to formats they render as (-templates-as-text)
+	RenderMapLines      []string                    // Entries of TemplatesAsText as written, for the -cache key
+	ReplaceFile         string                      // File of regex replacements for the final abstract (-replace-file)
+	ReplaceRules        []replaceRule               // Rules read from ReplaceFile
+	TemplateRenderers   map[string]templateRenderer // Templates rendered as text by -plain, built from RenderTemplates
+	Auth                credentials                 // Dump server credentials
+	Network             networkOptions              // -offline and -log-requests
+	Schedule            scheduleOptions             // Off-peak window the download waits for
+	Budget              errorBudget                 // Undecodable pages tolerated before aborting
+	FailOnAnomaly       bool                        // Exit with status 5 when the dump shows anomalies
+	Manifest            string                      // Path of the run manifest to write
+	StatsFile           string                      // Path of the JSON counters file to write, even after a failure or interrupt
+	Offsets             string                      // TSV file of each doc's <page> byte range
+	MaxFieldBytes       int                         // Longest field of the CSV and TSV outputs, in bytes (0: no limit)
+	TruncateTitles      bool                        // Cut doc titles to maxTitleBytes
+	TrimFields          stringList                  // -trim-field FIELD=BYTES rules
+	FieldTrims          []fieldTrim                 // Rules read from TrimFields
+	TitlePrefix         string                      // Put before every doc title, {lang} replaced
+	SetFields           stringList                  // -set-field NAME=VALUE pairs
+	ConstFields         map[string]string           // Pairs read from SetFields, added to every doc as extra
+	Middleware          []DocMiddleware             // Called on each doc after the built-ins, before the writer (see docChain)
+	EmitIndex           bool                        // Write a title index of the output next to it (-emit-index)
+	Atomic              bool                        // Write the output files under .tmp names and rename them into place on success
+	IndexBlock          int                         // Records per gzip member of indexed .gz output
+	OutIndex            *outputIndex                // Entries of -emit-index, set up by run
+	Similarity          string                      // TSV file of near-duplicate abstract pairs (-similarity)
+	SimilarityThreshold float64                     // Lowest Jaccard similarity of a reported pair
+	SimilarityVerify    bool                        // Score candidates by exact Jaccard instead of the MinHash estimate
+	MinHashes           int                         // Hash functions per MinHash signature
+	ShingleWords        int                         // Words per shingle (-similarity, -fingerprint)
+	Fingerprint         bool                        // Add a SimHash of the abstract to each doc
+	Products            map[string]bool             // -products: abstracts plus the side products written next to -o (nil: abstracts only)
+	MaxSignatures       int                         // Signatures held in memory before spilling to the workdir
+	SiteInfoRecord      bool                        // Write the siteinfo as the first JSONL record
+	SiteInfoOut         string                      // Write the siteinfo to this JSON file
+	Workdir             string                      // Scratch directory (default: a fresh one under os.TempDir)
+	Spool               bool                        // Queue docs on disk in front of a slow sink (-spool)
+	SpoolMax            int64                       // MiB of unsent segments past which extraction waits
+	SpoolSegment        int64                       // MiB per spool segment file
+	CPUProfile          string                      // File to write a CPU profile to
+	MemProfile          string                      // File to write a heap profile to
+	ProfileSeconds      int                         // Only profile the first N seconds
+	ProfilePages        int                         // Only profile the first N pages
+	Profiler            *profiler                   // Running profiles, set up by run
+	Stop                *atomic.Bool                // Set by SIGINT/SIGTERM to end the run after the current page (-stats-file)
+	Work                *workdir                    // Scratch space of the run, set up by run
+	Progress            *inputProgress              // Raw dump bytes consumed, set up by openInput
+	Streams             *streamTracker              // Compressed stream offsets for -with-offset and -with-stream-index, set up by openInput
+	Sources             *inputRegistry              // Input files of -with-provenance, set up by openInput
+	Options                                         // Clock and random source
+	Seed                uint64                      // -seed, from which each -lang language derives its own source (0: random)
+	Classify            bool                        // Emit length class and readability per doc
+	TopN                int                         // Report the top N pages by size and cleanup time (0: off)
+	RevisionAge         bool                        // Report percentiles of revision age against the dump date and run start
+	RevisionAgeField    bool                        // Also emit revision_age_days per doc
+	DumpDate            string                      // YYYY-MM-DD dump date for -revision-age; "" reads it from the dump name
+	LengthBounds        map[string][4]int           // Per-language word counts where each length class starts
+}
+
+// usageError marks a command-line mistake that has already been reported
+type usageError struct {
+	err error // What was wrong with the arguments
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+// parseFlags builds a config from the extract subcommand's arguments
+func parseFlags(args []string) (*config, error) {
+	cfg := &config{Options: defaultOptions(), Budget: errorBudget{MinSample: defaultMinSample}}
+	fs := flag.NewFlagSet("full-stream-wiki extract", flag.ContinueOnError)
+	fs.StringVar(&cfg.URL, "url", "", "dump URL (default: latest multistream dump for -lang)")
+	fs.StringVar(&cfg.Input, "input", "", "read a local dump file (.xml or .xml.bz2) instead of downloading; a comma-separated list of part files is read in order as one dump")
+	fs.BoolVar(&cfg.Follow, "follow", false, "keep reading -input while another process is still downloading it")
+	fs.DurationVar(&cfg.FollowGrace, "follow-grace", 2*time.Minute, "with -follow, treat the download as complete once the file has not grown for this long (0: never)")
+	fs.StringVar(&cfg.FollowSentinel, "follow-sentinel", "", "with -follow, treat the download as complete once this `file` exists")
+	expectedSize := fs.String("expected-size", "", "with -follow, the complete input size in `bytes`, or dumpstatus to look it up in the dump run's dumpstatus.json")
+	authFlags(fs, &cfg.Auth)
+	networkFlags(fs, &cfg.Network)
+	scheduleFlags(fs, &cfg.Schedule)
+	fs.StringVar(&cfg.Lang, "lang", "en", "wiki language code used for the default dump and page URLs; a comma-separated list processes each language's dump into its own output")
+	fs.IntVar(&cfg.LangJobs, "lang-jobs", 1, "with several -lang, download and process this many languages at once")
+	project := fs.String("project", "wikipedia", "Wikimedia project of the dump: wikipedia, wiktionary, wikibooks, wikinews, wikiquote, wikisource, wikiversity or wikivoyage; sets the default dump and page URLs, namespaces and abstract heuristics")
+	fs.StringVar(&cfg.Output, "o", "abstracts.xml", "output file path")
+	fs.StringVar(&cfg.Exec, "exec", "", "stream the output into this shell command's stdin instead of -o")
+	fs.StringVar(&cfg.Format, "format", "xml", "output format: "+strings.Join(formatNames(), ", "))
+	fs.StringVar(&cfg.ESURL, "es-url", "", "index docs into Elasticsearch at this base `URL` via _bulk instead of writing -o")
+	fs.StringVar(&cfg.ESIndex, "es-index", "abstracts", "Elasticsearch index for -es-url")
+	fs.IntVar(&cfg.ESBatch, "es-batch", 500, "docs per Elasticsearch _bulk request")
+	fs.IntVar(&cfg.ParquetRowGroup, "parquet-row-group", 50_000, "rows per Parquet row group, the unit held in memory (-format parquet)")
+	fs.IntVar(&cfg.Skip, "skip", 0, "drop the first `N` docs that pass every filter, then write the rest; with -limit, for manual sharding")
+	fs.IntVar(&cfg.Limit, "limit", 0, "stop after writing `N` docs (0: no limit)")
+	fs.Int64Var(&cfg.MaxOutputBytes, "max-output-bytes", 0, "stop after the doc that brings the output to `N` bytes, closing the document properly (0: no cap)")
+	fs.StringVar(&cfg.MaxOutputMeasure, "max-output-measure", "uncompressed", "what -max-output-bytes counts: uncompressed (bytes the format produces) or compressed (bytes that reach a .gz file)")
+	fs.IntVar(&cfg.GzipLevel, "gzip-level", gzip.DefaultCompression, "compression level of .gz output: 1 is fastest, 9 smallest, 0 stores uncompressed, -1 is gzip's default (6); levels past 6 spend noticeably more CPU for a few percent less output")
+	fs.StringVar(&cfg.ParquetCompression, "parquet-compression", "snappy", "Parquet page compression: snappy, gzip or none")
+	fs.StringVar(&cfg.Template, "template", "", "text/template `file` rendered per doc with -format template (helpers: json, xmlescape, urlquery, trunc, slug)")
+	fs.StringVar(&cfg.TemplateHeader, "template-header", "", "template `file` rendered once before the first doc")
+	fs.StringVar(&cfg.TemplateFooter, "template-footer", "", "template `file` rendered once after the last doc")
+	fs.StringVar(&cfg.TemplatePerDoc, "template-out-per-doc", "", "write each doc to its own file at this path `template`, e.g. \"pages/{{.Title | slug}}.md\"")
+	multistream := fs.String("multistream", "auto", "dump variant: yes (pages-articles-multistream), no (pages-articles, one bzip2 stream) or auto (from the name); no also picks the single-stream default -url")
+	namespaces := fs.String("namespaces", "", "comma-separated namespace numbers to keep (default: all)")
+	fs.Int64Var(&cfg.MinID, "min-id", 0, "skip pages whose <id> is below this")
+	fs.Int64Var(&cfg.MaxID, "max-id", 0, "skip pages whose <id> is above this (0: no limit)")
+	shardIndex := fs.Int("shard-index", 0, "keep only the pages of this shard, from 0 to -shard-count minus 1")
+	shardCount := fs.Int("shard-count", 0, "split the pages into this many shards by a stable hash, for runs on several machines (0: no sharding)")
+	shardBy := fs.String("shard-by", "title", "shard key: title (normalized) or id (page ID)")
+	fs.StringVar(&cfg.Index, "index", "", "multistream index `file` or URL (…-multistream-index.txt.bz2); only the streams holding pages within -min-id/-max-id are read, with range requests when streaming from -url")
+	fs.IntVar(&cfg.PrefetchStreams, "prefetch-streams", 0, "with -index, decompress the selected bzip2 streams on -workers goroutines, holding up to `N` ahead of the parser in dump order (0: one at a time)")
+	rangeBlockKB := fs.Int64("range-block-kb", 1024, "size of the blocks -index fetches from -url and caches, in KiB")
+	rangeCacheMB := fs.Int64("range-cache-mb", 64, "memory for the blocks -index caches, in MiB")
+	fs.BoolVar(&cfg.SkipRedirects, "skip-redirects", false, "drop redirect pages")
+	fs.BoolVar(&cfg.RedirectsOnly, "redirects-only", false, "emit {from, to} redirect pairs instead of abstracts (-format jsonl or csv)")
+	fs.StringVar(&cfg.Wikidata, "wikidata", "", "add wikidata_id from this title<TAB>QID `file`")
+	fs.BoolVar(&cfg.WikidataOnDisk, "wikidata-on-disk", false, "keep only title hashes of the -wikidata file in memory and read matches back from disk")
+	fs.BoolVar(&cfg.EnrichSummary, "enrich-summary", false, "add short_description and image (lead thumbnail URL) from the REST page/summary endpoint, one request per doc; slow, see -enrich-rate")
+	fs.Float64Var(&cfg.EnrichRate, "enrich-rate", 10, "most -enrich-summary requests per second")
+	fs.StringVar(&cfg.EnrichEndpoint, "enrich-endpoint", "", "summary URL prefix the title is appended to (default: https://<lang>.<project>.org/api/rest_v1/page/summary/)")
+	fs.BoolVar(&cfg.ValidateURLs, "validate-urls", false, "check that every page URL parses back to its title, logging and counting offenders")
+	fs.BoolVar(&cfg.DropInvalidURLs, "drop-invalid-urls", false, "with -validate-urls, also skip the offending docs")
+	titleKeySpec := fs.String("title-key", "exact", "how titles are compared by -dedup and -wikidata: exact, or a comma list of space (underscores as spaces) and fold (ignore case)")
+	fs.IntVar(&cfg.SampleK, "sample-k", 0, "write a uniform random sample of exactly `K` docs (all of them when fewer qualify), held in memory until the end")
+	fs.Uint64Var(&cfg.Seed, "seed", 0, "seed the random source of -sample-k and -similarity for reproducible output (0: random)")
+	fs.IntVar(&cfg.Workers, "workers", 1, "goroutines cleaning pages and building docs in parallel; docs come out in completion order unless -ordered")
+	fs.BoolVar(&cfg.Ordered, "ordered", false, "with -workers, write docs in dump order, buffering those finished early")
+	fs.IntVar(&cfg.ReorderBuffer, "reorder-buffer", 10000, "docs -ordered buffers behind a page still being built before failing the run")
+	fs.BoolVar(&cfg.Dedup, "dedup", false, "drop pages whose title was already seen")
+	fs.StringVar(&cfg.DedupMode, "dedup-mode", "exact", "title memory for -dedup: exact (map, grows with the dump) or bloom (fixed size, approximate)")
+	fs.IntVar(&cfg.DedupExpected, "dedup-expected", 10_000_000, "titles the -dedup-mode bloom filter is sized for")
+	fs.Float64Var(&cfg.DedupFPRate, "dedup-fp-rate", 0.001, "chance that -dedup-mode bloom drops a title it has not seen")
+	fs.StringVar(&cfg.TemplatesAsText, "templates-as-text", "", "with -plain, render the templates listed in this `file` as text, one \"name = format\" per line with {1}, {2} or {key} for their parameters, e.g. \"convert = {1} {2}\"; unlisted templates are removed")
+	fs.Var(&cfg.RenderTemplates, "render-template", "with -plain, render this `template` as text instead of removing it: a built-in rule (convert, nowrap, lang, ...; all for every one) or NAME=PATTERN with $1, $2 for its parameters (repeatable)")
+	fs.StringVar(&cfg.ReplaceFile, "replace-file", "", "apply the regex replacements in this `file`, one \"pattern<TAB>replacement\" per line, in order to every final abstract")
+	fs.Var(&cfg.HasTemplates, "has-template", "keep only pages invoking this `template` (repeatable; any one suffices)")
+	fs.Var(&cfg.NotTemplates, "not-template", "drop pages invoking this `template` (repeatable)")
+	bboxFlag := fs.String("bbox", "", "keep only pages with a {{coord}} inside this `box` of minLat,minLon,maxLat,maxLon in decimal degrees")
+	fs.BoolVar(&cfg.Plain, "plain", false, "strip wiki markup (templates, links, formatting) from abstracts")
+	fs.IntVar(&cfg.Paragraphs, "paragraphs", 1, "lead paragraphs per abstract")
+	fs.BoolVar(&cfg.LegacyAbstracts, "legacy-abstracts", false, "take each abstract as the original single-binary version did: the raw page text up to its first blank line")
+	fs.StringVar(&cfg.AbstractMode, "abstract-mode", "first-paragraph", "what the abstract holds: first-paragraph (the first -paragraphs paragraphs), or exintro (the whole cleaned lead without references, as the TextExtracts API's exintro gives; implies -plain)")
+	fs.IntVar(&cfg.ExSentences, "exsentences", 0, "with -abstract-mode exintro, keep only the first `N` sentences, as the TextExtracts parameter does")
+	fs.IntVar(&cfg.ExChars, "exchars", 0, "with -abstract-mode exintro, cut the abstract after `N` characters, at the end of a word, with an ellipsis, as the TextExtracts parameter does")
+	fs.BoolVar(&cfg.PreserveParagraphs, "preserve-paragraphs", false, "with -plain, keep a blank line (\\n\\n) between the -paragraphs paragraphs of an abstract instead of joining them with a space")
+	fs.BoolVar(&cfg.AbstractHTML, "abstract-html", false, "add abstract_html: the lead paragraph as sanitized HTML with bold, italics and links kept")
+	fs.BoolVar(&cfg.ExtractIPA, "extract-ipa", false, "capture the first {{IPA}}/{{IPAc-en}}/{{respell}} pronunciation in the lead")
+	fs.Float64Var(&cfg.Budget.MaxRate, "max-error-rate", 0.01, "abort when more than this fraction of pages fails to decode (checked after 1000 pages; 1 disables)")
+	fs.IntVar(&cfg.Budget.MaxErrors, "max-errors", 1000, "abort when more than this many pages fail to decode (-1 disables)")
+	fs.BoolVar(&cfg.FailOnAnomaly, "fail-on-anomaly", false, "exit with status 5 when the dump shows anomalies (page IDs out of order or repeated, pages without title or revision, a missing or early </mediawiki>)")
+	fs.BoolVar(&cfg.Spool, "spool", false, "queue docs in segment files under the workdir and feed a slow sink (-es-url, -exec) from them in the background, so extraction runs at full speed; segments a failed run leaves are sent by the next run with the same -workdir")
+	fs.Int64Var(&cfg.SpoolMax, "spool-max-mb", 1024, "MiB of unsent -spool segments past which extraction waits for the sink")
+	fs.Int64Var(&cfg.SpoolSegment, "spool-segment-mb", 16, "MiB per -spool segment file")
+	fs.StringVar(&cfg.Workdir, "workdir", "", "`dir` for scratch files, removed after a successful run (default: "+workdirPrefix+"<runid> under the temp dir)")
+	fs.BoolVar(&cfg.SiteInfoRecord, "siteinfo-record", false, "with -format jsonl, write the dump's siteinfo as the first record, tagged \"_type\":\"siteinfo\"")
+	fs.StringVar(&cfg.SiteInfoOut, "siteinfo-out", "", "write the dump's siteinfo (site name, base URL, case rule, namespaces) to this JSON `file`")
+	fs.StringVar(&cfg.Similarity, "similarity", "", "write pairs of near-duplicate abstracts, found by MinHash and LSH in the same pass, to this TSV `file` (title_a, title_b, score)")
+	fs.Float64Var(&cfg.SimilarityThreshold, "similarity-threshold", 0.7, "lowest Jaccard similarity of the word shingles of a -similarity pair")
+	fs.BoolVar(&cfg.SimilarityVerify, "similarity-verify", false, "score -similarity candidates by their exact Jaccard similarity instead of the MinHash estimate; keeps every abstract")
+	fs.IntVar(&cfg.MinHashes, "minhash-hashes", 128, "hash functions per -similarity signature: more give better estimates for more CPU and memory")
+	products := fs.String("products", "", "comma-separated `list` of what one pass writes: abstracts (to -o) plus links, categories and redirects, each to a JSONL file named after -o, e.g. out.links.jsonl")
+	fs.BoolVar(&cfg.Fingerprint, "fingerprint", false, "add fingerprint: a 64-bit SimHash of the abstract's word shingles as 16 hex digits, for clustering near-duplicates downstream")
+	fs.IntVar(&cfg.ShingleWords, "shingle-words", 3, "words per -similarity and -fingerprint shingle")
+	fs.IntVar(&cfg.MaxSignatures, "similarity-max-signatures", 500_000, "-similarity signatures held in memory; later ones spill to the workdir")
+	fs.BoolVar(&cfg.Atomic, "atomic", false, "write -o, its -emit-index and -template-out-per-doc files to .tmp files renamed into place only once complete, so readers never see a partial file")
+	fs.BoolVar(&cfg.EmitIndex, "emit-index", false, "write a title index of the output to the -o file plus .idx, for the lookup subcommand (-format xml or jsonl)")
+	fs.IntVar(&cfg.IndexBlock, "emit-index-block", 1000, "with -emit-index and .gz output, records per gzip member, each decompressible on its own")
+	fs.IntVar(&cfg.MaxFieldBytes, "max-field-bytes", 0, "cut longer fields of the CSV and TSV outputs (-redirects-only -format csv, -offsets, -similarity) to this many bytes, ending them with … (0: no limit)")
+	fs.BoolVar(&cfg.TruncateTitles, "truncate-titles", false, "cut doc titles longer than MediaWiki's 255-byte limit to 255 bytes, ending them with …")
+	fs.Var(&cfg.TrimFields, "trim-field", "cut a text field of every doc to at most `FIELD=BYTES`, ending it with … (title, abstract, abstract_html, ipa or short_description; repeatable)")
+	fs.StringVar(&cfg.TitlePrefix, "title-prefix", "", "put `PREFIX` before every doc title, {lang} replaced by the language, e.g. \"{lang}:\" for merging the outputs of several wikis")
+	fs.Var(&cfg.SetFields, "set-field", "add `NAME=VALUE` to every doc's extra fields, {lang} replaced by the language, e.g. source=enwiki-20240601 (repeatable)")
+	fs.StringVar(&cfg.Offsets, "offsets", "", "record each doc's page ID, title and decompressed <page> byte offset and length in this TSV `file`")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", "", "write a CPU profile (go tool pprof) to this `file`")
+	fs.StringVar(&cfg.MemProfile, "memprofile", "", "write a heap profile to this `file` when profiling ends")
+	fs.IntVar(&cfg.ProfileSeconds, "profile-seconds", 0, "end the profiles after `N` seconds instead of with the run")
+	fs.IntVar(&cfg.ProfilePages, "profile-pages", 0, "end the profiles after `N` pages instead of with the run")
+	fs.StringVar(&cfg.Manifest, "manifest", "", "write a JSON summary of the run, error budget included, to this `file`")
+	fs.BoolVar(&cfg.SentencesArray, "sentences-array", false, "also emit the abstract split into sentences: repeated <sentence> elements in XML, a sentences array in JSON (best with -plain)")
+	fs.IntVar(&cfg.TopN, "top-n", 0, "track the N largest pages, slowest cleanups and largest docs, and report them at the end and in -stats-file (0: off, no tracking cost)")
+	fs.BoolVar(&cfg.RevisionAge, "revision-age", false, "report p50/p90/p99/max age of each written doc's latest revision against the dump date and the run start, also in -stats-file")
+	fs.BoolVar(&cfg.RevisionAgeField, "revision-age-field", false, "also emit revision_age_days per doc (implies -revision-age)")
+	fs.StringVar(&cfg.DumpDate, "dump-date", "", "dump `date` (YYYY-MM-DD) for -revision-age (default: from the dump name, e.g. enwiki-20240601-...)")
+	fs.StringVar(&cfg.StatsFile, "stats-file", "", "write every counter of the run (skips by reason, bytes, duration, pages/sec) as JSON to this `file`, also when it fails or is interrupted")
+	fs.BoolVar(&cfg.Slug, "slug", false, "emit a lowercase, hyphenated ASCII-folded slug of each title")
+	slugScripts := fs.String("slug-scripts", "keep", "what slugs do with letters of non-Latin scripts: keep them, or spell them as hex code points")
+	fs.StringVar(&cfg.SlugCollisions, "slug-collisions", "suffix", "repeated slugs within the run: suffix (-2, -3, ...) or allow")
+	fs.BoolVar(&cfg.WithProvenance, "with-provenance", false, "emit source_file and source_offset, the input file each doc was read from and the decompressed offset of its page there, and list the inputs with their SHA-1 in -manifest")
+	fs.BoolVar(&cfg.WithOffset, "with-offset", false, "emit offset, the page's byte offset in the decompressed dump, and stream_offset, the offset of the bzip2 stream holding it")
+	fs.BoolVar(&cfg.WithStreamIndex, "with-stream-index", false, "emit stream_index, the ordinal of the bzip2 stream holding the page in a multistream dump (the siteinfo header is stream 0; 0 for any other dump)")
+	fs.StringVar(&cfg.ExtractInfobox, "extract-infobox", "", "add infobox: the |key = value parameters of the first {{`NAME`}} template, e.g. \"Infobox country\"")
+	fs.BoolVar(&cfg.ExtractAliases, "extract-aliases", false, "capture aliases: the other names bolded in the first paragraph of the lead, besides the title")
+	fs.BoolVar(&cfg.ExtractDates, "extract-dates", false, "capture birth_date and death_date from {{birth date}}, {{death date and age}} and similar templates")
+	fs.BoolVar(&cfg.ExtractRefs, "extract-refs", false, "capture the external URLs ({{cite ...|url=}}, [url label], bare URLs) in the lead")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "end the run with an error after this long, e.g. 2h, aborting requests, cleanup and -exec in flight (0: no limit)")
+	fs.DurationVar(&cfg.PageTimeout, "page-timeout", 0, "give up cleaning a page after this long, e.g. 5s, and write its naive abstract instead (0: no limit)")
+	fs.StringVar(&cfg.Cache, "cache", "", "keep cleaned abstracts keyed by revision ID in this `dir`, so later runs over the same revisions skip the cleanup")
+	fs.Int64Var(&cfg.CacheMaxMB, "cache-max-mb", 8192, "size cap of -cache in MiB; past it the least recently used results are evicted at the end of a run (0: none)")
+	fs.BoolVar(&cfg.CollapseReferences, "collapse-references", false, "with -plain or -abstract-html, remove <ref>...</ref> and <ref name=... /> citations with their content instead of leaving the citation text in the abstract")
+	fs.IntVar(&cfg.MaxDepth, "max-depth", defaultMaxDepth, "deepest {{template}}/[[link]] nesting parsed; deeper regions are dropped")
+	fs.BoolVar(&cfg.NoEscapeHTML, "no-escape-html", false, "write <, > and & literally in JSON output instead of as \\u003c, \\u003e, \\u0026")
+	fs.BoolVar(&cfg.Canonical, "canonical", false, "with -format jsonl, write the docs sorted by title with sorted keys and fixed formatting, so runs over two dumps diff by content only")
+	fs.BoolVar(&cfg.Classify, "classify", false, "emit length_class (stub..very-long by word count) and readability per doc")
+	lengthFile := fs.String("length-classes", "", "JSON `file` of per-language length class boundaries, e.g. {\"de\": [120, 400, 1200, 4000]}")
+	fs.BoolVar(&cfg.Score, "score", false, "emit a heuristic 0-100 quality score per doc")
+	fs.IntVar(&cfg.MinScore, "min-score", 0, "drop docs whose quality score is below `N`")
+	fs.BoolVar(&cfg.Quickstart, "quickstart", false, "process the small simplewiki dump with sensible defaults")
+	fs.BoolVar(&cfg.Demo, "demo", false, "process the embedded sample dump offline with -quickstart defaults")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		return nil, &usageError{err}
+	}
+	cfg.Auth.fillFromEnv()
+	cfg.Network.install()
+	// invalid reports a bad flag value the same way flag reports parse errors
+	invalid := func(err error) (*config, error) {
+		fmt.Fprintln(fs.Output(), err)
+		return nil, &usageError{err}
+	}
+	if err := cfg.Auth.check(); err != nil {
+		return invalid(err)
+	}
+
+	// Quickstart and demo only fill in flags the user left untouched
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if cfg.Quickstart || cfg.Demo {
+		defaults := map[string]string{
+			"lang":           "simple",
+			"namespaces":     "0",
+			"skip-redirects": "true",
+			"plain":          "true",
+			"format":         "jsonl",
+			"o":              "abstracts.jsonl",
+		}
+		for name, value := range defaults {
+			if !set[name] {
+				if err := fs.Set(name, value); err != nil {
+					return invalid(err)
+				}
+			}
+		}
+	}
+
+	var err error
+	if cfg.Project, err = lookupProject(*project); err != nil {
+		return invalid(err)
+	}
+	if strings.Contains(cfg.Lang, ",") {
+		if err := cfg.parseLangs(set); err != nil {
+			return invalid(err)
+		}
+	} else if set["lang-jobs"] {
+		return invalid(fmt.Errorf("-lang-jobs needs several -lang values"))
+	}
+	if !set["namespaces"] && *namespaces == "" {
+		*namespaces = cfg.Project.namespaces
+	}
+	for _, field := range strings.Split(*namespaces, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		ns, err := strconv.Atoi(field)
+		if err != nil {
+			return invalid(fmt.Errorf("invalid namespace %q: %w", field, err))
+		}
+		cfg.Namespaces = append(cfg.Namespaces, ns)
+	}
+	if cfg.URL == "" {
+		cfg.URL = cfg.Project.dumpURL(cfg.Lang, *multistream != "no")
+	}
+	if cfg.Input == "" && !cfg.Demo {
+		if err := cfg.Auth.fillFromNetrc(cfg.URL); err != nil {
+			return invalid(err)
+		}
+	}
+	name := cfg.URL
+	if cfg.Input != "" {
+		name = cfg.Input
+	}
+	if cfg.Multistream, err = isMultistream(*multistream, name); err != nil {
+		return invalid(err)
+	}
+	if cfg.Demo && *multistream == "auto" {
+		cfg.Multistream = true // The sample is built as one
+	}
+	if cfg.Index != "" && *multistream == "auto" {
+		cfg.Multistream = true // Only multistream dumps have an index
+	}
+	if cfg.LengthBounds, err = loadLengthBounds(*lengthFile); err != nil {
+		return invalid(err)
+	}
+	if cfg.Budget.MaxRate < 0 || cfg.Budget.MaxRate > 1 {
+		return invalid(fmt.Errorf("-max-error-rate must be between 0 and 1"))
+	}
+	switch *slugScripts {
+	case "keep":
+	case "hex":
+		cfg.SlugOptions.HexScripts = true
+	default:
+		return invalid(fmt.Errorf("unknown -slug-scripts %q (want keep or hex)", *slugScripts))
+	}
+	if cfg.SlugCollisions != "suffix" && cfg.SlugCollisions != "allow" {
+		return invalid(fmt.Errorf("unknown -slug-collisions %q (want suffix or allow)", cfg.SlugCollisions))
+	}
+	if cfg.ExpectedSize, cfg.SizeFromStatus, err = parseExpectedSize(*expectedSize); err != nil {
+		return invalid(err)
+	}
+	if strings.Contains(cfg.Input, ",") {
+		cfg.Inputs = strings.Split(cfg.Input, ",")
+		switch {
+		case slices.Contains(cfg.Inputs, ""):
+			return invalid(fmt.Errorf("-input %q names an empty part", cfg.Input))
+		case cfg.Follow || cfg.Index != "" || cfg.Demo:
+			return invalid(fmt.Errorf("several -input parts cannot be combined with -follow, -index or -demo"))
+		case cfg.WithOffset || cfg.WithStreamIndex || cfg.Offsets != "":
+			return invalid(fmt.Errorf("several -input parts have no offsets in one dump for -with-offset, -with-stream-index and -offsets; -with-provenance gives them per part"))
+		}
+	}
+	if cfg.Follow {
+		if err := checkFollowInput(cfg); err != nil {
+			return invalid(err)
+		}
+	} else if *expectedSize != "" || cfg.FollowSentinel != "" {
+		return invalid(fmt.Errorf("-expected-size and -follow-sentinel only apply with -follow"))
+	}
+	if cfg.TemplateRenderers, err = parseRenderRules(cfg.RenderTemplates); err != nil {
+		return invalid(err)
+	}
+	if cfg.TemplatesAsText != "" {
+		var mapped map[string]templateRenderer
+		if mapped, cfg.RenderMapLines, err = loadRenderMap(cfg.TemplatesAsText); err != nil {
+			return invalid(err)
+		}
+		for name, r := range cfg.TemplateRenderers {
+			mapped[name] = r // A -render-template rule wins over the file
+		}
+		cfg.TemplateRenderers = mapped
+	}
+	if cfg.FieldTrims, err = parseFieldTrims(cfg.TrimFields); err != nil {
+		return invalid(err)
+	}
+	if cfg.ConstFields, err = parseSetFields(cfg.SetFields); err != nil {
+		return invalid(err)
+	}
+	if cfg.ReplaceFile != "" {
+		if cfg.ReplaceRules, err = loadReplaceRules(cfg.ReplaceFile); err != nil {
+			return invalid(err)
+		}
+	}
+	if cfg.SampleK < 0 {
+		return invalid(fmt.Errorf("-sample-k must not be negative"))
+	}
+	if cfg.Similarity == "" {
+		for _, name := range []string{"similarity-threshold", "similarity-verify", "minhash-hashes", "similarity-max-signatures"} {
+			if set[name] {
+				return invalid(fmt.Errorf("-%s needs -similarity", name))
+			}
+		}
+	}
+	if set["shingle-words"] && cfg.Similarity == "" && !cfg.Fingerprint {
+		return invalid(fmt.Errorf("-shingle-words needs -similarity or -fingerprint"))
+	}
+	switch {
+	case cfg.SimilarityThreshold <= 0 || cfg.SimilarityThreshold > 1:
+		return invalid(fmt.Errorf("-similarity-threshold must be above 0 and at most 1"))
+	case cfg.MinHashes < 1 || cfg.MinHashes > 1024:
+		return invalid(fmt.Errorf("-minhash-hashes must be between 1 and 1024"))
+	case cfg.ShingleWords < 1:
+		return invalid(fmt.Errorf("-shingle-words must be at least 1"))
+	case cfg.MaxSignatures < 0:
+		return invalid(fmt.Errorf("-similarity-max-signatures must not be negative"))
+	case cfg.Similarity != "" && cfg.RedirectsOnly:
+		return invalid(fmt.Errorf("-similarity needs abstracts and cannot be combined with -redirects-only"))
+	}
+	if cfg.Seed != 0 {
+		cfg.Rand = rand.New(rand.NewPCG(cfg.Seed, 0))
+	}
+	if cfg.Index != "" {
+		switch {
+		case !cfg.Multistream || !strings.HasSuffix(name, ".bz2"):
+			err = fmt.Errorf("-index needs a multistream .bz2 dump")
+		case cfg.Demo || cfg.Follow:
+			err = fmt.Errorf("-index cannot be combined with -demo or -follow")
+		case cfg.WithOffset || cfg.WithStreamIndex || cfg.Offsets != "":
+			err = fmt.Errorf("-with-offset, -with-stream-index and -offsets cannot be combined with -index, which skips part of the dump")
+		case *rangeBlockKB < 1 || *rangeCacheMB < 1:
+			err = fmt.Errorf("-range-block-kb and -range-cache-mb must be positive")
+		}
+		if err != nil {
+			return invalid(err)
+		}
+		cfg.RangeBlock, cfg.RangeCache = *rangeBlockKB<<10, *rangeCacheMB<<20
+	}
+	switch {
+	case cfg.PrefetchStreams < 0:
+		return invalid(fmt.Errorf("-prefetch-streams must not be negative"))
+	case cfg.PrefetchStreams > 0 && cfg.Index == "":
+		return invalid(fmt.Errorf("-prefetch-streams needs -index, which gives the stream offsets"))
+	}
+	if cfg.Network.Offline {
+		var needs string
+		switch {
+		case cfg.Input == "" && !cfg.Demo:
+			needs = "downloading the dump (use -input)"
+		case cfg.ESURL != "":
+			needs = "-es-url"
+		case cfg.EnrichSummary:
+			needs = "-enrich-summary"
+		case cfg.SizeFromStatus:
+			needs = "-expected-size dumpstatus"
+		case strings.HasPrefix(cfg.Index, "http://") || strings.HasPrefix(cfg.Index, "https://"):
+			needs = "a remote -index"
+		}
+		if needs != "" {
+			return invalid(fmt.Errorf("-offline rules out %s, which needs the network", needs))
+		}
+	}
+	if cfg.TopN < 0 || cfg.Timeout < 0 {
+		return invalid(fmt.Errorf("-top-n and -timeout must not be negative"))
+	}
+	cfg.RevisionAge = cfg.RevisionAge || cfg.RevisionAgeField
+	if cfg.RevisionAge && cfg.RedirectsOnly {
+		return invalid(fmt.Errorf("-revision-age measures written docs, which -redirects-only does not produce"))
+	}
+	if cfg.DumpDate != "" {
+		if _, err := time.Parse("2006-01-02", cfg.DumpDate); err != nil {
+			return invalid(fmt.Errorf("-dump-date %q is not a YYYY-MM-DD date", cfg.DumpDate))
+		}
+	}
+	if cfg.EnrichSummary && cfg.EnrichRate <= 0 {
+		return invalid(fmt.Errorf("-enrich-rate must be positive"))
+	}
+	if cfg.TitleKey, err = parseTitleKey(*titleKeySpec); err != nil {
+		return invalid(err)
+	}
+	if cfg.DropInvalidURLs {
+		cfg.ValidateURLs = true
+	}
+	if cfg.Dedup {
+		if cfg.DedupExpected < 1 || cfg.DedupFPRate <= 0 || cfg.DedupFPRate >= 1 {
+			return invalid(fmt.Errorf("-dedup-expected must be positive and -dedup-fp-rate between 0 and 1"))
+		}
+		if cfg.DedupMode != "exact" && cfg.DedupMode != "bloom" {
+			return invalid(fmt.Errorf("unknown -dedup-mode %q (want exact or bloom)", cfg.DedupMode))
+		}
+	}
+	if cfg.MinID < 0 || cfg.MaxID < 0 || cfg.MaxID > 0 && cfg.MaxID < cfg.MinID {
+		return invalid(fmt.Errorf("-min-id and -max-id must be non-negative with -min-id <= -max-id"))
+	}
+	if cfg.Shard, err = parseShard(*shardIndex, *shardCount, *shardBy); err != nil {
+		return invalid(err)
+	}
+	if cfg.Shard == nil && (set["shard-index"] || set["shard-by"]) {
+		return invalid(fmt.Errorf("-shard-index and -shard-by need -shard-count"))
+	}
+	if *bboxFlag != "" {
+		if cfg.BBox, err = parseBBox(*bboxFlag); err != nil {
+			return invalid(err)
+		}
+	}
+	if cfg.ProfileSeconds < 0 || cfg.ProfilePages < 0 {
+		return invalid(fmt.Errorf("-profile-seconds and -profile-pages must not be negative"))
+	}
+	if cfg.CacheMaxMB < 0 {
+		return invalid(fmt.Errorf("-cache-max-mb must not be negative"))
+	}
+	if set["cache-max-mb"] && cfg.Cache == "" {
+		return invalid(fmt.Errorf("-cache-max-mb needs -cache"))
+	}
+	if cfg.MaxDepth < 1 {
+		return invalid(fmt.Errorf("-max-depth must be at least 1"))
+	}
+	if err := cfg.Schedule.check(); err != nil {
+		return invalid(err)
+	}
+	if cfg.Paragraphs < 1 {
+		return invalid(fmt.Errorf("-paragraphs must be at least 1"))
+	}
+	if cfg.LegacyAbstracts && (cfg.Plain || cfg.AbstractMode != "first-paragraph" || set["paragraphs"] || cfg.PreserveParagraphs) {
+		return invalid(fmt.Errorf("-legacy-abstracts takes the raw first paragraph and cannot be combined with -plain, -abstract-mode, -paragraphs or -preserve-paragraphs"))
+	}
+	if !slices.Contains(abstractModes, cfg.AbstractMode) {
+		return invalid(fmt.Errorf("unknown -abstract-mode %q (want %s)", cfg.AbstractMode, strings.Join(abstractModes, " or ")))
+	}
+	if cfg.AbstractMode == "exintro" {
+		if set["paragraphs"] || cfg.PreserveParagraphs {
+			return invalid(fmt.Errorf("-abstract-mode exintro keeps every lead paragraph, each on its own; drop -paragraphs and -preserve-paragraphs"))
+		}
+		cfg.Plain = true
+	}
+	switch {
+	case cfg.ExSentences < 0 || cfg.ExChars < 0:
+		return invalid(fmt.Errorf("-exsentences and -exchars must not be negative"))
+	case (cfg.ExSentences > 0 || cfg.ExChars > 0) && cfg.AbstractMode != "exintro":
+		return invalid(fmt.Errorf("-exsentences and -exchars need -abstract-mode exintro"))
+	case cfg.ExSentences > 0 && cfg.ExChars > 0:
+		return invalid(fmt.Errorf("-exsentences and -exchars cannot be combined"))
+	}
+	if cfg.PreserveParagraphs && !cfg.Plain {
+		return invalid(fmt.Errorf("-preserve-paragraphs needs -plain; without it abstracts keep the dump's line breaks"))
+	}
+	if cfg.RedirectsOnly {
+		if !set["format"] {
+			cfg.Format = "jsonl"
+		}
+		if err := inferOutputFormat(cfg, set, redirectFormats); err != nil {
+			return invalid(err)
+		}
+		if _, ok := redirectFormats[cfg.Format]; !ok {
+			return invalid(fmt.Errorf("-redirects-only writes jsonl or csv, not %q", cfg.Format))
+		}
+		if !set["o"] {
+			cfg.Output = "redirects." + cfg.Format
+		}
+	} else {
+		if err := inferOutputFormat(cfg, set, docFormatExts()); err != nil {
+			return invalid(err)
+		}
+		if _, ok := writerFactories[cfg.Format]; !ok {
+			return invalid(fmt.Errorf("unknown format %q (want one of %v)", cfg.Format, formatNames()))
+		}
+	}
+	if cfg.ESURL != "" {
+		if cfg.RedirectsOnly || cfg.Exec != "" {
+			return invalid(fmt.Errorf("-es-url cannot be combined with -redirects-only or -exec"))
+		}
+		if cfg.ESBatch < 1 {
+			return invalid(fmt.Errorf("-es-batch must be at least 1"))
+		}
+		cfg.Output = cfg.ESURL + "/" + cfg.ESIndex
+	}
+	if cfg.GzipLevel < gzip.DefaultCompression || cfg.GzipLevel > gzip.BestCompression {
+		return invalid(fmt.Errorf("invalid -gzip-level %d (want 0 to 9, or -1 for the default)", cfg.GzipLevel))
+	}
+	if set["gzip-level"] && cfg.Compression != "gzip" {
+		return invalid(fmt.Errorf("-gzip-level applies to .gz output only"))
+	}
+	if cfg.Skip < 0 || cfg.Limit < 0 {
+		return invalid(fmt.Errorf("-skip and -limit must not be negative"))
+	}
+	if (cfg.Skip > 0 || cfg.Limit > 0) && cfg.RedirectsOnly {
+		return invalid(fmt.Errorf("-skip and -limit count docs and cannot be combined with -redirects-only"))
+	}
+	if cfg.Skip > 0 && cfg.Workers > 1 && !cfg.Ordered {
+		return invalid(fmt.Errorf("-skip with -workers needs -ordered, so that every run counts the docs in dump order"))
+	}
+	if cfg.MaxOutputBytes < 0 {
+		return invalid(fmt.Errorf("-max-output-bytes must not be negative"))
+	}
+	if cfg.MaxOutputMeasure != "uncompressed" && cfg.MaxOutputMeasure != "compressed" {
+		return invalid(fmt.Errorf("invalid -max-output-measure %q (want uncompressed or compressed)", cfg.MaxOutputMeasure))
+	}
+	if set["max-output-measure"] && cfg.MaxOutputBytes == 0 {
+		return invalid(fmt.Errorf("-max-output-measure needs -max-output-bytes"))
+	}
+	if cfg.MaxOutputBytes > 0 && cfg.ESURL != "" {
+		return invalid(fmt.Errorf("-max-output-bytes does not apply to -es-url, which writes no output stream"))
+	}
+	if !cfg.Spool && (set["spool-max-mb"] || set["spool-segment-mb"]) {
+		return invalid(fmt.Errorf("-spool-max-mb and -spool-segment-mb need -spool"))
+	}
+	if cfg.SpoolSegment < 1 || cfg.SpoolMax < cfg.SpoolSegment {
+		return invalid(fmt.Errorf("-spool-segment-mb must be at least 1 and -spool-max-mb at least as large"))
+	}
+	if cfg.Spool && (cfg.RedirectsOnly || cfg.SiteInfoRecord || cfg.MaxOutputBytes > 0) {
+		return invalid(fmt.Errorf("-spool carries docs only and cannot be combined with -redirects-only, -siteinfo-record or -max-output-bytes"))
+	}
+	if *products != "" {
+		if cfg.Products, err = parseProducts(*products); err != nil {
+			return invalid(err)
+		}
+		if cfg.RedirectsOnly || cfg.Exec != "" || cfg.ESURL != "" {
+			return invalid(fmt.Errorf("-products writes files named after -o and cannot be combined with -redirects-only, -exec or -es-url"))
+		}
+	}
+	if cfg.Workers < 1 || cfg.ReorderBuffer < 1 {
+		return invalid(fmt.Errorf("-workers and -reorder-buffer must be at least 1"))
+	}
+	if cfg.Canonical && cfg.Workers > 1 {
+		// Slug suffixes and -dedup keep the first of a pair to arrive, so the
+		// canonical form needs the pages posted in dump order
+		cfg.Ordered = true
+	}
+	if cfg.Ordered && cfg.Workers == 1 || set["reorder-buffer"] && !cfg.Ordered {
+		return invalid(fmt.Errorf("-ordered needs -workers above 1, and -reorder-buffer needs -ordered"))
+	}
+	if cfg.Workers > 1 && cfg.RedirectsOnly {
+		return invalid(fmt.Errorf("-workers builds docs and cannot be combined with -redirects-only"))
+	}
+	if cfg.MaxOutputBytes > 0 && cfg.SampleK > 0 {
+		return invalid(fmt.Errorf("-max-output-bytes cannot be combined with -sample-k, whose docs are written only at the end"))
+	}
+	if cfg.EmitIndex {
+		switch {
+		case cfg.Format != "xml" && cfg.Format != "jsonl", cfg.RedirectsOnly:
+			return invalid(fmt.Errorf("-emit-index needs -format xml or jsonl"))
+		case cfg.ESURL != "" || cfg.Exec != "":
+			return invalid(fmt.Errorf("-emit-index needs an output file, not -es-url or -exec"))
+		case cfg.IndexBlock < 1:
+			return invalid(fmt.Errorf("-emit-index-block must be positive"))
+		}
+	}
+	if cfg.Canonical {
+		switch {
+		case cfg.Format != "jsonl" || cfg.ESURL != "" || cfg.RedirectsOnly:
+			return invalid(fmt.Errorf("-canonical needs -format jsonl"))
+		case cfg.MaxOutputBytes > 0:
+			return invalid(fmt.Errorf("-canonical cannot be combined with -max-output-bytes, as its docs are written only at the end"))
+		}
+	}
+	if cfg.MaxFieldBytes != 0 && cfg.MaxFieldBytes < 2*len(fieldCutMark) {
+		return invalid(fmt.Errorf("-max-field-bytes must be 0 or at least %d", 2*len(fieldCutMark)))
+	}
+	if cfg.Atomic && (cfg.ESURL != "" || cfg.Exec != "") {
+		return invalid(fmt.Errorf("-atomic needs an output file, not -es-url or -exec"))
+	}
+	if cfg.SiteInfoRecord && (cfg.Format != "jsonl" || cfg.ESURL != "") {
+		return invalid(fmt.Errorf("-siteinfo-record needs -format jsonl"))
+	}
+	if cfg.Format == "parquet" {
+		if _, ok := parquetCodecs[cfg.ParquetCompression]; !ok {
+			return invalid(fmt.Errorf("unknown -parquet-compression %q (want snappy, gzip or none)", cfg.ParquetCompression))
+		}
+		if cfg.ParquetRowGroup < 1 {
+			return invalid(fmt.Errorf("-parquet-row-group must be at least 1"))
+		}
+	}
+	if cfg.Format == "template" {
+		if cfg.TemplatePerDoc != "" && (cfg.TemplateHeader != "" || cfg.TemplateFooter != "") {
+			return invalid(fmt.Errorf("-template-header and -template-footer apply to single-stream output, not -template-out-per-doc"))
+		}
+		if cfg.Templates, err = loadDocTemplates(cfg); err != nil {
+			return invalid(err)
+		}
+		if !set["o"] {
+			cfg.Output = "abstracts.txt"
+		}
+	}
+	if cfg.Format == "zim" && !set["o"] {
+		cfg.Output = "abstracts.zim"
+	}
+	return cfg, nil
+}
+
+// inferOutputFormat takes the format and compression from the extension of an
+// explicit -o; an explicit -format wins, with a warning when the two disagree
+func inferOutputFormat(cfg *config, set map[string]bool, formats map[string][]string) error {
+	if !set["o"] || cfg.Exec != "" || cfg.ESURL != "" {
+		return nil
+	}
+	format, compression, ext := inferFormat(cfg.Output, formats)
+	switch {
+	case format == "" && ext != "" && !set["format"]:
+		fmt.Fprintf(os.Stderr, "warning: no format is known for %q files; writing %s (choose one with -format)\n", ext, cfg.Format)
+	case format == "":
+	case !set["format"]:
+		cfg.Format = format
+	case format != cfg.Format:
+		fmt.Fprintf(os.Stderr, "warning: -format %s overrides the %s extension of %s\n", cfg.Format, ext, cfg.Output)
+	}
+	if compression == "zstd" {
+		return fmt.Errorf("zstd output is not supported; use .gz")
+	}
+	cfg.Compression = compression
+	return nil
+}
+
+// run performs the extraction described by cfg: one dump, or one per
+// language of a -lang list
+func run(cfg *config) error {
+	if cfg.Input == "" && !cfg.Demo {
+		cfg.Schedule.wait(cfg.Options)
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		cfg.Ctx, cancel = context.WithTimeout(cfg.ctx(), cfg.Timeout)
+		defer cancel()
+	}
+	if len(cfg.Langs) > 1 {
+		return runLangs(cfg)
+	}
+	_, err := runDump(cfg)
+	return err
+}
+
+// ctx is the context of the run; configs not set up by run have none to end
+func (cfg *config) ctx() context.Context {
+	if cfg.Ctx == nil {
+		return context.Background()
+	}
+	return cfg.Ctx
+}
+
+// ctxErr is the error of a run whose context has ended, saying what was
+// stopped: exit status 130 on a signal, else the -timeout or the caller's
+// cancel, wrapping ctx.Err()
+func (cfg *config) ctxErr(stopped string) error {
+	ctx := cfg.ctx()
+	switch {
+	case ctx.Err() == nil:
+		return nil
+	case signalled(ctx):
+		return &exitCodeError{code: 130, err: errInterrupted}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("-timeout: stopped %s: %w", stopped, ctx.Err())
+	}
+	return fmt.Errorf("stopped %s: %w", stopped, ctx.Err())
+}
+
+// runDump performs one extraction described by cfg and returns its counters
+func runDump(cfg *config) (st *stats, err error) {
+	cfg.Work = newWorkdir(cfg.Workdir, cfg.NowFunc())
+	if cfg.Manifest != "" {
+		started := cfg.NowFunc()
+		defer func() {
+			if merr := writeManifest(cfg, st, started, err); merr != nil && err == nil {
+				err = merr
+			}
+		}()
+	}
+	outputBytes := &atomic.Int64{}
+	if cfg.StatsFile != "" {
+		started := cfg.NowFunc()
+		defer func() {
+			if serr := writeStatsFile(cfg, st, started, outputBytes, err); serr != nil && err == nil {
+				err = serr
+			}
+		}()
+	}
+	if cfg.StatsFile != "" || cfg.Atomic {
+		defer stopOnSignal(cfg)() // Lets -atomic remove its .tmp file on an interrupt, too
+	}
+	defer func() { err = cfg.Work.finish(err) }()
+	if cfg.Profiler, err = startProfiles(cfg); err != nil {
+		return st, err
+	}
+	if cfg.Profiler != nil {
+		defer func() {
+			if perr := cfg.Profiler.stop(); perr != nil && err == nil {
+				err = perr
+			}
+		}()
+	}
+
+	// 1. Open the (decompressed) dump stream
+	in, err := openInput(cfg)
+	if err != nil {
+		return st, err
+	}
+	defer in.Close() // Ensure the input stream is closed
+
+	// 2. Open the output destination
+	out, err := openOutput(cfg)
+	if err != nil {
+		return st, err
+	}
+	// fail aborts the destination so a half-fed consumer does not look successful
+	fail := func(err error) error {
+		if a, ok := out.(interface{ Abort(error) error }); ok {
+			return a.Abort(err)
+		}
+		out.Close()
+		return err
+	}
+	buf := bufio.NewWriter(&countingWriter{out, outputBytes})
+	if cfg.MaxOutputBytes > 0 {
+		cfg.OutputSize = outputMeter(cfg, out, buf, outputBytes)
+	}
+	if cfg.EmitIndex {
+		cfg.OutIndex = newOutputIndex(cfg, out, buf, outputBytes)
+	}
+
+	// 3. Stream pages into docs (or redirect pairs)
+	if cfg.RedirectsOnly {
+		st, err = extractRedirects(in, cfg, buf)
+	} else {
+		st, err = extractDocs(in, cfg, buf)
+	}
+	if err != nil {
+		return st, explainDiskFull(cfg, st, fail(err))
+	}
+
+	// 4. Flush everything to the destination
+	if err := buf.Flush(); err != nil {
+		return st, explainDiskFull(cfg, st, fail(fmt.Errorf("failed to flush output: %w", err)))
+	}
+	if err := out.Close(); err != nil {
+		return st, explainDiskFull(cfg, st, fmt.Errorf("failed to close output: %w", err))
+	}
+	if cfg.OutIndex != nil {
+		if err := cfg.OutIndex.write(indexPath(cfg.Output), cfg.Atomic); err != nil {
+			return st, err
+		}
+	}
+
+	// 5. Notify the user that processing is done
+	if st.Truncated {
+		fmt.Fprintf(os.Stderr, "WARNING: the dump stream ended without </mediawiki> after %d pages; the output holds only the pages read before the cut\n", st.Pages)
+	}
+	if st.DecodeErrors > 0 {
+		fmt.Fprintf(os.Stderr, "warning: skipped %d undecodable pages: %s\n", st.DecodeErrors, st.topErrorKinds(3))
+	}
+	if n := st.Anomalies.total(); n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d dump anomalies: %s\n", n, st.Anomalies.summary())
+	}
+	if st.NoNS > 0 {
+		version := st.DumpVersion
+		if version == "" {
+			version = "unknown"
+		}
+		fmt.Fprintf(os.Stderr, "warning: %d pages have no <ns> (export schema %s); their namespaces were taken from their title prefixes\n", st.NoNS, version)
+	}
+	if cfg.Exec != "" {
+		fmt.Fprintf(os.Stderr, "Done! %d docs streamed to %q.\n", st.Written, cfg.Exec)
+		if st.OutputCapped {
+			fmt.Fprintf(os.Stderr, "Output cap of %s reached.\n", formatBytes(cfg.MaxOutputBytes))
+		}
+		return st, st.exitError(cfg)
+	}
+	if cfg.ESURL != "" {
+		fmt.Printf("Done! %d docs sent to %s.\n", st.Written, cfg.Output)
+	} else {
+		fmt.Printf("Done! %s is ready.\n", cfg.Output)
+	}
+	if st.DumpVersion != "" {
+		fmt.Printf("Export schema %s.\n", st.DumpVersion)
+	}
+	if st.OutputCapped {
+		fmt.Printf("Output cap of %s reached; stopped after %d docs.\n", formatBytes(cfg.MaxOutputBytes), st.Written)
+	}
+	if cfg.Skip > 0 || cfg.Limit > 0 {
+		fmt.Printf("Skipped the first %d docs, then wrote %d", st.Skipped, st.Written)
+		if st.LimitReached {
+			fmt.Print(" (-limit reached)")
+		}
+		fmt.Println(".")
+	}
+	if cfg.MinID > 0 || cfg.MaxID > 0 {
+		fmt.Printf("Pages in ID range: %d of %d.\n", st.Pages-st.OutOfRange, st.Pages)
+	}
+	if cfg.Shard != nil {
+		fmt.Printf("Shard %d of %d: %s.\n", cfg.Shard.index, cfg.Shard.count, st.shardShare(cfg.Shard))
+	}
+	for _, pr := range st.Products {
+		fmt.Printf("Product %s: %d from %d pages in %s.\n", pr.Name, pr.Records, pr.Pages, pr.Path)
+	}
+	if cfg.Wikidata != "" {
+		fmt.Printf("Wikidata IDs: %d matched, %d unmatched.\n", st.QIDMatched, st.QIDUnmatched)
+	}
+	if cfg.EnrichSummary {
+		fmt.Printf("Summary enrichment: %d requests, %d failed.\n", st.Enriched, st.EnrichFailed)
+	}
+	if cfg.Cache != "" {
+		fmt.Printf("Cache: %d hits, %d misses in %s.\n", st.CacheHits, st.CacheMisses, cfg.Cache)
+	}
+	if cfg.Similarity != "" {
+		fmt.Printf("Similar abstracts: %d of %d candidate pairs written to %s.\n", st.SimilarPairs, st.SimilarChecked, cfg.Similarity)
+	}
+	if cfg.ValidateURLs {
+		fmt.Printf("Invalid URLs: %d.\n", st.InvalidURLs)
+	}
+	if st.Vetoed+st.ChainErrors > 0 {
+		fmt.Printf("Middleware: vetoed %d docs, skipped %d on errors.\n", st.Vetoed, st.ChainErrors)
+	}
+	if cfg.Dedup {
+		fmt.Printf("Dropped %d duplicate titles.\n", st.Duplicates)
+	}
+	if cfg.SampleK > 0 {
+		fmt.Printf("Sampled %d of %d docs.\n", st.Sampled, st.Written)
+	}
+	if st.TimedOut > 0 {
+		fmt.Printf("Timed out: %d pages fell back to the naive abstract.\n", st.TimedOut)
+	}
+	if tf := newTemplateFilter(cfg); tf.active() {
+		fmt.Printf("Template filters: %s\n", tf.summary(st))
+	}
+	if cfg.BBox != nil {
+		fmt.Printf("Geo filter: %d of %d geotagged pages inside -bbox.\n", st.GeoPassed, st.GeoTagged)
+	}
+	if len(st.Classes) > 0 {
+		printClassHistogram(st)
+	}
+	if st.Top != nil {
+		st.Top.print()
+	}
+	if st.Ages != nil {
+		st.Ages.print()
+	}
+	if cfg.Quickstart || cfg.Demo {
+		printNextSteps(cfg, st)
+	}
+	return st, st.exitError(cfg)
+}
+
+// extractDocs writes the docs of r to out in the configured format, trailer included
+func extractDocs(in io.Reader, cfg *config, out io.Writer) (*stats, error) {
+	w, err := newDocWriter(out, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OutIndex != nil {
+		w = &indexWriter{next: w, ix: cfg.OutIndex}
+	}
+	var spool *spoolWriter
+	if cfg.Spool {
+		if spool, err = newSpoolWriter(w, cfg); err != nil {
+			return nil, err
+		}
+		w = spool
+	}
+	if cfg.Canonical {
+		w = newSortWriter(w, cfg)
+	}
+	var sampler *reservoirWriter
+	if cfg.SampleK > 0 {
+		sampler = newReservoirWriter(w, cfg)
+		w = sampler
+	}
+	st, err := extract(in, cfg, w)
+	if err != nil {
+		if spool != nil {
+			spool.abort()
+		}
+		return st, err
+	}
+	if err := w.Close(); err != nil {
+		return st, fmt.Errorf("failed to finish output: %w", err)
+	}
+	if sampler != nil {
+		st.Sampled = len(sampler.sample)
+	}
+	return st, nil
+}
+
+// openOutput creates the output file, connects to the socket or FIFO it
+// names, or starts the -exec consumer
+func openOutput(cfg *config) (io.WriteCloser, error) {
+	if cfg.ESURL != "" {
+		return nopWriteCloser{io.Discard}, nil // Docs go straight to Elasticsearch
+	}
+	if cfg.Exec != "" {
+		return startExec(cfg.ctx(), cfg.Exec)
+	}
+	if kind := localSinkKind(cfg.Output); kind != "" {
+		if cfg.Compression != "" || cfg.EmitIndex {
+			return nil, fmt.Errorf("-o %s is a %s, which is written uncompressed and without -emit-index", cfg.Output, kind)
+		}
+		if cfg.Atomic {
+			return nil, fmt.Errorf("-o %s is a %s, which -atomic cannot rename into place", cfg.Output, kind)
+		}
+		return openLocalSink(cfg.Output, kind)
+	}
+	path := cfg.Output
+	if cfg.Atomic {
+		path += ".tmp"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	out := &outputFile{File: f}
+	if cfg.Atomic {
+		out.final = cfg.Output
+	}
+	if cfg.Compression == "gzip" {
+		zw, _ := gzip.NewWriterLevel(out, cfg.GzipLevel) // The level was checked by parseFlags
+		return &gzipFile{zw, out}, nil
+	}
+	return out, nil
+}
+
+// gzipFile compresses into a file and closes both together
+type gzipFile struct {
+	*gzip.Writer           // Compressor
+	f            io.Closer // Compressed file
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Abort ends the compressed stream and aborts the file under it
+func (g *gzipFile) Abort(err error) error {
+	g.Writer.Close()
+	return g.f.(*outputFile).Abort(err)
+}
+
+// endMember flushes everything written so far into a complete gzip member and
+// starts the next one. Unlike a sync flush, which keeps the compressor's
+// history, a new member can be decompressed from its offset alone.
+func (g *gzipFile) endMember() error {
+	if err := g.Writer.Close(); err != nil {
+		return err
+	}
+	g.Writer.Reset(g.f.(*outputFile))
+	return nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// printClassHistogram prints how many docs fell into each length class
+func printClassHistogram(st *stats) {
+	fmt.Print("Length classes:")
+	for _, class := range lengthClasses {
+		fmt.Printf(" %s=%d", class, st.Classes[class])
+	}
+	fmt.Println()
+}
+
+// printNextSteps explains where to go after a quickstart or demo run
+func printNextSteps(cfg *config, st *stats) {
+	fmt.Printf("Wrote %d docs from %d pages (%d filtered, %d empty, %d below -min-score).\n", st.Written, st.Pages, st.Filtered, st.Empty, st.LowScore)
+	fmt.Println("Next steps:")
+	hint := func(cmd, why string) { fmt.Printf("  %-44s # %s\n", cmd, why) }
+	if cfg.ESURL == "" {
+		hint("head -n 3 "+cfg.Output, "inspect the output")
+	}
+	if cfg.Demo {
+		hint("full-stream-wiki extract -quickstart", "same pipeline on the real simplewiki dump")
+	}
+	hint("full-stream-wiki extract -lang en -plain", "full English Wikipedia (~20 GB download)")
+	hint("full-stream-wiki extract -h", "all options")
+}
+
+// legacyNotice is printed when the binary is run without a subcommand. It
+// does not repeat the flags, which may carry credentials.
+const legacyNotice = `note: running full-stream-wiki without a subcommand is deprecated; the same flags work as "full-stream-wiki extract [flags]"`
+
+// helpRequested reports whether args ask for the usage text, which needs no notice
+func helpRequested(args []string) bool {
+	return len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help")
+}
+
+// commands maps subcommand names to their entry points
+var commands = map[string]func(args []string) error{
+	"extract":  extractCommand,
+	"download": downloadCommand,
+	"clean":    cleanCommand,
+
+	"census":            censusCommand,
+	"compare-abstracts": compareCommand,
+	"compare-dumps":     compareDumpsCommand,
+	"diff":              diffCommand,
+	"lookup":            lookupCommand,
+	"random":            randomCommand,
+	"serve":             serveCommand,
+	"validate":          validateCommand,
+}
+
+// extractCommand parses extract flags and performs the run
+func extractCommand(args []string) error {
+	cfg, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	return run(cfg)
+}
+
+func main() {
+	// Bare flags (or no arguments at all) mean extract, as before subcommands
+	// existed; cron jobs written then keep working, with a notice
+	args := os.Args[1:]
+	cmd := extractCommand
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd, args = c, args[1:]
+		}
+	}
+	if len(args) == len(os.Args[1:]) && !helpRequested(args) {
+		fmt.Fprintln(os.Stderr, legacyNotice)
+	}
+
+	err := cmd(args)
+	var usageErr *usageError
+	var exitErr *exitCodeError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.As(err, &usageErr):
+		os.Exit(2) // The problem has already been reported
+	case errors.As(err, &exitErr):
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitErr.code)
+	default:
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json" // Package for JSON encoding
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"time"          // Package for timestamps
+)
+
+// manifest describes one finished (or aborted) run for -manifest
+type manifest struct {
+	Input       string          `json:"input"`                  // Dump URL or file
+	Multistream bool            `json:"multistream"`            // Whether the dump is the multistream variant
+	Output      string          `json:"output"`                 // Output file or -exec command
+	Format      string          `json:"format"`                 // Output format
+	Started     time.Time       `json:"started"`                // Run start
+	Finished    time.Time       `json:"finished"`               // Run end
+	Status      string          `json:"status"`                 // "ok", "incomplete", "anomalies", "error_budget_exceeded", "interrupted", "timed_out" or "failed" (see runStatus)
+	Error       string          `json:"error,omitempty"`        // Why the run stopped, if it failed
+	Pages       int             `json:"pages"`                  // Pages decoded
+	Written     int             `json:"written"`                // Docs or redirects written
+	Filtered    int             `json:"filtered"`               // Pages dropped by filters
+	Empty       int             `json:"empty"`                  // Pages with an empty abstract
+	LowScore    int             `json:"low_score"`              // Pages below -min-score
+	Duplicates  int             `json:"duplicates"`             // Pages dropped by -dedup
+	OutOfRange  int             `json:"out_of_range"`           // Pages outside -min-id/-max-id
+	Errors      manifestErrors  `json:"errors"`                 // Decode errors against the budget
+	Products    []productRecord `json:"products,omitempty"`     // Every -products file, the abstracts first
+	SiteInfo    *SiteInfo       `json:"siteinfo,omitempty"`     // The dump's <siteinfo>
+	DumpVersion string          `json:"dump_version,omitempty"` // The version attribute of its <mediawiki> root
+	Sources     []*inputSource  `json:"sources,omitempty"`      // Every input file with its checksum and counts (-with-provenance)
+}
+
+// manifestErrors records the error budget and how much of it was spent
+type manifestErrors struct {
+	errorBudget
+	Count   int            `json:"count"`           // Pages that failed to decode
+	Rate    float64        `json:"rate"`            // Count over pages seen
+	Kinds   map[string]int `json:"kinds,omitempty"` // Count per error kind
+	Tripped bool           `json:"tripped"`         // Whether the budget aborted the run
+}
+
+// writeManifest records the outcome of a run in cfg.Manifest
+func writeManifest(cfg *config, st *stats, started time.Time, runErr error) error {
+	if st == nil {
+		st = &stats{}
+	}
+	m := manifest{
+		Input:       inputName(cfg),
+		Multistream: cfg.Multistream,
+		Output:      cfg.Output,
+		Format:      cfg.Format,
+		Started:     started,
+		Finished:    cfg.NowFunc(),
+		Status:      runStatus(runErr),
+		Pages:       st.Pages,
+		Written:     st.Written,
+		Filtered:    st.Filtered,
+		Empty:       st.Empty,
+		LowScore:    st.LowScore,
+		Duplicates:  st.Duplicates,
+		OutOfRange:  st.OutOfRange,
+		SiteInfo:    st.SiteInfo,
+		DumpVersion: st.DumpVersion,
+		Errors: manifestErrors{
+			errorBudget: cfg.Budget,
+			Count:       st.DecodeErrors,
+			Rate:        st.errorRate(),
+			Kinds:       st.ErrorKinds,
+		},
+	}
+	if cfg.Exec != "" {
+		m.Output = cfg.Exec
+	}
+	if cfg.Sources != nil {
+		m.Sources = cfg.Sources.sources
+	}
+	if cfg.Products != nil {
+		m.Products = append([]productRecord{{Name: "abstracts", Path: cfg.Output, Records: st.Written, Pages: st.Written}}, st.Products...)
+	}
+	if runErr != nil {
+		m.Error = runErr.Error()
+	}
+	m.Errors.Tripped = m.Status == "error_budget_exceeded"
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfg.Manifest, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// inputName describes where a run read its dump from
+func inputName(cfg *config) string {
+	switch {
+	case cfg.Demo:
+		return sampleName
+	case cfg.Input != "":
+		return cfg.Input
+	}
+	return redactURL(cfg.URL)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"maps"    // Package for copying the -set-field values
+	"os"      // Package for OS functions (standard error)
+	"slices"  // Package for listing the trimmable fields
+	"strconv" // Package for the -trim-field lengths
+	"strings" // Package for string manipulation
+)
+
+// DocMiddleware looks at a doc on its way to the output writer and may
+// change it in place. It returns keep false to veto the doc, or an error to
+// have it skipped with a warning. The middlewares of a run are called in
+// order on the goroutine that writes, one doc at a time, each seeing the doc
+// as the one before left it; a doc vetoed or failed goes no further.
+type DocMiddleware func(doc *Doc) (keep bool, err error)
+
+// trimmableFields are the text fields -trim-field can cut, by JSON name
+var trimmableFields = map[string]func(d *Doc) *string{
+	"title":             func(d *Doc) *string { return &d.Title },
+	"abstract":          func(d *Doc) *string { return &d.Abstract },
+	"abstract_html":     func(d *Doc) *string { return &d.AbstractHTML },
+	"ipa":               func(d *Doc) *string { return &d.IPA },
+	"short_description": func(d *Doc) *string { return &d.ShortDescription },
+}
+
+// fieldTrim is one -trim-field rule, FIELD=BYTES
+type fieldTrim struct {
+	get func(*Doc) *string // The field's value in a doc
+	max int                // Bytes kept, fieldCutMark included
+}
+
+// parseFieldTrims reads the -trim-field rules
+func parseFieldTrims(rules []string) ([]fieldTrim, error) {
+	var trims []fieldTrim
+	for _, rule := range rules {
+		field, bytesText, ok := strings.Cut(rule, "=")
+		n, err := strconv.Atoi(bytesText)
+		if !ok || err != nil || n < len(fieldCutMark) {
+			return nil, fmt.Errorf("-trim-field %q: want FIELD=BYTES, BYTES at least %d", rule, len(fieldCutMark))
+		}
+		get := trimmableFields[field]
+		if get == nil {
+			names := make([]string, 0, len(trimmableFields))
+			for name := range trimmableFields {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("-trim-field %q: cannot trim %s (want one of %s)", rule, field, strings.Join(names, ", "))
+		}
+		trims = append(trims, fieldTrim{get, n})
+	}
+	return trims, nil
+}
+
+// parseSetFields reads the -set-field NAME=VALUE pairs
+func parseSetFields(rules []string) (map[string]string, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	fields := map[string]string{}
+	for _, rule := range rules {
+		name, value, ok := strings.Cut(rule, "=")
+		switch _, repeated := fields[name]; {
+		case !ok || name == "":
+			return nil, fmt.Errorf("-set-field %q: want NAME=VALUE", rule)
+		case repeated:
+			return nil, fmt.Errorf("-set-field %s is given twice", name)
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+// docChain returns the middlewares of a run: the built-ins of -trim-field,
+// -title-prefix and -set-field, in that order, then those a caller put in
+// cfg.Middleware. {lang} in the prefix and the values is the run's -lang.
+func docChain(cfg *config) []DocMiddleware {
+	var chain []DocMiddleware
+	if len(cfg.FieldTrims) > 0 {
+		chain = append(chain, func(doc *Doc) (bool, error) {
+			for _, t := range cfg.FieldTrims {
+				s := t.get(doc)
+				*s = capField(*s, t.max)
+			}
+			return true, nil
+		})
+	}
+	if cfg.TitlePrefix != "" {
+		prefix := strings.ReplaceAll(cfg.TitlePrefix, "{lang}", cfg.Lang)
+		chain = append(chain, func(doc *Doc) (bool, error) {
+			doc.Title = prefix + doc.Title
+			return true, nil
+		})
+	}
+	if len(cfg.ConstFields) > 0 {
+		fields := make(infobox, len(cfg.ConstFields))
+		for name, value := range cfg.ConstFields {
+			fields[name] = strings.ReplaceAll(value, "{lang}", cfg.Lang)
+		}
+		chain = append(chain, func(doc *Doc) (bool, error) {
+			doc.Extra = maps.Clone(fields) // A copy, which later middlewares may change
+			return true, nil
+		})
+	}
+	return append(chain, cfg.Middleware...)
+}
+
+// applyChain passes doc through the chain and reports whether it goes on to
+// the writer, counting the docs that do not
+func applyChain(chain []DocMiddleware, doc *Doc, st *stats) bool {
+	for _, mw := range chain {
+		keep, err := mw(doc)
+		if err != nil {
+			st.ChainErrors++
+			fmt.Fprintf(os.Stderr, "warning: skipping %q: middleware: %v\n", forDisplay(doc.Title), err)
+			return false
+		}
+		if !keep {
+			st.Vetoed++
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"        // Package for joining the languages' errors
+	"fmt"           // Package for formatted I/O
+	"hash/fnv"      // Package for the languages' seeds
+	"math/rand/v2"  // Package for the languages' random sources
+	"path/filepath" // Package for per-language paths
+	"strings"       // Package for string manipulation
+	"sync"          // Package for running languages at once
+)
+
+// langSingleFlags are the flags that name one dump or one destination, which
+// a -lang list cannot share between its languages
+var langSingleFlags = []string{"index", "follow", "es-url", "exec", "wikidata", "cpuprofile", "memprofile", "demo", "quickstart"}
+
+// parseLangs splits a -lang list into cfg.Langs; cfg.Lang keeps the first
+// language so the flags derived from it still check out
+func (cfg *config) parseLangs(set map[string]bool) error {
+	seen := map[string]bool{}
+	for _, lang := range strings.Split(cfg.Lang, ",") {
+		lang = strings.TrimSpace(lang)
+		if !langRe.MatchString(lang) {
+			return fmt.Errorf("invalid language %q in -lang", lang)
+		}
+		if !seen[lang] {
+			seen[lang] = true
+			cfg.Langs = append(cfg.Langs, lang)
+		}
+	}
+	for _, name := range langSingleFlags {
+		if set[name] {
+			return fmt.Errorf("-%s cannot be combined with several -lang values, which each read their own dump into their own output", name)
+		}
+	}
+	for name, value := range map[string]string{"input": cfg.Input, "url": cfg.URL} {
+		if set[name] && !strings.Contains(value, "{lang}") {
+			return fmt.Errorf("with several -lang values, -%s must hold {lang} to name each language's dump", name)
+		}
+	}
+	if cfg.LangJobs < 1 {
+		return fmt.Errorf("-lang-jobs must be positive")
+	}
+	cfg.Lang = cfg.Langs[0]
+	return nil
+}
+
+// langPath is the file of one language of a -lang list: {lang} in path is
+// replaced, or else the language goes before the extensions, so
+// out/abstracts.xml.gz gives out/abstracts.de.xml.gz
+func langPath(path, lang string) string {
+	if strings.Contains(path, "{lang}") {
+		return strings.ReplaceAll(path, "{lang}", lang)
+	}
+	dir, base := filepath.Split(path)
+	stem, ext := base, ""
+	if i := strings.Index(base, "."); i > 0 {
+		stem, ext = base[:i], base[i:]
+	}
+	return dir + stem + "." + lang + ext
+}
+
+// forLang returns the settings of one language of a -lang list: its own
+// dump, outputs, workdir, cache and random source, and every other flag
+// shared
+func (cfg *config) forLang(lang string) *config {
+	lc := *cfg
+	lc.Lang, lc.Langs = lang, nil
+	lc.Rand = langRand(cfg.Seed, lang)
+	switch {
+	case cfg.Input != "":
+		lc.Input = strings.ReplaceAll(cfg.Input, "{lang}", lang)
+	case strings.Contains(cfg.URL, "{lang}"):
+		lc.URL = strings.ReplaceAll(cfg.URL, "{lang}", lang)
+	default:
+		lc.URL = cfg.Project.dumpURL(lang, cfg.Multistream)
+	}
+	lc.Output = langPath(cfg.Output, lang)
+	for _, path := range []*string{&lc.Manifest, &lc.StatsFile, &lc.Offsets, &lc.Similarity, &lc.SiteInfoOut} {
+		if *path != "" {
+			*path = langPath(*path, lang)
+		}
+	}
+	if cfg.Workdir != "" {
+		lc.Workdir = filepath.Join(cfg.Workdir, lang)
+	} else {
+		lc.Workdir = newWorkdir("", cfg.NowFunc()).path + "-" + lang
+	}
+	if cfg.Cache != "" {
+		lc.Cache = filepath.Join(cfg.Cache, lang)
+	}
+	return &lc
+}
+
+// langRand is the random source of one language of a -lang list. A shared
+// source would race between -lang-jobs, and which draws each language got
+// would depend on how they interleave; this one follows from -seed and the
+// language code alone, so a seeded language samples the same on every run.
+func langRand(seed uint64, lang string) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	h := fnv.New64a()
+	h.Write([]byte(lang))
+	return rand.New(rand.NewPCG(seed, h.Sum64()))
+}
+
+// langRun is the outcome of one language of a -lang list
+type langRun struct {
+	lang   string // Language code
+	output string // Its output file
+	st     *stats // Its counters; nil if it failed before reading the dump
+	err    error  // Why it failed, if it did
+}
+
+// runLangs runs the extraction once per language of cfg.Langs, at most
+// -lang-jobs at a time, and reports each one's counters at the end. A failed
+// language does not stop the others; every output is a complete run of its own.
+func runLangs(cfg *config) error {
+	runs := make([]langRun, len(cfg.Langs))
+	slots := make(chan struct{}, cfg.LangJobs)
+	var wg sync.WaitGroup
+	for i, lang := range cfg.Langs {
+		slots <- struct{}{} // Languages start in the order given
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			lc := cfg.forLang(lang)
+			fmt.Printf("Language %s: %s into %s\n", lang, inputName(lc), lc.Output)
+			st, err := runDump(lc)
+			runs[i] = langRun{lang: lang, output: lc.Output, st: st, err: err}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println("Languages:")
+	var errs []error
+	for _, r := range runs {
+		status := runStatus(r.err)
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("-lang %s: %w", r.lang, r.err))
+		}
+		if r.st == nil {
+			fmt.Printf("  %-8s %-12s %v\n", r.lang, status, r.err)
+			continue
+		}
+		fmt.Printf("  %-8s %-12s %9d pages %9d written %8d filtered %8d empty  %s\n",
+			r.lang, status, r.st.Pages, r.st.Written, r.st.Filtered, r.st.Empty, r.output)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d languages failed: %w", len(errs), len(runs), errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing" // Package for tests
+)
+
+// TestForLangPaths checks that every per-run file of a -lang list is named
+// for the language, so that no two languages write the same file
+func TestForLangPaths(t *testing.T) {
+	cfg, err := parseFlags([]string{"-lang", "en,de", "-o", "out/abstracts.xml.gz",
+		"-manifest", "run.json", "-stats-file", "stats/{lang}.json", "-offsets", "offsets.tsv",
+		"-similarity", "pairs.tsv", "-siteinfo-out", "siteinfo.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := cfg.forLang("de")
+	for _, c := range []struct{ name, got, want string }{
+		{"-o", lc.Output, "out/abstracts.de.xml.gz"},
+		{"-manifest", lc.Manifest, "run.de.json"},
+		{"-stats-file", lc.StatsFile, "stats/de.json"},
+		{"-offsets", lc.Offsets, "offsets.de.tsv"},
+		{"-similarity", lc.Similarity, "pairs.de.tsv"},
+		{"-siteinfo-out", lc.SiteInfoOut, "siteinfo.de.json"},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s for de is %q, want %q", c.name, c.got, c.want)
+		}
+	}
+}
+
+// TestForLangRand checks that each language of a -lang list gets a source of
+// its own, the same for the same -seed and language on every run
+func TestForLangRand(t *testing.T) {
+	cfg, err := parseFlags([]string{"-lang", "en,de", "-seed", "42", "-o", "out/abstracts.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	draws := func(lc *config) [4]uint64 {
+		var d [4]uint64
+		for i := range d {
+			d[i] = lc.Rand.Uint64()
+		}
+		return d
+	}
+	en, de := cfg.forLang("en"), cfg.forLang("de")
+	if en.Rand == cfg.Rand || de.Rand == cfg.Rand || en.Rand == de.Rand {
+		t.Fatal("languages share a random source")
+	}
+	enDraws, deDraws := draws(en), draws(de)
+	if enDraws == deDraws {
+		t.Errorf("en and de draw the same numbers: %v", enDraws)
+	}
+	if again := draws(cfg.forLang("en")); again != enDraws {
+		t.Errorf("en draws %v with -seed 42, then %v", enDraws, again)
+	}
+	other, err := parseFlags([]string{"-lang", "en,de", "-seed", "43", "-o", "out/abstracts.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := draws(other.forLang("en")); got == enDraws {
+		t.Errorf("-seed 43 draws the same numbers as -seed 42 for en: %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"           // Package for formatted I/O
+	"net/url"       // Package for the dump server host
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for the default netrc path
+	"slices"        // Package for finding macdef
+	"strings"       // Package for string manipulation
+)
+
+// netrcPath is $NETRC, or else .netrc in the home directory
+func netrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("-netrc: %w", err)
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// netrcLogin returns the login and password of the first machine entry of
+// a netrc file matching host, or of its default entry; ok is false when
+// neither exists. macdef blocks, which end at a blank line, are skipped.
+func netrcLogin(data, host string) (login, password string, ok bool) {
+	var fields []string
+	inMacro := false
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		f := strings.Fields(line)
+		if i := slices.Index(f, "macdef"); i >= 0 {
+			f, inMacro = f[:i], true
+		}
+		fields = append(fields, f...)
+	}
+
+	found := false
+	for i := 0; i < len(fields); i++ {
+		switch key := fields[i]; key {
+		case "machine", "default":
+			if found {
+				return login, password, true // The matching entry has ended
+			}
+			if key == "default" {
+				found = true
+			} else if i+1 < len(fields) {
+				i++
+				found = strings.EqualFold(fields[i], host)
+			}
+		case "login", "password", "account":
+			if i+1 >= len(fields) {
+				break
+			}
+			i++
+			if found && key == "login" {
+				login = fields[i]
+			} else if found && key == "password" {
+				password = fields[i]
+			}
+		}
+	}
+	return login, password, found
+}
+
+// fillFromNetrc takes the basic auth for the host of rawURL from the netrc
+// file under -netrc, unless credentials were given otherwise
+func (c *credentials) fillFromNetrc(rawURL string) error {
+	if !c.Netrc || c.User != "" || c.Pass != "" || c.Bearer != "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil // The request reports the bad URL
+	}
+	path, err := netrcPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("-netrc: %w", err)
+	}
+	if login, password, ok := netrcLogin(string(data), u.Hostname()); ok {
+		c.User, c.Pass = login, password
+	} else {
+		fmt.Fprintf(os.Stderr, "warning: %s has no entry for %s; sending no credentials\n", path, u.Hostname())
+	}
+	return nil
+}
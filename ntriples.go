@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"          // Package for formatted I/O
+	"io"           // Package for I/O primitives
+	"net/url"      // Package for IRI validation
+	"regexp"       // Package for regular expressions
+	"strings"      // Package for string manipulation
+	"unicode/utf8" // Package for decoding the URL
+)
+
+// RDF and schema.org IRIs used by the ntriples writer
+const (
+	rdfType        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	schemaArticle  = "https://schema.org/Article"
+	schemaName     = "https://schema.org/name"
+	schemaAbstract = "https://schema.org/abstract"
+	schemaSameAs   = "https://schema.org/sameAs"
+	wikidataEntity = "http://www.wikidata.org/entity/"
+)
+
+// langTagRe is the N-Triples LANGTAG production
+var langTagRe = regexp.MustCompile(`^[a-zA-Z]+(-[a-zA-Z0-9]+)*$`)
+
+// ntriplesWriter emits each doc as schema.org triples about its page URL
+type ntriplesWriter struct {
+	w   io.Writer // Destination stream
+	tag string    // "@lang" suffix for literals, or "" when -lang is not a valid tag
+}
+
+func newNTriplesWriter(w io.Writer, cfg *config) (docWriter, error) {
+	lang := cfg.Lang
+	if lang == "simple" {
+		lang = "en" // Simple English is English
+	}
+	nt := &ntriplesWriter{w: w}
+	if langTagRe.MatchString(lang) {
+		nt.tag = "@" + lang
+	}
+	return nt, nil
+}
+
+func (nt *ntriplesWriter) WriteDoc(doc *Doc) error {
+	subject, err := ntIRI(doc.URL)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(nt.w, "%s <%s> <%s> .\n%s <%s> %s%s .\n%s <%s> %s%s .\n",
+		subject, rdfType, schemaArticle,
+		subject, schemaName, ntLiteral(doc.Title), nt.tag,
+		subject, schemaAbstract, ntLiteral(doc.Abstract), nt.tag)
+	if err == nil && doc.WikidataID != "" {
+		_, err = fmt.Fprintf(nt.w, "%s <%s> <%s%s> .\n", subject, schemaSameAs, wikidataEntity, doc.WikidataID)
+	}
+	return err
+}
+
+func (nt *ntriplesWriter) Close() error {
+	return nil
+}
+
+// ntIRI renders an absolute URL as an N-Triples IRI. Characters IRIs forbid
+// (space, <>"{}|^`\, controls and the bidi formatting characters) are
+// percent-encoded, as are bytes that are not UTF-8 and a % that does not
+// start an escape, as in "100%_Pure"; all else is kept, so the IRI names the
+// same page as the doc's url field.
+func ntIRI(raw string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('<')
+	for i := 0; i < len(raw); {
+		r, size := utf8.DecodeRuneInString(raw[i:])
+		strayPercent := r == '%' && (i+2 >= len(raw) || !isHexDigit(raw[i+1]) || !isHexDigit(raw[i+2]))
+		if r <= 0x20 || r == 0x7f || strings.ContainsRune("<>\"{}|^`\\", r) || strayPercent || isBidiControl(r) ||
+			r == utf8.RuneError && size == 1 {
+			for _, c := range []byte(raw[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(raw[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('>')
+	iri := b.String()
+	if u, err := url.Parse(iri[1 : len(iri)-1]); err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("cannot use %q as an RDF subject: not an absolute URL", raw)
+	}
+	return iri, nil
+}
+
+// isBidiControl reports whether r is one of the bidi formatting characters
+// RFC 3987 keeps out of IRIs: the marks, embeddings, overrides and isolates
+func isBidiControl(r rune) bool {
+	return r == 0x200E || r == 0x200F || r >= 0x202A && r <= 0x202E || r >= 0x2066 && r <= 0x2069
+}
+
+// isHexDigit reports whether c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// ntLiteral quotes s as an N-Triples string; non-ASCII stays literal UTF-8
+func ntLiteral(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math/rand/v2" // Package for pseudo-random numbers
+	"time"         // Package for clocks and timers
+)
+
+// Options are the non-deterministic inputs of a run. Production code gets
+// the real clock and a randomly seeded source from defaultOptions; tests can
+// substitute fixed ones to replay sampling decisions and progress timings.
+type Options struct {
+	NowFunc func() time.Time                                        // Current time
+	Sleep   func(d time.Duration)                                   // Pause between retries
+	Ticker  func(d time.Duration) (c <-chan time.Time, stop func()) // Periodic progress ticks
+	Rand    *rand.Rand                                              // Source for every random decision
+}
+
+// defaultOptions returns the real clock and a randomly seeded source
+func defaultOptions() Options {
+	return Options{
+		NowFunc: time.Now,
+		Sleep:   time.Sleep,
+		Ticker: func(d time.Duration) (<-chan time.Time, func()) {
+			t := time.NewTicker(d)
+			return t.C, t.Stop
+		},
+		Rand: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"       // Package for buffered I/O
+	"errors"      // Package for error inspection
+	"fmt"         // Package for formatted I/O
+	"io"          // Package for I/O primitives
+	"os"          // Package for OS functions (file access)
+	"sync/atomic" // Package for the output byte counter
+	"syscall"     // Package for the ENOSPC error number
+)
+
+// diskFullError marks a write refused because the output device is full
+type diskFullError struct {
+	written   int64 // Bytes that reached the file before the failure
+	attempted int64 // Bytes the run had produced, the refused write included
+	err       error // Underlying write error
+}
+
+func (e *diskFullError) Error() string { return e.err.Error() }
+func (e *diskFullError) Unwrap() error { return e.err }
+
+// outputFile is the -o file; it counts what was written and recognizes a
+// full disk, including the short writes some file systems report instead
+type outputFile struct {
+	*os.File         // Destination file
+	written   int64  // Bytes written so far
+	attempted int64  // Bytes handed to Write so far
+	final     string // -atomic: path the file is renamed to once closed ("": written in place)
+}
+
+func (f *outputFile) Write(b []byte) (int, error) {
+	n, err := f.File.Write(b)
+	f.written += int64(n)
+	f.attempted += int64(len(b))
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, io.ErrShortWrite) {
+		return n, &diskFullError{written: f.written, attempted: f.attempted, err: err}
+	}
+	return n, err
+}
+
+// Close closes the file and, under -atomic, renames it into place; a file
+// that fails to close or rename is removed rather than published
+func (f *outputFile) Close() error {
+	err := f.File.Close()
+	if f.final == "" {
+		return err
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.final)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// Abort closes the file after a failed run; under -atomic it is removed,
+// leaving whatever was at the -o path before untouched
+func (f *outputFile) Abort(err error) error {
+	f.File.Close()
+	if f.final != "" {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// inputProgress counts the raw (compressed) dump bytes consumed so far
+type inputProgress struct {
+	read int64 // Bytes consumed
+	size int64 // Total size; 0 or less when unknown
+}
+
+// progressReader counts the bytes read through it into p
+type progressReader struct {
+	r io.Reader      // Raw dump stream
+	p *inputProgress // Counter
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.read += int64(n)
+	return n, err
+}
+
+// remaining estimates how much space a full run's output needs beyond the
+// written bytes, assuming output grows in step with the input consumed
+func (p *inputProgress) remaining(written, produced int64) (int64, bool) {
+	if p == nil || p.size <= 0 || p.read <= 0 {
+		return 0, false
+	}
+	return int64(float64(produced)*float64(p.size)/float64(p.read)) - written, true
+}
+
+// explainDiskFull turns a disk-full error into a message saying how far
+// the run got and how much space it lacks, and moves the partial output
+// aside so it cannot pass for a complete file. Other errors pass through.
+func explainDiskFull(cfg *config, st *stats, err error) error {
+	var full *diskFullError
+	if !errors.As(err, &full) {
+		return err
+	}
+	docs := 0
+	if st != nil {
+		docs = st.Written
+	}
+	need := "an unknown amount of space"
+	if more, ok := cfg.Progress.remaining(full.written, full.attempted); ok {
+		need = "approximately " + formatBytes(more) + " more"
+	}
+	where := cfg.Output
+	if cfg.Atomic {
+		where = "a .tmp file, since removed by -atomic"
+	} else if info, serr := os.Stat(cfg.Output); serr == nil && info.Mode().IsRegular() {
+		if os.Rename(cfg.Output, cfg.Output+".partial") == nil {
+			where = cfg.Output + ".partial"
+		}
+	}
+	return fmt.Errorf("output device full: wrote %d docs (%s) to %s, need %s: %w", docs, formatBytes(full.written), where, need, err)
+}
+
+// errOutputCapped ends the scan once the output reaches -max-output-bytes;
+// the extractors turn it into a normal end with st.OutputCapped set
+var errOutputCapped = errors.New("output reached -max-output-bytes")
+
+// outputMeter returns how much output the run has produced so far. Uncompressed
+// counts what the writers handed over, buffered bytes included; compressed counts
+// what reached the .gz file, which trails the docs by the compressor's window.
+// Without compression the two are the same.
+func outputMeter(cfg *config, out io.Writer, buf *bufio.Writer, handed *atomic.Int64) func() int64 {
+	if g, ok := out.(*gzipFile); ok && cfg.MaxOutputMeasure == "compressed" {
+		f := g.f.(*outputFile)
+		return func() int64 { return f.written }
+	}
+	return func() int64 { return handed.Load() + int64(buf.Buffered()) }
+}
+
+// outputFull reports whether the output has reached -max-output-bytes, recording it in st
+func (cfg *config) outputFull(st *stats) bool {
+	if cfg.OutputSize == nil || cfg.OutputSize() < cfg.MaxOutputBytes {
+		return false
+	}
+	st.OutputCapped = true
+	return true
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"           // Package for buffered index writes
+	"compress/gzip"   // Package for reading one gzip member
+	"encoding/binary" // Package for the index entry layout
+	"encoding/json"   // Package for decoding JSONL records
+	"encoding/xml"    // Package for decoding XML records
+	"errors"          // Package for error inspection
+	"flag"            // Package for command-line flag parsing
+	"fmt"             // Package for formatted I/O
+	"io"              // Package for I/O primitives
+	"os"              // Package for OS functions (file access)
+	"sort"            // Package for sorting and searching the entries
+	"sync/atomic"     // Package for the output byte counter
+)
+
+// Index file layout: a 24-byte header (magic, entry count, framed flag)
+// followed by the entries sorted by title hash, 32 bytes each
+const (
+	outIndexMagic  = "fswoidx1"
+	outIndexHeader = 24
+	outIndexEntry  = 32
+)
+
+// indexEntry locates one record of the output file. Without compression
+// block is 0 and offset is the record's byte offset in the file; in gzip
+// output block is the file offset of the gzip member holding the record and
+// offset counts decompressed bytes from the start of that member.
+type indexEntry struct {
+	hash   uint64 // outTitleHash of the record's title
+	block  int64  // Compressed offset of the gzip member (0: not compressed)
+	offset int64  // Decompressed offset of the record within the block
+	length int64  // Record length in bytes, decompressed
+}
+
+// indexPath is the sidecar index of an output file
+func indexPath(output string) string { return output + ".idx" }
+
+// outTitleHash is the titleHash of a normalized title, so "foo_bar" finds "Foo bar"
+func outTitleHash(title string) uint64 { return titleHash(normalizeTitle(title, false)) }
+
+// outputIndex collects the entries of -emit-index as the docs are written.
+// In gzip output it ends the gzip member every -emit-index-block records, so
+// each member can be decompressed on its own from its offset.
+type outputIndex struct {
+	buf        *bufio.Writer // Buffer in front of the output
+	handed     *atomic.Int64 // Bytes passed through buf so far
+	gz         *gzipFile     // Compressed output; nil if not compressed
+	every      int           // Records per gzip member
+	block      int64         // Compressed offset of the current member
+	blockStart int64         // Decompressed offset where the current member starts
+	inBlock    int           // Records in the current member
+	entries    []indexEntry  // Entries so far, in output order
+}
+
+func newOutputIndex(cfg *config, out io.Writer, buf *bufio.Writer, handed *atomic.Int64) *outputIndex {
+	ix := &outputIndex{buf: buf, handed: handed, every: cfg.IndexBlock}
+	ix.gz, _ = out.(*gzipFile)
+	return ix
+}
+
+// pos is the decompressed output offset the next write lands at
+func (ix *outputIndex) pos() int64 { return ix.handed.Load() + int64(ix.buf.Buffered()) }
+
+// cut ends the current gzip member and starts the next one at the current file offset
+func (ix *outputIndex) cut() error {
+	if err := ix.buf.Flush(); err != nil {
+		return err
+	}
+	if err := ix.gz.endMember(); err != nil {
+		return err
+	}
+	ix.block = ix.gz.f.(*outputFile).written
+	ix.blockStart = ix.pos()
+	ix.inBlock = 0
+	return nil
+}
+
+// write stores the entries, sorted by title hash, in path; atomic writes
+// them to path plus .tmp first, as -atomic does the output
+func (ix *outputIndex) write(path string, atomic bool) error {
+	sort.SliceStable(ix.entries, func(a, b int) bool { return ix.entries[a].hash < ix.entries[b].hash })
+	name := path
+	if atomic {
+		name += ".tmp"
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	f := &outputFile{File: file}
+	if atomic {
+		f.final = path
+	}
+	w := bufio.NewWriter(f)
+	le := binary.LittleEndian
+	head := make([]byte, outIndexHeader)
+	copy(head, outIndexMagic)
+	le.PutUint64(head[8:], uint64(len(ix.entries)))
+	if ix.gz != nil {
+		head[16] = 1
+	}
+	w.Write(head)
+	rec := make([]byte, outIndexEntry)
+	for _, e := range ix.entries {
+		le.PutUint64(rec[0:], e.hash)
+		le.PutUint64(rec[8:], uint64(e.block))
+		le.PutUint64(rec[16:], uint64(e.offset))
+		le.PutUint64(rec[24:], uint64(e.length))
+		w.Write(rec)
+	}
+	if err := w.Flush(); err != nil {
+		return f.Abort(fmt.Errorf("failed to write index: %w", err))
+	}
+	return f.Close()
+}
+
+// indexWriter records where each doc of the next writer lands
+type indexWriter struct {
+	next docWriter    // Real writer
+	ix   *outputIndex // Entries being collected
+}
+
+func (w *indexWriter) WriteDoc(doc *Doc) error {
+	ix := w.ix
+	if ix.gz != nil && ix.inBlock == ix.every {
+		if err := ix.cut(); err != nil {
+			return err
+		}
+	}
+	start := ix.pos()
+	if err := w.next.WriteDoc(doc); err != nil {
+		return err
+	}
+	ix.entries = append(ix.entries, indexEntry{hash: outTitleHash(doc.Title), block: ix.block, offset: start - ix.blockStart, length: ix.pos() - start})
+	ix.inBlock++
+	return nil
+}
+
+func (w *indexWriter) Close() error { return w.next.Close() }
+
+// indexReader looks titles up in an index file without loading it
+type indexReader struct {
+	f      *os.File // Index file
+	count  int      // Entries
+	framed bool     // The output is gzip with one member per block
+}
+
+// openIndex opens an index written by -emit-index
+func openIndex(path string) (*indexReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	head := make([]byte, outIndexHeader)
+	if _, err := io.ReadFull(f, head); err != nil || string(head[:8]) != outIndexMagic {
+		f.Close()
+		return nil, fmt.Errorf("%s is not an -emit-index file", path)
+	}
+	r := &indexReader{f: f, count: int(binary.LittleEndian.Uint64(head[8:])), framed: head[16] == 1}
+	if info, err := f.Stat(); err != nil || info.Size() != outIndexHeader+int64(r.count)*outIndexEntry {
+		f.Close()
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	return r, nil
+}
+
+func (r *indexReader) Close() error { return r.f.Close() }
+
+// entry reads the i-th entry
+func (r *indexReader) entry(i int) (indexEntry, error) {
+	rec := make([]byte, outIndexEntry)
+	if _, err := r.f.ReadAt(rec, outIndexHeader+int64(i)*outIndexEntry); err != nil {
+		return indexEntry{}, fmt.Errorf("failed to read index: %w", err)
+	}
+	le := binary.LittleEndian
+	return indexEntry{
+		hash:   le.Uint64(rec[0:]),
+		block:  int64(le.Uint64(rec[8:])),
+		offset: int64(le.Uint64(rec[16:])),
+		length: int64(le.Uint64(rec[24:])),
+	}, nil
+}
+
+// find returns the entries whose hash matches title, in output order; hash
+// collisions are possible, so callers check the title of each record
+func (r *indexReader) find(title string) ([]indexEntry, error) {
+	h := outTitleHash(title)
+	var err error
+	i := sort.Search(r.count, func(i int) bool {
+		e, eerr := r.entry(i)
+		if eerr != nil {
+			err = eerr
+			return true
+		}
+		return e.hash >= h
+	})
+	var found []indexEntry
+	for ; err == nil && i < r.count; i++ {
+		var e indexEntry
+		if e, err = r.entry(i); err != nil || e.hash != h {
+			break
+		}
+		found = append(found, e)
+	}
+	return found, err
+}
+
+// readRecord returns the bytes of the record e points at in the output file
+func (r *indexReader) readRecord(out *os.File, e indexEntry) ([]byte, error) {
+	rec := make([]byte, e.length)
+	if !r.framed {
+		if _, err := out.ReadAt(rec, e.offset); err != nil {
+			return nil, fmt.Errorf("failed to read record: %w", err)
+		}
+		return rec, nil
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(out, e.block, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	zr.Multistream(false) // The record never crosses into the next member
+	if _, err := io.CopyN(io.Discard, zr, e.offset); err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	if _, err := io.ReadFull(zr, rec); err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	return rec, nil
+}
+
+// decodeRecord decodes one record of an output in format, jsonl or xml
+func decodeRecord(format string, rec []byte) (Doc, error) {
+	var doc Doc
+	if format == "jsonl" {
+		return doc, json.Unmarshal(rec, &doc)
+	}
+	return doc, xml.Unmarshal(rec, &doc)
+}
+
+// lookupConfig holds the settings of the lookup subcommand
+type lookupConfig struct {
+	Output string // Output file written with -emit-index
+	Index  string // Its index; "" means Output + ".idx"
+}
+
+// errNotFound is returned when lookup finds no record for a title
+var errNotFound = errors.New("titles not found")
+
+// lookupCommand prints the records of the given titles from an output file
+// through its -emit-index sidecar, reading only the records asked for
+func lookupCommand(args []string) error {
+	cfg := &lookupConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki lookup", flag.ContinueOnError)
+	fs.StringVar(&cfg.Output, "o", "", "output `file` written with -emit-index (.xml or .jsonl, optionally .gz)")
+	fs.StringVar(&cfg.Index, "index", "", "index `file` (default: the -o file plus .idx)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	if cfg.Output == "" || fs.NArg() == 0 {
+		err := errors.New("lookup needs -o and at least one title")
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	if cfg.Index == "" {
+		cfg.Index = indexPath(cfg.Output)
+	}
+	format, _, _ := inferFormat(cfg.Output, docFormatExts())
+
+	ir, err := openIndex(cfg.Index)
+	if err != nil {
+		return err
+	}
+	defer ir.Close()
+	out, err := os.Open(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer out.Close()
+
+	missing := 0
+	for _, title := range fs.Args() {
+		entries, err := ir.find(title)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, e := range entries {
+			rec, err := ir.readRecord(out, e)
+			if err != nil {
+				return err
+			}
+			doc, err := decodeRecord(format, rec)
+			if err != nil {
+				return fmt.Errorf("record at %d+%d does not decode; is the index that of %s? %w", e.block, e.offset, cfg.Output, err)
+			}
+			if normalizeTitle(doc.Title, false) != normalizeTitle(title, false) {
+				continue // Another title with the same hash
+			}
+			found = true
+			os.Stdout.Write(rec)
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "not found: %s\n", title)
+			missing++
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d of %d %w", missing, fs.NArg(), errNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/xml" // Package for XML encoding/decoding
+	"fmt"          // Package for formatted I/O
+	"net/url"      // Package for URL parsing
+	"strings"      // Package for string manipulation
+	"unicode"      // Package for rune classification
+	"unicode/utf8" // Package for UTF-8 decoding
+)
+
+// Doc represents the <doc> element in the output XML
+type Doc struct {
+	XMLName          xml.Name `xml:"doc" json:"-"`                                                   // XML element name
+	ID               int64    `xml:"-" json:"-"`                                                     // Page ID, used as the Elasticsearch document ID
+	Title            string   `xml:"title" json:"title"`                                             // Title of the page
+	URL              string   `xml:"url" json:"url"`                                                 // URL of the wiki page
+	Slug             string   `xml:"slug,omitempty" json:"slug,omitempty"`                           // File- and URL-safe form of the title (-slug)
+	Abstract         string   `xml:"abstract" json:"abstract"`                                       // First paragraph of the page
+	Sentences        []string `xml:"sentence,omitempty" json:"sentences,omitempty"`                  // The abstract split into sentences (-sentences-array)
+	AbstractHTML     string   `xml:"abstract_html,omitempty" json:"abstract_html,omitempty"`         // Lead paragraph as sanitized HTML (-abstract-html)
+	IPA              string   `xml:"ipa,omitempty" json:"ipa,omitempty"`                             // First pronunciation in the lead (-extract-ipa)
+	Aliases          []string `xml:"alias,omitempty" json:"aliases,omitempty"`                       // Other names bolded in the lead (-extract-aliases)
+	References       refList  `xml:"references,omitempty" json:"references,omitempty"`               // External URLs cited in the lead (-extract-refs)
+	BirthDate        string   `xml:"birth_date,omitempty" json:"birth_date,omitempty"`               // ISO birth date from {{birth date}} etc. (-extract-dates)
+	DeathDate        string   `xml:"death_date,omitempty" json:"death_date,omitempty"`               // ISO death date from {{death date}} etc. (-extract-dates)
+	Infobox          infobox  `xml:"infobox,omitempty" json:"infobox,omitempty"`                     // Parameters of the -extract-infobox template
+	WikidataID       string   `xml:"wikidata_id,omitempty" json:"wikidata_id,omitempty"`             // Wikidata item, e.g. "Q42" (-wikidata)
+	ShortDescription string   `xml:"short_description,omitempty" json:"short_description,omitempty"` // {{Short description}}, else the REST summary's (-enrich-summary)
+	Image            string   `xml:"image,omitempty" json:"image,omitempty"`                         // Lead image thumbnail URL from the REST summary (-enrich-summary)
+	Score            *int     `xml:"score,omitempty" json:"score,omitempty"`                         // Heuristic 0–100 quality score (-score)
+	LengthClass      string   `xml:"length_class,omitempty" json:"length_class,omitempty"`           // stub/short/medium/long/very-long (-classify)
+	Readability      *float64 `xml:"readability,omitempty" json:"readability,omitempty"`             // Grade-level readability (-classify)
+	Fingerprint      string   `xml:"fingerprint,omitempty" json:"fingerprint,omitempty"`             // 64-bit SimHash of the abstract's word shingles, in hex (-fingerprint)
+	RevisionAgeDays  *float64 `xml:"revision_age_days,omitempty" json:"revision_age_days,omitempty"` // Days from the latest revision to the dump date or run start (-revision-age-field)
+	Offset           *int64   `xml:"offset,omitempty" json:"offset,omitempty"`                       // Byte offset of the <page> in the decompressed dump (-with-offset)
+	StreamOffset     *int64   `xml:"stream_offset,omitempty" json:"stream_offset,omitempty"`         // Compressed offset of the bzip2 stream holding it (-with-offset)
+	StreamIndex      *int     `xml:"stream_index,omitempty" json:"stream_index,omitempty"`           // Ordinal of that stream in a multistream dump, the header being 0 (-with-stream-index)
+	SourceFile       string   `xml:"source_file,omitempty" json:"source_file,omitempty"`             // Input file the page was read from (-with-provenance)
+	SourceOffset     *int64   `xml:"source_offset,omitempty" json:"source_offset,omitempty"`         // Decompressed offset of the <page> in that file (-with-provenance)
+	Extra            infobox  `xml:"extra,omitempty" json:"extra,omitempty"`                         // Constant fields of -set-field, encoded like the infobox
+}
+
+// refList encodes as <references><ref>URL</ref>...</references> in XML and
+// as a plain array in JSON; unlike a "references>ref" path it is omitted when empty
+type refList []string
+
+func (l refList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Ref []string `xml:"ref"`
+	}{l}, start)
+}
+
+// UnmarshalXML reads the <ref> elements back, for lookup and random
+func (l *refList) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Ref []string `xml:"ref"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*l = v.Ref
+	return nil
+}
+
+// page mirrors the parts of a dump <page> element the extractor reads
+type page struct {
+	Title    string `xml:"title"` // Page title
+	NS       int    `xml:"ns"`    // Namespace number (0 for articles)
+	ID       int64  `xml:"id"`    // Page ID
+	Redirect *struct {
+		Title string `xml:"title,attr"` // Redirect target title
+	} `xml:"redirect"` // Present only on redirect pages
+	Revision struct {
+		ID        int64  `xml:"id"`        // Revision ID (-cache key)
+		Timestamp string `xml:"timestamp"` // When the revision was saved, RFC 3339 (-revision-age)
+		Model     string `xml:"model"`     // Content model, e.g. "wikitext" (census)
+		Text      string `xml:"text"`      // Page content
+	} `xml:"revision"`
+	Offset int64 `xml:"-"` // Decompressed byte offset of the <page> element
+	Length int64 `xml:"-"` // Byte length of the <page> element, end tag included
+
+	StreamOffset int64 `xml:"-"` // Compressed offset of the bzip2 stream it starts in (-with-offset)
+	StreamIndex  int   `xml:"-"` // Ordinal of that stream, 0 unless the dump is multistream (-with-stream-index)
+
+	Source       *inputSource `xml:"-"` // Input file the page was read from (-with-provenance)
+	SourceOffset int64        `xml:"-"` // Decompressed byte offset of the <page> element in that file
+
+	hasRevision bool // A <revision> element was read
+	hasNS       bool // An <ns> element was read
+}
+
+// normalizeTitle puts a title in the form page titles take in the dump:
+// underscores as spaces, surrounding and repeated space dropped, first letter
+// uppercased unless the wiki is case-sensitive (see SiteInfo)
+func normalizeTitle(title string, caseSensitive bool) string {
+	title = strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " ")
+	if caseSensitive {
+		return title
+	}
+	r, size := utf8.DecodeRuneInString(title)
+	if r == utf8.RuneError {
+		return title
+	}
+	return string(unicode.ToUpper(r)) + title[size:]
+}
+
+// titleKey turns titles into the keys they are deduplicated and looked up
+// by; the emitted Doc.Title always keeps the title as the dump has it
+type titleKey struct {
+	space bool // Treat underscores as spaces and collapse runs of space
+	fold  bool // Ignore case, so "Apple" and "apple" share a key
+
+	caseSensitive bool // The wiki keeps first letters as written, from its siteinfo
+}
+
+// parseTitleKey reads a -title-key list such as "space,fold"; "" and "exact" mean neither
+func parseTitleKey(spec string) (titleKey, error) {
+	var k titleKey
+	for _, step := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(step) {
+		case "", "exact":
+		case "space":
+			k.space = true
+		case "fold":
+			k.fold = true
+		default:
+			return k, fmt.Errorf("unknown -title-key step %q (want space, fold or exact)", step)
+		}
+	}
+	return k, nil
+}
+
+// of returns the key of title
+func (k titleKey) of(title string) string {
+	if k.space {
+		title = strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " ")
+	}
+	if k.fold {
+		title = strings.ToLower(title)
+	}
+	return title
+}
+
+// wikidataKey is the key of -wikidata titles: always normalized as page
+// titles are in the dump, and case-folded as well with -title-key fold
+func (k titleKey) wikidataKey(title string) string {
+	return k.of(normalizeTitle(title, k.caseSensitive))
+}
+
+// pageURL builds the public URL of a page from its title
+func pageURL(base, title string) string {
+	return base + strings.ReplaceAll(title, " ", "_")
+}
+
+// checkPageURL reports why u, built by pageURL, would not lead back to its
+// page: it does not parse, or part of the title became a query or fragment
+func checkPageURL(u string) error {
+	parsed, err := url.Parse(u)
+	switch {
+	case err != nil:
+		return err
+	case parsed.RawQuery != "" || parsed.ForceQuery:
+		return fmt.Errorf("title leaks into the query string")
+	case parsed.Fragment != "" || strings.HasSuffix(u, "#"):
+		return fmt.Errorf("title leaks into the fragment")
+	}
+	return nil
+}
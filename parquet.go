@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"           // Package for in-memory buffers
+	"compress/gzip"   // Package for the GZIP codec
+	"encoding/binary" // Package for little-endian and varint encoding
+	"fmt"             // Package for formatted I/O
+	"io"              // Package for I/O primitives
+	"math"            // Package for float bit patterns
+)
+
+// Parquet enum values used by parquetWriter, as numbered in parquet.thrift
+const (
+	pqInt64     = 2 // Type INT64
+	pqDouble    = 5 // Type DOUBLE
+	pqByteArray = 6 // Type BYTE_ARRAY
+
+	pqRequired = 0 // FieldRepetitionType REQUIRED
+	pqOptional = 1 // FieldRepetitionType OPTIONAL
+	pqRepeated = 2 // FieldRepetitionType REPEATED
+
+	pqPlain = 0 // Encoding PLAIN
+	pqRLE   = 3 // Encoding RLE, for levels
+
+	pqUTF8     = 0 // ConvertedType UTF8
+	pqDataPage = 0 // PageType DATA_PAGE
+)
+
+// parquetCodecs maps -parquet-compression names to CompressionCodec values
+var parquetCodecs = map[string]int32{"none": 0, "snappy": 1, "gzip": 2}
+
+// parquetPageSize is the encoded size at which a column starts a new page
+const parquetPageSize = 1 << 20
+
+// pqColumn is one column of the file. It buffers the column chunk of the
+// row group being built: finished pages plus the page still being filled.
+type pqColumn struct {
+	name       string                    // Field name, as in JSON output
+	typ        int32                     // Physical type
+	repetition int32                     // Required, optional or repeated
+	add        func(c *pqColumn, d *Doc) // Appends a doc's value(s)
+
+	values    bytes.Buffer // PLAIN-encoded values of the open page
+	defs      []byte       // Definition levels of the open page
+	reps      []byte       // Repetition levels of the open page
+	numLevels int          // Values of the open page, nulls included
+
+	chunk        bytes.Buffer // Finished pages of the row group, headers included
+	chunkLevels  int64        // Values in chunk, nulls included
+	uncompressed int64        // Size of chunk had its pages not been compressed
+}
+
+// level records one value slot; repetition and definition levels are
+// only stored when the column can have them
+func (c *pqColumn) level(rep, def byte) {
+	if c.repetition == pqRepeated {
+		c.reps = append(c.reps, rep)
+	}
+	if c.repetition != pqRequired {
+		c.defs = append(c.defs, def)
+	}
+	c.numLevels++
+}
+
+// putString appends a BYTE_ARRAY value
+func (c *pqColumn) putString(s string) {
+	c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+	c.values.WriteString(s)
+}
+
+// flushPage compresses the open page into the chunk
+func (c *pqColumn) flushPage(codec int32) error {
+	if c.numLevels == 0 {
+		return nil
+	}
+	var raw bytes.Buffer
+	for _, levels := range [][]byte{c.reps, c.defs} {
+		if len(levels) > 0 {
+			enc := rleLevels(levels)
+			raw.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(enc))))
+			raw.Write(enc)
+		}
+	}
+	raw.Write(c.values.Bytes())
+	data, err := compressPage(raw.Bytes(), codec)
+	if err != nil {
+		return err
+	}
+
+	var t thriftWriter // PageHeader
+	t.i32(1, pqDataPage)
+	t.i32(2, int32(raw.Len()))
+	t.i32(3, int32(len(data)))
+	t.beginStruct(5) // DataPageHeader
+	t.i32(1, int32(c.numLevels))
+	t.i32(2, pqPlain)
+	t.i32(3, pqRLE)
+	t.i32(4, pqRLE)
+	t.endStruct()
+	t.stop()
+
+	c.chunk.Write(t.buf)
+	c.chunk.Write(data)
+	c.chunkLevels += int64(c.numLevels)
+	c.uncompressed += int64(len(t.buf) + raw.Len())
+	c.values.Reset()
+	c.defs, c.reps, c.numLevels = c.defs[:0], c.reps[:0], 0
+	return nil
+}
+
+// stringColumn holds a string field; optional ones store "" as null
+func stringColumn(name string, optional bool, get func(d *Doc) string) *pqColumn {
+	rep := int32(pqRequired)
+	if optional {
+		rep = pqOptional
+	}
+	return &pqColumn{name: name, typ: pqByteArray, repetition: rep, add: func(c *pqColumn, d *Doc) {
+		s := get(d)
+		if optional && s == "" {
+			c.level(0, 0)
+			return
+		}
+		c.level(0, 1)
+		c.putString(s)
+	}}
+}
+
+// listColumn holds a string list as a repeated field
+func listColumn(name string, get func(d *Doc) []string) *pqColumn {
+	return &pqColumn{name: name, typ: pqByteArray, repetition: pqRepeated, add: func(c *pqColumn, d *Doc) {
+		list := get(d)
+		if len(list) == 0 {
+			c.level(0, 0)
+		}
+		for i, s := range list {
+			c.level(byte(min(i, 1)), 1)
+			c.putString(s)
+		}
+	}}
+}
+
+// intColumn holds an optional integer field
+func intColumn[T int | int64](name string, get func(d *Doc) *T) *pqColumn {
+	return &pqColumn{name: name, typ: pqInt64, repetition: pqOptional, add: func(c *pqColumn, d *Doc) {
+		v := get(d)
+		if v == nil {
+			c.level(0, 0)
+			return
+		}
+		c.level(0, 1)
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(int64(*v))))
+	}}
+}
+
+// floatColumn holds an optional floating-point field
+func floatColumn(name string, get func(d *Doc) *float64) *pqColumn {
+	return &pqColumn{name: name, typ: pqDouble, repetition: pqOptional, add: func(c *pqColumn, d *Doc) {
+		v := get(d)
+		if v == nil {
+			c.level(0, 0)
+			return
+		}
+		c.level(0, 1)
+		c.values.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(*v)))
+	}}
+}
+
+// parquetColumns returns the columns of the Doc fields cfg produces, in JSON field order
+func parquetColumns(cfg *config) []*pqColumn {
+	cols := []*pqColumn{
+		stringColumn("title", false, func(d *Doc) string { return d.Title }),
+		stringColumn("url", false, func(d *Doc) string { return d.URL }),
+	}
+	if cfg.Slug {
+		cols = append(cols, stringColumn("slug", false, func(d *Doc) string { return d.Slug }))
+	}
+	cols = append(cols, stringColumn("abstract", false, func(d *Doc) string { return d.Abstract }))
+	if cfg.SentencesArray {
+		cols = append(cols, listColumn("sentences", func(d *Doc) []string { return d.Sentences }))
+	}
+	if cfg.AbstractHTML {
+		cols = append(cols, stringColumn("abstract_html", true, func(d *Doc) string { return d.AbstractHTML }))
+	}
+	if cfg.ExtractIPA {
+		cols = append(cols, stringColumn("ipa", true, func(d *Doc) string { return d.IPA }))
+	}
+	if cfg.ExtractAliases {
+		cols = append(cols, listColumn("aliases", func(d *Doc) []string { return d.Aliases }))
+	}
+	if cfg.ExtractRefs {
+		cols = append(cols, listColumn("references", func(d *Doc) []string { return d.References }))
+	}
+	if cfg.ExtractDates {
+		cols = append(cols,
+			stringColumn("birth_date", true, func(d *Doc) string { return d.BirthDate }),
+			stringColumn("death_date", true, func(d *Doc) string { return d.DeathDate }))
+	}
+	if cfg.ExtractInfobox != "" {
+		cols = append(cols, stringColumn("infobox", true, func(d *Doc) string { return d.Infobox.json() }))
+	}
+	if cfg.Wikidata != "" {
+		cols = append(cols, stringColumn("wikidata_id", true, func(d *Doc) string { return d.WikidataID }))
+	}
+	if cfg.EnrichSummary {
+		cols = append(cols,
+			stringColumn("short_description", true, func(d *Doc) string { return d.ShortDescription }),
+			stringColumn("image", true, func(d *Doc) string { return d.Image }))
+	}
+	if cfg.Score {
+		cols = append(cols, intColumn("score", func(d *Doc) *int { return d.Score }))
+	}
+	if cfg.Classify {
+		cols = append(cols,
+			stringColumn("length_class", true, func(d *Doc) string { return d.LengthClass }),
+			floatColumn("readability", func(d *Doc) *float64 { return d.Readability }))
+	}
+	if cfg.Fingerprint {
+		cols = append(cols, stringColumn("fingerprint", true, func(d *Doc) string { return d.Fingerprint }))
+	}
+	if cfg.WithOffset {
+		cols = append(cols,
+			intColumn("offset", func(d *Doc) *int64 { return d.Offset }),
+			intColumn("stream_offset", func(d *Doc) *int64 { return d.StreamOffset }))
+	}
+	if len(cfg.ConstFields) > 0 {
+		cols = append(cols, stringColumn("extra", true, func(d *Doc) string { return d.Extra.json() }))
+	}
+	if cfg.WithStreamIndex {
+		cols = append(cols, intColumn("stream_index", func(d *Doc) *int { return d.StreamIndex }))
+	}
+	if cfg.WithProvenance {
+		cols = append(cols,
+			stringColumn("source_file", true, func(d *Doc) string { return d.SourceFile }),
+			intColumn("source_offset", func(d *Doc) *int64 { return d.SourceOffset }))
+	}
+	if cfg.RevisionAgeField {
+		cols = append(cols, floatColumn("revision_age_days", func(d *Doc) *float64 { return d.RevisionAgeDays }))
+	}
+	return cols
+}
+
+// pqChunkMeta locates one written column chunk
+type pqChunkMeta struct {
+	offset       int64 // File offset of its first page
+	levels       int64 // Values, nulls included
+	uncompressed int64 // Uncompressed size, page headers included
+	compressed   int64 // Size in the file
+}
+
+// pqRowGroup describes one written row group
+type pqRowGroup struct {
+	chunks []pqChunkMeta // One per column
+	rows   int64         // Rows in the group
+	size   int64         // Uncompressed size of all its chunks
+}
+
+// parquetWriter streams docs into a Parquet file: rows accumulate in memory
+// for one row group at a time, which is then written out column by column,
+// and the footer describing every group is written by Close
+type parquetWriter struct {
+	w         io.Writer    // Destination stream
+	off       int64        // Bytes written so far
+	cols      []*pqColumn  // Columns of the selected fields
+	codec     int32        // Page compression
+	groupRows int          // Rows per row group
+	rows      int          // Rows in the open row group
+	groups    []pqRowGroup // Row groups written so far
+}
+
+func newParquetWriter(w io.Writer, cfg *config) (docWriter, error) {
+	p := &parquetWriter{w: w, cols: parquetColumns(cfg), codec: parquetCodecs[cfg.ParquetCompression], groupRows: cfg.ParquetRowGroup}
+	if err := p.write([]byte("PAR1")); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// write sends b to the destination, tracking the file offset
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.off += int64(n)
+	return err
+}
+
+func (p *parquetWriter) WriteDoc(doc *Doc) error {
+	for _, c := range p.cols {
+		c.add(c, doc)
+		if c.values.Len() >= parquetPageSize {
+			if err := c.flushPage(p.codec); err != nil {
+				return err
+			}
+		}
+	}
+	if p.rows++; p.rows >= p.groupRows {
+		return p.flushGroup()
+	}
+	return nil
+}
+
+// flushGroup writes the buffered row group, one column chunk after another
+func (p *parquetWriter) flushGroup() error {
+	if p.rows == 0 {
+		return nil
+	}
+	g := pqRowGroup{rows: int64(p.rows)}
+	for _, c := range p.cols {
+		if err := c.flushPage(p.codec); err != nil {
+			return err
+		}
+		m := pqChunkMeta{offset: p.off, levels: c.chunkLevels, uncompressed: c.uncompressed, compressed: int64(c.chunk.Len())}
+		if err := p.write(c.chunk.Bytes()); err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, m)
+		g.size += m.uncompressed
+		c.chunk.Reset()
+		c.chunkLevels, c.uncompressed = 0, 0
+	}
+	p.groups = append(p.groups, g)
+	p.rows = 0
+	return nil
+}
+
+// Close writes the last row group and the footer
+func (p *parquetWriter) Close() error {
+	if err := p.flushGroup(); err != nil {
+		return err
+	}
+	footer := p.fileMetaData()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	return p.write(append(footer, "PAR1"...))
+}
+
+// fileMetaData encodes the FileMetaData footer: schema and row group index
+func (p *parquetWriter) fileMetaData() []byte {
+	var numRows int64
+	for _, g := range p.groups {
+		numRows += g.rows
+	}
+	var t thriftWriter
+	t.i32(1, 1) // Format version
+
+	t.listHeader(2, thriftStruct, len(p.cols)+1)
+	t.beginElem() // Root of the schema
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.cols)))
+	t.endStruct()
+	for _, c := range p.cols {
+		t.beginElem()
+		t.i32(1, c.typ)
+		t.i32(3, c.repetition)
+		t.binary(4, c.name)
+		if c.typ == pqByteArray {
+			t.i32(6, pqUTF8)
+			t.beginStruct(10) // LogicalType
+			t.beginStruct(1)  // STRING
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64(3, numRows)
+
+	t.listHeader(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.beginElem() // RowGroup
+		t.listHeader(1, thriftStruct, len(g.chunks))
+		for i, m := range g.chunks {
+			c := p.cols[i]
+			t.beginElem() // ColumnChunk
+			t.i64(2, m.offset)
+			t.beginStruct(3) // ColumnMetaData
+			t.i32(1, c.typ)
+			t.listHeader(2, thriftI32, 2)
+			t.elemI32(pqPlain)
+			t.elemI32(pqRLE)
+			t.listHeader(3, thriftBinary, 1)
+			t.elemBinary(c.name)
+			t.i32(4, p.codec)
+			t.i64(5, m.levels)
+			t.i64(6, m.uncompressed)
+			t.i64(7, m.compressed)
+			t.i64(9, m.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+	t.binary(6, "full-stream-wiki")
+	t.stop()
+	return t.buf
+}
+
+// rleLevels encodes levels as runs of the RLE/bit-packing hybrid encoding,
+// one byte per value as levels here are 0 or 1
+func rleLevels(levels []byte) []byte {
+	var out []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		out = append(out, levels[i])
+		i = j
+	}
+	return out
+}
+
+// compressPage applies a Parquet codec to a page
+func compressPage(raw []byte, codec int32) ([]byte, error) {
+	switch codec {
+	case parquetCodecs["snappy"]:
+		return snappyEncode(raw), nil
+	case parquetCodecs["gzip"]:
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(raw)
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress parquet page: %w", err)
+		}
+		return b.Bytes(), nil
+	}
+	return raw, nil
+}
+
+// snappyEncode compresses src into a Snappy block, the form Parquet uses:
+// a varint length, then literals and back-references within 64 KiB blocks
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		block := src[:min(len(src), 1<<16)]
+		src = src[len(block):]
+		dst = snappyBlock(dst, block)
+	}
+	return dst
+}
+
+// snappyBlock greedily replaces repeated 4-byte sequences with copies
+func snappyBlock(dst, src []byte) []byte {
+	var table [1 << 14]int32 // Last position+1 of each hashed 4-byte sequence
+	lit := 0                 // Start of the pending literal
+	for i := 0; i+4 <= len(src); {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 0x1e35a7bd) >> 18
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || binary.LittleEndian.Uint32(src[cand:]) != seq {
+			i++
+			continue
+		}
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = snappyLiteral(dst, src[lit:i])
+		dst = snappyCopy(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+	return snappyLiteral(dst, src[lit:])
+}
+
+// snappyLiteral emits bytes stored as they are
+func snappyLiteral(dst, lit []byte) []byte {
+	switch n := len(lit) - 1; {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	default:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy emits a back-reference of n bytes, split as copies hold at most 64
+func snappyCopy(dst []byte, off, n int) []byte {
+	for n >= 68 {
+		dst = append(dst, 63<<2|2, byte(off), byte(off>>8))
+		n -= 64
+	}
+	if n > 64 {
+		dst = append(dst, 59<<2|2, byte(off), byte(off>>8))
+		n -= 60
+	}
+	if n <= 11 && off < 2048 {
+		return append(dst, byte(off>>8)<<5|byte(n-4)<<2|1, byte(off))
+	}
+	return append(dst, byte(n-1)<<2|2, byte(off), byte(off>>8))
+}
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol used by Parquet metadata
+type thriftWriter struct {
+	buf    []byte  // Encoded bytes
+	last   int16   // Previous field ID in the current struct
+	parent []int16 // Previous field IDs of the enclosing structs
+}
+
+// field writes a field header, as a delta from the previous field when it fits
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+// beginStruct opens a struct-valued field
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem opens a struct that is a list element
+func (t *thriftWriter) beginElem() {
+	t.parent = append(t.parent, t.last)
+	t.last = 0
+}
+
+// endStruct closes the innermost struct
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.parent[len(t.parent)-1]
+	t.parent = t.parent[:len(t.parent)-1]
+}
+
+// stop ends a struct's fields
+func (t *thriftWriter) stop() { t.buf = append(t.buf, 0) }
+
+// listHeader starts a list field of n elements of type elem
+func (t *thriftWriter) listHeader(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) elemI32(v int32) { t.buf = binary.AppendVarint(t.buf, int64(v)) }
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
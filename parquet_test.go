@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"         // Package for in-memory buffers
+	"encoding/json" // Package for reading the JSONL run back
+	"errors"        // Package for the end of the rows
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"path/filepath" // Package for the output paths
+	"reflect"       // Package for comparing decoded rows
+	"strings"       // Package for string manipulation
+	"testing"       // Package for tests
+
+	"github.com/parquet-go/parquet-go"        // Package for a Parquet reader independent of ours
+	"github.com/parquet-go/parquet-go/format" // Package for the footer's types
+)
+
+// parquetFlags select a column of every type and repetition parquetWriter
+// writes: required and optional strings, a repeated string list, INT64 and
+// DOUBLE
+var parquetFlags = []string{"-plain", "-slug", "-sentences-array", "-extract-refs", "-extract-dates",
+	"-score", "-classify", "-with-offset"}
+
+// readParquetRows reads every row of a Parquet file as a map from column
+// name to value, leaving out nulls: a string, a float64 for a number, or a
+// []string for a repeated column, as encoding/json decodes a JSONL line
+func readParquetRows(t *testing.T, data []byte) (*parquet.File, []map[string]any) {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range f.Schema().Columns() {
+		names = append(names, strings.Join(path, "."))
+	}
+	var rows []map[string]any
+	for _, g := range f.RowGroups() {
+		r := g.Rows()
+		buf := make([]parquet.Row, 16)
+		for {
+			n, err := r.ReadRows(buf)
+			for _, row := range buf[:n] {
+				m := map[string]any{}
+				for _, v := range row {
+					name := names[v.Column()]
+					leaf, _ := f.Schema().Lookup(name)
+					switch {
+					case v.IsNull():
+					case leaf.Node.Repeated():
+						list, _ := m[name].([]string)
+						m[name] = append(list, v.String())
+					case v.Kind() == parquet.Int64:
+						m[name] = float64(v.Int64())
+					case v.Kind() == parquet.Double:
+						m[name] = v.Double()
+					default:
+						m[name] = string(v.ByteArray())
+					}
+				}
+				rows = append(rows, m)
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Close()
+	}
+	return f, rows
+}
+
+// jsonlRows reads a JSONL output as maps of the types readParquetRows gives
+func jsonlRows(t *testing.T, data []byte) []map[string]any {
+	t.Helper()
+	var rows []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range m {
+			if v, ok := v.([]any); ok {
+				list := make([]string, len(v))
+				for i, s := range v {
+					list[i] = s.(string)
+				}
+				m[k] = list
+			}
+		}
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+// TestParquetRoundTrip writes the sample as Parquet with every codec and
+// several row group sizes, reads it back with another implementation and
+// checks that each row holds what the JSONL output of the same run does
+func TestParquetRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := jsonlRows(t, runTool(t, append([]string{"-format", "jsonl"}, parquetFlags...), dir, filepath.Join(dir, "want.jsonl")))
+	for _, codec := range []string{"none", "snappy", "gzip"} {
+		for _, group := range []int{1, 7, 50_000} {
+			t.Run(fmt.Sprintf("%s/%d", codec, group), func(t *testing.T) {
+				args := append([]string{"-format", "parquet", "-parquet-compression", codec,
+					"-parquet-row-group", fmt.Sprint(group)}, parquetFlags...)
+				data := runTool(t, args, dir, filepath.Join(dir, fmt.Sprintf("%s-%d.parquet", codec, group)))
+				f, got := readParquetRows(t, data)
+				if len(got) != len(want) {
+					t.Fatalf("%d rows, want %d", len(got), len(want))
+				}
+				for i := range want {
+					if !reflect.DeepEqual(got[i], want[i]) {
+						t.Fatalf("row %d:\n got:  %v\n want: %v", i, got[i], want[i])
+					}
+				}
+				meta := f.Metadata()
+				if groups := (len(want) + group - 1) / group; len(meta.RowGroups) != groups {
+					t.Errorf("%d row groups, want %d", len(meta.RowGroups), groups)
+				}
+				for _, g := range meta.RowGroups {
+					for _, c := range g.Columns {
+						if c.MetaData.Codec != format.CompressionCodec(parquetCodecs[codec]) {
+							t.Fatalf("column %s is compressed with %v, want %s", c.MetaData.PathInSchema, c.MetaData.Codec, codec)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestParquetSchema checks the column types and repetitions the README
+// promises: strings as UTF8, score as INT64, readability as DOUBLE, optional
+// fields nullable and references repeated
+func TestParquetSchema(t *testing.T) {
+	dir := t.TempDir()
+	data := runTool(t, append([]string{"-format", "parquet"}, parquetFlags...), dir, filepath.Join(dir, "schema.parquet"))
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"title": "required BYTE_ARRAY UTF8", "url": "required BYTE_ARRAY UTF8", "slug": "required BYTE_ARRAY UTF8",
+		"abstract": "required BYTE_ARRAY UTF8", "sentences": "repeated BYTE_ARRAY UTF8", "references": "repeated BYTE_ARRAY UTF8",
+		"birth_date": "optional BYTE_ARRAY UTF8", "death_date": "optional BYTE_ARRAY UTF8", "score": "optional INT64",
+		"length_class": "optional BYTE_ARRAY UTF8", "readability": "optional DOUBLE",
+		"offset": "optional INT64", "stream_offset": "optional INT64",
+	}
+	got := map[string]string{}
+	for _, path := range f.Schema().Columns() {
+		leaf, _ := f.Schema().Lookup(path...)
+		rep := "required"
+		switch {
+		case leaf.Node.Repeated():
+			rep = "repeated"
+		case leaf.Node.Optional():
+			rep = "optional"
+		}
+		typ := leaf.Node.Type().Kind().String()
+		if lt := leaf.Node.Type().LogicalType(); lt != nil && lt.UTF8 != nil {
+			typ += " UTF8"
+		}
+		got[strings.Join(path, ".")] = rep + " " + typ
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schema %v, want %v", got, want)
+	}
+}
+
+// TestParquetEmpty checks that a run writing no docs still gives a file
+// readers open, with the schema and no rows
+func TestParquetEmpty(t *testing.T) {
+	dir := t.TempDir()
+	data := runTool(t, []string{"-plain", "-format", "parquet", "-min-id", "999999999"}, dir, filepath.Join(dir, "empty.parquet"))
+	f, rows := readParquetRows(t, data)
+	if len(rows) != 0 || f.NumRows() != 0 || len(f.Schema().Columns()) != 3 {
+		t.Errorf("%d rows and %d columns, want none and title, url, abstract", len(rows), len(f.Schema().Columns()))
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"  // Package for formatted I/O
+	"sync" // Package for waiting on the workers
+	"time" // Package for cleanup timing (-top-n)
+)
+
+// pageResult is what building one page's doc found, for post to count and write
+type pageResult struct {
+	seq      int           // Position among the pages handed to the pool
+	p        *page         // The page; with -workers its text is dropped once built
+	doc      Doc           // The doc, unless the page is dropped
+	empty    bool          // The abstract came out empty
+	lowScore bool          // The page scored below -min-score
+	timedOut bool          // Cleanup ran past -page-timeout; doc holds the naive abstract
+	badURL   error         // Why the URL failed -validate-urls
+	target   string        // Redirect target, for the -wikidata lookup
+	cleanup  time.Duration // Time spent building the doc (-top-n)
+}
+
+// pageJob is one page handed to a worker
+type pageJob struct {
+	seq int   // Position among the pages handed to the pool
+	p   *page // The page
+}
+
+// workerPool builds docs on -workers goroutines, each with its own cleaner,
+// while reading, filtering, counting and writing stay on the calling
+// goroutine. Results are posted as they finish or, with -ordered, in the
+// order the pages were submitted: a reorder buffer holds those finished
+// ahead of the oldest page still in a worker and fails the run when it would
+// grow past -reorder-buffer, as a worker stuck on one page would otherwise
+// make it grow without bound.
+type workerPool struct {
+	jobs     chan *pageJob           // Pages waiting for a worker
+	results  chan *pageResult        // Built pages
+	post     func(*pageResult) error // Counts and writes one result
+	ordered  bool                    // Post in submission order (-ordered)
+	limit    int                     // Results the reorder buffer holds at most
+	pending  map[int]*pageResult     // Finished results waiting for an earlier one
+	titles   map[int]string          // Titles of the pages not yet posted, for the error
+	seq      int                     // Position of the next page submitted
+	next     int                     // Position of the next result to post, with -ordered
+	inFlight int                     // Pages submitted and not yet received back
+	closed   bool                    // No more jobs will be sent
+	wg       sync.WaitGroup          // Running workers
+}
+
+// newWorkerPool starts cfg.Workers workers running build with copies of c
+func newWorkerPool(cfg *config, c *cleaner, build func(*cleaner, *page) *pageResult, post func(*pageResult) error) *workerPool {
+	w := &workerPool{
+		jobs:    make(chan *pageJob, cfg.Workers),
+		results: make(chan *pageResult, cfg.Workers),
+		post:    post,
+		ordered: cfg.Ordered,
+		limit:   cfg.ReorderBuffer,
+		pending: map[int]*pageResult{},
+		titles:  map[int]string{},
+	}
+	for range cfg.Workers {
+		wc := c.clone()
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for j := range w.jobs {
+				wc.startPage()
+				r := build(wc, j.p)
+				r.seq = j.seq
+				j.p.Revision.Text = "" // The result may wait in the reorder buffer; the text is not needed again
+				w.results <- r
+			}
+		}()
+	}
+	return w
+}
+
+// submit hands p to a worker, posting the results that finish meanwhile
+func (w *workerPool) submit(p *page) error {
+	j := &pageJob{seq: w.seq, p: p}
+	w.seq++
+	if w.ordered {
+		w.titles[j.seq] = p.Title
+	}
+	for {
+		select {
+		case w.jobs <- j:
+			w.inFlight++
+			return nil
+		case r := <-w.results:
+			w.inFlight--
+			if err := w.receive(r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receive posts r or, with -ordered, files it and posts every result now in order
+func (w *workerPool) receive(r *pageResult) error {
+	if !w.ordered {
+		return w.post(r)
+	}
+	w.pending[r.seq] = r
+	for {
+		r, ok := w.pending[w.next]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.next)
+		delete(w.titles, w.next)
+		w.next++
+		if err := w.post(r); err != nil {
+			return err
+		}
+	}
+	if len(w.pending) > w.limit {
+		return fmt.Errorf("-ordered: page %q was still being built after %d later pages finished, past -reorder-buffer %d (raise it, or bound pages with -page-timeout)",
+			w.titles[w.next], len(w.pending), w.limit)
+	}
+	return nil
+}
+
+// finish posts the results still in the workers and stops them
+func (w *workerPool) finish() error {
+	close(w.jobs)
+	w.closed = true
+	for w.inFlight > 0 {
+		r := <-w.results
+		w.inFlight--
+		if err := w.receive(r); err != nil {
+			w.abort()
+			return err
+		}
+	}
+	w.wg.Wait()
+	return nil
+}
+
+// abort stops the workers of a failed run, dropping what they built
+func (w *workerPool) abort() {
+	if !w.closed {
+		close(w.jobs)
+		w.closed = true
+	}
+	for ; w.inFlight > 0; w.inFlight-- {
+		<-w.results
+	}
+	w.wg.Wait()
+}
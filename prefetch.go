@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes" // Package for the decompressed streams
+	"io"    // Package for I/O primitives
+	"sync"  // Package for stopping the decompressors once
+)
+
+// streamPrefetcher decompresses the bzip2 streams of a multistream dump on
+// -workers goroutines at once, as each stream decompresses on its own, and
+// reads them back in dump order, so the XML parser sees exactly the bytes
+// the serial path gives it. At most -prefetch-streams decompressed streams
+// are held ahead of the parser; past that the decompressors wait.
+type streamPrefetcher struct {
+	order chan chan prefetched // One slot per stream in dump order, filled by its decompressor
+	cur   *bytes.Reader        // The stream being read
+	err   error                // First decompression error, returned once cur runs out
+	read  *inputProgress       // Counts each stream's compressed size as the parser reaches it
+	stop  chan struct{}        // Closed by Close to end the feeding goroutine
+	once  sync.Once            // Guards closing stop
+}
+
+// prefetched is one decompressed stream
+type prefetched struct {
+	data []byte // Decompressed bytes
+	size int64  // Compressed bytes
+	err  error  // Why decompression failed
+}
+
+// newStreamPrefetcher starts decompressing streams, each opened by open on
+// its own goroutine
+func newStreamPrefetcher(cfg *config, streams []streamRange, open func(off, end int64) io.Reader) *streamPrefetcher {
+	p := &streamPrefetcher{
+		order: make(chan chan prefetched, cfg.PrefetchStreams),
+		cur:   bytes.NewReader(nil),
+		read:  cfg.Progress,
+		stop:  make(chan struct{}),
+	}
+	go func() {
+		defer close(p.order)
+		workers := make(chan struct{}, cfg.Workers) // One token per running decompressor
+		for _, s := range streams {
+			slot := make(chan prefetched, 1) // Buffered, so a decompressor never waits on the reader
+			select {
+			case p.order <- slot:
+			case <-p.stop:
+				return
+			}
+			select {
+			case workers <- struct{}{}:
+			case <-p.stop:
+				return
+			}
+			go func(s streamRange) {
+				data, err := io.ReadAll(open(s.off, s.end))
+				<-workers
+				slot <- prefetched{data, s.end - s.off, err}
+			}(s)
+		}
+	}()
+	return p
+}
+
+func (p *streamPrefetcher) Read(b []byte) (int, error) {
+	for p.cur.Len() == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		slot, ok := <-p.order
+		if !ok {
+			return 0, io.EOF
+		}
+		r := <-slot
+		p.cur, p.err = bytes.NewReader(r.data), r.err
+		p.read.read += r.size // On this goroutine, as progressReader counts
+	}
+	return p.cur.Read(b)
+}
+
+// Close stops decompressing streams not yet started
+func (p *streamPrefetcher) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	return nil
+}
+
+// closeBoth closes a wrapping stream and then the one beneath it
+type closeBoth [2]io.Closer
+
+func (c closeBoth) Close() error {
+	err := c[0].Close()
+	if err2 := c[1].Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"         // Package for buffered output
+	"encoding/json" // Package for JSONL encoding
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for deriving product file names
+	"strings"       // Package for string manipulation
+)
+
+// productNames are the -products values, in the order their files are listed
+var productNames = []string{"abstracts", "links", "categories", "redirects"}
+
+// parseProducts splits and checks the -products list. The abstracts are the
+// -o output itself and so must be among them.
+func parseProducts(list string) (map[string]bool, error) {
+	products := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, p := range productNames {
+			known = known || p == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown product %q in -products (want %s)", name, strings.Join(productNames, ", "))
+		}
+		products[name] = true
+	}
+	if !products["abstracts"] {
+		return nil, fmt.Errorf("-products must include abstracts, which are written to -o")
+	}
+	return products, nil
+}
+
+// productPath is the file of a product next to the abstracts output:
+// out/enwiki.jsonl.gz gives out/enwiki.links.jsonl
+func productPath(output, name string) string {
+	stem := output
+	for _, ext := range []string{".gz", ".bz2", ".zst"} {
+		stem = strings.TrimSuffix(stem, ext)
+	}
+	stem = strings.TrimSuffix(stem, filepath.Ext(stem))
+	return stem + "." + name + ".jsonl"
+}
+
+// productRecord is what the summary, the manifest and the stats file say of one product
+type productRecord struct {
+	Name    string `json:"name"`    // Product, as in -products
+	Path    string `json:"path"`    // File written
+	Records int    `json:"records"` // Links, categories, redirects or docs written
+	Pages   int    `json:"pages"`   // Pages that contributed at least one
+}
+
+// pageObserver is one side product of -products. Every page in the ID range
+// is shown to every observer once, before the abstract filters, so all
+// products come out of the same pass over the dump.
+type pageObserver interface {
+	observe(p *page) error // Look at one page and write what it yields
+	close() error          // Flush and close the product's file
+	record() productRecord // Counts for the summary and the manifest
+}
+
+// productFile is the JSONL file shared by the observers
+type productFile struct {
+	productRecord
+	f   *os.File      // Product file
+	buf *bufio.Writer // Buffered records
+	enc *json.Encoder // Record encoder
+}
+
+// createProductFile creates the file of the named product
+func createProductFile(cfg *config, name string) (*productFile, error) {
+	pf := &productFile{productRecord: productRecord{Name: name, Path: productPath(cfg.Output, name)}}
+	f, err := os.Create(pf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s file: %w", name, err)
+	}
+	pf.f, pf.buf = f, bufio.NewWriter(f)
+	pf.enc = newJSONEncoder(pf.buf, cfg)
+	return pf, nil
+}
+
+// write encodes one page's record, which holds n items
+func (pf *productFile) write(v any, n int) error {
+	if err := pf.enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", pf.Name, err)
+	}
+	pf.Records += n
+	pf.Pages++
+	return nil
+}
+
+func (pf *productFile) close() error {
+	err := pf.buf.Flush()
+	if cerr := pf.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", pf.Name, err)
+	}
+	return nil
+}
+
+func (pf *productFile) record() productRecord { return pf.productRecord }
+
+// newObservers creates the observers of the side products cfg asks for
+func newObservers(cfg *config) ([]pageObserver, error) {
+	var observers []pageObserver
+	for _, name := range productNames[1:] {
+		if !cfg.Products[name] {
+			continue
+		}
+		pf, err := createProductFile(cfg, name)
+		if err != nil {
+			for _, o := range observers {
+				o.close()
+			}
+			return nil, err
+		}
+		inNS := namespaceFilter(cfg)
+		switch name {
+		case "links":
+			observers = append(observers, &linksObserver{pf, cfg, inNS})
+		case "categories":
+			observers = append(observers, &categoriesObserver{productFile: pf, cfg: cfg, inNS: inNS})
+		case "redirects":
+			observers = append(observers, &redirectsObserver{pf, inNS})
+		}
+	}
+	return observers, nil
+}
+
+// linksObserver writes {"title", "links"} with the wiki link targets of every
+// article, normalized and each once, in the order they first appear
+type linksObserver struct {
+	*productFile
+	cfg  *config        // Title case rule
+	inNS func(int) bool // Namespace selection
+}
+
+func (o *linksObserver) observe(p *page) error {
+	if !o.inNS(p.NS) || redirectOf(p) != "" {
+		return nil
+	}
+	links := pageLinks(p.Revision.Text, o.cfg.TitleKey.caseSensitive)
+	if len(links) == 0 {
+		return nil
+	}
+	return o.write(struct {
+		Title string   `json:"title"`
+		Links []string `json:"links"`
+	}{p.Title, links}, len(links))
+}
+
+// pageLinks returns the targets of the [[links]] in text that point at other
+// pages of the wiki: file, category and interlanguage links are left out, as
+// are links to a section of the page itself. A link inside a caption counts.
+func pageLinks(text string, caseSensitive bool) []string {
+	text = commentRe.ReplaceAllString(text, "")
+	var links []string
+	seen := map[string]bool{}
+	for {
+		i := strings.Index(text, "[[")
+		if i < 0 {
+			return links
+		}
+		text = text[i+2:]
+		end := strings.IndexAny(text, "|]\n")
+		if end < 0 || strings.HasPrefix(text[end:], "]") && !strings.HasPrefix(text[end:], "]]") {
+			continue
+		}
+		target := strings.TrimSpace(text[:end])
+		if strings.Contains(target, "[[") || strings.Contains(target, "{") {
+			continue
+		}
+		forced := strings.HasPrefix(target, ":") // [[:Category:X]] links to the category page
+		target = strings.TrimPrefix(target, ":")
+		if prefix, _, ok := strings.Cut(target, ":"); ok && !forced {
+			prefix = strings.ToLower(strings.TrimSpace(prefix))
+			if droppedLinks[prefix] || interwikiRe.MatchString(prefix) {
+				continue
+			}
+		}
+		target, _, _ = strings.Cut(target, "#")
+		if target = normalizeTitle(target, caseSensitive); target != "" && !seen[target] {
+			seen[target] = true
+			links = append(links, target)
+		}
+	}
+}
+
+// categoriesObserver writes {"title", "categories"} with the categories of
+// every article, as [[Category:Name|sort key]] assigns them, by the English
+// prefix or the dump's own name for namespace 14
+type categoriesObserver struct {
+	*productFile
+	cfg      *config        // Title case rule
+	inNS     func(int) bool // Namespace selection
+	prefixes []string       // Lowercased category prefixes, colon included
+}
+
+// categoryNS is the namespace number of categories on every MediaWiki
+const categoryNS = 14
+
+// begin picks up the localized category prefix from the siteinfo
+func (o *categoriesObserver) begin(s *SiteInfo) {
+	o.prefixes = categoryPrefixes(s)
+}
+
+// categoryPrefixes are the lowercased link prefixes of categories: the
+// English one and the dump's own name for namespace 14, if it has another
+func categoryPrefixes(s *SiteInfo) []string {
+	prefixes := []string{"category:"}
+	if s == nil {
+		return prefixes
+	}
+	for _, ns := range s.Namespaces {
+		if name := strings.ToLower(ns.Name); ns.Key == categoryNS && name != "" && name != "category" {
+			prefixes = append(prefixes, name+":")
+		}
+	}
+	return prefixes
+}
+
+func (o *categoriesObserver) observe(p *page) error {
+	if !o.inNS(p.NS) || redirectOf(p) != "" {
+		return nil
+	}
+	if o.prefixes == nil {
+		o.begin(nil)
+	}
+	cats := pageCategories(p.Revision.Text, o.prefixes, o.cfg.TitleKey.caseSensitive)
+	if len(cats) == 0 {
+		return nil
+	}
+	return o.write(struct {
+		Title      string   `json:"title"`
+		Categories []string `json:"categories"`
+	}{p.Title, cats}, len(cats))
+}
+
+// pageCategories returns the categories text assigns with
+// [[Category:Name|sort key]] links, normalized, each once, in text order
+func pageCategories(text string, prefixes []string, caseSensitive bool) []string {
+	var cats []string
+	seen := map[string]bool{}
+	text = commentRe.ReplaceAllString(text, "")
+	for {
+		i := strings.Index(text, "[[")
+		if i < 0 {
+			break
+		}
+		text = text[i+2:]
+		end := strings.IndexAny(text, "|]\n")
+		if end < 0 {
+			break
+		}
+		body := strings.TrimSpace(text[:end])
+		lower := strings.ToLower(body)
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(lower, prefix) {
+				continue
+			}
+			name := normalizeTitle(body[len(prefix):], caseSensitive)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				cats = append(cats, name)
+			}
+			break
+		}
+	}
+	return cats
+}
+
+// redirectsObserver writes the {"from", "to"} pairs of -redirects-only; with
+// it among the products, redirect pages are left out of the abstracts
+type redirectsObserver struct {
+	*productFile
+	inNS func(int) bool // Namespace selection
+}
+
+func (o *redirectsObserver) observe(p *page) error {
+	if !o.inNS(p.NS) {
+		return nil
+	}
+	if to := redirectOf(p); to != "" {
+		return o.write(redirect{From: p.Title, To: to}, 1)
+	}
+	return nil
+}
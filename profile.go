@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file creation)
+	"os/signal"     // Package for signal notification
+	"runtime"       // Package for forcing a GC before the heap profile
+	"runtime/pprof" // Package for CPU and heap profiles
+	"sync"          // Package for one-time shutdown
+	"syscall"       // Package for signal numbers
+	"time"          // Package for the profiling window
+)
+
+// profiler writes the -cpuprofile and -memprofile of a run. Profiles cover
+// the whole run, or only its first -profile-seconds or -profile-pages, and
+// are written even when the run is interrupted.
+type profiler struct {
+	cpu     *os.File       // Open CPU profile, if any
+	memPath string         // Where the heap profile goes, if anywhere
+	pages   int            // Stop after this many pages (0: at the end of the run)
+	once    sync.Once      // Guards stop
+	err     error          // Outcome of stop
+	sigs    chan os.Signal // SIGINT/SIGTERM while profiling
+	done    chan struct{}  // Closed by stop
+}
+
+// startProfiles starts the profiles cfg asks for; it returns nil when there are none
+func startProfiles(cfg *config) (*profiler, error) {
+	if cfg.CPUProfile == "" && cfg.MemProfile == "" {
+		return nil, nil
+	}
+	p := &profiler{memPath: cfg.MemProfile, pages: cfg.ProfilePages, sigs: make(chan os.Signal, 1), done: make(chan struct{})}
+	if cfg.CPUProfile != "" {
+		f, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		p.cpu = f
+	}
+	if cfg.ProfileSeconds > 0 {
+		time.AfterFunc(time.Duration(cfg.ProfileSeconds)*time.Second, p.stopEarly)
+	}
+
+	// An interrupt would otherwise end the process with the profiles unwritten.
+	// With -exec the run winds down by itself once the signal reaches the child,
+	// and with -stats-file once the page being processed is done.
+	signal.Notify(p.sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-p.sigs:
+			p.stop()
+			if cfg.Exec == "" && cfg.Stop == nil {
+				fmt.Fprintln(os.Stderr, "error:", errInterrupted)
+				os.Exit(130)
+			}
+		case <-p.done:
+		}
+	}()
+	return p, nil
+}
+
+// page is called per decoded page to end a -profile-pages window
+func (p *profiler) page(n int) {
+	if p != nil && n == p.pages {
+		p.stopEarly()
+	}
+}
+
+// stopEarly ends the profiling window before the run ends
+func (p *profiler) stopEarly() {
+	if err := p.stop(); err != nil {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+}
+
+// stop writes the profiles; only the first call has an effect
+func (p *profiler) stop() error {
+	p.once.Do(func() {
+		signal.Stop(p.sigs)
+		close(p.done)
+		if p.cpu != nil {
+			pprof.StopCPUProfile()
+			if err := p.cpu.Close(); err != nil {
+				p.err = fmt.Errorf("failed to write CPU profile: %w", err)
+			}
+		}
+		if p.memPath != "" {
+			runtime.GC() // Report live memory as of now
+			f, err := os.Create(p.memPath)
+			if err == nil {
+				err = pprof.WriteHeapProfile(f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil && p.err == nil {
+				p.err = fmt.Errorf("failed to write heap profile: %w", err)
+			}
+		}
+	})
+	return p.err
+}
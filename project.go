@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"sort"    // Package for listing the projects
+	"strings" // Package for string manipulation
+)
+
+// wikiProject describes a Wikimedia project whose dumps the extractor reads (-project)
+type wikiProject struct {
+	host       string              // Domain under the language code, e.g. "wikipedia.org"
+	db         string              // Suffix of the dump's database name, e.g. "wiktionary" in "enwiktionary"
+	namespaces string              // -namespaces default ("" keeps every namespace)
+	lead       func(string) string // Picks the wikitext the abstract is taken from (nil: the whole page)
+}
+
+// projects are the -project values. Wikipedia keeps every namespace unless
+// told otherwise, as it always has; the others default to their main
+// namespace, the only content namespace whose number is the same on every language.
+var projects = map[string]wikiProject{
+	"wikipedia":   {host: "wikipedia.org", db: "wiki"},
+	"wiktionary":  {host: "wiktionary.org", db: "wiktionary", namespaces: "0", lead: wiktionaryDefinitions},
+	"wikibooks":   {host: "wikibooks.org", db: "wikibooks", namespaces: "0"},
+	"wikinews":    {host: "wikinews.org", db: "wikinews", namespaces: "0"},
+	"wikiquote":   {host: "wikiquote.org", db: "wikiquote", namespaces: "0"},
+	"wikisource":  {host: "wikisource.org", db: "wikisource", namespaces: "0"},
+	"wikiversity": {host: "wikiversity.org", db: "wikiversity", namespaces: "0"},
+	"wikivoyage":  {host: "wikivoyage.org", db: "wikivoyage", namespaces: "0"},
+}
+
+// lookupProject returns the -project of that name
+func lookupProject(name string) (wikiProject, error) {
+	p, ok := projects[name]
+	if !ok {
+		names := make([]string, 0, len(projects))
+		for n := range projects {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return p, fmt.Errorf("unknown -project %q (want one of %s)", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// dumpURL returns the latest articles dump URL of the project in a language,
+// in its multistream variant or as the single bzip2 stream
+func (p wikiProject) dumpURL(lang string, multistream bool) string {
+	db := lang + p.db
+	if !multistream {
+		return fmt.Sprintf("https://dumps.wikimedia.org/%[1]s/latest/%[1]s-latest-pages-articles.xml.bz2", db)
+	}
+	return fmt.Sprintf("https://dumps.wikimedia.org/%[1]s/latest/%[1]s-latest-pages-articles-multistream.xml.bz2", db)
+}
+
+// base returns the base URL under which the project's pages in a language live
+func (p wikiProject) base(lang string) string {
+	return "https://" + lang + "." + p.host + "/wiki/"
+}
+
+// wiktionaryDefinitions returns the definitions of the first language section
+// of a Wiktionary entry, one paragraph each, so the abstract is the first of
+// them that survives cleanup. Entries have no lead: a "==Language==" heading
+// is followed by part-of-speech sections whose "# " lines are the senses;
+// "#:" examples, "#*" quotations and "##" sub-senses are left out.
+func wiktionaryDefinitions(text string) string {
+	var defs []string
+	inLanguage := false
+	for _, line := range strings.Split(text, "\n") {
+		if headingLevel(line) == 2 {
+			if len(defs) > 0 {
+				break // Only the first language with definitions counts
+			}
+			inLanguage = true
+			continue
+		}
+		if !inLanguage || !strings.HasPrefix(line, "#") || len(line) > 1 && strings.ContainsRune(":*#", rune(line[1])) {
+			continue
+		}
+		if def := strings.TrimRight(strings.TrimSpace(line[1:]), ":"); def != "" { // "... of:" introduces the examples
+			defs = append(defs, def)
+		}
+	}
+	return strings.Join(defs, "\n\n")
+}
+
+// headingLevel returns the level of a "== Heading ==" line, or 0 for any other line
+func headingLevel(line string) int {
+	line = strings.TrimRight(line, " \t")
+	open := len(line) - len(strings.TrimLeft(line, "="))
+	closing := len(line) - len(strings.TrimRight(line, "="))
+	if open == 0 || open != closing || open*2 >= len(line) {
+		return 0
+	}
+	return open
+}
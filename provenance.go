@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha1"  // Package for the checksums Wikimedia publishes with its dumps
+	"encoding/hex" // Package for printing them
+	"hash"         // Package for the running checksum
+	"io"           // Package for I/O primitives
+	"sort"         // Package for finding the part of an offset
+)
+
+// inputSource is one file of the input registry: the dump, or one part of an
+// -input list, with what was read from it. It is also a -manifest record.
+type inputSource struct {
+	File    string `json:"file"`           // File or redacted URL, as in messages
+	SHA1    string `json:"sha1,omitempty"` // Of the bytes read, once read to the end; absent when the run stopped early
+	Pages   int    `json:"pages"`          // Pages decoded from it
+	Records int    `json:"records"`        // Docs or redirects written from it
+
+	start  int64     // Decompressed offset of its first byte in the stream scanPages reads
+	hash   hash.Hash // Checksum of the bytes read so far
+	opened bool      // Its first byte has been read
+}
+
+// inputRegistry lists the files of a -with-provenance run in reading order.
+// Every page points at its inputSource, so the docs share its name rather
+// than carry a copy each.
+type inputRegistry struct {
+	sources []*inputSource
+}
+
+// add registers a file and returns it, for checksum to wrap its bytes in
+func (reg *inputRegistry) add(file string) *inputSource {
+	src := &inputSource{File: file, hash: sha1.New()}
+	reg.sources = append(reg.sources, src)
+	return src
+}
+
+// checksum passes r through, adding what is read to the file's checksum and
+// recording it when r is read to the end
+func (src *inputSource) checksum(r io.Reader) io.Reader {
+	return checksumReader{r, src}
+}
+
+// checksumReader feeds an inputSource's checksum
+type checksumReader struct {
+	r   io.Reader
+	src *inputSource
+}
+
+func (c checksumReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.src.hash.Write(b[:n])
+	if err == io.EOF && c.src.SHA1 == "" {
+		c.src.SHA1 = hex.EncodeToString(c.src.hash.Sum(nil))
+	}
+	return n, err
+}
+
+// locate returns the file holding the decompressed stream offset off, and
+// the offset within that file's decompressed bytes
+func (reg *inputRegistry) locate(off int64) (*inputSource, int64) {
+	i := sort.Search(len(reg.sources), func(i int) bool {
+		return !reg.sources[i].opened || reg.sources[i].start > off
+	})
+	src := reg.sources[max(i-1, 0)]
+	return src, off - src.start
+}
+
+// partsReader reads the decompressed -input parts one after the other,
+// noting in the registry where each starts
+type partsReader struct {
+	parts []io.Reader
+	srcs  []*inputSource
+	n     int64 // Decompressed bytes read
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for len(p.parts) > 0 {
+		if !p.srcs[0].opened {
+			p.srcs[0].start, p.srcs[0].opened = p.n, true
+		}
+		n, err := p.parts[0].Read(b)
+		p.n += int64(n)
+		if err == io.EOF {
+			p.parts, p.srcs = p.parts[1:], p.srcs[1:]
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// closers closes every part file
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json" // Package for JSONL records
+	"encoding/xml"  // Package for XML records
+	"errors"        // Package for error inspection
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"math/rand/v2"  // Package for the picks
+	"net/http"      // Package for GET /random
+	"os"            // Package for OS functions (file access)
+	"slices"        // Package for the -exclude kinds
+	"strconv"       // Package for the query parameters
+	"strings"       // Package for string manipulation
+	"sync"          // Package for sharing the random source
+	"unicode/utf8"  // Package for abstract lengths
+)
+
+// randomFilter is what a doc must satisfy to be picked
+type randomFilter struct {
+	MinLength int      // Fewest characters in the abstract
+	Exclude   []string // Page kinds to leave out: list, disambiguation
+}
+
+// randomKinds are the kinds -exclude accepts; docs keep no templates, so
+// only the kinds pageKind tells from the title are known
+var randomKinds = []string{kindList, kindDisambiguation}
+
+// parseExclude turns a comma-separated -exclude into kinds
+func parseExclude(spec string) ([]string, error) {
+	var kinds []string
+	for _, k := range strings.Split(spec, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if !slices.Contains(randomKinds, k) {
+			return nil, fmt.Errorf("unknown kind %q to exclude; want %s", k, strings.Join(randomKinds, " or "))
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
+
+// keep tells whether doc may be picked
+func (f randomFilter) keep(doc *Doc) bool {
+	return utf8.RuneCountInString(doc.Abstract) >= f.MinLength && !slices.Contains(f.Exclude, pageKind(doc.Title, nil))
+}
+
+// randomSource picks docs from an output file: through its -emit-index
+// sidecar when there is one, or else by a full scan
+type randomSource struct {
+	path   string       // Output file
+	format string       // jsonl or xml
+	ir     *indexReader // The sidecar; nil scans the output for every pick
+	out    *os.File     // The output, for reading records through ir
+}
+
+// openRandomSource opens output and its index. A missing index at the
+// default path means scanning, after a warning; a missing -index is an error.
+func openRandomSource(output, index string) (*randomSource, error) {
+	format, _, _ := inferFormat(output, docFormatExts())
+	if format != "jsonl" && format != "xml" {
+		return nil, fmt.Errorf("%s is not an .xml or .jsonl output", output)
+	}
+	s := &randomSource{path: output, format: format}
+	explicit := index != ""
+	if !explicit {
+		index = indexPath(output)
+	}
+	if _, err := os.Stat(index); err != nil && !explicit && errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "warning: %s has no index (%s); every pick scans the whole output (write one with -emit-index)\n", output, index)
+		return s, nil
+	}
+	var err error
+	if s.ir, err = openIndex(index); err != nil {
+		return nil, err
+	}
+	if s.out, err = os.Open(output); err != nil {
+		s.ir.Close()
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	return s, nil
+}
+
+func (s *randomSource) Close() error {
+	if s.ir == nil {
+		return nil
+	}
+	s.ir.Close()
+	return s.out.Close()
+}
+
+// pick returns up to n docs passing f, each doc passing it equally likely,
+// and no doc twice. With an index it walks a random permutation of the
+// entries, reading one record at a time; without one it keeps a reservoir
+// sample over a scan of the output.
+func (s *randomSource) pick(n int, f randomFilter, rnd *rand.Rand) ([]Doc, error) {
+	if s.ir == nil {
+		return s.scan(n, f, rnd)
+	}
+	var picked []Doc
+	swapped := map[int]int{} // The permutation where it differs from the identity
+	at := func(i int) int {
+		if v, ok := swapped[i]; ok {
+			return v
+		}
+		return i
+	}
+	for i := 0; i < s.ir.count && len(picked) < n; i++ {
+		j := i + rnd.IntN(s.ir.count-i)
+		vi, vj := at(i), at(j)
+		swapped[i], swapped[j] = vj, vi
+		e, err := s.ir.entry(vj)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := s.ir.readRecord(s.out, e)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := decodeRecord(s.format, rec)
+		if err != nil {
+			return nil, fmt.Errorf("record at %d+%d does not decode; is the index that of %s? %w", e.block, e.offset, s.path, err)
+		}
+		if f.keep(&doc) {
+			picked = append(picked, doc)
+		}
+	}
+	return picked, nil
+}
+
+// scan keeps a reservoir of n docs passing f over all of the output
+func (s *randomSource) scan(n int, f randomFilter, rnd *rand.Rand) ([]Doc, error) {
+	var picked []Doc
+	seen := 0
+	err := eachOutputDoc(s.path, s.format, func(doc *Doc) {
+		if !f.keep(doc) {
+			return
+		}
+		if seen++; len(picked) < n {
+			picked = append(picked, *doc)
+		} else if j := rnd.IntN(seen); j < n {
+			picked[j] = *doc
+		}
+	})
+	rnd.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked, err
+}
+
+// eachOutputDoc calls fn with every doc of an output file in format
+func eachOutputDoc(path, format string, fn func(doc *Doc)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	r, _, err := decompressByName(f, path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if format == "jsonl" {
+		dec := json.NewDecoder(r)
+		for {
+			var doc Doc
+			if err := dec.Decode(&doc); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			fn(&doc)
+		}
+	}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "doc" {
+			var doc Doc
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			fn(&doc)
+		}
+	}
+}
+
+// maxRandomDocs bounds n of one GET /random
+const maxRandomDocs = 100
+
+// randomServer answers GET /random?n=5&min_length=200&exclude=list,disambiguation
+// with a JSON array of random docs
+type randomServer struct {
+	src *randomSource // Output to pick from
+	cfg *config       // Extract flags, for the JSON encoding
+
+	mu sync.Mutex // Guards cfg.Rand, which is not safe for concurrent use
+}
+
+func (rs *randomServer) serveRandom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	q := r.URL.Query()
+	n, f := 1, randomFilter{}
+	var err error
+	if s := q.Get("n"); s != "" {
+		if n, err = strconv.Atoi(s); err == nil && (n < 1 || n > maxRandomDocs) {
+			err = fmt.Errorf("n must be between 1 and %d", maxRandomDocs)
+		}
+	}
+	if s := q.Get("min_length"); s != "" && err == nil {
+		f.MinLength, err = strconv.Atoi(s)
+	}
+	if err == nil {
+		f.Exclude, err = parseExclude(q.Get("exclude"))
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rs.mu.Lock()
+	docs, err := rs.src.pick(n, f, rs.cfg.Rand)
+	rs.mu.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if docs == nil {
+		docs = []Doc{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	newJSONEncoder(w, rs.cfg).Encode(docs)
+}
+
+// randomConfig holds the settings of the random subcommand
+type randomConfig struct {
+	Output string       // Output file to pick from
+	Index  string       // Its index; "" means Output + ".idx", scanning when missing
+	N      int          // Docs to print
+	Filter randomFilter // What the docs must satisfy
+	Seed   uint64       // Seed of the picks; 0 picks differently every run
+}
+
+// randomCommand prints -n uniformly random docs of an output file, in its
+// own record format
+func randomCommand(args []string) error {
+	cfg := &randomConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki random", flag.ContinueOnError)
+	fs.StringVar(&cfg.Output, "o", "", "output `file` to pick from (.xml or .jsonl, optionally .gz)")
+	fs.StringVar(&cfg.Index, "index", "", "its -emit-index `file` (default: the -o file plus .idx; without one the output is scanned)")
+	fs.IntVar(&cfg.N, "n", 1, "docs to print")
+	fs.IntVar(&cfg.Filter.MinLength, "min-length", 0, "pick only docs whose abstract has at least `N` characters")
+	exclude := fs.String("exclude", "", "comma-separated `kinds` not to pick, judged from the title: list, disambiguation")
+	fs.Uint64Var(&cfg.Seed, "seed", 0, "seed the picks, to repeat them (0: different every run)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	var err error
+	if cfg.Filter.Exclude, err = parseExclude(*exclude); err == nil && (cfg.Output == "" || fs.NArg() > 0 || cfg.N < 1) {
+		err = errors.New("random needs -o and a positive -n, and takes no arguments")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	rnd := defaultOptions().Rand
+	if cfg.Seed != 0 {
+		rnd = rand.New(rand.NewPCG(cfg.Seed, 0))
+	}
+
+	src, err := openRandomSource(cfg.Output, cfg.Index)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	docs, err := src.pick(cfg.N, cfg.Filter, rnd)
+	if err != nil {
+		return err
+	}
+	var w docWriter = &jsonlWriter{enc: json.NewEncoder(os.Stdout)}
+	if src.format == "xml" {
+		w = &xmlWriter{w: os.Stdout}
+	}
+	for i := range docs {
+		if err := w.WriteDoc(&docs[i]); err != nil {
+			return err
+		}
+	}
+	if len(docs) < cfg.N {
+		fmt.Fprintf(os.Stderr, "only %d docs pass the filters\n", len(docs))
+	}
+	return nil
+}
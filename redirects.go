@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv" // Package for CSV encoding
+	"errors"       // Package for error inspection
+	"fmt"          // Package for formatted I/O
+	"io"           // Package for I/O primitives
+	"regexp"       // Package for regular expressions
+	"strings"      // Package for string manipulation
+)
+
+// redirectTextRe matches "#REDIRECT [[Target]]" for dumps without a <redirect> element
+var redirectTextRe = regexp.MustCompile(`(?i)^\s*#\s*redirect\s*:?\s*\[\[([^\]|]+)`)
+
+// redirectFormats are the -format values accepted with -redirects-only, with
+// the output extensions implying them
+var redirectFormats = map[string][]string{"jsonl": {".jsonl", ".ndjson"}, "csv": {".csv"}}
+
+// redirect is one edge of the redirect graph
+type redirect struct {
+	From string `json:"from"` // Redirect page title
+	To   string `json:"to"`   // Target title, without any #section fragment
+}
+
+// redirectTarget returns the title a redirect page points at, or "" for ordinary pages
+func redirectTarget(p *page) string {
+	if p.Redirect != nil && p.Redirect.Title != "" {
+		return p.Redirect.Title
+	}
+	m := redirectTextRe.FindStringSubmatch(p.Revision.Text)
+	if m == nil {
+		return ""
+	}
+	target, _, _ := strings.Cut(m[1], "#")
+	return strings.TrimSpace(strings.ReplaceAll(target, "_", " "))
+}
+
+// redirectOf returns the target of a redirect page, or "" for ordinary
+// pages, which are told apart before any of their text is examined
+func redirectOf(p *page) string {
+	if p.Redirect == nil && !strings.HasPrefix(strings.TrimSpace(p.Revision.Text), "#") {
+		return ""
+	}
+	return redirectTarget(p)
+}
+
+// extractRedirects writes the from -> to pairs of every redirect in r to out
+func extractRedirects(r io.Reader, cfg *config, out io.Writer) (*stats, error) {
+	st := &stats{}
+	inNS := namespaceFilter(cfg)
+	var write func(rd redirect) error
+	var flush func() error
+	switch cfg.Format {
+	case "csv":
+		cw := csv.NewWriter(out)
+		if err := cw.Write([]string{"from", "to"}); err != nil {
+			return st, err
+		}
+		write = func(rd redirect) error {
+			return cw.Write([]string{capField(rd.From, cfg.MaxFieldBytes), capField(rd.To, cfg.MaxFieldBytes)})
+		}
+		flush = func() error { cw.Flush(); return cw.Error() }
+	default:
+		enc := newJSONEncoder(out, cfg)
+		write = func(rd redirect) error { return enc.Encode(rd) }
+		flush = func() error { return nil }
+	}
+
+	err := scanPages(r, cfg, st, func(p *page) error {
+		to := ""
+		if inNS(p.NS) {
+			to = redirectOf(p)
+		}
+		if to == "" {
+			st.Filtered++
+			return nil
+		}
+		if err := write(redirect{From: p.Title, To: to}); err != nil {
+			return fmt.Errorf("failed to write redirect: %w", err)
+		}
+		st.Written++
+		if p.Source != nil {
+			p.Source.Records++
+		}
+		if cfg.outputFull(st) {
+			return errOutputCapped
+		}
+		return nil
+	})
+	if errors.Is(err, errOutputCapped) {
+		err = nil
+	}
+	if err != nil {
+		return st, err
+	}
+	return st, flush()
+}
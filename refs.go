@@ -0,0 +1,63 @@
+package main
+
+import (
+	"regexp"  // Package for regular expressions
+	"strings" // Package for string manipulation
+)
+
+// urlRe matches http(s)/ftp URLs, bare or bracketed, and protocol-relative
+// [//host/path] links; group 1 is the scheme (or "[") and group 2 the rest
+var urlRe = regexp.MustCompile(`(?i)(\[|\b(?:https?|ftp):)(//[^\s<>\[\]{}|"']+)`)
+
+// extractRefs returns the distinct external URLs cited in a lead section, in
+// order: the url= of {{cite ...}}/{{citation}} templates first, then
+// [url label] links and bare URLs outside templates
+func (c *cleaner) extractRefs(lead string) []string {
+	lead = commentRe.ReplaceAllString(lead, "")
+	lead = nowikiRe.ReplaceAllString(lead, "")
+
+	var refs []string
+	seen := map[string]bool{}
+	add := func(u string) {
+		if u = strings.TrimSpace(u); u != "" && !seen[u] {
+			seen[u] = true
+			refs = append(refs, u)
+		}
+	}
+
+	// 1. Citation templates name their source explicitly
+	for _, t := range c.templates(lead) {
+		name := strings.ToLower(t.Name)
+		if !strings.HasPrefix(name, "cite") && name != "citation" {
+			continue
+		}
+		if u, ok := t.named("url"); ok && urlRe.MatchString(u) {
+			add(u)
+		}
+	}
+
+	// 2. Links in running text; template parameters such as archive-url are not references
+	for _, m := range urlRe.FindAllStringSubmatch(stripTemplates(lead), -1) {
+		scheme := m[1]
+		if scheme == "[" {
+			scheme = "https:"
+		}
+		add(trimURLPunct(scheme + m[2]))
+	}
+	return refs
+}
+
+// trimURLPunct drops the sentence punctuation a bare URL picks up, keeping a
+// closing parenthesis that balances one inside the URL
+func trimURLPunct(u string) string {
+	for {
+		trimmed := strings.TrimRight(u, ".,;:!?")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == u {
+			return u
+		}
+		u = trimmed
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/list" // Package for the LRU order of cached blocks
+	"context"        // Package for request cancellation
+	"errors"         // Package for error handling
+	"fmt"            // Package for formatted I/O
+	"io"             // Package for I/O primitives
+	"net/http"       // Package for HTTP range requests
+	"strconv"        // Package for Content-Range parsing
+	"strings"        // Package for string manipulation
+	"sync"           // Package for guarding the cache
+)
+
+// errNoRanges reports a server that answers range requests with the whole file
+var errNoRanges = errors.New("server does not support range requests")
+
+// remoteReaderAt reads a remote file at arbitrary offsets through HTTP Range
+// requests, keeping the most recently used fixed-size blocks in memory. It is
+// safe for concurrent use: a block wanted by several readers is fetched once,
+// and adjacent missing blocks are fetched with a single request.
+type remoteReaderAt struct {
+	ctx       context.Context // The run's, ending fetches on -timeout or a signal
+	url       string          // File URL
+	creds     credentials     // Dump server credentials
+	size      int64           // File size, from the probe's Content-Range
+	block     int64           // Block size
+	maxBlocks int             // Cache budget in blocks
+	readahead int64           // Blocks a sequential section fetches at once
+
+	mu       sync.Mutex              // Guards the fields below
+	cache    map[int64]*list.Element // Cached blocks by index; values are *remoteBlock
+	lru      *list.List              // Cached blocks, most recently used first
+	inflight map[int64]*remoteFetch  // Blocks being fetched by some reader
+}
+
+// remoteBlock is one cached block
+type remoteBlock struct {
+	index int64  // Block number
+	data  []byte // Block contents; shorter than the block size at the end of the file
+}
+
+// remoteFetch is a block some reader is fetching; others wait on done
+type remoteFetch struct {
+	done chan struct{} // Closed once data or err is set
+	data []byte        // Block contents
+	err  error         // Why the fetch failed
+}
+
+// newRemoteReaderAt probes url with a one-byte range request, failing with
+// errNoRanges when the server ignores ranges
+func newRemoteReaderAt(ctx context.Context, url string, creds credentials, blockSize, cacheBytes int64) (*remoteReaderAt, error) {
+	r := &remoteReaderAt{
+		ctx:       ctx,
+		url:       url,
+		creds:     creds,
+		block:     blockSize,
+		maxBlocks: int(max(cacheBytes/blockSize, 1)),
+		cache:     map[int64]*list.Element{},
+		lru:       list.New(),
+		inflight:  map[int64]*remoteFetch{},
+	}
+	r.readahead = int64(min(max(r.maxBlocks/4, 1), 16))
+	resp, err := r.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if r.size, err = contentRangeSize(resp.Header.Get("Content-Range")); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// get requests bytes from through to, inclusive
+func (r *remoteReaderAt) get(from, to int64) (*http.Response, error) {
+	req, err := newRequest(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	resp, err := sendAuthed(req, r.creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download dump: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp, nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, errNoRanges
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+}
+
+// contentRangeSize returns the total size from a "bytes A-B/SIZE" header
+func contentRangeSize(header string) (int64, error) {
+	_, total, ok := strings.Cut(header, "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil || size < 0 {
+		return 0, fmt.Errorf("unexpected Content-Range %q", header)
+	}
+	return size, nil
+}
+
+// Size returns the size of the remote file
+func (r *remoteReaderAt) Size() int64 { return r.size }
+
+// ReadAt reads len(p) bytes at off, fetching whatever blocks are not cached
+func (r *remoteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), r.size)
+	blocks, err := r.load(off/r.block, (end-1)/r.block)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, data := range blocks {
+		if i == 0 {
+			data = data[off%r.block:]
+		}
+		n += copy(p[n:], data)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// load returns blocks first through last, fetching the missing ones
+func (r *remoteReaderAt) load(first, last int64) ([][]byte, error) {
+	// 1. Take the cached blocks, wait for those other readers are fetching
+	// and claim the rest
+	blocks := make([][]byte, last-first+1)
+	waits := map[int64]*remoteFetch{}
+	var claimed []int64
+	r.mu.Lock()
+	for b := first; b <= last; b++ {
+		if e, ok := r.cache[b]; ok {
+			r.lru.MoveToFront(e)
+			blocks[b-first] = e.Value.(*remoteBlock).data
+		} else if f, ok := r.inflight[b]; ok {
+			waits[b] = f
+		} else {
+			f := &remoteFetch{done: make(chan struct{})}
+			r.inflight[b], waits[b] = f, f
+			claimed = append(claimed, b)
+		}
+	}
+	r.mu.Unlock()
+
+	// 2. Fetch each run of adjacent claimed blocks with one request
+	for len(claimed) > 0 {
+		n := 1
+		for n < len(claimed) && claimed[n] == claimed[n-1]+1 {
+			n++
+		}
+		r.fetch(claimed[0], claimed[n-1], waits)
+		claimed = claimed[n:]
+	}
+
+	// 3. Collect what the fetches produced
+	for b, f := range waits {
+		<-f.done
+		if f.err != nil {
+			return nil, f.err
+		}
+		blocks[b-first] = f.data
+	}
+	return blocks, nil
+}
+
+// fetch downloads blocks first through last and publishes them to their
+// waiters and the cache; on failure every waiter gets the error
+func (r *remoteReaderAt) fetch(first, last int64, fetches map[int64]*remoteFetch) {
+	from, to := first*r.block, min((last+1)*r.block, r.size)-1
+	data, err := func() ([]byte, error) {
+		resp, err := r.get(from, to)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data := make([]byte, to-from+1)
+		if _, err := io.ReadFull(resp.Body, data); err != nil {
+			return nil, fmt.Errorf("failed to download dump: %w", err)
+		}
+		return data, nil
+	}()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for b := first; b <= last; b++ {
+		f := fetches[b]
+		delete(r.inflight, b)
+		if err != nil {
+			f.err = err
+			close(f.done)
+			continue
+		}
+		f.data = data[(b-first)*r.block : min((b-first+1)*r.block, int64(len(data)))]
+		close(f.done)
+		r.cache[b] = r.lru.PushFront(&remoteBlock{b, f.data})
+		for r.lru.Len() > r.maxBlocks {
+			oldest := r.lru.Remove(r.lru.Back()).(*remoteBlock)
+			delete(r.cache, oldest.index)
+		}
+	}
+}
+
+// section returns a sequential reader of bytes [off, end). Reaching a block
+// that is not cached fetches the blocks after it too, past end if need be,
+// since the streams an index selects tend to follow each other closely.
+func (r *remoteReaderAt) section(off, end int64) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		if off >= end {
+			return 0, io.EOF
+		}
+		b, last := off/r.block, off/r.block
+		r.mu.Lock()
+		if _, ok := r.cache[b]; !ok {
+			last = min(b+r.readahead, (r.size+r.block-1)/r.block) - 1
+		}
+		r.mu.Unlock()
+		blocks, err := r.load(b, last)
+		if err != nil {
+			return 0, err
+		}
+		data := blocks[0][off%r.block:]
+		n := copy(p, data[:min(int64(len(data)), end-off)])
+		off += int64(n)
+		return n, nil
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"regexp"  // Package for $N references in rule patterns
+	"sort"    // Package for listing rule names
+	"strconv" // Package for parameter numbers
+	"strings" // Package for string manipulation
+)
+
+// templateRenderer turns one template invocation into the plain text it
+// shows on the page; ok is false when the invocation is not understood and
+// should be stripped like any other template
+type templateRenderer func(t template) (text string, ok bool)
+
+// builtinRenderers are the rules -render-template can name, keyed by
+// normalized template name (see templateName)
+var builtinRenderers = map[string]templateRenderer{
+	"Abbr":    positionalRenderer(0), // {{abbr|km|kilometre}} → km
+	"Convert": renderConvert,         // {{convert|5|km}} → 5 km
+	"Cvt":     renderConvert,         // Short alias of Convert
+	"Lang":    positionalRenderer(1), // {{lang|fr|Paris}} → Paris
+	"Nobr":    positionalRenderer(0), // Same as Nowrap
+	"Nowrap":  positionalRenderer(0), // {{nowrap|5 km}} → 5 km
+	"Small":   positionalRenderer(0), // {{small|text}} → text
+}
+
+// positionalRenderer renders a template as its n-th unnamed parameter
+func positionalRenderer(n int) templateRenderer {
+	return func(t template) (string, bool) {
+		args := t.positional()
+		if n >= len(args) {
+			return "", false
+		}
+		return args[n], true
+	}
+}
+
+// convertRanges are the {{convert}} words joining two values, as displayed
+var convertRanges = map[string]string{"to": " to ", "and": " and ", "or": " or ", "-": "–", "–": "–", "x": " × ", "by": " × ", "+/-": " ± "}
+
+// convertUnits are unit codes that do not display as they are written
+var convertUnits = map[string]string{
+	"km2": "km²", "m2": "m²", "cm2": "cm²", "sqmi": "sq mi", "sqft": "sq ft",
+	"m3": "m³", "km3": "km³", "cuft": "cu ft", "C": "°C", "F": "°F", "kph": "km/h", "ft2": "sq ft",
+}
+
+// renderConvert renders {{convert|VALUE|UNIT|...}} as the value in its
+// original unit, without the conversion: "5 km", "5 to 10 km", "105 km²".
+// A range such as {{convert|5|-|10|km}} keeps its second value.
+func renderConvert(t template) (string, bool) {
+	args := t.positional()
+	if len(args) < 2 || args[0] == "" {
+		return "", false
+	}
+	text, i := args[0], 1
+	for i+1 < len(args) {
+		join, ok := convertRanges[args[i]]
+		if !ok {
+			break
+		}
+		text += join + args[i+1]
+		i += 2
+	}
+	if i >= len(args) || args[i] == "" {
+		return "", false
+	}
+	unit := args[i]
+	if shown, ok := convertUnits[unit]; ok {
+		unit = shown
+	}
+	return text + " " + unit, true
+}
+
+// ruleParamRe matches the $1, $2, ... references of a -render-template pattern
+var ruleParamRe = regexp.MustCompile(`\$([1-9])`)
+
+// patternRenderer renders a template through a pattern whose $N stand for
+// its unnamed parameters, e.g. "$2 ($1)"; a missing parameter renders empty
+func patternRenderer(pattern string) templateRenderer {
+	return func(t template) (string, bool) {
+		args := t.positional()
+		return ruleParamRe.ReplaceAllStringFunc(pattern, func(ref string) string {
+			n, _ := strconv.Atoi(ref[1:])
+			if n > len(args) {
+				return ""
+			}
+			return args[n-1]
+		}), true
+	}
+}
+
+// parseRenderRules builds the -render-template allowlist. Each rule is a
+// built-in template name, "all" for every built-in rule, or NAME=PATTERN
+// to render any other template through a pattern.
+func parseRenderRules(rules []string) (map[string]templateRenderer, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	out := map[string]templateRenderer{}
+	for _, rule := range rules {
+		if name, pattern, ok := strings.Cut(rule, "="); ok {
+			out[templateName(name)] = patternRenderer(pattern)
+			continue
+		}
+		if rule == "all" {
+			for name, r := range builtinRenderers {
+				out[name] = r
+			}
+			continue
+		}
+		r, ok := builtinRenderers[templateName(rule)]
+		if !ok {
+			names := make([]string, 0, len(builtinRenderers))
+			for name := range builtinRenderers {
+				names = append(names, strings.ToLower(name))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("no built-in rule renders {{%s}} (have %s; use NAME=PATTERN for others)", rule, strings.Join(names, ", "))
+		}
+		out[templateName(rule)] = r
+	}
+	return out, nil
+}
+
+// renderTemplates is stripTemplates with the -render-template allowlist
+// applied: templates are resolved innermost first, so an allowed template
+// sees its nested templates already rendered or removed, and whatever is not
+// allowed is dropped as before.
+func (c *cleaner) renderTemplates(s string) string {
+	// stack[0] collects the output; each open template collects its body above it
+	stack := []*strings.Builder{{}}
+	for i := 0; i < len(s); {
+		if c.tick() {
+			return ""
+		}
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			stack = append(stack, &strings.Builder{})
+			i += 2
+		case len(stack) > 1 && strings.HasPrefix(s[i:], "}}"):
+			body := stack[len(stack)-1].String()
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].WriteString(c.renderTemplate(body))
+			i += 2
+		default:
+			stack[len(stack)-1].WriteByte(s[i])
+			i++
+		}
+	}
+	return stack[0].String() // Templates left open run to the end and are dropped
+}
+
+// renderTemplate returns the text of one template body, "" when it is not allowed
+func (c *cleaner) renderTemplate(body string) string {
+	parts := splitTopLevel(body, '|')
+	t := template{Name: templateName(parts[0]), Params: parts[1:]}
+	r, ok := c.render[t.Name]
+	if !ok {
+		return ""
+	}
+	text, ok := r(t)
+	if !ok {
+		return ""
+	}
+	return text
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"   // Package for reading the map line by line
+	"fmt"     // Package for formatted I/O
+	"os"      // Package for OS functions (file access)
+	"strconv" // Package for parameter numbers
+	"strings" // Package for string manipulation
+)
+
+// renderPart is one piece of a -templates-as-text format: literal text, or
+// a reference to a positional (pos > 0) or named parameter
+type renderPart struct {
+	text string // Literal text, when pos is 0 and name is empty
+	pos  int    // 1-based unnamed parameter
+	name string // Named parameter
+}
+
+// parseRenderFormat checks and splits a format such as "{1} {2}" or
+// "{2} ({title})". {N} is the N-th unnamed parameter, {key} the parameter
+// called key; {{ and }} stand for literal braces.
+func parseRenderFormat(format string) ([]renderPart, error) {
+	var parts []renderPart
+	var lit strings.Builder
+	for i := 0; i < len(format); i++ {
+		switch {
+		case strings.HasPrefix(format[i:], "{{"), strings.HasPrefix(format[i:], "}}"):
+			lit.WriteByte(format[i])
+			i++
+		case format[i] == '}':
+			return nil, fmt.Errorf("unmatched } (write }} for a brace)")
+		case format[i] == '{':
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { (write {{ for a brace)")
+			}
+			ref := strings.TrimSpace(format[i+1 : i+end])
+			part := renderPart{}
+			if n, err := strconv.Atoi(ref); err == nil {
+				if n < 1 {
+					return nil, fmt.Errorf("{%s}: parameters are numbered from 1", ref)
+				}
+				part.pos = n
+			} else if ref == "" || strings.ContainsAny(ref, "{|=") {
+				return nil, fmt.Errorf("{%s} is neither a parameter number nor a name", ref)
+			} else {
+				part.name = ref
+			}
+			if lit.Len() > 0 {
+				parts = append(parts, renderPart{text: lit.String()})
+				lit.Reset()
+			}
+			parts = append(parts, part)
+			i += end
+		default:
+			lit.WriteByte(format[i])
+		}
+	}
+	if lit.Len() > 0 {
+		parts = append(parts, renderPart{text: lit.String()})
+	}
+	return parts, nil
+}
+
+// formatRenderer renders a template through parsed format parts; a
+// parameter the invocation lacks renders empty
+func formatRenderer(parts []renderPart) templateRenderer {
+	return func(t template) (string, bool) {
+		args := t.positional()
+		var b strings.Builder
+		for _, p := range parts {
+			switch {
+			case p.pos > 0:
+				if p.pos <= len(args) {
+					b.WriteString(args[p.pos-1])
+				}
+			case p.name != "":
+				v, _ := t.named(p.name)
+				b.WriteString(v)
+			default:
+				b.WriteString(p.text)
+			}
+		}
+		return strings.TrimSpace(b.String()), true
+	}
+}
+
+// loadRenderMap reads a -templates-as-text file of "name = format" lines,
+// e.g. "convert = {1} {2}". Blank lines and lines starting with # are
+// skipped; a name given twice or a format that does not parse fails the
+// whole file with its line number. lines are the entries as written, for
+// the -cache key.
+func loadRenderMap(path string) (renderers map[string]templateRenderer, lines []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-templates-as-text: %w", err)
+	}
+	defer f.Close()
+	renderers = map[string]templateRenderer{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		raw, format, ok := strings.Cut(line, "=")
+		name := templateName(raw)
+		if !ok || name == "" {
+			return nil, nil, fmt.Errorf("-templates-as-text %s:%d: want \"name = format\", got %q", path, n, line)
+		}
+		if _, dup := renderers[name]; dup {
+			return nil, nil, fmt.Errorf("-templates-as-text %s:%d: {{%s}} is mapped twice", path, n, name)
+		}
+		format = strings.TrimSpace(format)
+		parts, err := parseRenderFormat(format)
+		if err != nil {
+			return nil, nil, fmt.Errorf("-templates-as-text %s:%d: %q: %w", path, n, format, err)
+		}
+		renderers[name] = formatRenderer(parts)
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, fmt.Errorf("-templates-as-text: %w", err)
+	}
+	return renderers, lines, nil
+}
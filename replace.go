@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"   // Package for reading the rules line by line
+	"fmt"     // Package for formatted I/O
+	"os"      // Package for OS functions (file access)
+	"regexp"  // Package for the rule patterns
+	"strings" // Package for string manipulation
+)
+
+// replaceRule is one -replace-file line: matches of re become repl, in which
+// $1 or ${name} expand to the groups of the match
+type replaceRule struct {
+	re   *regexp.Regexp // Pattern
+	repl string         // Replacement
+	line string         // The line as written, for the -cache key
+}
+
+// loadReplaceRules reads a -replace-file of "pattern<TAB>replacement" lines.
+// A line without a tab deletes what it matches; blank lines and lines
+// starting with # are skipped. A pattern that does not compile fails the
+// whole file with its line number.
+func loadReplaceRules(path string) ([]replaceRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("-replace-file: %w", err)
+	}
+	defer f.Close()
+	var rules []replaceRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, repl, _ := strings.Cut(line, "\t")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("-replace-file %s:%d: %w", path, n, err)
+		}
+		rules = append(rules, replaceRule{re: re, repl: repl, line: line})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("-replace-file: %w", err)
+	}
+	return rules, nil
+}
+
+// applyReplaceRules runs the rules over an abstract in order, each on the
+// result of the one before, and trims the space they leave at the ends
+func applyReplaceRules(rules []replaceRule, abstract string) string {
+	for _, r := range rules {
+		abstract = r.re.ReplaceAllString(abstract, r.repl)
+	}
+	return strings.TrimSpace(abstract)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"           // Package for formatted I/O
+	"path/filepath" // Package for the dump file name
+	"strings"       // Package for string manipulation
+	"time"          // Package for revision timestamps
+)
+
+// revisionAges tracks how old the latest revision of each written doc is,
+// both against the dump date and against the time of the extraction, for
+// freshness targets such as "p99 of pages under 30 days behind the dump"
+type revisionAges struct {
+	DumpDate time.Time // Day the dump was taken; zero when the input name does not say
+	Started  time.Time // Start of the extraction
+
+	vsDump     histogram // Ages against DumpDate, in seconds
+	vsRun      histogram // Ages against Started, in seconds
+	futureDump int       // Revisions dated after DumpDate
+	futureRun  int       // Revisions dated after Started
+	missing    int       // Revisions without a readable <timestamp>
+}
+
+// newRevisionAges starts tracking for the run of cfg
+func newRevisionAges(cfg *config) *revisionAges {
+	a := &revisionAges{Started: cfg.NowFunc()}
+	a.DumpDate, _ = dumpDate(cfg)
+	return a
+}
+
+// dumpDate is -dump-date, else the date in the dump's name, as in
+// enwiki-20240601-pages-articles.xml.bz2 or .../enwiki/20240601/...
+func dumpDate(cfg *config) (time.Time, bool) {
+	if cfg.DumpDate != "" {
+		t, err := time.Parse("2006-01-02", cfg.DumpDate)
+		return t, err == nil
+	}
+	name := inputName(cfg)
+	if parts := strings.SplitN(filepath.Base(name), "-", 3); len(parts) == 3 {
+		if t, err := time.Parse("20060102", parts[1]); err == nil {
+			return t, true
+		}
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if len(seg) != 8 {
+			continue
+		}
+		if t, err := time.Parse("20060102", seg); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// add records the revision of p and returns its age in days against the
+// dump date, else against the run start; ok is false when the revision has
+// no timestamp or is dated after that reference
+func (a *revisionAges) add(p *page) (days float64, ok bool) {
+	ts, err := time.Parse(time.RFC3339, p.Revision.Timestamp)
+	if err != nil {
+		a.missing++
+		return 0, false
+	}
+	age := a.Started.Sub(ts)
+	if age < 0 {
+		a.futureRun++
+	} else {
+		a.vsRun.add(age.Seconds())
+	}
+	if !a.DumpDate.IsZero() {
+		// The dump date is the day the dump began, so the whole day counts as before it
+		if age = a.DumpDate.AddDate(0, 0, 1).Sub(ts); age < 0 {
+			a.futureDump++
+		} else {
+			a.vsDump.add(age.Seconds())
+		}
+	}
+	if age < 0 {
+		return 0, false
+	}
+	return age.Hours() / 24, true
+}
+
+// ageSummary is one reference point of the -stats-file revision_age object
+type ageSummary struct {
+	Count   int64   `json:"count"`    // Revisions measured
+	P50Days float64 `json:"p50_days"` // Median age
+	P90Days float64 `json:"p90_days"` // 90th percentile
+	P99Days float64 `json:"p99_days"` // 99th percentile
+	MaxDays float64 `json:"max_days"` // Oldest
+	Future  int     `json:"future"`   // Revisions dated after the reference, not measured
+}
+
+// revisionAgeStats is the -stats-file record of -revision-age
+type revisionAgeStats struct {
+	DumpDate string      `json:"dump_date,omitempty"` // As YYYY-MM-DD; absent when unknown
+	VsDump   *ageSummary `json:"vs_dump,omitempty"`   // Ages against the dump date
+	VsRun    ageSummary  `json:"vs_run"`              // Ages against the extraction start
+	Missing  int         `json:"missing"`             // Revisions without a timestamp
+}
+
+// summarize turns a histogram of seconds into days
+func summarize(h *histogram, future int) ageSummary {
+	day := (24 * time.Hour).Seconds()
+	s := ageSummary{Count: h.count(), Future: future}
+	if h.count() > 0 {
+		s.P50Days, s.P90Days, s.P99Days = h.quantile(0.5)/day, h.quantile(0.9)/day, h.quantile(0.99)/day
+		s.MaxDays = *h.max / day
+	}
+	return s
+}
+
+// record is what -stats-file shows of the ages
+func (a *revisionAges) record() *revisionAgeStats {
+	rs := &revisionAgeStats{VsRun: summarize(&a.vsRun, a.futureRun), Missing: a.missing}
+	if !a.DumpDate.IsZero() {
+		rs.DumpDate = a.DumpDate.Format("2006-01-02")
+		vs := summarize(&a.vsDump, a.futureDump)
+		rs.VsDump = &vs
+	}
+	return rs
+}
+
+// print writes the summary lines of the ages
+func (a *revisionAges) print() {
+	line := func(what string, s ageSummary) {
+		fmt.Printf("Revision age vs %s: p50 %.1fd, p90 %.1fd, p99 %.1fd, max %.1fd over %d revisions (%d dated later).\n",
+			what, s.P50Days, s.P90Days, s.P99Days, s.MaxDays, s.Count, s.Future)
+	}
+	rs := a.record()
+	if rs.VsDump != nil {
+		line("dump "+rs.DumpDate, *rs.VsDump)
+	}
+	line("extraction", rs.VsRun)
+	if rs.Missing > 0 {
+		fmt.Printf("Revision age: %d revisions had no timestamp.\n", rs.Missing)
+	}
+}
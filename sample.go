@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math/rand/v2" // Package for the sampler's random source
+	"sort"         // Package for restoring stream order
+)
+
+// reservoirWriter keeps a uniform random sample of -sample-k docs out of all
+// the docs written to it, holding at most k in memory (Algorithm R), and
+// hands the sample to the real writer in stream order when closed
+type reservoirWriter struct {
+	next   docWriter    // Writer the sample goes to
+	k      int          // Sample size
+	rand   *rand.Rand   // Source of the replacement decisions (cfg.Rand)
+	seen   int          // Docs offered so far
+	sample []sampledDoc // Current sample
+}
+
+// sampledDoc is a doc in the sample with its position in the stream
+type sampledDoc struct {
+	seq int // Index among the docs offered
+	doc Doc // Copy of the doc
+}
+
+func newReservoirWriter(next docWriter, cfg *config) *reservoirWriter {
+	return &reservoirWriter{next: next, k: cfg.SampleK, rand: cfg.Rand, sample: make([]sampledDoc, 0, min(cfg.SampleK, 1<<16))}
+}
+
+// WriteDoc offers doc to the sample: the n-th doc replaces a random member with probability k/n
+func (r *reservoirWriter) WriteDoc(doc *Doc) error {
+	r.seen++
+	if len(r.sample) < r.k {
+		r.sample = append(r.sample, sampledDoc{r.seen, *doc})
+		return nil
+	}
+	if j := r.rand.IntN(r.seen); j < r.k {
+		r.sample[j] = sampledDoc{r.seen, *doc}
+	}
+	return nil
+}
+
+// WriteSiteInfo passes the siteinfo record straight through, ahead of the sample
+func (r *reservoirWriter) WriteSiteInfo(s *SiteInfo) error {
+	if sw, ok := r.next.(siteInfoWriter); ok {
+		return sw.WriteSiteInfo(s)
+	}
+	return nil
+}
+
+// Close writes the sample in the order its docs appeared, then closes the real writer
+func (r *reservoirWriter) Close() error {
+	sort.Slice(r.sample, func(a, b int) bool { return r.sample[a].seq < r.sample[b].seq })
+	for i := range r.sample {
+		if err := r.next.WriteDoc(&r.sample[i].doc); err != nil {
+			return err
+		}
+	}
+	return r.next.Close()
+}
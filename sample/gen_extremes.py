@@ -0,0 +1,37 @@
+# Generates sample/extremes.xml.bz2, a tiny dump of pages at the extremes
+# the output guards are for: titles above MediaWiki's 255-byte limit (ASCII
+# and multi-byte), a redirect between such titles, and a lead that is one
+# 12,000-character line, as a table flattened by cleanup becomes. Run it
+# from the repository root:
+#
+#   python3 sample/gen_extremes.py sample/extremes.xml.bz2
+import bz2, sys
+from xml.sax.saxutils import escape
+
+long_ascii = "The " + "very " * 60 + "long title"
+long_cyrillic = "Очень " * 50 + "длинное название"
+flattened = " ".join("Row %d: value %d, total %d;" % (i, i * 7, i * 13) for i in range(500))
+
+pages = [
+    (1, "Short page", None, "'''Short page''' is a page with a short title and a short abstract."),
+    (2, long_ascii, None, "'''%s''' is a page whose title is too long for MediaWiki." % long_ascii),
+    (3, "Flattened table", None, "'''Flattened table''' lists " + flattened),
+    (4, long_cyrillic, "Flattened table", "#REDIRECT [[Flattened table]]"),
+    (5, "Short redirect", long_ascii, "#REDIRECT [[%s]]" % long_ascii),
+]
+
+out = ['<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11" xml:lang="en">\n',
+       '  <siteinfo>\n    <sitename>Wikipedia</sitename>\n    <dbname>simplewiki</dbname>\n'
+       '    <base>https://simple.wikipedia.org/wiki/Main_Page</base>\n    <case>first-letter</case>\n'
+       '    <namespaces>\n      <namespace key="0" case="first-letter" />\n    </namespaces>\n  </siteinfo>\n']
+for pid, title, redirect, text in pages:
+    out.append('  <page>\n    <title>%s</title>\n    <ns>0</ns>\n    <id>%d</id>\n' % (escape(title), pid))
+    if redirect:
+        out.append('    <redirect title="%s" />\n' % escape(redirect, {'"': "&quot;"}))
+    out.append('    <revision>\n      <id>%d</id>\n      <timestamp>2024-06-01T00:00:00Z</timestamp>\n' % (1000 + pid))
+    out.append('      <model>wikitext</model>\n      <format>text/x-wiki</format>\n')
+    out.append('      <text bytes="%d" xml:space="preserve">%s</text>\n    </revision>\n  </page>\n'
+               % (len(text.encode()), escape(text)))
+out.append('</mediawiki>\n')
+with open(sys.argv[1], "wb") as f:
+    f.write(bz2.compress("".join(out).encode(), 9))
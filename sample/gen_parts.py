@@ -0,0 +1,20 @@
+# Splits the sample dump into two part files the way Wikimedia splits large
+# dumps (pages-articles1.xml-p1p41242.bz2, ...): each part a whole document
+# with its own <mediawiki> root and <siteinfo>, the pages divided between
+# them in order. The first part is bzip2-compressed and the second plain XML,
+# so a run over both reads each kind. Run it from the repository root:
+#
+#   python3 sample/gen_parts.py sample/simplewiki-sample.xml.bz2 sample/parts
+import bz2, os, sys
+
+data = bz2.open(sys.argv[1]).read().decode()
+head, body = data.split("  <page>", 1)
+body = body.rsplit("</mediawiki>", 1)[0]
+pages = ["  <page>" + p for p in body.split("  <page>")]
+half = len(pages) // 2
+os.makedirs(sys.argv[2], exist_ok=True)
+parts = [("simplewiki-sample-part1.xml.bz2", pages[:half]), ("simplewiki-sample-part2.xml", pages[half:])]
+for name, chunk in parts:
+    doc = (head + "".join(chunk) + "</mediawiki>\n").encode()
+    with open(os.path.join(sys.argv[2], name), "wb") as f:
+        f.write(bz2.compress(doc, 9) if name.endswith(".bz2") else doc)
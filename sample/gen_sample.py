@@ -0,0 +1,346 @@
+# Generates a synthetic simplewiki-style pages-articles dump used as the
+# embedded demo sample. All article text is original filler written for the
+# fixture; it is not copied from Wikipedia.
+import bz2, random, sys
+from xml.sax.saxutils import escape
+
+random.seed(20240601)
+pages = []
+
+def add(title, text, ns=0, redirect=None):
+    pages.append((title, ns, text, redirect))
+
+# --- handcrafted articles exercising the markup the cleaner must cope with ---
+add("Apple", """{{Short description|Fruit of the apple tree}}
+{{Infobox plant
+| name = Apple
+| image = Malus domestica fruit.jpg
+| genus = Malus
+}}
+An '''apple''' is a round, edible [[fruit]] produced by an [[Malus domestica|apple tree]].<ref>{{cite web|url=https://example.org/apples|title=Apples}}</ref> Apple trees are grown worldwide and are the most widely grown species in the genus ''[[Malus]]''.<ref name="fao"/>
+
+The tree first grew in [[Central Asia]], where its wild ancestor, ''Malus sieversii'', is still found today.
+
+== History ==
+Apples have been grown for thousands of years in [[Asia]] and [[Europe]].
+
+== References ==
+{{reflist}}
+
+[[Category:Fruits]]
+[[Category:Trees]]""")
+
+add("Paris", """{{Infobox settlement
+| name = Paris
+| country = [[France]]
+| coordinates = {{coord|48|51|24|N|2|21|08|E|display=inline,title}}
+| population = 2,102,650
+}}
+'''Paris''' ({{IPA-fr|paʁi|pron}}) is the [[capital city]] of [[France]]. It has an area of {{convert|105|km2|sqmi}} and a population of about 2.1 million people.<ref>{{cite web |url=https://example.org/paris-census |title=Census}}</ref>
+
+Paris is on the [[Seine]] river, in the north of the country. It is one of the most visited cities in the world.
+
+== Geography ==
+The city is divided into 20 areas called ''arrondissements''.
+
+[[Category:Capitals in Europe]]
+[[Category:Cities in France]]""")
+
+add("Albert Einstein", """{{Short description|German-born physicist (1879–1955)}}
+{{Infobox scientist
+| name = Albert Einstein
+| birth_date = {{birth date|1879|3|14}}
+| birth_place = [[Ulm]], [[Germany]]
+| death_date = {{death date and age|1955|4|18|1879|3|14}}
+| death_place = [[Princeton, New Jersey]]
+}}
+'''Albert Einstein''' ({{IPAc-en|ˈ|aɪ|n|s|t|aɪ|n}}; 14 March 1879 – 18 April 1955) was a German-born [[physicist]]. He developed the [[theory of relativity]].<ref name="nobel">{{cite web|url=https://example.org/nobel/einstein|title=Nobel Prize}}</ref> He is also known for his formula [[Mass–energy equivalence|''E'' = ''mc''<sup>2</sup>]].
+
+In 1921 he won the [[Nobel Prize in Physics]] for his work on the [[photoelectric effect]].<ref name="nobel" />
+
+== Life ==
+Einstein was born in Ulm.
+
+[[Category:1879 births]]
+[[Category:1955 deaths]]
+[[Category:Physicists]]""")
+
+add("Marie Curie", """{{Infobox person
+| name = Marie Curie
+| birth_date = {{Birth date|df=yes|1867|11|7}}
+| death_date = {{Death date and age|df=yes|1934|7|4|1867|11|7}}
+}}
+'''Marie Salomea Skłodowska–Curie''' ({{IPA-pl|ˈmarja skwɔˈdɔfska kʲiˈri|}}), also known as '''Madame Curie''', was a [[Poland|Polish]] and naturalized-[[France|French]] [[physicist]] and [[chemist]].<ref>Smith, ''Curie'', 2001, p. 4.</ref> She was the first woman to win a [[Nobel Prize]].
+
+She discovered the elements [[polonium]] and [[radium]].
+
+[[Category:1867 births]]
+[[Category:1934 deaths]]
+[[Category:Chemists]]""")
+
+add("Mercury", """'''Mercury''' may mean:
+
+* [[Mercury (planet)]], the planet closest to the Sun
+* [[Mercury (element)]], a chemical element
+* [[Mercury (mythology)]], a Roman god
+
+{{disambiguation}}""")
+
+add("Mercury (planet)", """{{Infobox planet
+| name = Mercury
+| mean_radius = {{convert|2439.7|km|mi|abbr=on}}
+}}
+'''Mercury''' is the smallest [[planet]] in the [[Solar System]] and the closest to the [[Sun]]. It goes around the Sun once every 88 days.
+
+Mercury has no [[moon]]s.
+
+[[Category:Planets]]""")
+
+add("List of rivers of Europe", """This is a '''list of rivers of [[Europe]]'''.
+
+{| class="wikitable"
+! River !! Length
+|-
+| [[Volga]] || {{convert|3530|km|mi}}
+|-
+| [[Danube]] || {{convert|2850|km|mi}}
+|}
+
+* [[Volga]]
+* [[Danube]]
+* [[Rhine]]
+* [[Elbe]]
+
+[[Category:Lists of rivers]]""")
+
+add("Tokyo", """{{Infobox settlement
+| name = Tokyo
+| native_name = {{nowrap|東京都}}
+| coordinates = {{Coord|35|41|22|N|139|41|30|E|type:city}}
+}}
+'''Tokyo''' ({{lang|ja|東京}}, {{IPA-ja|toːkʲoː|}}) is the [[capital city]] of [[Japan]]. About 14 million people live there.<ref>[https://example.org/tokyo-population Tokyo population figures]</ref> The greater Tokyo area is the largest [[metropolitan area]] in the world. More information is at https://example.org/tokyo-guide.
+
+[[Category:Capitals in Asia]]""")
+
+add("Water", """[[File:Drops of water.jpg|thumb|Drops of [[water]] falling. See [[Liquid|liquids]].]]
+'''Water''' is a [[chemical compound]] made of [[hydrogen]] and [[oxygen]] (H<sub>2</sub>O). It is a [[liquid]] at [[room temperature]].
+
+Water covers about 71% of the [[Earth]]'s surface.<ref group="note">Most of it is in the [[ocean]]s.</ref>
+
+[[Category:Chemical compounds]]""")
+
+add("Cat", """{{Taxobox
+| name = Cat
+| image = Cat poster 1.jpg
+| status = DOM
+}}
+The '''cat''' (''Felis catus''), also called the '''domestic cat''' or '''house cat''', is a small [[mammal]]. It is often kept as a [[pet]].<ref>{{Cite book|title=Cats|year=2010}}</ref>
+
+Cats are good at hunting [[mouse|mice]] and other small animals.
+
+[[Category:Cats]]
+[[Category:Pets]]""")
+
+add("Zebra", """{{stub}}
+A '''zebra''' is an [[African]] [[horse]]-like animal with black and white stripes.
+
+[[Category:Mammals]]""")
+
+add("Moon", """{{Use dmy dates}}
+<!-- This is a hidden comment that should not appear in abstracts -->
+The '''Moon''' is the [[Earth]]'s only natural [[satellite]]. It is about {{convert|384400|km|mi}} from Earth.<ref>{{cite web|url=https://example.org/moon-distance|title=Distance}}</ref><ref>{{cite web|url=https://example.org/moon-distance|title=Distance (duplicate)}}</ref>
+
+The Moon takes about 27 days to go around the Earth.
+
+[[Category:Moon]]""")
+
+add("Python (programming language)", """{{Infobox programming language
+| name = Python
+| designer = [[Guido van Rossum]]
+}}
+'''Python''' is a [[programming language]]. It is used to write [[computer program]]s. The code <code>print("Hello")</code> shows text on the screen. Python was made by [[Guido van Rossum]] and first released in 1991.
+
+== Example ==
+<syntaxhighlight lang="python">
+print("Hello, world!")
+</syntaxhighlight>
+
+[[Category:Programming languages]]""")
+
+add("Nowiki example", """'''Nowiki example''' is a page about markup. Writing <nowiki>{{Copyvio}}</nowiki> shows the text without using a template, and the word Taxobox in prose is just a word.
+
+[[Category:Help]]""")
+
+add("Mount Everest", """{{Infobox mountain
+| name = Mount Everest
+| elevation_m = 8848
+| coordinates = {{coord|27.9881|N|86.9250|E}}
+}}
+'''Mount Everest''' (also called '''Sagarmatha''' or '''Chomolungma''') is the highest [[mountain]] on [[Earth]]. It is {{convert|8848|m|ft}} tall and is in the [[Himalayas]], on the border between [[Nepal]] and [[China]].
+
+[[Category:Mountains of Asia]]""")
+
+add("Amazon River", """'''Amazon River''' is a river in [[South America]]. It is about {{convert|6400|km|mi}} long.&nbsp;It carries more water than any other river. {{coord|-3.1|-60.0|display=title}}
+
+[[Category:Rivers of Brazil]]""")
+
+add("Leonardo da Vinci", """{{Infobox artist
+| name = Leonardo da Vinci
+| birth_date = 15 April 1452
+| death_date = 2 May 1519
+}}
+'''Leonardo di ser Piero da Vinci''' ({{IPA-it|leoˈnardo da (v)ˈvintʃi|}}; 15 April 1452 – 2 May 1519) was an [[Italy|Italian]] [[painter]], [[engineer]] and [[scientist]]. He painted the ''[[Mona Lisa]]''.
+
+[[Category:1452 births]]
+[[Category:1519 deaths]]""")
+
+add("Empty page", """{{Infobox thing
+| name = Nothing
+}}
+[[Category:Empty]]""")
+
+add("Ampersand in text", """'''Ampersand in text''' tests characters like &amp; and &lt;b&gt; inside content, along with "quotes" and 'apostrophes'.
+
+[[Category:Test pages]]""")
+
+add("Wikipedia:About", """This page is about the project. It is in the project namespace.""", ns=4)
+add("Talk:Apple", """== Color ==
+Are all apples red? --[[User:Example|Example]] 10:00, 1 January 2024 (UTC)""", ns=1)
+add("Template:Stub", """<small>This article is a [[Wikipedia:Stub|stub]]. You can help by expanding it.</small><noinclude>[[Category:Stub templates]]</noinclude>""", ns=10)
+add("Category:Fruits", """Pages about '''fruits'''.
+
+[[Category:Food]]""", ns=14)
+add("Category:Planets", """Pages about '''planets''' of the [[Solar System]].""", ns=14)
+add("Help:Editing", """This '''help page''' explains how to edit pages.""", ns=12)
+add("File:Drops of water.jpg", """Drops of water on a leaf.""", ns=6)
+
+# redirects
+for src, dst in [("Apples", "Apple"), ("Einstein", "Albert Einstein"), ("Felis catus", "Cat"),
+                 ("Everest", "Mount Everest"), ("Madame Curie", "Marie Curie"), ("H2O", "Water"),
+                 ("Luna (moon)", "Moon"), ("Python language", "Python (programming language)"),
+                 ("Paris, France", "Paris"), ("Amazon river", "Amazon River")]:
+    add(src, "#REDIRECT [[%s]]\n\n{{R from alternative name}}" % dst, redirect=dst)
+
+# --- procedurally generated filler articles ---
+adjectives = ["small", "large", "old", "busy", "quiet", "famous", "coastal", "mountain", "river", "historic"]
+regions = ["Alba", "Brevia", "Corland", "Dornia", "Estmark", "Falland", "Gorvia", "Halden", "Istria Nova", "Jorvik"]
+crops = ["wheat", "grapes", "olives", "potatoes", "rice", "corn", "apples", "tea"]
+for i in range(95):
+    region = regions[i % len(regions)]
+    name = "%s%s" % (random.choice(["North ", "South ", "East ", "West ", "Old ", "New ", ""]), random.choice(
+        ["Ashford", "Brookvale", "Cedarton", "Dunmore", "Elmstead", "Fairview", "Glenwood", "Hillcrest", "Ironbridge",
+         "Juniper", "Kingsbury", "Lakeside", "Millbrook", "Northwick", "Oakridge", "Pinehurst", "Queensford",
+         "Redhill", "Stonehaven", "Thornbury"]))
+    title = "%s, %s" % (name, region)
+    pop = random.randint(500, 900000)
+    adj = random.choice(adjectives)
+    crop = random.choice(crops)
+    lat = round(random.uniform(-60, 70), 4)
+    lon = round(random.uniform(-170, 170), 4)
+    stub = "{{%s-geo-stub}}\n" % region.lower().replace(" ", "") if i % 4 == 0 else ""
+    body = ("{{Infobox settlement\n| name = %s\n| population_total = %d\n| coordinates = {{coord|%s|%s}}\n}}\n"
+            "'''%s''' is a %s [[town]] in [[%s]]. About %s people live there.<ref>{{cite web|url=https://example.org/census/%d|title=Census %d}}</ref>"
+            " The town is known for growing [[%s]].\n\n") % (title, pop, lat, lon, name, adj, region, format(pop, ","), i, i, crop)
+    if i % 3 == 0:
+        body += "The town has a [[railway station]] and a [[market]] that is open on Saturdays. Many visitors come in the summer.\n\n"
+    body += "== History ==\nPeople have lived in %s since the [[Middle Ages]].\n\n" % name
+    body += stub
+    body += "[[Category:Towns in %s]]" % region
+    add(title, body)
+
+elements = [("Hydrogen", "H", 1), ("Helium", "He", 2), ("Lithium", "Li", 3), ("Beryllium", "Be", 4),
+            ("Boron", "B", 5), ("Carbon", "C", 6), ("Nitrogen", "N", 7), ("Oxygen", "O", 8), ("Fluorine", "F", 9),
+            ("Neon", "Ne", 10), ("Sodium", "Na", 11), ("Magnesium", "Mg", 12), ("Aluminium", "Al", 13),
+            ("Silicon", "Si", 14), ("Phosphorus", "P", 15), ("Sulfur", "S", 16), ("Chlorine", "Cl", 17),
+            ("Argon", "Ar", 18), ("Potassium", "K", 19), ("Calcium", "Ca", 20)]
+for name, sym, num in elements:
+    add(name, ("{{Infobox element\n| name = %s\n| symbol = %s\n| number = %d\n}}\n"
+               "'''%s''' is a [[chemical element]]. Its symbol is '''%s''' and its [[atomic number]] is %d.<ref name=\"ptable\">{{cite web|url=https://example.org/elements/%s|title=%s}}</ref> "
+               "It is found in the [[periodic table]].\n\n%s has many uses in [[industry]].\n\n[[Category:Chemical elements]]") %
+        (name, sym, num, name, sym, num, sym.lower(), name, name))
+
+first = ["Anna", "Boris", "Clara", "David", "Elena", "Felix", "Greta", "Hugo", "Ines", "Jonas", "Karin", "Lukas",
+         "Mina", "Nils", "Olga", "Pavel", "Rosa", "Stefan", "Tara", "Viktor"]
+last = ["Almqvist", "Berger", "Castell", "Dahl", "Eriksen", "Fontaine", "Gruber", "Horvat", "Ivanova", "Jansen"]
+jobs = ["painter", "writer", "composer", "footballer", "politician", "scientist", "actor", "architect"]
+for i in range(40):
+    fn, ln = first[i % len(first)], last[(i * 7) % len(last)]
+    title = "%s %s" % (fn, ln)
+    by = random.randint(1800, 1990)
+    bm, bd = random.randint(1, 12), random.randint(1, 28)
+    job = random.choice(jobs)
+    alive = i % 3 != 0
+    if alive:
+        dates = "{{birth date and age|%d|%d|%d}}" % (by, bm, bd)
+        lead = "'''%s''' (born %d) is a [[%s]] from [[%s]]." % (title, by, job, random.choice(regions))
+    else:
+        dy = by + random.randint(40, 90)
+        dates = "{{birth date|%d|%d|%d}}\n| death_date = {{death date and age|%d|1|1|%d|%d|%d}}" % (by, bm, bd, dy, by, bm, bd)
+        lead = "'''%s''' (%d – %d) was a [[%s]] from [[%s]]." % (title, by, dy, job, random.choice(regions))
+    alias = " He was also known as '''%s the Younger'''." % fn if i % 5 == 0 else ""
+    body = ("{{Infobox person\n| name = %s\n| birth_date = %s\n}}\n%s%s<ref>{{cite news|title=Profile|url=https://example.org/people/%d}}</ref>"
+            " %s won several [[award]]s.\n\n== Career ==\n%s worked in many countries.\n\n[[Category:%s births]]") % (
+        title, dates, lead, alias, i, fn, fn, by)
+    if i % 6 == 5:
+        body = "{{stub}}\n" + body
+    add(title, body)
+
+numbers = ["Zero", "One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Eleven", "Twelve"]
+for n, word in enumerate(numbers):
+    title = "%d (number)" % n
+    add(title, "'''%s''' ('''%d''') is a [[number]]. It comes after %d and before %d.\n\n{{Integers|zero}}\n\n[[Category:Integers]]" %
+        (word, n, n - 1, n + 1))
+
+for i in range(25):
+    name = "%s River" % random.choice(["Silver", "Black", "Green", "Clear", "Long", "Stone", "Willow", "Fox", "Bear", "Pine"]) + ("" if i < 10 else " (%s)" % regions[i % 10])
+    length = random.randint(20, 900)
+    add(name, ("'''%s''' is a [[river]] in [[%s]]. It is {{convert|%d|km|mi}} long and flows into the [[sea]].\n\n"
+               "{{river-stub}}\n\n[[Category:Rivers of %s]]") % (name, regions[i % 10], length, regions[i % 10]))
+
+# deduplicate titles keeping first occurrence
+seen, uniq = set(), []
+for p in pages:
+    if p[0] in seen:
+        continue
+    seen.add(p[0]); uniq.append(p)
+pages = uniq
+
+nsmap = {-2: "Media", -1: "Special", 0: "", 1: "Talk", 2: "User", 3: "User talk", 4: "Wikipedia", 5: "Wikipedia talk",
+         6: "File", 7: "File talk", 8: "MediaWiki", 9: "MediaWiki talk", 10: "Template", 11: "Template talk",
+         12: "Help", 13: "Help talk", 14: "Category", 15: "Category talk", 828: "Module", 829: "Module talk"}
+
+out = []
+out.append('<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.mediawiki.org/xml/export-0.11/ http://www.mediawiki.org/xml/export-0.11.xsd" version="0.11" xml:lang="en">\n')
+out.append('  <siteinfo>\n    <sitename>Wikipedia</sitename>\n    <dbname>simplewiki</dbname>\n    <base>https://simple.wikipedia.org/wiki/Main_Page</base>\n    <generator>MediaWiki 1.43.0-wmf.8</generator>\n    <case>first-letter</case>\n    <namespaces>\n')
+for k in sorted(nsmap):
+    if k == 0:
+        out.append('      <namespace key="0" case="first-letter" />\n')
+    else:
+        out.append('      <namespace key="%d" case="first-letter">%s</namespace>\n' % (k, nsmap[k]))
+out.append('    </namespaces>\n  </siteinfo>\n')
+pid, rid = 1, 1000
+for title, ns, text, redirect in pages:
+    pid += random.randint(1, 40)
+    rid += random.randint(1, 5000)
+    ts = "2024-%02d-%02dT%02d:%02d:%02dZ" % (random.randint(1, 5), random.randint(1, 28), random.randint(0, 23), random.randint(0, 59), random.randint(0, 59))
+    out.append('  <page>\n    <title>%s</title>\n    <ns>%d</ns>\n    <id>%d</id>\n' % (escape(title), ns, pid))
+    if redirect:
+        out.append('    <redirect title="%s" />\n' % escape(redirect, {'"': "&quot;"}))
+    out.append('    <revision>\n      <id>%d</id>\n      <parentid>%d</parentid>\n      <timestamp>%s</timestamp>\n' % (rid, rid - 1, ts))
+    out.append('      <contributor>\n        <username>Example</username>\n        <id>42</id>\n      </contributor>\n')
+    out.append('      <model>wikitext</model>\n      <format>text/x-wiki</format>\n')
+    out.append('      <text bytes="%d" xml:space="preserve">%s</text>\n' % (len(text.encode()), escape(text)))
+    out.append('      <sha1>%s</sha1>\n    </revision>\n  </page>\n' % ("%031x" % random.getrandbits(124)))
+out.append('</mediawiki>\n')
+data = "".join(out).encode()
+# Split into multistream-style chunks: siteinfo alone, then 100 pages per stream.
+head_end = data.index(b"  <page>")
+body = data[head_end:]
+chunks = [data[:head_end]]
+parts = body.split(b"  <page>")[1:]
+for i in range(0, len(parts), 100):
+    chunks.append(b"".join(b"  <page>" + p for p in parts[i:i + 100]))
+with open(sys.argv[1], "wb") as f:
+    for c in chunks:
+        f.write(bz2.compress(c, 9))
+print(len(pages), "pages", len(data), "bytes", file=sys.stderr)
@@ -0,0 +1,37 @@
+# Rewrites the sample dump in two other dialects of the export schema, for
+# the schema cases of TestGolden. Run it from the repository root:
+#
+#   python3 sample/gen_schemas.py sample/simplewiki-sample.xml.bz2 sample/schemas
+#
+# prefixed.xml.bz2 puts every element under an explicit namespace prefix
+# (<mw:page>) instead of the default namespace, keeping the sample's streams
+# with a multistream index of its own, prefixed-index.txt. export-0.5.xml.bz2
+# declares schema 0.5 and drops what that version lacks: <ns>, <model>,
+# <format> and <sha1>.
+import bz2, os, re, sys
+
+raw = open(sys.argv[1], "rb").read()
+streams = []
+while raw:
+    d = bz2.BZ2Decompressor()
+    streams.append(d.decompress(raw).decode())
+    raw = d.unused_data
+os.makedirs(sys.argv[2], exist_ok=True)
+
+prefixed = [re.sub(r"<(/?)([a-zA-Z]+)(?=[\s>/])", r"<\1mw:\2", s) for s in streams]
+prefixed[0] = prefixed[0].replace("<mw:mediawiki xmlns=", "<mw:mediawiki xmlns:mw=", 1)
+index, off = [], 0
+with open(os.path.join(sys.argv[2], "prefixed.xml.bz2"), "wb") as f:
+    for s in prefixed:
+        data = bz2.compress(s.encode(), 9)
+        for page in re.finditer(r"<mw:title>(.*?)</mw:title>\s*<mw:ns>\d+</mw:ns>\s*<mw:id>(\d+)</mw:id>", s):
+            index.append("%d:%s:%s\n" % (off, page.group(2), page.group(1).replace("&amp;", "&").replace("&quot;", '"')))
+        f.write(data)
+        off += len(data)
+with open(os.path.join(sys.argv[2], "prefixed-index.txt"), "w") as f:
+    f.writelines(index)
+
+old = "".join(streams).replace("export-0.11", "export-0.5").replace('version="0.11"', 'version="0.5"')
+old = re.sub(r"\n\s*<(ns|model|format|sha1)>.*?</\1>", "", old)
+with open(os.path.join(sys.argv[2], "export-0.5.xml.bz2"), "wb") as f:
+    f.write(bz2.compress(old.encode(), 9))
@@ -0,0 +1,89 @@
+# Measures how close -abstract-mode exintro comes to the TextExtracts API
+# (prop=extracts&exintro&explaintext) on a real dump. TextExtracts only
+# serves the current revision, so the responses must be fetched close to the
+# dump date and stored next to it; run it from the repository root:
+#
+#   python3 sample/parity.py -fetch DUMP RESPONSES Title1 Title2 ...  # store the API's extracts
+#   python3 sample/parity.py DUMP RESPONSES                           # compare against them
+#
+# RESPONSES holds one <title>.json per page, the API reply as stored. The
+# comparison prints a similarity ratio per page (difflib, over the text with
+# paragraphs on single lines) and their mean, so drift shows from run to run.
+# -min R makes the exit status 1 when the mean falls below R.
+import difflib, json, os, re, subprocess, sys, tempfile, urllib.parse, urllib.request
+
+USER_AGENT = "full-stream-wiki-golang parity check (+https://github.com/AhmedOthman94/full-stream-wiki-golang)"
+
+def api_url(dump):
+    """The action API of the wiki a dump file name such as simplewiki-20240601-... belongs to."""
+    m = re.match(r"([a-z_-]+?)wiki-", os.path.basename(dump))
+    if not m:
+        sys.exit("cannot tell the wiki from %s" % dump)
+    return "https://%s.wikipedia.org/w/api.php" % m.group(1).replace("_", "-")
+
+def file_name(title):
+    return title.replace("/", "%2F") + ".json"
+
+def fetch(dump, responses, titles):
+    os.makedirs(responses, exist_ok=True)
+    for title in titles:
+        q = urllib.parse.urlencode({"action": "query", "prop": "extracts", "exintro": "1", "explaintext": "1",
+                                    "redirects": "1", "format": "json", "formatversion": "2", "titles": title})
+        req = urllib.request.Request(api_url(dump) + "?" + q, headers={"User-Agent": USER_AGENT})
+        with urllib.request.urlopen(req) as resp:
+            data = resp.read()
+        with open(os.path.join(responses, file_name(title)), "wb") as f:
+            f.write(data)
+        print("stored %s" % title)
+
+def normalize(text):
+    """Paragraphs on single lines, whitespace collapsed: TextExtracts separates them with one newline."""
+    return "\n".join(" ".join(p.split()) for p in re.split(r"\n\s*", text.strip()) if p.strip())
+
+def compare(dump, responses, minimum):
+    want = {}
+    for name in sorted(os.listdir(responses)):
+        with open(os.path.join(responses, name), "rb") as f:
+            for page in json.load(f)["query"]["pages"]:
+                if "extract" in page:
+                    want[page["title"]] = page["extract"]
+    if not want:
+        sys.exit("no stored extracts in %s; store some with -fetch" % responses)
+    with tempfile.TemporaryDirectory() as tmp:
+        binary, out = os.path.join(tmp, "full-stream-wiki"), os.path.join(tmp, "exintro.jsonl")
+        subprocess.run(["go", "build", "-o", binary, "."], check=True)
+        subprocess.run([binary, "extract", "-input", dump, "-abstract-mode", "exintro", "-format", "jsonl", "-o", out],
+                       check=True, stdout=subprocess.DEVNULL)
+        with open(out, encoding="utf-8") as f:
+            got = {d["title"]: d["abstract"] for d in map(json.loads, f) if d["title"] in want}
+    ratios = []
+    for title in sorted(want):
+        if title not in got:
+            print("missing  %s (not in the dump's output)" % title)
+            continue
+        r = difflib.SequenceMatcher(None, normalize(got[title]), normalize(want[title])).ratio()
+        ratios.append(r)
+        print("%.3f    %s" % (r, title))
+    mean = sum(ratios) / len(ratios) if ratios else 0
+    print("mean %.3f over %d pages" % (mean, len(ratios)))
+    if mean < minimum:
+        sys.exit(1)
+
+def main():
+    args = sys.argv[1:]
+    minimum = 0.0
+    if "-min" in args:
+        i = args.index("-min")
+        minimum = float(args[i + 1])
+        del args[i:i + 2]
+    if args and args[0] == "-fetch":
+        if len(args) < 4:
+            sys.exit("usage: parity.py -fetch DUMP RESPONSES TITLE...")
+        fetch(args[1], args[2], args[3:])
+    elif len(args) == 2:
+        compare(args[0], args[1], minimum)
+    else:
+        sys.exit("usage: parity.py [-fetch] DUMP RESPONSES [TITLE...] [-min R]")
+
+if __name__ == "__main__":
+    main()
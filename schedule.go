@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag" // Package for command-line flag parsing
+	"fmt"  // Package for formatted I/O
+	"os"   // Package for the countdown on stderr
+	"time" // Package for wall-clock windows
+)
+
+// scheduleOptions is an off-peak window a download waits for before it
+// starts, as Wikimedia asks of bulk downloaders
+type scheduleOptions struct {
+	After  string        // Window start as "HH:MM" wall-clock time; "" starts at once
+	Window time.Duration // Window length from After
+	TZ     string        // IANA time zone of After; "" is the local zone
+
+	start time.Duration  // After as time since midnight, set by check
+	loc   *time.Location // TZ, set by check
+}
+
+// scheduleFlags registers -schedule-after, -schedule-window and -schedule-tz
+func scheduleFlags(fs *flag.FlagSet, s *scheduleOptions) {
+	fs.StringVar(&s.After, "schedule-after", "", "wait until this `HH:MM` wall-clock time before downloading, unless already inside -schedule-window")
+	fs.DurationVar(&s.Window, "schedule-window", 6*time.Hour, "length of the -schedule-after window; a run started inside it begins at once")
+	fs.StringVar(&s.TZ, "schedule-tz", "", "IANA time `zone` of -schedule-after, e.g. UTC or Europe/Berlin (default: local time)")
+}
+
+// check parses the flags; it runs after flag parsing
+func (s *scheduleOptions) check() error {
+	if s.After == "" {
+		return nil
+	}
+	t, err := time.Parse("15:04", s.After)
+	if err != nil {
+		return fmt.Errorf("-schedule-after %q is not an HH:MM time", s.After)
+	}
+	s.start = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if s.Window <= 0 || s.Window > 24*time.Hour {
+		return fmt.Errorf("-schedule-window must be between 0 and 24h")
+	}
+	s.loc = time.Local
+	if s.TZ != "" {
+		if s.loc, err = time.LoadLocation(s.TZ); err != nil {
+			return fmt.Errorf("-schedule-tz: %w", err)
+		}
+	}
+	return nil
+}
+
+// opens returns now when now lies inside a window, else when the next one
+// opens. Yesterday's window is checked too, for windows past midnight.
+func (s *scheduleOptions) opens(now time.Time) time.Time {
+	t := now.In(s.loc)
+	y, m, d := t.Date()
+	hour, minute := int(s.start/time.Hour), int(s.start%time.Hour/time.Minute)
+	for day := -1; ; day++ {
+		start := time.Date(y, m, d+day, hour, minute, 0, 0, s.loc)
+		switch {
+		case start.After(now):
+			return start
+		case now.Before(start.Add(s.Window)):
+			return now
+		}
+	}
+}
+
+// wait blocks until the window opens, counting down on stderr once a
+// second. It sleeps on opts.Ticker, so tests can drive it with a fake
+// clock; SIGINT still ends the process at once, as no handler is installed yet.
+func (s *scheduleOptions) wait(opts Options) {
+	if s.After == "" {
+		return
+	}
+	at := s.opens(opts.NowFunc())
+	ticks, stop := opts.Ticker(time.Second)
+	defer stop()
+	waited := false
+	for now := opts.NowFunc(); now.Before(at); now = opts.NowFunc() {
+		fmt.Fprintf(os.Stderr, "\rWaiting %s for the %s window (%s) ", at.Sub(now).Truncate(time.Second), s.After, s.loc)
+		waited = true
+		<-ticks
+	}
+	if waited {
+		fmt.Fprintln(os.Stderr)
+	}
+}
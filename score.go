@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings" // Package for string manipulation
+)
+
+// qualityFeatures are the per-page signals the quality score is built from
+type qualityFeatures struct {
+	AbstractChars int     // Length of the plain abstract in characters
+	Sentences     int     // Sentences in the plain abstract
+	LeadCitations int     // <ref> tags in the lead section
+	HasShortDesc  bool    // Page carries {{Short description}}
+	Kind          string  // pageKind result
+	ProseRatio    float64 // Plain lead bytes divided by raw lead bytes
+}
+
+// qualityScore turns features into a deterministic 0–100 score. All weights
+// live here so they can be tuned in one place:
+//
+//	up to 30  abstract length, saturating at 600 characters
+//	up to 20  sentence count, 5 per sentence up to 4
+//	up to 20  lead citations, 5 per citation up to 4
+//	      10  presence of a short description
+//	up to 20  prose-to-markup ratio of the lead
+//	    -25   stub, -20 list, -40 disambiguation
+func qualityScore(f qualityFeatures) int {
+	score := 30 * float64(min(f.AbstractChars, 600)) / 600
+	score += 5 * float64(min(f.Sentences, 4))
+	score += 5 * float64(min(f.LeadCitations, 4))
+	if f.HasShortDesc {
+		score += 10
+	}
+	score += 20 * min(f.ProseRatio, 1)
+	switch f.Kind {
+	case kindStub:
+		score -= 25
+	case kindList:
+		score -= 20
+	case kindDisambiguation:
+		score -= 40
+	}
+	return int(max(0, min(100, score+0.5)))
+}
+
+// scorePage computes the quality score of a page
+func scorePage(c *cleaner, p *page, templates []template) int {
+	lead := leadSection(p.Revision.Text)
+	abstract := c.plainAbstract(p.Revision.Text)
+	f := qualityFeatures{
+		AbstractChars: len([]rune(abstract)),
+		Sentences:     len(splitSentences(abstract)),
+		LeadCitations: strings.Count(lead, "<ref>") + strings.Count(lead, "<ref "),
+		HasShortDesc:  shortDescription(templates) != "",
+		Kind:          pageKind(p.Title, templates),
+	}
+	if len(lead) > 0 {
+		f.ProseRatio = float64(len(collapseSpace(c.clean(lead)))) / float64(len(lead))
+	}
+	return qualityScore(f)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"      // Package for string manipulation
+	"unicode"      // Package for rune classification
+	"unicode/utf8" // Package for UTF-8 decoding
+)
+
+// abbreviations end in a period without ending a sentence
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "mt": true, "jr": true, "sr": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "c": true, "ca": true, "no": true, "vol": true, "pp": true,
+	"inc": true, "ltd": true, "co": true, "corp": true, "u.s": true, "u.k": true, "jan": true, "feb": true,
+	"mar": true, "apr": true, "jun": true, "jul": true, "aug": true, "sep": true, "sept": true, "oct": true,
+	"nov": true, "dec": true, "gen": true, "gov": true, "sen": true, "rev": true, "fr": true, "approx": true,
+}
+
+// splitSentences splits plain text into sentences at ., ! or ? followed by
+// whitespace and an uppercase letter, digit, or opening quote. A period does
+// not end a sentence after a known abbreviation or a single-letter initial
+// ("J. R. R. Tolkien"), and decimals like 2.1 never contain the required space.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '.' && c != '!' && c != '?' {
+			continue
+		}
+		// Absorb closing punctuation that belongs to this sentence, rune by
+		// rune: the curly quotes share lead bytes with dashes and other marks
+		end := i + 1
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !strings.ContainsRune(`"')]»”’`, r) {
+				break
+			}
+			end += size
+		}
+		if end >= len(text) || text[end] != ' ' {
+			continue
+		}
+		if c == '.' && !endsSentence(text[start:i]) {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(text[end+1:])
+		if !unicode.IsUpper(next) && !unicode.IsDigit(next) && strings.IndexRune(`"'“‘(«`, next) < 0 {
+			continue
+		}
+		if s := strings.TrimSpace(text[start:end]); s != "" {
+			out = append(out, s)
+		}
+		start = end + 1
+		i = end
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// endsSentence reports whether a period after text terminates a sentence
+func endsSentence(text string) bool {
+	word := text[strings.LastIndexAny(text, " (\n")+1:]
+	if abbreviations[strings.ToLower(word)] {
+		return false
+	}
+	r, size := utf8.DecodeRuneInString(word)
+	return !(size == len(word) && unicode.IsUpper(r)) // Single-letter initial
+}
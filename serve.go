@@ -0,0 +1,401 @@
+package main
+
+import (
+	"container/list" // Package for the LRU order of cached pages
+	"context"        // Package for shutting the server down
+	"encoding/json"  // Package for API responses and errors
+	"errors"         // Package for error inspection
+	"flag"           // Package for command-line flag parsing
+	"fmt"            // Package for formatted I/O
+	"io"             // Package for I/O primitives
+	"net/http"       // Package for the server and the API client
+	"net/url"        // Package for building API requests
+	"os"             // Package for OS functions (signals)
+	"os/signal"      // Package for stopping on Ctrl-C
+	"regexp"         // Package for checking language codes
+	"strings"        // Package for string manipulation
+	"sync"           // Package for the shared rate limit and cache
+	"time"           // Package for the rate limit and the cache
+)
+
+// serveConfig holds the settings of the serve subcommand
+type serveConfig struct {
+	Addr        string        // Listen address
+	API         string        // MediaWiki action API URL; "" derives it from the language
+	Rate        float64       // Most API requests per second
+	CacheTTL    time.Duration // How long fetched wikitext is reused
+	CacheSize   int           // Most fetched pages the cache holds
+	RandomFrom  string        // Output file GET /random picks from; "" leaves /random out
+	ExtractArgs []string      // Extract flags given after "--", for the pipeline
+}
+
+// apiTimeout bounds one action API request
+const apiTimeout = 10 * time.Second
+
+// langRe is what a wiki language code looks like, e.g. "en", "simple", "zh-yue"
+var langRe = regexp.MustCompile(`^[a-z][a-z0-9-]{0,15}$`)
+
+// serveCommand answers GET /extract?title=X&lang=en with the doc of the
+// current revision of one article, built by the same code as a dump run
+func serveCommand(args []string) error {
+	scfg := &serveConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki serve", flag.ContinueOnError)
+	fs.StringVar(&scfg.Addr, "addr", "localhost:8080", "listen `address`")
+	fs.StringVar(&scfg.API, "api", "", "MediaWiki action API `URL` for every language (default: https://<lang>.<project>.org/w/api.php)")
+	fs.Float64Var(&scfg.Rate, "api-rate", 5, "most action API requests per second, over all clients")
+	fs.DurationVar(&scfg.CacheTTL, "cache-ttl", time.Minute, "reuse fetched wikitext for this long (0: never)")
+	fs.IntVar(&scfg.CacheSize, "cache-size", 10000, "most fetched pages to keep, dropping the least recently requested first")
+	fs.StringVar(&scfg.RandomFrom, "random-from", "", "serve GET /random with docs picked from this output `file` and its -emit-index sidecar")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	scfg.ExtractArgs = fs.Args()
+	if scfg.Rate <= 0 || scfg.CacheTTL < 0 || scfg.CacheSize < 1 {
+		err := errors.New("-api-rate and -cache-size must be positive and -cache-ttl not negative")
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+	cfg, err := parseFlags(scfg.ExtractArgs)
+	if err != nil {
+		return err
+	}
+	if cfg.Network.Offline {
+		err := errors.New("serve fetches live wikitext and cannot run with -offline")
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+
+	le := newLiveExtractor(cfg, scfg)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/extract", le.serveExtract)
+	mux.HandleFunc("/metrics", le.serveMetrics)
+	if scfg.RandomFrom != "" {
+		src, err := openRandomSource(scfg.RandomFrom, "")
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		mux.HandleFunc("/random", (&randomServer{src: src, cfg: cfg}).serveRandom)
+	}
+	srv := &http.Server{Addr: scfg.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	fmt.Printf("Serving http://%s/extract?title=...&lang=%s\n", scfg.Addr, cfg.Lang)
+	if scfg.RandomFrom != "" {
+		fmt.Printf("Serving http://%s/random?n=... from %s\n", scfg.Addr, scfg.RandomFrom)
+	}
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// liveExtractor fetches the current wikitext of single pages from the action
+// API and runs it through the dump pipeline. API requests from all clients
+// share one rate limit, and fetched pages are reused for -cache-ttl, up to
+// -cache-size of them.
+type liveExtractor struct {
+	cfg    *config       // Extraction settings, from the flags after "--"
+	scfg   *serveConfig  // Server settings
+	client *http.Client  // Client with apiTimeout
+	every  time.Duration // Minimum spacing of API requests
+
+	mu       sync.Mutex               // Guards the fields below
+	next     time.Time                // Earliest time of the next API request
+	cache    map[string]*list.Element // Fetched pages by language and title; values are *livePage
+	lru      *list.List               // Fetched pages, most recently requested first
+	inflight map[string]*liveFetch    // Pages being fetched, which other requests for them wait on
+	builders map[string]*docBuilder   // Builders per language
+	counts   liveCounts               // What GET /metrics reports
+}
+
+// livePage is a fetched page, or the fact that the wiki has none by that title
+type livePage struct {
+	key     string    // Cache key: language and title
+	p       *page     // The page; nil if missing
+	fetched time.Time // When it was fetched
+}
+
+// liveFetch is a page some request is fetching; others wait on done
+type liveFetch struct {
+	done chan struct{} // Closed once p or err is set
+	p    *page         // The page; nil if missing
+	err  error         // Why the fetch failed
+}
+
+// liveCounts are the counters of GET /metrics
+type liveCounts struct {
+	hits        int64 // Requests answered from the cache
+	misses      int64 // Requests that fetched the page, expired entries included
+	coalesced   int64 // Requests that waited for another request's fetch of the page
+	evictions   int64 // Pages dropped to stay within -cache-size
+	apiErrors   int64 // Fetches that failed
+	extracted   int64 // Docs served
+	unbuildable int64 // Pages whose abstract came out empty or below -min-score
+}
+
+func newLiveExtractor(cfg *config, scfg *serveConfig) *liveExtractor {
+	return &liveExtractor{
+		cfg:      cfg,
+		scfg:     scfg,
+		client:   newHTTPClient(apiTimeout),
+		every:    time.Duration(float64(time.Second) / scfg.Rate),
+		cache:    map[string]*list.Element{},
+		lru:      list.New(),
+		inflight: map[string]*liveFetch{},
+		builders: map[string]*docBuilder{},
+	}
+}
+
+// serveExtract handles GET /extract
+func (le *liveExtractor) serveExtract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	title := normalizeTitle(r.URL.Query().Get("title"), false)
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = le.cfg.Lang
+	}
+	switch {
+	case title == "":
+		httpError(w, http.StatusBadRequest, "missing title")
+		return
+	case !langRe.MatchString(lang):
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("bad lang %q", lang))
+		return
+	}
+
+	p, err := le.page(r.Context(), lang, title)
+	switch {
+	case err != nil:
+		httpError(w, http.StatusBadGateway, err.Error())
+		return
+	case p == nil:
+		httpError(w, http.StatusNotFound, fmt.Sprintf("no page %q on %s", title, lang))
+		return
+	}
+	doc, err := le.extract(lang, p)
+	le.mu.Lock()
+	if err != nil {
+		le.counts.unbuildable++
+	} else {
+		le.counts.extracted++
+	}
+	le.mu.Unlock()
+	if err != nil {
+		httpError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	newJSONEncoder(w, le.cfg).Encode(doc)
+}
+
+// extract builds the doc of p as a dump run with the same flags would
+func (le *liveExtractor) extract(lang string, p *page) (*Doc, error) {
+	le.mu.Lock()
+	b := le.builders[lang]
+	if b == nil {
+		b = newDocBuilder(le.cfg, lang, false)
+		le.builders[lang] = b
+	}
+	le.mu.Unlock()
+
+	c := newCleaner(le.cfg)
+	c.startPage()
+	r := b.build(c, p)
+	switch {
+	case r.empty:
+		return nil, fmt.Errorf("%q has an empty abstract", p.Title)
+	case r.lowScore:
+		return nil, fmt.Errorf("%q scores below -min-score %d", p.Title, le.cfg.MinScore)
+	}
+	return &r.doc, nil
+}
+
+// page returns the current revision of title, from the cache while it is
+// fresh; a missing page is nil
+func (le *liveExtractor) page(ctx context.Context, lang, title string) (*page, error) {
+	key := lang + "\x00" + title
+	now := le.cfg.NowFunc()
+	le.mu.Lock()
+	if e, ok := le.cache[key]; ok {
+		if lp := e.Value.(*livePage); now.Sub(lp.fetched) < le.scfg.CacheTTL {
+			le.lru.MoveToFront(e)
+			le.counts.hits++
+			le.mu.Unlock()
+			return lp.p, nil
+		}
+	}
+	if f, ok := le.inflight[key]; ok {
+		le.counts.coalesced++
+		le.mu.Unlock()
+		select {
+		case <-f.done:
+			if errors.Is(f.err, context.Canceled) && ctx.Err() == nil {
+				return le.page(ctx, lang, title) // The client that fetched it went away; this one has not
+			}
+			return f.p, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	le.counts.misses++
+	f := &liveFetch{done: make(chan struct{})}
+	le.inflight[key] = f
+	// Take the next request slot, then wait for it outside the lock
+	slot := le.next
+	if slot.Before(now) {
+		slot = now
+	}
+	le.next = slot.Add(le.every)
+	le.mu.Unlock()
+	if wait := slot.Sub(now); wait > 0 {
+		le.cfg.Sleep(wait)
+	}
+
+	p, err := le.fetch(ctx, lang, title)
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	delete(le.inflight, key)
+	f.p, f.err = p, err
+	close(f.done)
+	if err != nil {
+		le.counts.apiErrors++
+		return nil, err
+	}
+	if le.scfg.CacheTTL > 0 {
+		lp := &livePage{key: key, p: p, fetched: le.cfg.NowFunc()}
+		if e, ok := le.cache[key]; ok {
+			e.Value = lp // Another request refreshed it meanwhile, or it had expired
+			le.lru.MoveToFront(e)
+		} else {
+			le.cache[key] = le.lru.PushFront(lp)
+		}
+		for le.lru.Len() > le.scfg.CacheSize {
+			oldest := le.lru.Remove(le.lru.Back()).(*livePage)
+			delete(le.cache, oldest.key)
+			le.counts.evictions++
+		}
+	}
+	return p, nil
+}
+
+// serveMetrics handles GET /metrics with the cache and request counters in
+// the Prometheus text format
+func (le *liveExtractor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	le.mu.Lock()
+	c, size := le.counts, le.lru.Len()
+	le.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"fsw_serve_cache_hits_total", "counter", "Requests answered from the page cache.", c.hits},
+		{"fsw_serve_cache_misses_total", "counter", "Requests that fetched the page from the action API, expired entries included.", c.misses},
+		{"fsw_serve_cache_coalesced_total", "counter", "Requests that waited for a fetch of the same page already under way.", c.coalesced},
+		{"fsw_serve_cache_evictions_total", "counter", "Pages dropped from the cache to stay within -cache-size.", c.evictions},
+		{"fsw_serve_cache_entries", "gauge", "Pages in the cache.", int64(size)},
+		{"fsw_serve_cache_capacity", "gauge", "The -cache-size.", int64(le.scfg.CacheSize)},
+		{"fsw_serve_api_errors_total", "counter", "Action API requests that failed.", c.apiErrors},
+		{"fsw_serve_docs_total", "counter", "Docs served.", c.extracted},
+		{"fsw_serve_unbuildable_total", "counter", "Pages whose abstract came out empty or below -min-score.", c.unbuildable},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
+
+// apiResponse is the part of an action=query&prop=revisions reply
+// (formatversion=2) the extractor reads
+type apiResponse struct {
+	Query struct {
+		Pages []struct {
+			PageID    int64  `json:"pageid"`  // Page ID
+			NS        int    `json:"ns"`      // Namespace number
+			Title     string `json:"title"`   // Title, after following redirects
+			Missing   bool   `json:"missing"` // No page has the title
+			Invalid   bool   `json:"invalid"` // The title is not a valid one
+			Revisions []struct {
+				Slots struct {
+					Main struct {
+						Content string `json:"content"` // Wikitext
+					} `json:"main"`
+				} `json:"slots"`
+			} `json:"revisions"` // The current revision
+		} `json:"pages"`
+	} `json:"query"`
+	Error *struct {
+		Code string `json:"code"` // Error code
+		Info string `json:"info"` // Explanation
+	} `json:"error"`
+}
+
+// fetch requests the current wikitext of title, following redirects
+func (le *liveExtractor) fetch(ctx context.Context, lang, title string) (*page, error) {
+	api := le.scfg.API
+	if api == "" {
+		api = strings.TrimSuffix(le.cfg.Project.base(lang), "/wiki/") + "/w/api.php"
+	}
+	q := url.Values{
+		"action":        {"query"},
+		"prop":          {"revisions"},
+		"rvprop":        {"content"},
+		"rvslots":       {"main"},
+		"redirects":     {"1"},
+		"format":        {"json"},
+		"formatversion": {"2"},
+		"titles":        {title},
+	}
+	req, err := newRequest(ctx, http.MethodGet, api+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := le.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if wait, ok := retryAfter(resp, le.cfg.NowFunc()); ok {
+		le.mu.Lock()
+		le.next = le.cfg.NowFunc().Add(max(wait, le.every)) // Hold the following requests back as asked
+		le.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("action API: %w: %s", ErrBadStatus, resp.Status)
+	}
+	var ar apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&ar); err != nil {
+		return nil, fmt.Errorf("action API: %w", err)
+	}
+	if ar.Error != nil {
+		return nil, fmt.Errorf("action API: %s: %s", ar.Error.Code, ar.Error.Info)
+	}
+	if len(ar.Query.Pages) == 0 || ar.Query.Pages[0].Missing || ar.Query.Pages[0].Invalid {
+		return nil, nil
+	}
+	ap := ar.Query.Pages[0]
+	p := &page{Title: ap.Title, NS: ap.NS, ID: ap.PageID, hasRevision: len(ap.Revisions) > 0}
+	if p.hasRevision {
+		p.Revision.Text = ap.Revisions[0].Slots.Main.Content
+	}
+	return p, nil
+}
+
+// httpError replies with status and {"error": msg}
+func httpError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
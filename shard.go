@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"      // Package for formatted I/O
+	"hash/fnv" // Package for the shard hash
+	"strconv"  // Package for string conversions
+)
+
+// shardSpec assigns every page to one of count shards (-shard-index,
+// -shard-count), so runs on count machines over the same dump write disjoint
+// outputs that together hold every page. The assignment is part of the
+// interface and must not change between versions: FNV-1a (64 bit) of the
+// page's key, modulo count. The key is the title normalized as by
+// normalizeTitle with case folding of the first letter, or with -shard-by id
+// the page ID in decimal.
+type shardSpec struct {
+	index int  // Shard this run keeps, from 0
+	count int  // Number of shards
+	byID  bool // Key on the page ID instead of the title
+}
+
+// parseShard builds the shardSpec of the -shard-* flags; nil means no sharding
+func parseShard(index, count int, by string) (*shardSpec, error) {
+	if by != "title" && by != "id" {
+		return nil, fmt.Errorf("unknown -shard-by %q (want title or id)", by)
+	}
+	if count == 0 && index == 0 {
+		return nil, nil
+	}
+	if count < 1 || index < 0 || index >= count {
+		return nil, fmt.Errorf("-shard-index must be at least 0 and below -shard-count (got %d of %d)", index, count)
+	}
+	return &shardSpec{index: index, count: count, byID: by == "id"}, nil
+}
+
+// shardOf returns the shard a page belongs to among count
+func shardOf(title string, id int64, count int, byID bool) int {
+	key := normalizeTitle(title, false)
+	if byID {
+		key = strconv.FormatInt(id, 10)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(count))
+}
+
+// owns reports whether the page belongs to this run's shard; without sharding every page does
+func (s *shardSpec) owns(p *page) bool {
+	return s == nil || shardOf(p.Title, p.ID, s.count, s.byID) == s.index
+}
+
+// shardShare describes how many of the scanned pages fell into the shard,
+// against the even share, so a skewed split shows up
+func (st *stats) shardShare(s *shardSpec) string {
+	scanned := st.Pages - st.OutOfRange
+	share := 0.0
+	if scanned > 0 {
+		share = 100 * float64(st.ShardPages) / float64(scanned)
+	}
+	return fmt.Sprintf("%d of %d pages (%.1f%%, even share %.1f%%)", st.ShardPages, scanned, share, 100/float64(s.count))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"           // Package for buffered I/O
+	"encoding/binary" // Package for the spill record layout
+	"fmt"             // Package for formatted I/O
+	"hash/fnv"        // Package for shingle and band hashes
+	"math"            // Package for the LSH threshold
+	"os"              // Package for OS functions (file access)
+	"strings"         // Package for string manipulation
+	"unicode"         // Package for word splitting
+)
+
+// similarityBucketCap bounds the docs kept per LSH bucket: an abstract shared
+// by thousands of stubs pairs each with its most recent predecessors only,
+// instead of making the run quadratic
+const similarityBucketCap = 1000
+
+// similarityFinder reports pairs of near-duplicate abstracts in one pass
+// (-similarity). Each abstract gets a MinHash signature over its word
+// shingles; the signature is cut into bands and a doc becomes a candidate of
+// every earlier doc sharing a band. Candidates whose estimated (or, with
+// -similarity-verify, exact) Jaccard similarity reaches the threshold are
+// written as "title_a<TAB>title_b<TAB>score" rows.
+type similarityFinder struct {
+	f         *os.File              // Pairs file
+	buf       *bufio.Writer         // Buffered rows
+	seeds     []uint64              // One seed per hash function, from cfg.Rand
+	shingle   int                   // Words per shingle
+	rows      int                   // Signature values per band
+	threshold float64               // Lowest score written
+	verify    bool                  // Score candidates by exact Jaccard over their shingles
+	tables    []map[uint64][]uint32 // Per band: band hash → docs, oldest first
+	store     signatureStore        // Signatures, titles and (with verify) abstracts by doc number
+	docs      uint32                // Docs added
+	checked   int                   // Candidate pairs scored
+	written   int                   // Pairs written
+	maxField  int                   // -max-field-bytes
+}
+
+func newSimilarityFinder(cfg *config) (*similarityFinder, error) {
+	f, err := os.Create(cfg.Similarity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create similarity file: %w", err)
+	}
+	bands, rows := lshBands(cfg.MinHashes, cfg.SimilarityThreshold)
+	s := &similarityFinder{
+		f:         f,
+		buf:       bufio.NewWriter(f),
+		seeds:     make([]uint64, bands*rows),
+		shingle:   cfg.ShingleWords,
+		rows:      rows,
+		threshold: cfg.SimilarityThreshold,
+		verify:    cfg.SimilarityVerify,
+		tables:    make([]map[uint64][]uint32, bands),
+		store:     signatureStore{hashes: bands * rows, limit: cfg.MaxSignatures, work: cfg.Work},
+		maxField:  cfg.MaxFieldBytes,
+	}
+	for i := range s.seeds {
+		s.seeds[i] = cfg.Rand.Uint64()
+	}
+	for i := range s.tables {
+		s.tables[i] = map[uint64][]uint32{}
+	}
+	s.buf.WriteString("title_a\ttitle_b\tscore\n")
+	return s, nil
+}
+
+// lshBands splits n hash functions into bands of rows values. A pair of
+// similarity s shares at least one band with probability 1-(1-s^rows)^bands;
+// the split minimizes the chance of missing a pair above the threshold plus
+// a third of that of proposing one below it, as candidates are scored anyway
+// and a false one costs only CPU. Hashes left over by the split are not computed.
+func lshBands(n int, threshold float64) (bands, rows int) {
+	candidate := func(s float64, b, r int) float64 { return 1 - math.Pow(1-math.Pow(s, float64(r)), float64(b)) }
+	const steps = 100
+	bands, rows, best := n, 1, math.Inf(1)
+	for r := 1; r <= n; r++ {
+		b := n / r
+		var missed, proposed float64
+		for i := range steps {
+			s := (float64(i) + 0.5) / steps
+			if p := candidate(s, b, r); s >= threshold {
+				missed += 1 - p
+			} else {
+				proposed += p
+			}
+		}
+		if cost := missed + proposed/3; cost < best {
+			bands, rows, best = b, r, cost
+		}
+	}
+	return bands, rows
+}
+
+// add signs the doc's abstract, writes its pairs with earlier docs and files it in the tables
+func (s *similarityFinder) add(doc *Doc) error {
+	shingles := wordShingles(doc.Abstract, s.shingle)
+	if len(shingles) == 0 {
+		return nil
+	}
+	sig := s.signature(shingles)
+	id := s.docs
+	s.docs++
+
+	// 1. Collect the earlier docs sharing a band, each once, in table order
+	seen := map[uint32]bool{}
+	var candidates []uint32
+	keys := make([]uint64, len(s.tables))
+	for band, table := range s.tables {
+		keys[band] = bandKey(sig[band*s.rows : (band+1)*s.rows])
+		for _, other := range table[keys[band]] {
+			if !seen[other] {
+				seen[other] = true
+				candidates = append(candidates, other)
+			}
+		}
+	}
+
+	// 2. Score them and write those reaching the threshold
+	text := ""
+	if s.verify {
+		text = doc.Abstract
+	}
+	for _, other := range candidates {
+		o, err := s.store.get(other)
+		if err != nil {
+			return err
+		}
+		s.checked++
+		score := estimateJaccard(sig, o.sig)
+		if s.verify {
+			score = exactJaccard(shingles, wordShingles(o.text, s.shingle))
+		}
+		if score < s.threshold {
+			continue
+		}
+		if _, err := fmt.Fprintf(s.buf, "%s\t%s\t%.3f\n", capField(o.title, s.maxField), capField(doc.Title, s.maxField), score); err != nil {
+			return fmt.Errorf("failed to write similarity pairs: %w", err)
+		}
+		s.written++
+	}
+
+	// 3. Keep the doc for the ones still to come
+	if err := s.store.put(signedDoc{title: doc.Title, sig: sig, text: text}); err != nil {
+		return err
+	}
+	for band, table := range s.tables {
+		bucket := append(table[keys[band]], id)
+		if len(bucket) > similarityBucketCap {
+			bucket = bucket[1:]
+		}
+		table[keys[band]] = bucket
+	}
+	return nil
+}
+
+// signature returns the MinHash of a shingle set: per hash function, the smallest value over the set
+func (s *similarityFinder) signature(shingles map[uint64]bool) []uint32 {
+	sig := make([]uint32, len(s.seeds))
+	for i := range sig {
+		sig[i] = math.MaxUint32
+	}
+	for sh := range shingles {
+		sh = mix64(sh) // FNV of short strings leaves too much structure for seeding by XOR alone
+		for i, seed := range s.seeds {
+			if v := uint32(mix64(sh ^ seed)); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// Close flushes the rows and drops the spilled signatures; closing twice is harmless
+func (s *similarityFinder) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.buf.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	s.store.close()
+	if err != nil {
+		return fmt.Errorf("failed to write similarity pairs: %w", err)
+	}
+	return nil
+}
+
+// wordShingles hashes every run of n consecutive lowercased words of text;
+// a text shorter than n words is a single shingle
+func wordShingles(text string, n int) map[uint64]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	set := map[uint64]bool{}
+	if len(words) == 0 {
+		return set
+	}
+	for i := 0; i == 0 || i+n <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+n, len(words))], " ")))
+		set[h.Sum64()] = true
+	}
+	return set
+}
+
+// mix64 is the splitmix64 finalizer, turning one shingle hash into many independent ones
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// bandKey hashes the signature values of one band
+func bandKey(values []uint32) uint64 {
+	h := fnv.New64a()
+	var b [4]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint32(b[:], v)
+		h.Write(b[:])
+	}
+	return h.Sum64()
+}
+
+// estimateJaccard is the share of hash functions on which two signatures agree
+func estimateJaccard(a, b []uint32) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// exactJaccard is the size of the intersection of two shingle sets over that of their union
+func exactJaccard(a, b map[uint64]bool) float64 {
+	common := 0
+	for sh := range a {
+		if b[sh] {
+			common++
+		}
+	}
+	if union := len(a) + len(b) - common; union > 0 {
+		return float64(common) / float64(union)
+	}
+	return 0
+}
+
+// signedDoc is what the finder remembers of a doc
+type signedDoc struct {
+	title string   // Page title
+	sig   []uint32 // MinHash signature
+	text  string   // Abstract, kept for -similarity-verify only
+}
+
+// signatureStore holds the first limit docs in memory and spills the rest
+// to a workdir file of variable-length records, read back by offset
+type signatureStore struct {
+	hashes int         // Signature length
+	limit  int         // Docs held in memory
+	work   *workdir    // Scratch space of the spill file
+	mem    []signedDoc // Docs 0 to limit-1
+	spill  *os.File    // Records of the later docs
+	offs   []int64     // Start of each spilled record, plus the end of the last
+}
+
+// put stores the next doc
+func (st *signatureStore) put(d signedDoc) error {
+	if len(st.mem) < st.limit {
+		st.mem = append(st.mem, d)
+		return nil
+	}
+	if st.spill == nil {
+		f, err := st.work.create("similarity-*.bin")
+		if err != nil {
+			return err
+		}
+		st.spill, st.offs = f, []int64{0}
+	}
+	rec := make([]byte, 0, 4*st.hashes+8+len(d.title)+len(d.text))
+	for _, v := range d.sig {
+		rec = binary.LittleEndian.AppendUint32(rec, v)
+	}
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(len(d.title)))
+	rec = append(rec, d.title...)
+	rec = append(rec, d.text...)
+	end := st.offs[len(st.offs)-1]
+	if _, err := st.spill.WriteAt(rec, end); err != nil {
+		return fmt.Errorf("failed to spill signatures: %w", err)
+	}
+	st.offs = append(st.offs, end+int64(len(rec)))
+	return nil
+}
+
+// get returns doc i
+func (st *signatureStore) get(i uint32) (signedDoc, error) {
+	if int(i) < len(st.mem) {
+		return st.mem[i], nil
+	}
+	j := int(i) - len(st.mem)
+	rec := make([]byte, st.offs[j+1]-st.offs[j])
+	if _, err := st.spill.ReadAt(rec, st.offs[j]); err != nil {
+		return signedDoc{}, fmt.Errorf("failed to read spilled signatures: %w", err)
+	}
+	d := signedDoc{sig: make([]uint32, st.hashes)}
+	for k := range d.sig {
+		d.sig[k] = binary.LittleEndian.Uint32(rec[4*k:])
+	}
+	rest := rec[4*st.hashes:]
+	n := binary.LittleEndian.Uint32(rest)
+	d.title, d.text = string(rest[4:4+n]), string(rest[4+n:])
+	return d, nil
+}
+
+// close closes the spill file; the workdir removes it with the other scratch files
+func (st *signatureStore) close() {
+	if st.spill != nil {
+		st.spill.Close()
+		st.spill = nil
+	}
+}
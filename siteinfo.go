@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json" // Package for siteinfo.json
+	"encoding/xml"  // Package for the root element
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"strings"       // Package for string manipulation
+)
+
+// exportNamespace starts the xmlns of every dump's root, which ends in the
+// export schema version, e.g. export-0.11/
+const exportNamespace = "http://www.mediawiki.org/xml/export-"
+
+// dumpSchema is how a dump writes its elements, as its <mediawiki> root
+// declares: in which XML namespace, under which prefix, and in which version
+// of the export schema. Wikimedia's dumps put every element in the default
+// namespace; other exports may use a prefix (<mw:page>) or none at all.
+type dumpSchema struct {
+	space   string // Namespace of the dump's elements, "" when none is declared
+	prefix  string // Prefix they are written with, e.g. "mw:", or ""
+	version string // Export schema version, e.g. "0.11", or "" when unknown
+}
+
+// is reports whether name is the dump element local. An element of another
+// namespace merely sharing the local name, as <x:page> might in an export
+// carrying foreign markup, is not a dump element.
+func (s dumpSchema) is(name xml.Name, local string) bool {
+	return name.Local == local && name.Space == s.space
+}
+
+// rootTag is a <mediawiki> start tag declaring the dump's namespace, for a
+// decoder that takes up the stream past the root, as after a lenient page
+func (s dumpSchema) rootTag() string {
+	if s.space == "" {
+		return "<mediawiki>"
+	}
+	var space strings.Builder
+	xml.EscapeText(&space, []byte(s.space))
+	return fmt.Sprintf(`<%smediawiki xmlns%s="%s">`, s.prefix, strings.TrimSuffix(":"+s.prefix, ":"), space.String())
+}
+
+// checkRoot makes sure the first element of the input is the <mediawiki>
+// root of a dump, so that an unrelated XML or HTML file fails at once rather
+// than yielding zero pages, and returns its schema. The version is the
+// root's version attribute, or else the one its namespace ends in.
+func checkRoot(start xml.StartElement) (dumpSchema, error) {
+	s := dumpSchema{space: start.Name.Space}
+	if start.Name.Local != "mediawiki" {
+		return s, fmt.Errorf("not a MediaWiki dump: the root element is <%s>, not <mediawiki>", start.Name.Local)
+	}
+	if s.space != "" && !strings.HasPrefix(s.space, exportNamespace) {
+		return s, fmt.Errorf("not a MediaWiki dump: the <mediawiki> namespace is %q, not %s<version>/", s.space, exportNamespace)
+	}
+	s.version = strings.TrimSuffix(strings.TrimPrefix(s.space, exportNamespace), "/")
+	defaultNS := false
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Local == "version" && a.Name.Space == "":
+			s.version = a.Value
+		case a.Name.Local == "xmlns" && a.Name.Space == "":
+			defaultNS = a.Value == s.space
+		case a.Name.Space == "xmlns" && a.Value == s.space && s.prefix == "":
+			s.prefix = a.Name.Local + ":"
+		}
+	}
+	if defaultNS {
+		s.prefix = "" // The root itself is unprefixed
+	}
+	return s, nil
+}
+
+// SiteInfo is the <siteinfo> block that opens every dump
+type SiteInfo struct {
+	SiteName   string      `xml:"sitename" json:"sitename"`               // e.g. "Wikipedia"
+	DBName     string      `xml:"dbname" json:"dbname"`                   // e.g. "enwiki"
+	Base       string      `xml:"base" json:"base"`                       // Main page URL
+	Generator  string      `xml:"generator" json:"generator"`             // MediaWiki version that wrote the dump
+	Case       string      `xml:"case" json:"case"`                       // "first-letter" or "case-sensitive"
+	Namespaces []Namespace `xml:"namespaces>namespace" json:"namespaces"` // Namespace table
+}
+
+// Namespace is one entry of the siteinfo namespace table
+type Namespace struct {
+	Key  int    `xml:"key,attr" json:"key"`   // Namespace number, as in <page><ns>
+	Case string `xml:"case,attr" json:"case"` // Case rule of titles in this namespace
+	Name string `xml:",chardata" json:"name"` // Localized prefix; "" for articles
+}
+
+// namespaceOf returns the namespace a title's prefix names in the siteinfo
+// table, as in "Talk:Apple", or 0: the namespace of a page whose dump gives
+// no <ns>
+func (s *SiteInfo) namespaceOf(title string) int {
+	prefix, _, ok := strings.Cut(title, ":")
+	if !ok || s == nil {
+		return 0
+	}
+	for _, ns := range s.Namespaces {
+		if ns.Name != "" && strings.EqualFold(ns.Name, prefix) {
+			return ns.Key
+		}
+	}
+	return 0
+}
+
+// caseSensitive reports whether the wiki keeps the first letter of titles as
+// written (Wiktionary), rather than uppercasing it (Wikipedia)
+func (s *SiteInfo) caseSensitive() bool {
+	return s != nil && s.Case == "case-sensitive"
+}
+
+// siteInfoWriter is implemented by writers that can carry the siteinfo as a record of their own
+type siteInfoWriter interface {
+	WriteSiteInfo(s *SiteInfo) error // Encode the siteinfo ahead of the docs
+}
+
+// WriteSiteInfo writes the siteinfo as a JSONL record told apart by its _type
+func (j *jsonlWriter) WriteSiteInfo(s *SiteInfo) error {
+	return j.enc.Encode(struct {
+		Type string `json:"_type"`
+		*SiteInfo
+	}{"siteinfo", s})
+}
+
+// useSiteInfo applies the dump's siteinfo once it is known, before the
+// first page: the title case rule, the -siteinfo-record and -siteinfo-out
+func useSiteInfo(cfg *config, s *SiteInfo, c *cleaner, w docWriter) error {
+	c.caseSensitive = s.caseSensitive()
+	cfg.TitleKey.caseSensitive = s.caseSensitive()
+	if s == nil {
+		return nil
+	}
+	if cfg.SiteInfoRecord {
+		if sw, ok := w.(siteInfoWriter); ok {
+			if err := sw.WriteSiteInfo(s); err != nil {
+				return fmt.Errorf("failed to write siteinfo: %w", err)
+			}
+		}
+	}
+	if cfg.SiteInfoOut != "" {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cfg.SiteInfoOut, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("failed to write siteinfo: %w", err)
+		}
+	}
+	return nil
+}
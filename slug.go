@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"strings" // Package for string manipulation
+	"unicode" // Package for rune classification
+)
+
+// SlugOptions controls what Slug does with letters outside the Latin script
+type SlugOptions struct {
+	HexScripts bool // Spell them as hex code points, leaving a pure ASCII slug
+}
+
+// latinFold maps the lowercase Latin-extended letters, and the Latin
+// ligatures and compatibility forms, to their ASCII spelling
+var latinFold = func() map[rune]string {
+	m := map[rune]string{}
+	for ascii, letters := range map[string]string{
+		"a": "àáâãäåāăąǎǟǡǻȁȃȧḁạảấầẩẫậắằẳẵặ", "ae": "æǣǽ", "b": "ƀḃḅḇ", "c": "çćĉċčḉ",
+		"d": "ðďđḋḍḏḑḓ", "dz": "ǆǳ", "e": "èéêëēĕėęěǝəɛȅȇȩḕḗḙḛḝẹẻẽếềểễệ", "f": "ƒḟ",
+		"ff": "ﬀ", "ffi": "ﬃ", "ffl": "ﬄ", "fi": "ﬁ", "fl": "ﬂ", "g": "ĝğġģǧǵḡ",
+		"h": "ĥħȟḣḥḧḩḫẖ", "i": "ìíîïĩīĭįıǐȉȋḭḯỉị", "ij": "ĳ", "j": "ĵǰ", "k": "ķĸƙǩḱḳḵ",
+		"l": "ĺļľŀłƚḷḹḻḽ", "lj": "ǉ", "m": "ḿṁṃ", "n": "ñńņňŉǹṅṇṉṋ", "ng": "ŋ", "nj": "ǌ",
+		"o": "òóôõöøǿōŏőơǒǫǭȍȏȫȭȯȱɔṍṏṑṓọỏốồổỗộớờởỡợ", "oe": "œ", "p": "ƥṕṗ",
+		"r": "ŕŗřȑȓṙṛṝṟ", "s": "śŝşšſșṡṣṥṧṩẛ", "ss": "ß", "st": "ﬅﬆ", "t": "ţťŧƭțṫṭṯṱẗ",
+		"th": "þ", "u": "ùúûüũūŭůűųưǔǖǘǚǜȕȗṳṵṷṹṻụủứừửữự", "v": "ṽṿ", "w": "ŵẁẃẅẇẉẘ",
+		"x": "ẋẍ", "y": "ýÿŷƴȳẏẙỳỵỷỹ", "z": "źżžƶẑẓẕ",
+	} {
+		for _, r := range letters {
+			m[r] = ascii
+		}
+	}
+	return m
+}()
+
+// Slug turns a title into a lowercase, hyphenated identifier that is safe in
+// file names and URLs, e.g. "Æthelred the Unready" → "aethelred-the-unready":
+//
+//  1. Fullwidth forms become their ASCII counterparts and the title is lowercased.
+//  2. Latin-extended letters and ligatures are transliterated (é → e, æ → ae,
+//     ß → ss) and combining accents after them are dropped.
+//  3. Letters and digits of other scripts are kept as they are, or with
+//     opts.HexScripts spelled as hyphen-separated hex code points (東京 → 6771-4eac).
+//  4. Apostrophes are dropped, and every other run of characters becomes one
+//     hyphen, none leading or trailing.
+//
+// A title with nothing left is "untitled".
+func Slug(title string, opts SlugOptions) string {
+	var b strings.Builder
+	gap := false   // A hyphen is due before the next word character
+	other := false // The last character was a letter of another script, which marks belong to
+	word := func(s string) {
+		if gap && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(s)
+		gap = false
+	}
+	for _, r := range title {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0 // Fullwidth ASCII
+		}
+		r = unicode.ToLower(r)
+		ascii, folded := latinFold[r]
+		switch {
+		case r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			word(string(r))
+			other = false
+		case folded:
+			word(ascii)
+			other = false
+		case unicode.IsMark(r) && !other:
+			// An accent to drop, or a mark with no letter to belong to
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			if opts.HexScripts {
+				word(fmt.Sprintf("%x", r))
+				gap = true
+			} else {
+				word(string(r))
+			}
+			other = true
+		case r == '\'' || r == '’':
+		default:
+			gap, other = true, false
+		}
+	}
+	if b.Len() == 0 {
+		return "untitled"
+	}
+	return b.String()
+}
+
+// slugger hands out the -slug of each doc. Repeats within the run get a
+// deterministic -2, -3, ... suffix in stream order, unless collisions are allowed.
+type slugger struct {
+	opts SlugOptions // How to spell other scripts
+	seen titleSet    // Slugs handed out so far; nil when collisions are allowed
+}
+
+// newSlugger builds the slugger of cfg
+func newSlugger(cfg *config) *slugger {
+	s := &slugger{opts: cfg.SlugOptions}
+	if cfg.SlugCollisions == "suffix" {
+		s.seen = exactSet{}
+	}
+	return s
+}
+
+// next returns the slug of title, unique among those handed out before
+func (s *slugger) next(title string) string {
+	slug := Slug(title, s.opts)
+	if s.seen == nil {
+		return slug
+	}
+	candidate := slug
+	for n := 2; !s.seen.addNew(candidate); n++ {
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return candidate
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"          // Package for byte slice readers
+	"compress/bzip2" // Package for bzip2 decompression
+	_ "embed"        // Package for embedding the sample dump
+	"fmt"            // Package for formatted I/O
+	"io"             // Package for I/O primitives
+	"net/http"       // Package for HTTP client functionality
+	"os"             // Package for OS functions (file access)
+	"strings"        // Package for string manipulation
+)
+
+// sampleDump is a ~200-page simplewiki-style multistream dump used by -demo.
+// It is regenerated with sample/gen_sample.py.
+//
+//go:embed sample/simplewiki-sample.xml.bz2
+var sampleDump []byte
+
+// sampleName labels the embedded dump in messages
+const sampleName = "embedded simplewiki sample"
+
+// isMultistream decides whether a dump is the multistream variant: many
+// concatenated bzip2 streams of 100 pages each, with a separate index.
+// "auto" goes by the name, as the dumps carry "multistream" in theirs.
+// Both variants decompress the same way; only index-based access differs.
+func isMultistream(mode, name string) (bool, error) {
+	switch mode {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	case "auto":
+		return strings.Contains(strings.ToLower(name), "multistream"), nil
+	}
+	return false, fmt.Errorf("unknown -multistream %q (want auto, yes or no)", mode)
+}
+
+// openInput returns the decompressed dump stream selected by cfg
+func openInput(cfg *config) (io.ReadCloser, error) {
+	if cfg.WithProvenance {
+		cfg.Sources = &inputRegistry{}
+	}
+	if cfg.Index != "" {
+		if in, err := openIndexedInput(cfg); in != nil || err != nil {
+			if cfg.Sources != nil {
+				cfg.Sources.add(inputName(cfg)) // Read in part, so without a checksum
+			}
+			return in, err
+		}
+	}
+
+	// 1. Open the raw (usually compressed) stream
+	var raw io.ReadCloser
+	name := cfg.URL
+	cfg.Progress = &inputProgress{}
+	switch {
+	case len(cfg.Inputs) > 1:
+		return openParts(cfg)
+	case cfg.Demo:
+		raw, name = io.NopCloser(bytes.NewReader(sampleDump)), "sample.xml.bz2"
+		cfg.Progress.size = int64(len(sampleDump))
+	case cfg.Input != "":
+		f, err := os.Open(cfg.Input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		raw, name = f, cfg.Input
+		if info, err := f.Stat(); err == nil {
+			cfg.Progress.size = info.Size()
+		}
+		if cfg.Follow {
+			if cfg.SizeFromStatus {
+				if cfg.ExpectedSize, err = dumpStatusSize(cfg); err != nil {
+					f.Close()
+					return nil, err
+				}
+			}
+			raw = readCloser{newFollowReader(f, cfg), f}
+			cfg.Progress.size = cfg.ExpectedSize // Unknown until the download is done
+		}
+	default:
+		// Send an HTTP GET request to download the compressed data
+		resp, err := httpGet(cfg.ctx(), cfg.URL, cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download dump: %w", err)
+		}
+		// Verify a successful HTTP response
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+		}
+		raw = resp.Body
+		cfg.Progress.size = resp.ContentLength
+	}
+	if cfg.Sources != nil {
+		raw = readCloser{cfg.Sources.add(inputName(cfg)).checksum(raw), raw}
+	}
+	counted := readCloser{progressReader{raw, cfg.Progress}, raw}
+
+	// 2. Decompress bzip2 on-the-fly; plain .xml files are read as-is. The
+	// reader continues across stream boundaries, so multistream and
+	// single-stream dumps alike decode as one XML document.
+	if !strings.HasSuffix(name, ".bz2") {
+		return counted, nil
+	}
+	if cfg.WithOffset || cfg.WithStreamIndex {
+		cfg.Streams = newStreamTracker()
+		return readCloser{cfg.Streams.decompressed(bzip2.NewReader(cfg.Streams.compressed(counted))), raw}, nil
+	}
+	return readCloser{bzip2.NewReader(counted), raw}, nil
+}
+
+// openParts returns the decompressed parts of a comma-separated -input as
+// one stream, each part still one XML document of its own
+func openParts(cfg *config) (io.ReadCloser, error) {
+	reg := cfg.Sources
+	if reg == nil {
+		reg = &inputRegistry{} // The parts are counted all the same
+	}
+	pr := &partsReader{}
+	var files closers
+	for _, name := range cfg.Inputs {
+		f, err := os.Open(name)
+		if err != nil {
+			files.Close()
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		files = append(files, f)
+		if info, err := f.Stat(); err == nil {
+			cfg.Progress.size += info.Size()
+		}
+		src := reg.add(name)
+		var r io.Reader = f
+		if cfg.Sources != nil {
+			r = src.checksum(r)
+		}
+		r = progressReader{r, cfg.Progress}
+		if strings.HasSuffix(name, ".bz2") {
+			r = bzip2.NewReader(r)
+		}
+		pr.parts, pr.srcs = append(pr.parts, r), append(pr.srcs, src)
+	}
+	return readCloser{pr, files}, nil
+}
+
+// readCloser pairs a wrapping reader with the closer of the stream it wraps
+type readCloser struct {
+	io.Reader           // Decoded stream
+	c         io.Closer // Underlying stream to close
+}
+
+func (r readCloser) Close() error {
+	return r.c.Close()
+}
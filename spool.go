@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"         // Package for buffered I/O
+	"encoding/gob"  // Package for the segment records
+	"errors"        // Package for error inspection
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for segment paths
+	"sort"          // Package for ordering leftover segments
+	"sync"          // Package for the producer/consumer handoff
+	"time"          // Package for sealing idle segments
+)
+
+// spoolSealAfter seals a segment that has been open this long, so a slow
+// trickle of docs still reaches the sink instead of waiting for a full segment
+const spoolSealAfter = 2 * time.Second
+
+// spoolWriter decouples extraction from a slow sink (-spool). WriteDoc appends
+// docs to segment files in <workdir>/spool and returns at disk speed; a
+// goroutine drains sealed segments into the real writer at whatever rate it
+// sustains and deletes each once sent. Past -spool-max-mb of unsent segments
+// WriteDoc waits, so the disk is bounded. Segments left behind by a failed or
+// killed run are sent first when the next run uses the same -workdir: delivery
+// is at least once, as a segment cut off mid-way is sent again whole.
+type spoolWriter struct {
+	next     docWriter // Real writer, fed by the drain goroutine only
+	dir      string    // Segment directory
+	segBytes int64     // Size at which the open segment is sealed
+	maxBytes int64     // Unsent bytes past which WriteDoc waits
+	opts     *Options  // Clock and progress ticker
+
+	// Producer side, touched by WriteDoc and Close only
+	cur     *os.File      // Open segment
+	curBuf  *bufio.Writer // Its buffer
+	enc     *gob.Encoder  // Its record encoder
+	curSize int64         // Bytes encoded into it
+	opened  time.Time     // When it was created
+	seq     int           // Number of the next segment
+
+	mu       sync.Mutex    // Guards the fields below
+	cond     *sync.Cond    // Signals sealed segments, freed space, errors and the end
+	sealed   []string      // Segments ready to send, oldest first
+	unsent   int64         // Bytes of unsent segments, the open one included
+	queued   int           // Docs this run spooled
+	sent     int           // Docs handed to the real writer, recovered ones included
+	finished bool          // No more segments will be sealed
+	stop     bool          // Drain must stop now, leaving segments on disk
+	err      error         // First error of the real writer
+	done     chan struct{} // Closed when the drain goroutine exits
+
+	recovered   int   // Docs sent from a previous run's segments
+	recoveredID int64 // Highest page ID among them
+}
+
+// newSpoolWriter sets up the spool in front of next, picking up the segments
+// a previous run in the same workdir left unsent
+func newSpoolWriter(next docWriter, cfg *config) (*spoolWriter, error) {
+	if err := cfg.Work.setup(); err != nil {
+		return nil, err
+	}
+	s := &spoolWriter{
+		next:     next,
+		dir:      filepath.Join(cfg.Work.path, "spool"),
+		segBytes: cfg.SpoolSegment << 20,
+		maxBytes: cfg.SpoolMax << 20,
+		opts:     &cfg.Options,
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool: %w", err)
+	}
+	leftover, err := filepath.Glob(filepath.Join(s.dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(leftover)
+	for _, name := range leftover {
+		if fi, err := os.Stat(name); err == nil {
+			s.unsent += fi.Size()
+		}
+		fmt.Sscanf(filepath.Base(name), "%d.seg", &s.seq)
+		s.seq++
+	}
+	if len(leftover) > 0 {
+		fmt.Fprintf(os.Stderr, "Spool: sending %d segments (%s) a previous run left unsent first\n", len(leftover), formatBytes(s.unsent))
+	}
+	s.sealed = leftover
+	go s.drain(len(leftover))
+	return s, nil
+}
+
+// WriteDoc appends doc to the open segment, waiting while the spool is full
+func (s *spoolWriter) WriteDoc(doc *Doc) error {
+	s.mu.Lock()
+	full := s.unsent >= s.maxBytes
+	s.mu.Unlock()
+	if full {
+		if err := s.seal(); err != nil { // The open segment must be sendable to free space
+			return err
+		}
+	}
+	s.mu.Lock()
+	for s.unsent >= s.maxBytes && s.err == nil {
+		s.cond.Wait()
+	}
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.cur == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	before := s.curSize
+	if err := s.enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to spool doc: %w", err)
+	}
+	s.mu.Lock()
+	s.unsent += s.curSize - before
+	s.queued++
+	s.mu.Unlock()
+	if s.curSize >= s.segBytes || s.opts.NowFunc().Sub(s.opened) >= spoolSealAfter {
+		return s.seal()
+	}
+	return nil
+}
+
+// open starts a new segment
+func (s *spoolWriter) open() error {
+	f, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("%012d.seg", s.seq)))
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	s.seq++
+	s.cur, s.curSize, s.opened = f, 0, s.opts.NowFunc()
+	s.curBuf = bufio.NewWriter(f)
+	s.enc = gob.NewEncoder(writerFunc(func(b []byte) (int, error) {
+		n, err := s.curBuf.Write(b)
+		s.curSize += int64(n)
+		return n, err
+	}))
+	return nil
+}
+
+// seal closes the open segment and hands it to the drain goroutine
+func (s *spoolWriter) seal() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.curBuf.Flush()
+	if cerr := s.cur.Close(); err == nil {
+		err = cerr
+	}
+	name := s.cur.Name()
+	s.cur = nil
+	if err != nil {
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	s.mu.Lock()
+	s.sealed = append(s.sealed, name)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// drain sends sealed segments to the real writer until the producer has
+// finished and nothing is left, the writer fails, or abort stops it. The
+// first recovered segments come from a previous run.
+func (s *spoolWriter) drain(recovered int) {
+	defer close(s.done)
+	for n := 0; ; n++ {
+		s.mu.Lock()
+		for len(s.sealed) == 0 && !s.finished && !s.stop {
+			s.cond.Wait()
+		}
+		if s.stop || len(s.sealed) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		name := s.sealed[0]
+		s.mu.Unlock()
+
+		size, err := s.send(name, n < recovered)
+		s.mu.Lock()
+		if err != nil {
+			if s.err == nil {
+				s.err = err
+			}
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return
+		}
+		s.sealed = s.sealed[1:]
+		s.unsent -= size
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		os.Remove(name)
+	}
+}
+
+// send writes every doc of one segment to the real writer and returns the
+// segment's size. A segment whose last record is cut off, as a killed run
+// leaves it, ends at the last whole doc.
+func (s *spoolWriter) send(name string, recovered bool) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		s.mu.Lock()
+		stop := s.stop
+		s.mu.Unlock()
+		if stop {
+			return 0, errSpoolStopped
+		}
+		var doc Doc
+		err := dec.Decode(&doc)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fi.Size(), nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("corrupt spool segment %s: %w", name, err)
+		}
+		if err := s.next.WriteDoc(&doc); err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+		s.sent++
+		if recovered {
+			s.recovered++
+			s.recoveredID = max(s.recoveredID, doc.ID)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// errSpoolStopped ends the drain of a failed run; its segments stay on disk
+var errSpoolStopped = errors.New("spool drain stopped")
+
+// Close seals the last segment, waits for the spool to drain while reporting
+// progress, then closes the real writer and removes the emptied spool
+func (s *spoolWriter) Close() error {
+	if err := s.seal(); err != nil {
+		s.abort()
+		return err
+	}
+	s.mu.Lock()
+	s.finished = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	ticks, stopTicks := s.opts.Ticker(time.Second)
+	defer stopTicks()
+	for waiting := true; waiting; {
+		select {
+		case <-s.done:
+			waiting = false
+		case <-ticks:
+			s.mu.Lock()
+			fmt.Fprintf(os.Stderr, "Draining spool: %d of %d docs sent, %s on disk\n", s.sent-s.recovered, s.queued, formatBytes(s.unsent))
+			s.mu.Unlock()
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if s.recovered > 0 {
+		fmt.Fprintf(os.Stderr, "Spool: sent %d docs a previous run left unsent, up to page ID %d\n", s.recovered, s.recoveredID)
+	}
+	if err := s.next.Close(); err != nil {
+		return err
+	}
+	os.Remove(s.dir)
+	return nil
+}
+
+// abort stops the drain after the doc being sent and keeps the unsent
+// segments, the open one included, for the next run in the same workdir
+func (s *spoolWriter) abort() {
+	if s.cur != nil {
+		s.curBuf.Flush()
+		s.cur.Close()
+		s.cur = nil
+	}
+	s.mu.Lock()
+	s.stop = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	<-s.done
+}
+
+// writerFunc adapts a function into an io.Writer
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
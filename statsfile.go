@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"       // Package for recognising -timeout
+	"encoding/json" // Package for JSON encoding
+	"errors"        // Package for error inspection
+	"fmt"           // Package for formatted I/O
+	"os"            // Package for OS functions (file access)
+	"os/signal"     // Package for signal notification
+	"sync/atomic"   // Package for the stop flag
+	"syscall"       // Package for signal numbers
+	"time"          // Package for the run duration
+)
+
+// runStats is the -stats-file record: every counter of the run as one flat
+// JSON object, so CI can assert on thresholds such as the redirect ratio
+type runStats struct {
+	Status          string            `json:"status"`                   // As in the manifest, plus "interrupted"
+	Error           string            `json:"error,omitempty"`          // Why the run stopped, if it failed
+	Seen            int               `json:"seen"`                     // <page> elements read, undecodable ones included
+	Pages           int               `json:"pages"`                    // Pages decoded
+	DumpVersion     string            `json:"dump_version,omitempty"`   // Export schema version of the dump
+	NoNS            int               `json:"pages_without_ns"`         // Pages whose namespace came from the title, lacking <ns>
+	Written         int               `json:"written"`                  // Docs or redirects written
+	Filtered        int               `json:"filtered"`                 // Pages dropped by namespace, redirect, template, -bbox or ID filters
+	OutOfRange      int               `json:"out_of_range"`             // Of those, pages outside -min-id/-max-id
+	ShardPages      int               `json:"shard_pages,omitempty"`    // Pages in range that belong to the -shard-index
+	Empty           int               `json:"empty"`                    // Pages with an empty abstract
+	LowScore        int               `json:"low_score"`                // Pages below -min-score
+	Duplicates      int               `json:"duplicates"`               // Pages dropped by -dedup
+	InvalidURLs     int               `json:"invalid_urls"`             // Docs whose URL failed -validate-urls
+	Vetoed          int               `json:"middleware_vetoed"`        // Docs a DocMiddleware vetoed
+	ChainErrors     int               `json:"middleware_errors"`        // Docs skipped on DocMiddleware errors
+	TimedOut        int               `json:"timed_out"`                // Pages past -page-timeout
+	DecodeErrors    int               `json:"decode_errors"`            // Pages that failed to decode
+	ErrorKinds      map[string]int    `json:"error_kinds,omitempty"`    // DecodeErrors per kind
+	Anomalies       map[string]int    `json:"anomalies,omitempty"`      // Dump anomalies per kind
+	TemplateHits    map[string]int    `json:"template_hits,omitempty"`  // Pages matched per template filter rule
+	GeoTagged       int               `json:"geo_tagged,omitempty"`     // Pages -bbox found a {{coord}} in
+	GeoPassed       int               `json:"geo_passed,omitempty"`     // Of those, pages inside the box
+	LengthClasses   map[string]int    `json:"length_classes,omitempty"` // Written docs per -classify class
+	QIDMatched      int               `json:"qid_matched"`              // Docs given a wikidata_id
+	QIDUnmatched    int               `json:"qid_unmatched"`            // Docs without one
+	Sampled         int               `json:"sampled"`                  // Docs kept by -sample-k
+	Enriched        int               `json:"enriched"`                 // -enrich-summary requests
+	EnrichFailed    int               `json:"enrich_failed"`            // Of those, failures
+	CacheHits       int               `json:"cache_hits"`               // Pages -cache had the result of
+	CacheMisses     int               `json:"cache_misses"`             // Pages cleaned and added to -cache
+	Truncated       bool              `json:"truncated"`                // The stream ended before </mediawiki>
+	OutputCapped    bool              `json:"output_capped"`            // The run stopped at -max-output-bytes
+	Skipped         int               `json:"skipped"`                  // Docs dropped by -skip
+	LimitReached    bool              `json:"limit_reached"`            // The run stopped at -limit
+	SimilarChecked  int               `json:"similar_checked"`          // -similarity candidate pairs scored
+	SimilarPairs    int               `json:"similar_pairs"`            // Of those, pairs written
+	InputBytes      int64             `json:"input_bytes"`              // Raw dump bytes read
+	OutputBytes     int64             `json:"output_bytes"`             // Bytes handed to the output, before compression
+	DurationSeconds float64           `json:"duration_seconds"`         // Wall time of the run
+	PagesPerSecond  float64           `json:"pages_per_second"`         // Seen over the duration
+	Products        []productRecord   `json:"products,omitempty"`       // -products side files and their counts
+	Top             *outliers         `json:"top,omitempty"`            // -top-n lists, largest first
+	RevisionAge     *revisionAgeStats `json:"revision_age,omitempty"`   // -revision-age percentiles
+}
+
+// runStatus names how a run ended, for the manifest and the stats file
+func runStatus(runErr error) string {
+	var exitErr *exitCodeError
+	switch {
+	case runErr == nil:
+		return "ok"
+	case errors.As(runErr, &exitErr) && exitErr.code == exitErrorBudget:
+		return "error_budget_exceeded"
+	case errors.As(runErr, &exitErr) && exitErr.code == exitIncomplete:
+		return "incomplete"
+	case errors.As(runErr, &exitErr) && exitErr.code == exitAnomaly:
+		return "anomalies"
+	case errors.Is(runErr, ErrCancelled):
+		return "interrupted"
+	case errors.Is(runErr, context.DeadlineExceeded):
+		return "timed_out"
+	}
+	return "failed"
+}
+
+// writeStatsFile records the counters of a finished, failed or interrupted run in cfg.StatsFile
+func writeStatsFile(cfg *config, st *stats, started time.Time, outputBytes *atomic.Int64, runErr error) error {
+	if st == nil {
+		st = &stats{}
+	}
+	elapsed := cfg.NowFunc().Sub(started).Seconds()
+	rs := runStats{
+		Status:          runStatus(runErr),
+		Seen:            st.Pages + st.DecodeErrors,
+		Pages:           st.Pages,
+		DumpVersion:     st.DumpVersion,
+		NoNS:            st.NoNS,
+		Written:         st.Written,
+		Filtered:        st.Filtered,
+		OutOfRange:      st.OutOfRange,
+		ShardPages:      st.ShardPages,
+		Empty:           st.Empty,
+		LowScore:        st.LowScore,
+		Duplicates:      st.Duplicates,
+		InvalidURLs:     st.InvalidURLs,
+		Vetoed:          st.Vetoed,
+		ChainErrors:     st.ChainErrors,
+		TimedOut:        st.TimedOut,
+		DecodeErrors:    st.DecodeErrors,
+		ErrorKinds:      st.ErrorKinds,
+		Anomalies:       st.Anomalies.counts,
+		TemplateHits:    st.TemplateHits,
+		GeoTagged:       st.GeoTagged,
+		GeoPassed:       st.GeoPassed,
+		LengthClasses:   st.Classes,
+		QIDMatched:      st.QIDMatched,
+		QIDUnmatched:    st.QIDUnmatched,
+		Sampled:         st.Sampled,
+		Enriched:        st.Enriched,
+		EnrichFailed:    st.EnrichFailed,
+		CacheHits:       st.CacheHits,
+		CacheMisses:     st.CacheMisses,
+		Truncated:       st.Truncated,
+		OutputCapped:    st.OutputCapped,
+		Skipped:         st.Skipped,
+		LimitReached:    st.LimitReached,
+		SimilarChecked:  st.SimilarChecked,
+		SimilarPairs:    st.SimilarPairs,
+		Products:        st.Products,
+		Top:             st.Top,
+		OutputBytes:     outputBytes.Load(),
+		DurationSeconds: elapsed,
+	}
+	if runErr != nil {
+		rs.Error = runErr.Error()
+	}
+	if st.Ages != nil {
+		rs.RevisionAge = st.Ages.record()
+	}
+	if cfg.Progress != nil {
+		rs.InputBytes = cfg.Progress.read
+	}
+	if elapsed > 0 {
+		rs.PagesPerSecond = float64(rs.Seen) / elapsed
+	}
+	data, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfg.StatsFile, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	return nil
+}
+
+// stopOnSignal makes SIGINT/SIGTERM end the run after the page being
+// processed rather than killing the process, so the deferred -stats-file
+// and -manifest still get written and -atomic removes its .tmp file. The
+// signal also cancels the run's context with errInterrupted, which ends a
+// read blocked on a stalled download or a -follow input; a second signal
+// exits at once. The returned func uninstalls the handler.
+func stopOnSignal(cfg *config) func() {
+	parent := cfg.Ctx
+	ctx, cancel := context.WithCancelCause(cfg.ctx())
+	cfg.Ctx, cfg.Stop = ctx, &atomic.Bool{}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range sigs {
+			if cfg.Stop.Swap(true) {
+				fmt.Fprintln(os.Stderr, "error:", errInterrupted, "(twice, exiting now)")
+				os.Exit(130)
+			}
+			cancel(errInterrupted)
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+		cancel(nil)
+		cfg.Ctx = parent
+	}
+}
+
+// signalled reports whether ctx ended because a signal stopped the run,
+// rather than by -timeout
+func signalled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
+
+// stopped reports whether a signal asked the run to wind down
+func (cfg *config) stopped() bool {
+	return cfg.Stop != nil && cfg.Stop.Load()
+}
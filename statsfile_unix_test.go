@@ -0,0 +1,112 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json" // Package for reading the stats file back
+	"errors"        // Package for error inspection
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for file path manipulation
+	"strconv"       // Package for the stream offset
+	"strings"       // Package for string manipulation
+	"sync"          // Package for signalling the first poll once
+	"syscall"       // Package for sending the signal
+	"testing"       // Package for tests
+	"time"          // Package for timeouts
+)
+
+// TestSignalEndsBlockedRun sends SIGTERM while -follow waits for the rest of
+// a dump, with -atomic alone and with -stats-file: the run must stop with
+// status 130 and remove its .tmp file rather than wait for input forever
+func TestSignalEndsBlockedRun(t *testing.T) {
+	dump, err := os.ReadFile("sample/simplewiki-sample.xml.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the dump where its last stream starts, so the pages before it are
+	// read and the follow reader then waits for the rest
+	index, err := os.ReadFile("sample/simplewiki-sample-index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(index)), "\n")
+	offset, _, _ := strings.Cut(lines[len(lines)-1], ":")
+	cut, err := strconv.Atoi(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, withStats := range []bool{false, true} {
+		name := "atomic"
+		if withStats {
+			name = "atomic-stats-file"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "growing.xml.bz2")
+			if err := os.WriteFile(input, dump[:cut], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			out, statsFile := filepath.Join(dir, "pub.jsonl"), filepath.Join(dir, "st.json")
+			args := []string{"-input", input, "-follow", "-follow-grace", "1h", "-atomic",
+				"-plain", "-format", "jsonl", "-o", out}
+			if withStats {
+				args = append(args, "-stats-file", statsFile)
+			}
+			cfg, err := parseFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			waiting := make(chan struct{})
+			var once sync.Once
+			cfg.Sleep = func(time.Duration) {
+				once.Do(func() { close(waiting) })
+				time.Sleep(10 * time.Millisecond)
+			}
+			done := make(chan error, 1)
+			go func() { done <- run(cfg) }()
+
+			select {
+			case <-waiting:
+			case err := <-done:
+				t.Fatalf("the run ended before reaching the end of the input: %v", err)
+			case <-time.After(30 * time.Second):
+				t.Fatal("the run never waited for more input")
+			}
+			if _, err := os.Stat(out + ".tmp"); err != nil {
+				t.Fatalf("no .tmp file while the run waits: %v", err)
+			}
+			if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the run did not stop on SIGTERM")
+			}
+
+			var exitErr *exitCodeError
+			if !errors.As(err, &exitErr) || exitErr.code != 130 {
+				t.Fatalf("run returned %v, want exit status 130", err)
+			}
+			for _, path := range []string{out + ".tmp", out} {
+				if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s survived the interrupt (%v)", filepath.Base(path), err)
+				}
+			}
+			if !withStats {
+				return
+			}
+			data, err := os.ReadFile(statsFile)
+			if err != nil {
+				t.Fatalf("no stats file: %v", err)
+			}
+			var rs runStats
+			if err := json.Unmarshal(data, &rs); err != nil {
+				t.Fatal(err)
+			}
+			if rs.Status != "interrupted" || rs.Pages == 0 {
+				t.Errorf("stats file has status %q after %d pages, want interrupted after some", rs.Status, rs.Pages)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes" // Package for searching the compressed window
+	"io"    // Package for I/O primitives
+)
+
+// bz2BlockMagic follows the "BZh1".."BZh9" header that starts a bzip2 stream
+var bz2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+
+// bz2Slack covers the compressed bytes the decompressor has read ahead of what
+// it decoded (compress/bzip2 reads through a 4 KiB buffer)
+const bz2Slack = 8 << 10
+
+// streamTracker finds the compressed offset of the bzip2 stream each
+// decompressed byte came from (-with-offset). compress/bzip2 decodes a whole
+// block before returning any of it and never returns two blocks from one
+// Read, so a Read that pulls compressed bytes has started a new block; when
+// a stream header lies where that block began, it starts a stream. Stream
+// headers are byte-aligned, so in a multistream dump the offsets are exact
+// and match the dump's index; a single-stream dump is one stream at 0.
+type streamTracker struct {
+	raw      int64           // Compressed bytes pulled by the decompressor
+	window   []byte          // The latest compressed bytes, ending at raw
+	current  int64           // Start of the stream being decoded (-1: none yet)
+	index    int             // Its ordinal among the streams, from 0
+	out      int64           // Decompressed bytes produced
+	segments []streamSegment // Decompressed ranges not looked up yet, oldest first
+}
+
+// streamSegment is a decompressed range ending at end, from the stream at stream
+type streamSegment struct {
+	end    int64 // Decompressed offset just past the range
+	stream int64 // Compressed offset of its stream
+	index  int   // Ordinal of its stream
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{current: -1}
+}
+
+// compressed wraps the stream the decompressor reads, remembering its bytes
+func (t *streamTracker) compressed(r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		t.window = append(t.window, p[:n]...)
+		t.raw += int64(n)
+		if len(t.window) > 4<<20 {
+			t.window = append(t.window[:0], t.window[len(t.window)-2<<20:]...)
+		}
+		return n, err
+	})
+}
+
+// decompressed wraps the decompressor's output, attributing it to streams
+func (t *streamTracker) decompressed(r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		before := t.raw
+		n, err := r.Read(p)
+		if t.raw != before {
+			t.newBlock(before)
+		}
+		if n > 0 {
+			t.out += int64(n)
+			if last := len(t.segments) - 1; last >= 0 && t.segments[last].stream == t.current {
+				t.segments[last].end = t.out
+			} else {
+				t.segments = append(t.segments, streamSegment{end: t.out, stream: t.current, index: t.index})
+			}
+		}
+		return n, err
+	})
+}
+
+// newBlock looks for a stream header near before, where the block just read began
+func (t *streamTracker) newBlock(before int64) {
+	windowStart := t.raw - int64(len(t.window))
+	from := max(before-bz2Slack, t.current+1, windowStart)
+	to := min(before+bz2Slack, t.raw)
+	for pos := from; pos+10 <= to; pos++ {
+		i := bytes.Index(t.window[pos-windowStart:to-windowStart], []byte("BZh"))
+		if i < 0 {
+			break
+		}
+		pos += int64(i)
+		w := t.window[pos-windowStart:]
+		if len(w) >= 10 && w[3] >= '1' && w[3] <= '9' && bytes.Equal(w[4:10], bz2BlockMagic) {
+			if t.current >= 0 {
+				t.index++
+			}
+			t.current = pos
+			return
+		}
+	}
+	t.current = max(t.current, 0) // A block continuing the current stream
+}
+
+// lookup returns the stream offset and ordinal of the decompressed byte at
+// off. Offsets must be looked up in increasing order, as earlier ranges are
+// forgotten.
+func (t *streamTracker) lookup(off int64) (int64, int) {
+	for len(t.segments) > 1 && t.segments[0].end <= off {
+		t.segments = t.segments[1:]
+	}
+	if len(t.segments) == 0 {
+		return max(t.current, 0), t.index
+	}
+	return t.segments[0].stream, t.segments[0].index
+}
+
+// readerFunc adapts a function to io.Reader
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"     // Package for formatted I/O
+	"strings" // Package for string manipulation
+)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// templateFilter keeps or drops pages by the templates they invoke
+type templateFilter struct {
+	has []string // Page must invoke at least one of these (-has-template)
+	not []string // Page must invoke none of these (-not-template)
+}
+
+// newTemplateFilter normalizes the flag values the way template names are resolved
+func newTemplateFilter(cfg *config) *templateFilter {
+	f := &templateFilter{}
+	for _, name := range cfg.HasTemplates {
+		f.has = append(f.has, templateName(name))
+	}
+	for _, name := range cfg.NotTemplates {
+		f.not = append(f.not, templateName(name))
+	}
+	return f
+}
+
+// active reports whether any template rule was given
+func (f *templateFilter) active() bool {
+	return len(f.has) > 0 || len(f.not) > 0
+}
+
+// keep reports whether a page passes the rules, counting each rule's
+// matches into st. Only real invocations count: names in prose, comments
+// or <nowiki> are not templates.
+func (f *templateFilter) keep(c *cleaner, text string, st *stats) bool {
+	text = commentRe.ReplaceAllString(text, "")
+	text = nowikiRe.ReplaceAllString(text, "")
+	invoked := map[string]bool{}
+	for _, t := range c.templates(text) {
+		invoked[t.Name] = true
+	}
+	if st.TemplateHits == nil {
+		st.TemplateHits = map[string]int{}
+	}
+	keep := len(f.has) == 0
+	for _, name := range f.has {
+		if invoked[name] {
+			st.TemplateHits["-has-template "+name]++
+			keep = true
+		}
+	}
+	for _, name := range f.not {
+		if invoked[name] {
+			st.TemplateHits["-not-template "+name]++
+			keep = false
+		}
+	}
+	return keep
+}
+
+// summary lists each rule with the number of pages it matched, in flag order
+func (f *templateFilter) summary(st *stats) string {
+	var parts []string
+	for _, name := range f.has {
+		parts = append(parts, fmt.Sprintf("-has-template %s=%d", name, st.TemplateHits["-has-template "+name]))
+	}
+	for _, name := range f.not {
+		parts = append(parts, fmt.Sprintf("-not-template %s=%d", name, st.TemplateHits["-not-template "+name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"         // Package for sorting slices
+	"strings"      // Package for string manipulation
+	"unicode"      // Package for rune classification
+	"unicode/utf8" // Package for UTF-8 decoding
+)
+
+// template is one parsed {{name|param|...}} invocation
+type template struct {
+	Name   string   // Normalized name (see templateName)
+	Params []string // Raw parameters in order, split at top-level pipes
+}
+
+// templates returns every template invocation in text, nested ones
+// included, in the order their opening braces appear. It is a single pass:
+// each open template records the pipes at its own level, so deeply nested
+// input costs linear time rather than one rescan per level. Templates nested
+// deeper than maxDepth are not reported; they stay as raw text inside the
+// parameter of the deepest template that is.
+func (c *cleaner) templates(text string) []template {
+	type frame struct {
+		start     int   // Offset of the opening braces
+		pipes     []int // Offsets of this template's own parameter separators
+		linkDepth int   // [[...]] nesting inside this template
+		computed  bool  // The name itself contains a template, e.g. {{ {{x}} }}
+	}
+	type found struct {
+		start int
+		t     template
+	}
+	var stack []*frame
+	var out []found
+	overflow := 0 // Open templates beyond maxDepth
+	for i := 0; i < len(text); i++ {
+		if c.tick() {
+			return nil
+		}
+		switch {
+		case overflow > 0 && strings.HasPrefix(text[i:], "{{"):
+			overflow++
+			i++
+		case overflow > 0 && strings.HasPrefix(text[i:], "}}"):
+			overflow--
+			i++
+		case overflow > 0:
+		case len(stack) >= c.maxDepth && strings.HasPrefix(text[i:], "{{"):
+			overflow++
+			i++
+		case strings.HasPrefix(text[i:], "{{"):
+			if len(stack) > 0 && len(stack[len(stack)-1].pipes) == 0 {
+				stack[len(stack)-1].computed = true
+			}
+			stack = append(stack, &frame{start: i})
+			i++
+		case len(stack) > 0 && strings.HasPrefix(text[i:], "}}"):
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			bounds := append(append([]int{f.start + 1}, f.pipes...), i)
+			parts := make([]string, len(bounds)-1)
+			for k := range parts {
+				parts[k] = text[bounds[k]+1 : bounds[k+1]]
+			}
+			name := "" // Computed names cannot be resolved statically
+			if !f.computed {
+				name = templateName(parts[0])
+			}
+			out = append(out, found{f.start, template{Name: name, Params: parts[1:]}})
+			i++
+		case len(stack) == 0:
+		case strings.HasPrefix(text[i:], "[["):
+			stack[len(stack)-1].linkDepth++
+			i++
+		case strings.HasPrefix(text[i:], "]]") && stack[len(stack)-1].linkDepth > 0:
+			stack[len(stack)-1].linkDepth--
+			i++
+		case text[i] == '|' && stack[len(stack)-1].linkDepth == 0:
+			f := stack[len(stack)-1]
+			f.pipes = append(f.pipes, i)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].start < out[b].start })
+	templates := make([]template, len(out))
+	for k, f := range out {
+		templates[k] = f.t
+	}
+	return templates
+}
+
+// templateName normalizes a template name the way MediaWiki resolves it:
+// surrounding space trimmed, underscores as spaces, first letter uppercased
+func templateName(raw string) string {
+	name := strings.Join(strings.Fields(strings.ReplaceAll(raw, "_", " ")), " ")
+	name = strings.TrimPrefix(name, "Template:")
+	r, size := utf8.DecodeRuneInString(name)
+	if r == utf8.RuneError {
+		return name
+	}
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// positional returns the trimmed unnamed parameters
+func (t template) positional() []string {
+	var out []string
+	for _, p := range t.Params {
+		if _, _, ok := splitNamed(p); !ok {
+			out = append(out, strings.TrimSpace(p))
+		}
+	}
+	return out
+}
+
+// named returns the trimmed value of the parameter called key
+func (t template) named(key string) (string, bool) {
+	for _, p := range t.Params {
+		if k, v, ok := splitNamed(p); ok && strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// splitNamed splits a "key = value" parameter; the '=' must not sit inside nested markup
+func splitNamed(param string) (key, value string, ok bool) {
+	eq := strings.IndexByte(param, '=')
+	if eq < 0 {
+		return "", "", false
+	}
+	if nested := strings.IndexAny(param, "{["); nested >= 0 && nested < eq {
+		return "", "", false
+	}
+	return strings.TrimSpace(param[:eq]), strings.TrimSpace(param[eq+1:]), true
+}
+
+// splitTopLevel splits s at sep, ignoring separators inside {{...}} and [[...]]
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "[["):
+			depth++
+			i++
+		case depth > 0 && (strings.HasPrefix(s[i:], "}}") || strings.HasPrefix(s[i:], "]]")):
+			depth--
+			i++
+		case depth == 0 && s[i] == sep:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
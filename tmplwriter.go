@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"                      // Package for byte buffers
+	"encoding/json"              // Package for the json helper
+	"encoding/xml"               // Package for the xmlescape helper
+	"fmt"                        // Package for formatted I/O
+	"io"                         // Package for I/O primitives
+	"os"                         // Package for OS functions (file access)
+	"path/filepath"              // Package for file path manipulation
+	"strings"                    // Package for string manipulation
+	texttemplate "text/template" // Package for user-supplied output templates
+	"unicode/utf8"               // Package for UTF-8 decoding
+)
+
+// docTemplates are the parsed user templates behind -format template
+type docTemplates struct {
+	doc    *texttemplate.Template // Rendered once per doc (-template)
+	header *texttemplate.Template // Rendered once before the first doc (-template-header)
+	footer *texttemplate.Template // Rendered once after the last doc (-template-footer)
+	perDoc *texttemplate.Template // Path of each doc's own file (-template-out-per-doc)
+}
+
+// templateFuncs are the helpers available to every user template; slug
+// follows -slug-scripts but, being a pure function, never numbers collisions
+func templateFuncs(cfg *config) texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"xmlescape": func(s string) string {
+			var b strings.Builder
+			xml.EscapeText(&b, []byte(s))
+			return b.String()
+		},
+		"trunc": func(n int, s string) string {
+			if utf8.RuneCountInString(s) <= n {
+				return s
+			}
+			return string([]rune(s)[:n]) + "…"
+		},
+		"slug": func(s string) string { return Slug(s, cfg.SlugOptions) },
+	}
+}
+
+// loadDocTemplates parses the template files named in cfg, so mistakes fail at startup
+func loadDocTemplates(cfg *config) (*docTemplates, error) {
+	if cfg.Template == "" {
+		return nil, fmt.Errorf("-format template needs -template FILE")
+	}
+	parse := func(path string) (*texttemplate.Template, error) {
+		if path == "" {
+			return nil, nil
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		return texttemplate.New(filepath.Base(path)).Funcs(templateFuncs(cfg)).Option("missingkey=error").Parse(string(text))
+	}
+	t := &docTemplates{}
+	var err error
+	if t.doc, err = parse(cfg.Template); err != nil {
+		return nil, err
+	}
+	if t.header, err = parse(cfg.TemplateHeader); err != nil {
+		return nil, err
+	}
+	if t.footer, err = parse(cfg.TemplateFooter); err != nil {
+		return nil, err
+	}
+	if cfg.TemplatePerDoc != "" {
+		if t.perDoc, err = texttemplate.New("-template-out-per-doc").Funcs(templateFuncs(cfg)).Parse(cfg.TemplatePerDoc); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// templateWriter renders each doc through the user's template, either into
+// the output stream or into a file of its own, whose path then goes to the stream
+type templateWriter struct {
+	w      io.Writer       // Destination stream
+	t      *docTemplates   // Parsed templates
+	used   map[string]bool // Per-doc paths already written, lowercased
+	failed int             // Docs skipped because their template failed
+	atomic bool            // -atomic: per-doc files are renamed into place once written
+}
+
+func newTemplateWriter(w io.Writer, cfg *config) (docWriter, error) {
+	t := cfg.Templates
+	if t == nil {
+		var err error
+		if t, err = loadDocTemplates(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if t.header != nil {
+		if err := t.header.Execute(w, nil); err != nil {
+			return nil, fmt.Errorf("template header: %w", err)
+		}
+	}
+	return &templateWriter{w: w, t: t, used: map[string]bool{}, atomic: cfg.Atomic}, nil
+}
+
+// WriteDoc renders doc in full before writing anything, so a template that
+// fails halfway leaves no partial output; such docs are logged and skipped
+func (tw *templateWriter) WriteDoc(doc *Doc) error {
+	var buf bytes.Buffer
+	if err := tw.t.doc.Execute(&buf, doc); err != nil {
+		tw.failed++
+		fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n", forDisplay(doc.Title), err)
+		return nil
+	}
+	if tw.t.perDoc == nil {
+		_, err := tw.w.Write(buf.Bytes())
+		return err
+	}
+
+	var name strings.Builder
+	if err := tw.t.perDoc.Execute(&name, doc); err != nil {
+		tw.failed++
+		fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n", forDisplay(doc.Title), err)
+		return nil
+	}
+	path := tw.uniquePath(filepath.Clean(name.String()))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := tw.writeFile(path, buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(tw.w, path) // The stream lists the files written
+	return err
+}
+
+// uniquePath appends -2, -3, ... before the extension until path is unused.
+// Paths are compared case-insensitively, as titles differing only in case
+// would overwrite each other on macOS and Windows.
+func (tw *templateWriter) uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	candidate := path
+	for n := 2; tw.used[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s-%d%s", stem, n, ext)
+	}
+	tw.used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+func (tw *templateWriter) Close() error {
+	if tw.failed > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d docs skipped by template errors\n", tw.failed)
+	}
+	if tw.t.footer != nil {
+		if err := tw.t.footer.Execute(tw.w, nil); err != nil {
+			return fmt.Errorf("template footer: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFile writes one per-doc file, under -atomic by way of a .tmp file
+func (tw *templateWriter) writeFile(path string, data []byte) error {
+	if !tw.atomic {
+		return os.WriteFile(path, data, 0o644)
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
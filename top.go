@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/heap" // Package for the bounded top-N heaps
+	"encoding/json"  // Package for the JSON report
+	"fmt"            // Package for formatted I/O
+	"sort"           // Package for ordering the final lists
+	"time"           // Package for cleanup durations
+)
+
+// outliers tracks the top -top-n pages by raw text size, cleanup time and
+// output size, each in a heap holding at most n entries
+type outliers struct {
+	RawText    topList `json:"raw_text_bytes"`       // Largest wikitext
+	Cleanup    topList `json:"cleanup_microseconds"` // Slowest cleanup, from abstract extraction to the finished doc
+	OutputSize topList `json:"output_bytes"`         // Largest docs, counting the text of their fields
+}
+
+func newOutliers(n int) *outliers {
+	return &outliers{RawText: topList{n: n}, Cleanup: topList{n: n}, OutputSize: topList{n: n}}
+}
+
+// topList keeps the n entries with the largest values offered to it
+type topList struct {
+	n int     // Entries kept
+	h topHeap // Min-heap of the entries, so the smallest is replaced first
+}
+
+// topEntry is one page in a top list
+type topEntry struct {
+	Title string `json:"title"` // Page title
+	ID    int64  `json:"id"`    // Page ID
+	Value int64  `json:"value"` // Metric in the list's unit
+}
+
+// offer adds the page when its value beats the smallest kept
+func (t *topList) offer(title string, id, value int64) {
+	if t.n <= 0 {
+		return
+	}
+	if len(t.h) < t.n {
+		heap.Push(&t.h, topEntry{title, id, value})
+	} else if value > t.h[0].Value {
+		t.h[0] = topEntry{title, id, value}
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the kept entries, largest first
+func (t *topList) sorted() []topEntry {
+	out := append([]topEntry(nil), t.h...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Value > out[j].Value })
+	return out
+}
+
+func (t topList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.sorted())
+}
+
+// topHeap is a min-heap of entries on Value
+type topHeap []topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Value < h[j].Value }
+func (h topHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x any)        { *h = append(*h, x.(topEntry)) }
+func (h *topHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// docBytes is the size of a doc independent of the output format: the text of all its fields
+func docBytes(doc *Doc) int64 {
+	n := len(doc.Title) + len(doc.URL) + len(doc.Slug) + len(doc.Abstract) + len(doc.AbstractHTML) + len(doc.IPA) +
+		len(doc.BirthDate) + len(doc.DeathDate) + len(doc.WikidataID) + len(doc.ShortDescription) + len(doc.Image) + len(doc.LengthClass) + len(doc.Fingerprint) + len(doc.SourceFile)
+	for _, ref := range doc.References {
+		n += len(ref)
+	}
+	for _, alias := range doc.Aliases {
+		n += len(alias)
+	}
+	return int64(n)
+}
+
+// print writes the three lists for the end-of-run summary
+func (o *outliers) print() {
+	lists := []struct {
+		name   string
+		list   *topList
+		format func(v int64) string
+	}{
+		{"Largest pages (wikitext)", &o.RawText, func(v int64) string { return formatBytes(v) }},
+		{"Slowest cleanups", &o.Cleanup, func(v int64) string { return (time.Duration(v) * time.Microsecond).String() }},
+		{"Largest docs", &o.OutputSize, func(v int64) string { return formatBytes(v) }},
+	}
+	for _, l := range lists {
+		entries := l.list.sorted()
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", l.name)
+		for _, e := range entries {
+			fmt.Printf("  %10s  %s (id %d)\n", l.format(e.Value), forDisplay(e.Title), e.ID)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"         // Package for reading JSONL lines
+	"encoding/csv"  // Package for CSV decoding
+	"encoding/json" // Package for JSON decoding
+	"encoding/xml"  // Package for XML decoding
+	"errors"        // Package for error inspection
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"net/url"       // Package for URL parsing
+	"os"            // Package for OS functions (file access)
+	"strings"       // Package for string manipulation
+)
+
+// validateConfig holds the settings of the validate subcommand
+type validateConfig struct {
+	Input     string // Output file to check
+	Format    string // xml, jsonl or csv; "" infers it from the name
+	FailFast  bool   // Stop at the first bad record
+	MaxReport int    // Bad records printed; the rest are only counted (0: all)
+}
+
+// errInvalidOutput is returned when validate finds bad records
+var errInvalidOutput = errors.New("output file failed validation")
+
+// validateFormats are the formats validate reads, with the extensions implying them
+var validateFormats = map[string][]string{
+	"xml":   {".xml"},
+	"jsonl": {".jsonl", ".ndjson"},
+	"csv":   {".csv"},
+}
+
+// validateCommand checks an existing output file without rerunning the extraction
+func validateCommand(args []string) error {
+	cfg := &validateConfig{}
+	fs := flag.NewFlagSet("full-stream-wiki validate", flag.ContinueOnError)
+	fs.StringVar(&cfg.Input, "input", "", "output `file` to check (.xml, .jsonl/.ndjson or .csv, optionally .gz/.bz2)")
+	fs.StringVar(&cfg.Format, "format", "", "xml, jsonl or csv (default: from the -input extension)")
+	fs.BoolVar(&cfg.FailFast, "fail-fast", false, "stop at the first bad record instead of counting them all")
+	fs.IntVar(&cfg.MaxReport, "max-report", 20, "bad records to print; the rest are only counted (0: all)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+	if cfg.Input == "" && fs.NArg() == 1 {
+		cfg.Input = fs.Arg(0)
+	}
+	var err error
+	switch {
+	case cfg.Input == "":
+		err = fmt.Errorf("validate needs -input")
+	case cfg.MaxReport < 0:
+		err = fmt.Errorf("-max-report must not be negative")
+	case cfg.Format == "":
+		if cfg.Format, _, _ = inferFormat(cfg.Input, validateFormats); cfg.Format == "" {
+			err = fmt.Errorf("cannot tell the format of %s; pass -format xml, jsonl or csv", cfg.Input)
+		}
+	case validateFormats[cfg.Format] == nil:
+		err = fmt.Errorf("unknown -format %q (want xml, jsonl or csv)", cfg.Format)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return &usageError{err}
+	}
+
+	f, err := os.Open(cfg.Input)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", cfg.Input, err)
+	}
+	defer f.Close()
+	r, _, err := decompressByName(f, cfg.Input)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", cfg.Input, err)
+	}
+	v := &validator{cfg: cfg}
+	switch cfg.Format {
+	case "xml":
+		err = v.checkXML(r)
+	case "jsonl":
+		err = v.checkJSONL(r)
+	case "csv":
+		err = v.checkCSV(r)
+	}
+	if err != nil && !errors.Is(err, errStopValidation) {
+		return fmt.Errorf("%s: %w", cfg.Input, err)
+	}
+	if v.bad > cfg.MaxReport && cfg.MaxReport > 0 {
+		fmt.Printf("... and %d more\n", v.bad-cfg.MaxReport)
+	}
+	fmt.Printf("Checked %d records of %s: %d invalid.\n", v.records, cfg.Input, v.bad)
+	if v.bad > 0 {
+		return errInvalidOutput
+	}
+	return nil
+}
+
+// errStopValidation ends a -fail-fast check at the first bad record
+var errStopValidation = errors.New("validation stopped")
+
+// validator counts and reports the records of one file
+type validator struct {
+	cfg     *validateConfig // Settings
+	records int             // Records checked
+	bad     int             // Records with at least one problem
+}
+
+// report prints problems found in the record at where; it returns
+// errStopValidation once -fail-fast has its answer
+func (v *validator) report(where string, problems []string) error {
+	v.records++
+	if len(problems) == 0 {
+		return nil
+	}
+	v.bad++
+	if v.cfg.MaxReport == 0 || v.bad <= v.cfg.MaxReport {
+		fmt.Printf("%s: %s: %s\n", v.cfg.Input, where, strings.Join(problems, "; "))
+	}
+	if v.cfg.FailFast {
+		return errStopValidation
+	}
+	return nil
+}
+
+// docProblems checks the fields every doc carries
+func docProblems(title, pageURL, abstract string) []string {
+	var problems []string
+	if strings.TrimSpace(title) == "" {
+		problems = append(problems, "missing title")
+	}
+	if strings.TrimSpace(abstract) == "" {
+		problems = append(problems, "missing abstract")
+	}
+	if pageURL == "" {
+		return append(problems, "missing url")
+	}
+	if u, err := url.Parse(pageURL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("url %q is not an absolute http(s) URL", pageURL))
+	} else if err := checkPageURL(pageURL); err != nil {
+		problems = append(problems, fmt.Sprintf("url %q: %v", pageURL, err))
+	}
+	return problems
+}
+
+// checkXML checks a <documents> file: well-formed throughout, and every <doc> complete
+func (v *validator) checkXML(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	depth, rooted := 0, false
+	for {
+		off := dec.InputOffset()
+		tok, err := dec.Token()
+		line, _ := dec.InputPos() // The line the token ends on
+		if err == io.EOF && !rooted {
+			return fmt.Errorf("no <documents> element")
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("not well-formed: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && t.Name.Local != "documents":
+				return fmt.Errorf("line %d: root element is <%s>, want <documents>", line, t.Name.Local)
+			case depth == 0:
+				rooted = true
+			case depth == 1 && t.Name.Local == "doc":
+				var rec abstractRecord
+				if err := dec.DecodeElement(&rec, &t); err != nil {
+					return fmt.Errorf("line %d (offset %d): not well-formed: %w", line, off, err)
+				}
+				where := fmt.Sprintf("line %d (offset %d), doc %q", line, off, rec.Title)
+				if err := v.report(where, docProblems(rec.Title, rec.URL, rec.Abstract)); err != nil {
+					return err
+				}
+				continue
+			case depth == 1:
+				if err := v.report(fmt.Sprintf("line %d (offset %d)", line, off), []string{fmt.Sprintf("unexpected <%s> element", t.Name.Local)}); err != nil {
+					return err
+				}
+				if err := dec.Skip(); err != nil {
+					return fmt.Errorf("not well-formed: %w", err)
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// checkJSONL checks one JSON object per line: docs, or redirects as
+// -redirects-only writes them, optionally led by a -siteinfo-record line
+func (v *validator) checkJSONL(r io.Reader) error {
+	br := bufio.NewReader(r)
+	kind := "" // "doc" or "redirect", from the first record
+	var off int64
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		where := fmt.Sprintf("line %d (offset %d)", n, off)
+		off += int64(len(line))
+		if strings.TrimSpace(string(line)) == "" {
+			if rerr := v.report(where, []string{"empty line"}); rerr != nil {
+				return rerr
+			}
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if jerr := json.Unmarshal(line, &fields); jerr != nil {
+			if rerr := v.report(where, []string{"not a JSON object: " + jerr.Error()}); rerr != nil {
+				return rerr
+			}
+			continue
+		}
+		var problems []string
+		str := func(name string) string {
+			raw, ok := fields[name]
+			if !ok {
+				return ""
+			}
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				problems = append(problems, name+" is not a string")
+			}
+			return s
+		}
+		if str("_type") == "siteinfo" {
+			if n != 1 {
+				problems = append(problems, "siteinfo record after the first line")
+			}
+		} else {
+			recKind := "doc"
+			if _, ok := fields["from"]; ok {
+				recKind = "redirect"
+			}
+			if kind == "" {
+				kind = recKind
+			}
+			switch {
+			case recKind != kind:
+				problems = append(problems, fmt.Sprintf("%s record in a file of %ss", recKind, kind))
+			case recKind == "redirect":
+				if str("from") == "" || str("to") == "" {
+					problems = append(problems, "redirect without from and to")
+				}
+			default:
+				title := str("title")
+				problems = append(problems, docProblems(title, str("url"), str("abstract"))...)
+				if title != "" {
+					where += fmt.Sprintf(", doc %q", title)
+				}
+			}
+		}
+		if rerr := v.report(where, problems); rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// checkCSV checks a -redirects-only CSV file: the from,to header, then two non-empty fields per row
+func (v *validator) checkCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Counted below, per record
+	header, err := cr.Read()
+	if err == io.EOF {
+		return fmt.Errorf("empty file")
+	}
+	if err != nil {
+		return fmt.Errorf("not valid CSV: %w", err)
+	}
+	if len(header) != 2 || header[0] != "from" || header[1] != "to" {
+		return fmt.Errorf("header is %q, want from,to", strings.Join(header, ","))
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if rerr := v.report(fmt.Sprintf("line %d", parseErr.StartLine), []string{parseErr.Err.Error()}); rerr != nil {
+				return rerr
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		var problems []string
+		if len(rec) != 2 {
+			problems = append(problems, fmt.Sprintf("%d fields, want 2", len(rec)))
+		} else if strings.TrimSpace(rec[0]) == "" || strings.TrimSpace(rec[1]) == "" {
+			problems = append(problems, "empty from or to")
+		}
+		if rerr := v.report(fmt.Sprintf("line %d", line), problems); rerr != nil {
+			return rerr
+		}
+	}
+}
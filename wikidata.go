@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"    // Package for line-oriented reading
+	"fmt"      // Package for formatted I/O
+	"hash/fnv" // Package for title hashes
+	"io"       // Package for I/O primitives
+	"os"       // Package for OS functions (file access)
+	"sort"     // Package for sorting and binary search
+	"strconv"  // Package for string conversions
+	"strings"  // Package for string manipulation
+)
+
+// qidIndex maps normalized titles to Wikidata item IDs
+type qidIndex interface {
+	lookup(title string) (qid string, ok bool) // QID such as "Q42" for a title key
+	Close() error                              // Release any open file
+}
+
+// openQIDIndex loads a "title<TAB>QID" file, in memory or, with onDisk, as
+// an index of hashes pointing into the file itself. Titles are stored and
+// looked up under key(title).
+func openQIDIndex(path string, onDisk bool, key func(string) string) (qidIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wikidata mapping: %w", err)
+	}
+	var idx qidIndex
+	if onDisk {
+		idx, err = loadDiskQIDs(f, key)
+	} else {
+		idx, err = loadMemQIDs(f, key)
+		f.Close()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// parseQIDLine splits one mapping line into its title key and numeric QID
+func parseQIDLine(line string, key func(string) string) (title string, qid uint32, err error) {
+	title, q, ok := strings.Cut(line, "\t")
+	if !ok {
+		return "", 0, fmt.Errorf("want title<TAB>QID, got %q", line)
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(q), "Q"), 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid QID %q", q)
+	}
+	return key(title), uint32(n), nil
+}
+
+// memQIDs keeps every title in one string and a sorted table of offsets, about
+// a third of what a map would take for the ~7M entries of enwiki
+type memQIDs struct {
+	blob    string     // All titles, concatenated
+	entries []qidEntry // Sorted by title
+}
+
+// qidEntry locates one title inside memQIDs.blob
+type qidEntry struct {
+	off uint32 // Start of the title in blob
+	n   uint32 // Title length
+	qid uint32 // Item number, without the "Q"
+}
+
+func loadMemQIDs(r io.Reader, key func(string) string) (*memQIDs, error) {
+	var blob strings.Builder
+	var entries []qidEntry
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if sc.Text() == "" {
+			continue
+		}
+		title, qid, err := parseQIDLine(sc.Text(), key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, qidEntry{off: uint32(blob.Len()), n: uint32(len(title)), qid: qid})
+		blob.WriteString(title)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	m := &memQIDs{blob: blob.String(), entries: entries}
+	sort.SliceStable(m.entries, func(a, b int) bool { return m.title(a) < m.title(b) })
+	return m, nil
+}
+
+func (m *memQIDs) title(i int) string {
+	e := m.entries[i]
+	return m.blob[e.off : e.off+e.n]
+}
+
+// lookup finds the first entry for title, so the file's first mapping wins
+func (m *memQIDs) lookup(title string) (string, bool) {
+	i := sort.Search(len(m.entries), func(i int) bool { return m.title(i) >= title })
+	if i == len(m.entries) || m.title(i) != title {
+		return "", false
+	}
+	return "Q" + strconv.FormatUint(uint64(m.entries[i].qid), 10), true
+}
+
+func (m *memQIDs) Close() error { return nil }
+
+// diskQIDs keeps only a hash and a line offset per title in memory and reads
+// the line back from the mapping file to confirm a match
+type diskQIDs struct {
+	f       *os.File            // Mapping file
+	key     func(string) string // Title key of the lines read back
+	entries []diskEntry         // Sorted by hash
+}
+
+// diskEntry locates the line of one title
+type diskEntry struct {
+	hash uint64 // FNV-1a of the title key
+	off  int64  // Byte offset of the line
+}
+
+func loadDiskQIDs(f *os.File, key func(string) string) (*diskQIDs, error) {
+	d := &diskQIDs{f: f, key: key}
+	br := bufio.NewReader(f)
+	var off int64
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if trimmed := strings.TrimRight(text, "\r\n"); trimmed != "" {
+			title, _, perr := parseQIDLine(trimmed, key)
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, perr)
+			}
+			d.entries = append(d.entries, diskEntry{hash: titleHash(title), off: off})
+		}
+		off += int64(len(text))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(d.entries, func(a, b int) bool { return d.entries[a].hash < d.entries[b].hash })
+	return d, nil
+}
+
+// titleHash is the 64-bit FNV-1a hash of a title
+func titleHash(title string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	return h.Sum64()
+}
+
+// lookup checks each line whose title hash matches, first mapping first
+func (d *diskQIDs) lookup(title string) (string, bool) {
+	h := titleHash(title)
+	for i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].hash >= h }); i < len(d.entries) && d.entries[i].hash == h; i++ {
+		line, err := bufio.NewReader(io.NewSectionReader(d.f, d.entries[i].off, 1<<20)).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", false
+		}
+		if t, qid, err := parseQIDLine(strings.TrimRight(line, "\r\n"), d.key); err == nil && t == title {
+			return "Q" + strconv.FormatUint(uint64(qid), 10), true
+		}
+	}
+	return "", false
+}
+
+func (d *diskQIDs) Close() error { return d.f.Close() }
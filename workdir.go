@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"        // Package for error inspection
+	"flag"          // Package for command-line flag parsing
+	"fmt"           // Package for formatted I/O
+	"io/fs"         // Package for directory walking
+	"os"            // Package for OS functions (file access)
+	"path/filepath" // Package for file path manipulation
+	"strconv"       // Package for string conversions
+	"strings"       // Package for string manipulation
+	"time"          // Package for run IDs
+)
+
+// workdirPrefix names the default scratch directories, so clean can find them
+const workdirPrefix = "full-stream-wiki-"
+
+// workdirPidFile records the owning process inside a scratch directory
+const workdirPidFile = "pid"
+
+// workdir is the scratch space of one run. It is only created once a
+// feature asks for a file, removed when the run succeeds, and kept for
+// debugging when it fails.
+type workdir struct {
+	path    string   // Directory holding the scratch files
+	owned   bool     // Whether the run created the directory itself
+	created bool     // Whether the directory has been set up
+	files   []string // Scratch files handed out, removed on success
+}
+
+// newWorkdir returns the workdir at dir, or a fresh one under os.TempDir
+func newWorkdir(dir string, now time.Time) *workdir {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("%s%s-%d", workdirPrefix, now.Format("20060102T150405"), os.Getpid()))
+	}
+	return &workdir{path: dir}
+}
+
+// setup creates the directory and records the owning process in it
+func (w *workdir) setup() error {
+	if w.created {
+		return nil
+	}
+	if _, err := os.Stat(w.path); errors.Is(err, fs.ErrNotExist) {
+		w.owned = true
+	}
+	if err := os.MkdirAll(w.path, 0o700); err != nil {
+		return fmt.Errorf("failed to create workdir: %w", err)
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	if err := os.WriteFile(filepath.Join(w.path, workdirPidFile), pid, 0o600); err != nil {
+		return fmt.Errorf("failed to create workdir: %w", err)
+	}
+	w.created = true
+	return nil
+}
+
+// create opens a new scratch file named after pattern (see os.CreateTemp)
+func (w *workdir) create(pattern string) (*os.File, error) {
+	if err := w.setup(); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(w.path, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch file: %w", err)
+	}
+	w.files = append(w.files, f.Name())
+	return f, nil
+}
+
+// reserve fails early when the workdir's file system has fewer than need
+// bytes free, rather than letting a long run die of a full disk near its end
+func (w *workdir) reserve(need int64) error {
+	if err := w.setup(); err != nil {
+		return err
+	}
+	free, ok := freeSpace(w.path)
+	if !ok || free >= need {
+		return nil // Unknown on this platform, or enough
+	}
+	return fmt.Errorf("workdir %s has %s free, but this run needs about %s of scratch space (choose another with -workdir)",
+		w.path, formatBytes(free), formatBytes(need))
+}
+
+// finish cleans up after a successful run. After a failure the scratch
+// files are kept and runErr is extended to say where they are.
+func (w *workdir) finish(runErr error) error {
+	if !w.created {
+		return runErr
+	}
+	if runErr != nil {
+		return fmt.Errorf("%w (scratch files kept in %s)", runErr, w.path)
+	}
+	if w.owned {
+		os.RemoveAll(w.path)
+		return nil
+	}
+	for _, name := range w.files {
+		os.Remove(name)
+	}
+	os.Remove(filepath.Join(w.path, workdirPidFile))
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// cleanCommand removes the workdirs that crashed runs left behind
+func cleanCommand(args []string) error {
+	fs := flag.NewFlagSet("full-stream-wiki clean", flag.ContinueOnError)
+	dir := fs.String("dir", os.TempDir(), "directory to look for leftover "+workdirPrefix+"* workdirs in")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{err}
+	}
+
+	// 1. Find scratch directories whose run is no longer alive
+	entries, err := os.ReadDir(*dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", *dir, err)
+	}
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), workdirPrefix) {
+			continue
+		}
+		path := filepath.Join(*dir, e.Name())
+		data, err := os.ReadFile(filepath.Join(path, workdirPidFile))
+		if err != nil {
+			continue // Not one of ours
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && processAlive(pid) {
+			continue // Still running
+		}
+
+		// 2. Remove it, reporting how much space that frees
+		size := dirSize(path)
+		if *dryRun {
+			fmt.Printf("Would remove %s (%s).\n", path, formatBytes(size))
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove %s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("Removed %s (%s).\n", path, formatBytes(size))
+		removed++
+	}
+	if !*dryRun {
+		fmt.Printf("Done! %d leftover workdirs removed.\n", removed)
+	}
+	return nil
+}
+
+// dirSize adds up the sizes of the regular files under path
+func dirSize(path string) int64 {
+	var n int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
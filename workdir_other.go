@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "os" // Package for OS process handles
+
+// freeSpace is not implemented here, so the free-space check is skipped
+func freeSpace(path string) (int64, bool) {
+	return 0, false
+}
+
+// processAlive reports whether a process with this pid can be found
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"  // Package for error inspection
+	"syscall" // Package for file system statistics and signals
+)
+
+// freeSpace reports the bytes available to unprivileged users on path's file system
+func freeSpace(path string) (int64, bool) {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(path, &s); err != nil {
+		return 0, false
+	}
+	return int64(s.Bavail) * int64(s.Bsize), true
+}
+
+// processAlive reports whether a process with this pid exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
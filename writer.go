@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json" // Package for JSON encoding
+	"encoding/xml"  // Package for XML encoding/decoding
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"path/filepath" // Package for file name extensions
+	"slices"        // Package for slice searching
+	"sort"          // Package for sorting slices
+	"strings"       // Package for string manipulation
+)
+
+// docWriter encodes extracted docs into one output format
+type docWriter interface {
+	WriteDoc(doc *Doc) error // Encode a single doc
+	Close() error            // Write any trailer; the underlying writer stays open
+}
+
+// writerFormat is one registered output format
+type writerFormat struct {
+	new  func(w io.Writer, cfg *config) (docWriter, error) // Constructor
+	exts []string                                          // Output file extensions implying the format
+}
+
+// writerFactories maps each -format name to its constructor and extensions
+var writerFactories = map[string]writerFormat{
+	"xml":      {newXMLWriter, []string{".xml"}},
+	"jsonl":    {newJSONLWriter, []string{".jsonl", ".ndjson"}},
+	"ntriples": {newNTriplesWriter, []string{".nt"}},
+	"jsonld":   {newJSONLDWriter, []string{".jsonld"}},
+	"parquet":  {newParquetWriter, []string{".parquet"}},
+	"zim":      {newZIMWriter, []string{".zim"}},
+	"template": {newTemplateWriter, nil}, // Templates can produce anything
+}
+
+// formatNames returns the registered format names in sorted order
+func formatNames() []string {
+	names := make([]string, 0, len(writerFactories))
+	for name := range writerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// docFormatExts returns the extensions of every registered doc format
+func docFormatExts() map[string][]string {
+	exts := make(map[string][]string, len(writerFactories))
+	for name, f := range writerFactories {
+		exts[name] = f.exts
+	}
+	return exts
+}
+
+// compressionExts maps a trailing output extension to the compression it asks for
+var compressionExts = map[string]string{".gz": "gzip", ".zst": "zstd"}
+
+// inferFormat reads the format and compression implied by an output file
+// name, e.g. "en.JSONL.gz" gives jsonl and gzip. When no format matches,
+// format is empty and ext is the extension that was not recognized.
+func inferFormat(path string, formats map[string][]string) (format, compression, ext string) {
+	name := strings.ToLower(filepath.Base(path))
+	ext = filepath.Ext(name)
+	if c, ok := compressionExts[ext]; ok {
+		compression, name = c, strings.TrimSuffix(name, ext)
+		ext = filepath.Ext(name)
+	}
+	for f, exts := range formats {
+		if slices.Contains(exts, ext) {
+			return f, compression, ext
+		}
+	}
+	return "", compression, ext
+}
+
+// newDocWriter builds the writer registered for format
+func newDocWriter(w io.Writer, cfg *config) (docWriter, error) {
+	if cfg.ESURL != "" {
+		return newESWriter(w, cfg)
+	}
+	f, ok := writerFactories[cfg.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (want one of %v)", cfg.Format, formatNames())
+	}
+	return f.new(w, cfg)
+}
+
+// xmlWriter emits the original <documents><doc>...</doc></documents> layout
+type xmlWriter struct {
+	w io.Writer // Destination stream
+}
+
+func newXMLWriter(w io.Writer, _ *config) (docWriter, error) {
+	// Write the XML header and opening <documents> tag
+	if _, err := fmt.Fprint(w, xml.Header); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(w, "<documents>"); err != nil {
+		return nil, err
+	}
+	return &xmlWriter{w: w}, nil
+}
+
+func (x *xmlWriter) WriteDoc(doc *Doc) error {
+	output, err := xml.MarshalIndent(doc, "  ", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal Doc: %w", err)
+	}
+	_, err = fmt.Fprintln(x.w, string(output))
+	return err
+}
+
+func (x *xmlWriter) Close() error {
+	_, err := fmt.Fprintln(x.w, "</documents>")
+	return err
+}
+
+// jsonlWriter emits one JSON object per line
+type jsonlWriter struct {
+	enc *json.Encoder // Encoder bound to the destination stream
+}
+
+func newJSONLWriter(w io.Writer, cfg *config) (docWriter, error) {
+	if cfg.Canonical {
+		return &canonicalJSONLWriter{w: w}, nil
+	}
+	return &jsonlWriter{enc: newJSONEncoder(w, cfg)}, nil
+}
+
+// newJSONEncoder returns an encoder that escapes <, > and & as \u003c etc.
+// unless -no-escape-html asked for them to be written literally
+func newJSONEncoder(w io.Writer, cfg *config) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!cfg.NoEscapeHTML)
+	return enc
+}
+
+func (j *jsonlWriter) WriteDoc(doc *Doc) error {
+	return j.enc.Encode(doc)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"         // Package for the rendered outputs
+	"encoding/json" // Package for reading the JSON outputs back
+	"encoding/xml"  // Package for reading the XML output back
+	"fmt"           // Package for formatted I/O
+	"io"            // Package for I/O primitives
+	"net/url"       // Package for checking IRIs
+	"regexp"        // Package for the N-Triples grammar
+	"strconv"       // Package for unquoting N-Triples literals
+	"strings"       // Package for string manipulation
+	"testing"       // Package for tests
+	"unicode/utf8"  // Package for checking the outputs are UTF-8
+)
+
+// fuzzFormat is one output format FuzzOutputs renders a doc in, with the
+// strict reading that must give back what went in
+type fuzzFormat struct {
+	name  string
+	cfg   *config
+	new   func(w io.Writer, cfg *config) (docWriter, error)
+	check func(out []byte, s string) error
+}
+
+// fuzzFormats are the doc formats checked. Parquet is binary and has no
+// reader here; -format template escapes what the user's template says.
+var fuzzFormats = []fuzzFormat{
+	{"xml", &config{}, newXMLWriter, checkFuzzXML},
+	{"jsonl", &config{}, newJSONLWriter, checkFuzzJSONL},
+	{"jsonl -no-escape-html", &config{NoEscapeHTML: true}, newJSONLWriter, checkFuzzJSONL},
+	{"jsonl -canonical", &config{Canonical: true}, newJSONLWriter, checkFuzzJSONL},
+	{"jsonld", &config{Lang: "en"}, newJSONLDWriter, checkFuzzJSONLD},
+	{"ntriples", &config{Lang: "en"}, newNTriplesWriter, checkFuzzNTriples},
+}
+
+// fuzzTokens are seeds of FuzzOutputs besides its corpus, each within a
+// sentence: markup and escapes of every format, characters XML cannot hold,
+// bidi controls and broken UTF-8
+var fuzzTokens = []string{
+	`"`, `'`, `<`, `>`, `&`, `&amp;`, `&#1;`, `]]>`, `<![CDATA[`, `<!--`, `-->`, `<?xml`, `</doc>`,
+	"\x00", "\x01", "\x0b", "\x1f", "\x7f", "\r", "\r\n", "\n", "\t",
+	"\u202e", "\u202a", "\u2066", "\u2069", "\u200f", "\u2028", "\ufeff", "\ufffe", "\uffff", "\U0001f600",
+	"\xff", "\xc3", "\xed\xa0\x80", "\xf4\x90\x80\x80",
+	`%`, `%zz`, `%41`, `#`, `?`, `\`, `A`, `{`, `}`, `|`, `^`, "`", "@en", "\" .\n<", "_:b0",
+}
+
+// fuzzDoc is a doc holding s in every text field the formats write
+func fuzzDoc(s string) *Doc {
+	return &Doc{
+		ID:               1,
+		Title:            s,
+		URL:              pageURL("https://en.wikipedia.org/wiki/", s),
+		Abstract:         s,
+		Sentences:        []string{s},
+		AbstractHTML:     s,
+		Aliases:          []string{s},
+		References:       refList{s},
+		Infobox:          infobox{s: s},
+		ShortDescription: s,
+		SourceFile:       s,
+	}
+}
+
+// FuzzOutputs renders docs whose every text field holds an adversarial
+// string in each output format, and reads each output back with a strict
+// parser: it must parse and give the string back, apart from what the format
+// cannot carry, which becomes U+FFFD. The seed corpus is in
+// testdata/fuzz/FuzzOutputs, where go test -fuzz saves failing inputs too.
+func FuzzOutputs(f *testing.F) {
+	for _, tok := range fuzzTokens {
+		f.Add("Title " + tok + " text")
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if s == "" {
+			return // A page without a title is skipped, and XML leaves out empty list items
+		}
+		for _, format := range fuzzFormats {
+			if err := fuzzOne(format, s); err != nil {
+				t.Errorf("%s: %v", format.name, err)
+			}
+		}
+	})
+}
+
+// fuzzOne renders the doc of s in the format f and checks it reads back
+func fuzzOne(f fuzzFormat, s string) error {
+	var out bytes.Buffer
+	w, err := f.new(&out, f.cfg)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteDoc(fuzzDoc(s)); err != nil {
+		return fmt.Errorf("writing: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("writing: %v", err)
+	}
+	if !utf8.Valid(out.Bytes()) {
+		return fmt.Errorf("the output is not UTF-8")
+	}
+	return f.check(out.Bytes(), s)
+}
+
+// fuzzWant is s as a text format can carry it: each invalid UTF-8 byte
+// becomes U+FFFD, as encoding/json writes it
+func fuzzWant(s string) string {
+	return string([]rune(s))
+}
+
+// fuzzWantXML is s as XML can carry it: the characters XML 1.0 does not
+// allow become U+FFFD too, as encoding/xml writes them
+func fuzzWantXML(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == 0x09 || r == 0x0A || r == 0x0D || r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF {
+			return r
+		}
+		return utf8.RuneError
+	}, s)
+}
+
+// compareFuzzDoc reports the first field of got not holding want
+func compareFuzzDoc(got *Doc, want, wantURL string) error {
+	fields := []struct {
+		name string
+		got  []string
+	}{
+		{"title", []string{got.Title}},
+		{"url", []string{got.URL}},
+		{"abstract", []string{got.Abstract}},
+		{"sentences", got.Sentences},
+		{"abstract_html", []string{got.AbstractHTML}},
+		{"aliases", got.Aliases},
+		{"references", got.References},
+		{"infobox value", []string{got.Infobox[want]}},
+		{"short_description", []string{got.ShortDescription}},
+		{"source_file", []string{got.SourceFile}},
+	}
+	for _, f := range fields {
+		w := want
+		if f.name == "url" {
+			w = wantURL
+		}
+		if len(f.got) != 1 || f.got[0] != w {
+			return fmt.Errorf("%s reads back as %q", f.name, f.got)
+		}
+	}
+	return nil
+}
+
+// checkFuzzXML parses the XML output strictly, to its end
+func checkFuzzXML(out []byte, s string) error {
+	dec := xml.NewDecoder(bytes.NewReader(out))
+	dec.Strict = true
+	var docs struct {
+		XMLName xml.Name `xml:"documents"`
+		Docs    []Doc    `xml:"doc"`
+	}
+	if err := dec.Decode(&docs); err != nil {
+		return fmt.Errorf("the XML does not parse: %v", err)
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if cd, ok := tok.(xml.CharData); err != nil || !ok || len(bytes.TrimSpace(cd)) > 0 {
+			return fmt.Errorf("the XML goes on after </documents>")
+		}
+	}
+	if len(docs.Docs) != 1 {
+		return fmt.Errorf("the XML holds %d docs", len(docs.Docs))
+	}
+	want := fuzzWantXML(s)
+	return compareFuzzDoc(&docs.Docs[0], want, fuzzWantXML(fuzzDoc(s).URL))
+}
+
+// checkFuzzJSONL reads the one line back as a doc
+func checkFuzzJSONL(out []byte, s string) error {
+	line, ok := bytes.CutSuffix(out, []byte("\n"))
+	if !ok || bytes.ContainsAny(line, "\r\n") || !json.Valid(line) {
+		return fmt.Errorf("the output is not one JSON line")
+	}
+	var doc Doc
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return err
+	}
+	return compareFuzzDoc(&doc, fuzzWant(s), fuzzWant(fuzzDoc(s).URL))
+}
+
+// checkFuzzJSONLD reads the one JSON-LD line back, with IRIs for @id and url
+func checkFuzzJSONLD(out []byte, s string) error {
+	line, ok := bytes.CutSuffix(out, []byte("\n"))
+	if !ok || bytes.ContainsAny(line, "\r\n") || !json.Valid(line) {
+		return fmt.Errorf("the output is not one JSON line")
+	}
+	var ld jsonldDoc
+	if err := json.Unmarshal(line, &ld); err != nil {
+		return err
+	}
+	switch want := fuzzWant(s); {
+	case ld.Name != want || ld.Abstract != want:
+		return fmt.Errorf("name and abstract read back as %q and %q", ld.Name, ld.Abstract)
+	case !fuzzIRIRe.MatchString(ld.ID) || ld.URL != ld.ID:
+		return fmt.Errorf("@id %q and url %q are not the same absolute IRI", ld.ID, ld.URL)
+	}
+	return checkFuzzIRI(ld.ID)
+}
+
+// fuzzIRIRe matches the characters of an N-Triples IRIREF
+var fuzzIRIRe = regexp.MustCompile("^[^\\x00-\\x20<>\"{}|^`\\\\]*$")
+
+// fuzzTripleRe matches one line of N-Triples: two IRIs, then an IRI or a
+// string literal with a language tag, and the full stop
+var fuzzTripleRe = regexp.MustCompile("^<([^\\x00-\\x20<>\"{}|^`\\\\]*)> <([^\\x00-\\x20<>\"{}|^`\\\\]*)> " +
+	"(?:<([^\\x00-\\x20<>\"{}|^`\\\\]*)>|\"((?:[^\"\\\\\\n\\r]|\\\\[tbnrf\"'\\\\]|\\\\u[0-9A-Fa-f]{4}|\\\\U[0-9A-Fa-f]{8})*)\"@[a-zA-Z]+(?:-[a-zA-Z0-9]+)*) \\.$")
+
+// checkFuzzNTriples parses the N-Triples output line by line and reads the
+// name and abstract literals back
+func checkFuzzNTriples(out []byte, s string) error {
+	text, ok := strings.CutSuffix(string(out), "\n")
+	if !ok {
+		return fmt.Errorf("the output does not end with a newline")
+	}
+	literals := map[string]string{}
+	subjects := map[string]bool{}
+	for i, line := range strings.Split(text, "\n") {
+		m := fuzzTripleRe.FindStringSubmatch(line)
+		if m == nil {
+			return fmt.Errorf("line %d is not a triple: %q", i+1, line)
+		}
+		subjects[m[1]] = true
+		if m[3] == "" {
+			lit, err := strconv.Unquote(`"` + m[4] + `"`)
+			if err != nil {
+				return fmt.Errorf("line %d: literal: %v", i+1, err)
+			}
+			literals[m[2]] = lit
+		}
+	}
+	want := fuzzWant(s)
+	if literals[schemaName] != want || literals[schemaAbstract] != want {
+		return fmt.Errorf("name and abstract read back as %q and %q", literals[schemaName], literals[schemaAbstract])
+	}
+	if len(subjects) != 1 {
+		return fmt.Errorf("the triples have %d subjects", len(subjects))
+	}
+	for subject := range subjects {
+		return checkFuzzIRI(subject)
+	}
+	return nil
+}
+
+// checkFuzzIRI checks that an IRI of the output parses as an absolute URL
+// of the page's wiki, without the bidi controls IRIs forbid. A title with
+// "?" or "#" leaks into the query or the fragment as it does in the url field, which -validate-urls reports.
+func checkFuzzIRI(iri string) error {
+	if strings.ContainsFunc(iri, isBidiControl) {
+		return fmt.Errorf("IRI %q holds a bidi control", iri)
+	}
+	if u, err := url.Parse(iri); err != nil || u.Scheme != "https" || u.Host != "en.wikipedia.org" {
+		return fmt.Errorf("IRI %q is not the page's URL", iri)
+	}
+	return nil
+}
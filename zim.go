@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"                      // Package for in-memory buffers
+	"fmt"                        // Package for formatted I/O
+	htmltemplate "html/template" // Package for the article pages
+	"io"                         // Package for I/O primitives
+	"os"                         // Package for OS functions (scratch file, standard error)
+	"strings"                    // Package for string manipulation
+
+	"github.com/AhmedOthman94/full-stream-wiki-golang/zim" // Package for the ZIM archive format
+)
+
+// zimArticle is the page written for each doc
+var zimArticle = htmltemplate.Must(htmltemplate.New("zim").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Abstract}}</p>
+<p><a href="{{.URL}}">Read the full article online</a></p>
+</body>
+</html>
+`))
+
+// zimWriter writes the docs as a ZIM archive for offline readers such as
+// Kiwix (see package zim): an HTML page per doc plus the metadata entries
+type zimWriter struct {
+	z       *zim.Writer // Archive being built
+	scratch *os.File    // Its finished clusters, in the workdir
+	lang    string      // Language of the pages, as an HTML tag
+	meta    [][2]string // Metadata entries, name and value
+}
+
+func newZIMWriter(w io.Writer, cfg *config) (docWriter, error) {
+	scratch, err := cfg.Work.create("zim-clusters-*")
+	if err != nil {
+		return nil, err
+	}
+	lang, name := cfg.Lang, cfg.Lang+cfg.Project.db
+	if lang == "simple" {
+		lang = "en" // Simple English is English, as in ntriples
+	}
+	return &zimWriter{
+		z: zim.NewWriter(w, scratch), scratch: scratch, lang: lang,
+		meta: [][2]string{
+			{"Title", name + " abstracts"},
+			{"Description", "Lead-section abstracts of " + name},
+			{"Language", zim.LanguageCode(cfg.Lang)},
+			{"Name", name + "_abstracts"},
+			{"Creator", cfg.Project.host},
+			{"Publisher", "full-stream-wiki"},
+			{"Date", cfg.NowFunc().Format("2006-01-02")},
+		},
+	}, nil
+}
+
+func (z *zimWriter) WriteDoc(doc *Doc) error {
+	var page bytes.Buffer
+	if err := zimArticle.Execute(&page, struct {
+		*Doc
+		Lang string
+	}{doc, z.lang}); err != nil {
+		return err
+	}
+	return z.z.AddArticle(zimPath(doc.Title), doc.Title, page.Bytes())
+}
+
+// zimPath is the URL of a title's page, underscored as on the wiki
+func zimPath(title string) string {
+	return strings.ReplaceAll(title, " ", "_")
+}
+
+// Close adds the metadata and writes out the archive
+func (z *zimWriter) Close() error {
+	defer z.scratch.Close()
+	for _, m := range z.meta {
+		if err := z.z.AddMetadata(m[0], m[1]); err != nil {
+			return err
+		}
+	}
+	if err := z.z.Close(); err != nil {
+		return err
+	}
+	if dups := z.z.Duplicates(); dups > 0 {
+		fmt.Fprintf(os.Stderr, "warning: zim: left out %d docs whose URL an earlier doc has\n", dups)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package zim
+
+import (
+	"bytes"        // Package for in-memory buffers
+	"io"           // Package for I/O primitives
+	"math/rand/v2" // Package for incompressible input
+	"strings"      // Package for repetitive input
+	"testing"      // Package for tests
+
+	"github.com/ulikunitz/xz" // Package for an xz decoder independent of ours
+)
+
+// TestXZRoundTrip decodes xzCompress output with another implementation.
+// The inputs cover an empty block, matches at every distance the coder
+// uses, and more than one LZMA2 chunk of each size limit.
+func TestXZRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(rng.Uint32())
+		}
+		return b
+	}
+	var text strings.Builder
+	for text.Len() < 3<<20 {
+		text.WriteString("Paris is the capital and largest city of France. ")
+		text.Write(random(rng.IntN(8)))
+	}
+	for name, src := range map[string][]byte{
+		"empty":     {},
+		"one byte":  []byte("a"),
+		"repeated":  bytes.Repeat([]byte("abc"), 100_000),
+		"zeros":     make([]byte, 5<<20),
+		"random":    random(200_000), // Past the 64 KiB packed limit of a chunk
+		"text":      []byte(text.String()),
+		"long runs": append(random(70_000), append(make([]byte, 1000), random(70_000)...)...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			packed := xzCompress(src)
+			r, err := xz.NewReader(bytes.NewReader(packed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Fatalf("decoded %d bytes, want %d", len(got), len(src))
+			}
+		})
+	}
+}
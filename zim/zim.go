@@ -0,0 +1,256 @@
+// Package zim writes ZIM archives, the format of offline readers such as
+// Kiwix, as the openZIM specification describes it: version 5 archives with
+// the classic namespaces A (articles) and M (metadata), xz-compressed
+// clusters, the URL and title pointer lists readers search, and the MD5
+// checksum they verify. There is no main page, no full-text index and no
+// MIME type besides text/html and text/plain.
+package zim
+
+import (
+	"bufio"           // Package for buffered I/O
+	"bytes"           // Package for in-memory buffers
+	"crypto/md5"      // Package for the checksum and UUID
+	"encoding/binary" // Package for little-endian encoding
+	"fmt"             // Package for formatted I/O
+	"hash"            // Package for the running checksum
+	"io"              // Package for I/O primitives
+	"slices"          // Package for sorting the directory
+	"strings"         // Package for string manipulation
+)
+
+// Format values, as in the openZIM specification
+const (
+	magic          = 72173914 // Header magic number
+	majorVersion   = 5        // Major version: clusters with 32-bit blob offsets
+	minorVersion   = 0        // Minor version: the namespaces A (articles) and M (metadata)
+	headerLen      = 80       // Header size, where the MIME type list starts
+	noPage         = 0xFFFFFFFF
+	compressionXZ  = 4       // Cluster compression byte: xz
+	clusterMaxSize = 1 << 20 // Uncompressed bytes at which a cluster is closed
+)
+
+// mimeTypes is the MIME type list; directory entries index into it
+var mimeTypes = []string{"text/html", "text/plain"}
+
+// Indexes of mimeTypes
+const (
+	mimeHTML = 0
+	mimeText = 1
+)
+
+// languages maps wiki language codes to the ISO 639-3 codes ZIM's Language
+// metadata wants
+var languages = map[string]string{
+	"ar": "ara", "bg": "bul", "ca": "cat", "cs": "ces", "da": "dan", "de": "deu", "el": "ell", "en": "eng",
+	"eo": "epo", "es": "spa", "et": "est", "eu": "eus", "fa": "fas", "fi": "fin", "fr": "fra", "he": "heb",
+	"hi": "hin", "hr": "hrv", "hu": "hun", "hy": "hye", "id": "ind", "it": "ita", "ja": "jpn", "ka": "kat",
+	"ko": "kor", "la": "lat", "lt": "lit", "ms": "msa", "nl": "nld", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "ron", "ru": "rus", "simple": "eng", "sk": "slk", "sl": "slv", "sr": "srp", "sv": "swe",
+	"ta": "tam", "th": "tha", "tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie", "zh": "zho",
+}
+
+// LanguageCode returns the ISO 639-3 code of a wiki language code for the
+// Language metadata, e.g. "eng" for "simple"; other codes are returned as
+// they are
+func LanguageCode(lang string) string {
+	if code := languages[lang]; code != "" {
+		return code
+	}
+	return lang
+}
+
+// entry is one directory entry, pointing at its blob
+type entry struct {
+	ns      byte   // Namespace, 'A' or 'M'
+	path    string // URL within the namespace
+	title   string // Title, or "" for the path
+	mime    uint16 // Index into mimeTypes
+	cluster uint32 // Cluster of the content
+	blob    uint32 // Blob within the cluster
+}
+
+// sortTitle is the title an entry is listed under
+func (e *entry) sortTitle() string {
+	if e.title == "" {
+		return e.path
+	}
+	return e.title
+}
+
+// Writer builds a ZIM archive. Blobs are packed into xz clusters that go to
+// a scratch file as they fill; Close writes the header, directory and
+// pointer lists, which need every entry, and then copies the clusters after
+// them. Only the directory is held in memory, a path and title per entry.
+type Writer struct {
+	w          io.Writer          // Destination stream
+	scratch    io.ReadWriteSeeker // Finished clusters
+	sum        hash.Hash          // Checksum of the clusters, for the UUID
+	clusters   []int64            // Offset of each cluster in the scratch file
+	size       int64              // Bytes in the scratch file
+	blobs      bytes.Buffer       // Blobs of the open cluster
+	ends       []uint32           // End of each blob in blobs
+	entries    []entry            // Directory, in writing order
+	duplicates int                // Entries left out by Close
+}
+
+// NewWriter returns a Writer of an archive to w. Finished clusters wait in
+// scratch, which must be empty and which the caller closes and removes.
+func NewWriter(w io.Writer, scratch io.ReadWriteSeeker) *Writer {
+	return &Writer{w: w, scratch: scratch, sum: md5.New()}
+}
+
+// AddArticle adds the HTML page A/path, listed under title
+func (z *Writer) AddArticle(path, title string, html []byte) error {
+	return z.add(entry{ns: 'A', path: path, title: title, mime: mimeHTML}, html)
+}
+
+// AddMetadata adds the text/plain entry M/name, such as Title, Language
+// (see LanguageCode), Date, Name, Creator, Publisher or Description
+func (z *Writer) AddMetadata(name, value string) error {
+	return z.add(entry{ns: 'M', path: name, mime: mimeText}, []byte(value))
+}
+
+// Duplicates is how many entries Close left out because an earlier entry
+// has their namespace and path
+func (z *Writer) Duplicates() int {
+	return z.duplicates
+}
+
+// add records an entry and puts its content in the open cluster
+func (z *Writer) add(e entry, content []byte) error {
+	e.cluster, e.blob = uint32(len(z.clusters)), uint32(len(z.ends))
+	z.entries = append(z.entries, e)
+	z.blobs.Write(content)
+	z.ends = append(z.ends, uint32(z.blobs.Len()))
+	if z.blobs.Len() >= clusterMaxSize {
+		return z.flushCluster()
+	}
+	return nil
+}
+
+// flushCluster compresses the open cluster into the scratch file. A cluster
+// is its compression byte and then, compressed, the offsets of its blobs
+// from the start of that offset list, one more than there are blobs, and
+// the blobs themselves.
+func (z *Writer) flushCluster() error {
+	if len(z.ends) == 0 {
+		return nil
+	}
+	start := uint32(4 * (len(z.ends) + 1))
+	raw := binary.LittleEndian.AppendUint32(nil, start)
+	for _, end := range z.ends {
+		raw = binary.LittleEndian.AppendUint32(raw, start+end)
+	}
+	raw = append(raw, z.blobs.Bytes()...)
+	cluster := append([]byte{compressionXZ}, xzCompress(raw)...)
+	if _, err := z.scratch.Write(cluster); err != nil {
+		return fmt.Errorf("failed to write ZIM cluster: %w", err)
+	}
+	z.sum.Write(cluster)
+	z.clusters = append(z.clusters, z.size)
+	z.size += int64(len(cluster))
+	z.blobs.Reset()
+	z.ends = z.ends[:0]
+	return nil
+}
+
+// Close writes out the archive; nothing can be added after it
+func (z *Writer) Close() error {
+	if err := z.flushCluster(); err != nil {
+		return err
+	}
+
+	// 1. Sort the directory by namespace and URL, as readers search it
+	slices.SortStableFunc(z.entries, func(a, b entry) int {
+		if a.ns != b.ns {
+			return int(a.ns) - int(b.ns)
+		}
+		return strings.Compare(a.path, b.path)
+	})
+	z.entries = slices.CompactFunc(z.entries, func(a, b entry) bool {
+		if a.ns == b.ns && a.path == b.path {
+			z.duplicates++
+			return true
+		}
+		return false
+	})
+	byTitle := make([]uint32, len(z.entries))
+	for i := range byTitle {
+		byTitle[i] = uint32(i)
+	}
+	slices.SortStableFunc(byTitle, func(a, b uint32) int {
+		ea, eb := &z.entries[a], &z.entries[b]
+		if ea.ns != eb.ns {
+			return int(ea.ns) - int(eb.ns)
+		}
+		return strings.Compare(ea.sortTitle(), eb.sortTitle())
+	})
+
+	// 2. Lay the file out: header, MIME types, URL and title pointer lists,
+	// directory entries, cluster pointers, clusters and the checksum
+	var mimes []byte
+	for _, m := range mimeTypes {
+		mimes = append(append(mimes, m...), 0)
+	}
+	mimes = append(mimes, 0)
+	urlPtrPos := int64(headerLen + len(mimes))
+	titlePtrPos := urlPtrPos + 8*int64(len(z.entries))
+	dirents := titlePtrPos + 4*int64(len(z.entries))
+	var dir []byte
+	urlPtrs := make([]byte, 0, 8*len(z.entries))
+	for _, e := range z.entries {
+		urlPtrs = binary.LittleEndian.AppendUint64(urlPtrs, uint64(dirents+int64(len(dir))))
+		dir = binary.LittleEndian.AppendUint16(dir, e.mime)
+		dir = append(dir, 0, e.ns)                     // No parameter
+		dir = binary.LittleEndian.AppendUint32(dir, 0) // Revision
+		dir = binary.LittleEndian.AppendUint32(dir, e.cluster)
+		dir = binary.LittleEndian.AppendUint32(dir, e.blob)
+		dir = append(append(dir, e.path...), 0)
+		dir = append(append(dir, e.title...), 0)
+	}
+	titlePtrs := make([]byte, 0, 4*len(byTitle))
+	for _, i := range byTitle {
+		titlePtrs = binary.LittleEndian.AppendUint32(titlePtrs, i)
+	}
+	clusterPtrPos := dirents + int64(len(dir))
+	clustersPos := clusterPtrPos + 8*int64(len(z.clusters))
+	clusterPtrs := make([]byte, 0, 8*len(z.clusters))
+	for _, off := range z.clusters {
+		clusterPtrs = binary.LittleEndian.AppendUint64(clusterPtrs, uint64(clustersPos+off))
+	}
+
+	// The UUID is taken from the content, so the same entries give the same archive
+	uuid := z.sum.Sum(nil)
+	uuid[6] = uuid[6]&0x0F | 0x30
+	uuid[8] = uuid[8]&0x3F | 0x80
+	header := binary.LittleEndian.AppendUint32(nil, magic)
+	header = binary.LittleEndian.AppendUint16(header, majorVersion)
+	header = binary.LittleEndian.AppendUint16(header, minorVersion)
+	header = append(header, uuid...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(z.entries)))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(z.clusters)))
+	header = binary.LittleEndian.AppendUint64(header, uint64(urlPtrPos))
+	header = binary.LittleEndian.AppendUint64(header, uint64(titlePtrPos))
+	header = binary.LittleEndian.AppendUint64(header, uint64(clusterPtrPos))
+	header = binary.LittleEndian.AppendUint64(header, headerLen) // MIME type list
+	header = binary.LittleEndian.AppendUint32(header, noPage)    // Main page
+	header = binary.LittleEndian.AppendUint32(header, noPage)    // Layout page
+	header = binary.LittleEndian.AppendUint64(header, uint64(clustersPos+z.size))
+
+	// 3. Write it all, the checksum being the MD5 of everything before it
+	sum := md5.New()
+	out := io.MultiWriter(z.w, sum)
+	for _, part := range [][]byte{header, mimes, urlPtrs, titlePtrs, dir, clusterPtrs} {
+		if _, err := out.Write(part); err != nil {
+			return err
+		}
+	}
+	if _, err := z.scratch.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, bufio.NewReader(z.scratch)); err != nil {
+		return fmt.Errorf("failed to copy ZIM clusters: %w", err)
+	}
+	_, err := z.w.Write(sum.Sum(nil))
+	return err
+}
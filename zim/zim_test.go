@@ -0,0 +1,285 @@
+package zim
+
+import (
+	"bytes"           // Package for in-memory buffers
+	"crypto/md5"      // Package for the checksum
+	"encoding/binary" // Package for little-endian decoding
+	"fmt"             // Package for formatted I/O
+	"io"              // Package for I/O primitives
+	"math/rand/v2"    // Package for incompressible pages
+	"os"              // Package for the scratch file
+	"slices"          // Package for order checks
+	"strings"         // Package for string manipulation
+	"testing"         // Package for tests
+
+	"github.com/ulikunitz/xz" // Package for an xz decoder independent of ours
+)
+
+// archive is a ZIM file as parsed back by readArchive
+type archive struct {
+	uuid     []byte           // Header UUID
+	mimes    []string         // MIME type list
+	entries  []dirent         // Directory, in URL pointer order
+	byTitle  []dirent         // Directory, in title pointer order
+	clusters [][][]byte       // Blobs of each cluster
+	content  map[string]entry // Entry by namespace and path, e.g. "A/Foo"
+}
+
+// dirent is a parsed directory entry
+type dirent struct {
+	entry
+	revision uint32 // Always 0
+}
+
+// readArchive parses a ZIM file the way a reader does, checking its layout
+// against the specification as it goes
+func readArchive(t *testing.T, data []byte) *archive {
+	t.Helper()
+	le := binary.LittleEndian
+	if len(data) < headerLen+16 {
+		t.Fatalf("archive of %d bytes is shorter than a header and checksum", len(data))
+	}
+	if got := le.Uint32(data); got != magic {
+		t.Fatalf("magic %d, want %d", got, magic)
+	}
+	if major, minor := le.Uint16(data[4:]), le.Uint16(data[6:]); major != 5 || minor != 0 {
+		t.Fatalf("version %d.%d, want 5.0", major, minor)
+	}
+	a := &archive{uuid: data[8:24], content: map[string]entry{}}
+	entryCount, clusterCount := le.Uint32(data[24:]), le.Uint32(data[28:])
+	urlPtrPos, titlePtrPos, clusterPtrPos := le.Uint64(data[32:]), le.Uint64(data[40:]), le.Uint64(data[48:])
+	mimePos, mainPage, layoutPage, checksumPos := le.Uint64(data[56:]), le.Uint32(data[64:]), le.Uint32(data[68:]), le.Uint64(data[72:])
+	if mimePos != headerLen || mainPage != noPage || layoutPage != noPage {
+		t.Fatalf("MIME list at %d, main page %#x, layout page %#x", mimePos, mainPage, layoutPage)
+	}
+	if checksumPos != uint64(len(data)-16) {
+		t.Fatalf("checksum at %d in a file of %d bytes", checksumPos, len(data))
+	}
+	if sum := md5.Sum(data[:checksumPos]); !bytes.Equal(sum[:], data[checksumPos:]) {
+		t.Fatal("the MD5 checksum does not match")
+	}
+
+	// MIME types: zero-terminated strings, ended by an empty one
+	list, _, ok := bytes.Cut(data[mimePos:urlPtrPos], []byte{0, 0})
+	if !ok || int(mimePos)+len(list)+2 != int(urlPtrPos) {
+		t.Fatal("the MIME type list does not end in an empty string right before the URL pointers")
+	}
+	a.mimes = strings.Split(string(list), "\x00")
+
+	// Clusters, each up to the next one or the checksum
+	bounds := make([]uint64, 0, clusterCount+1)
+	for i := range clusterCount {
+		bounds = append(bounds, le.Uint64(data[clusterPtrPos+8*uint64(i):]))
+	}
+	bounds = append(bounds, checksumPos)
+	for i := range clusterCount {
+		raw := data[bounds[i]:bounds[i+1]]
+		if raw[0] != compressionXZ {
+			t.Fatalf("cluster %d: compression %d, want %d", i, raw[0], compressionXZ)
+		}
+		r, err := xz.NewReader(bytes.NewReader(raw[1:]))
+		if err != nil {
+			t.Fatalf("cluster %d: %v", i, err)
+		}
+		plain, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("cluster %d: %v", i, err)
+		}
+		first := le.Uint32(plain)
+		var blobs [][]byte
+		for off := uint32(0); off+4 < first; off += 4 {
+			start, end := le.Uint32(plain[off:]), le.Uint32(plain[off+4:])
+			if start > end || int(end) > len(plain) {
+				t.Fatalf("cluster %d: blob %d spans %d-%d of %d bytes", i, off/4, start, end, len(plain))
+			}
+			blobs = append(blobs, plain[start:end])
+		}
+		if last := le.Uint32(plain[first-4:]); int(last) != len(plain) {
+			t.Fatalf("cluster %d: the last offset is %d, not its size %d", i, last, len(plain))
+		}
+		a.clusters = append(a.clusters, blobs)
+	}
+
+	// Directory entries, through the URL pointer list
+	for i := range entryCount {
+		ptr := le.Uint64(data[urlPtrPos+8*uint64(i):])
+		d := dirent{entry: entry{mime: le.Uint16(data[ptr:]), ns: data[ptr+3]}}
+		if param := data[ptr+2]; param != 0 {
+			t.Fatalf("entry %d: parameter length %d", i, param)
+		}
+		d.revision, d.cluster, d.blob = le.Uint32(data[ptr+4:]), le.Uint32(data[ptr+8:]), le.Uint32(data[ptr+12:])
+		fields := bytes.SplitN(data[ptr+16:], []byte{0}, 3)
+		d.path, d.title = string(fields[0]), string(fields[1])
+		if int(d.mime) >= len(a.mimes) || d.revision != 0 {
+			t.Fatalf("entry %d: MIME type %d of %d, revision %d", i, d.mime, len(a.mimes), d.revision)
+		}
+		if int(d.cluster) >= len(a.clusters) || int(d.blob) >= len(a.clusters[d.cluster]) {
+			t.Fatalf("entry %s: blob %d/%d does not exist", d.path, d.cluster, d.blob)
+		}
+		a.entries = append(a.entries, d)
+		a.content[string(d.ns)+"/"+d.path] = d.entry
+	}
+	for i := range entryCount {
+		idx := le.Uint32(data[titlePtrPos+4*uint64(i):])
+		if idx >= entryCount {
+			t.Fatalf("title pointer %d names entry %d of %d", i, idx, entryCount)
+		}
+		a.byTitle = append(a.byTitle, a.entries[idx])
+	}
+	return a
+}
+
+// blob returns the content of an entry
+func (a *archive) blob(e entry) []byte {
+	return a.clusters[e.cluster][e.blob]
+}
+
+// writeArchive builds an archive from fn's entries and returns its bytes
+func writeArchive(t *testing.T, fn func(z *Writer)) ([]byte, *Writer) {
+	t.Helper()
+	scratch, err := os.CreateTemp(t.TempDir(), "clusters-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scratch.Close()
+	var out bytes.Buffer
+	z := NewWriter(&out, scratch)
+	fn(z)
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), z
+}
+
+// TestWriterRoundTrip writes pages and metadata, enough of them to fill
+// several clusters, and reads every entry back through both pointer lists
+func TestWriterRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	pages := map[string]string{} // Path to content
+	titles := map[string]string{}
+	var order []string
+	for i := range 300 {
+		// Incompressible bytes, so that the 1 MiB clusters fill up
+		page := make([]byte, 5000)
+		for j := range page {
+			page[j] = byte(rng.Uint32())
+		}
+		title := fmt.Sprintf("Page %03d", 299-i)
+		pages[strings.ReplaceAll(title, " ", "_")], titles[strings.ReplaceAll(title, " ", "_")] = string(page), title
+		order = append(order, strings.ReplaceAll(title, " ", "_"))
+	}
+	// Paths and titles sort differently: "Zebra" comes last by path, first by title
+	pages["Zebra"], titles["Zebra"] = "<p>zebra</p>", "Aardvark (as a zebra)"
+	pages["Café_Ñ"], titles["Café_Ñ"] = "<p>unicode</p>", "Café Ñ"
+	order = append(order, "Zebra", "Café_Ñ")
+	meta := [][2]string{{"Title", "test"}, {"Language", "eng"}, {"Date", "2026-10-14"}}
+
+	data, _ := writeArchive(t, func(z *Writer) {
+		for _, path := range order {
+			if err := z.AddArticle(path, titles[path], []byte(pages[path])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, m := range meta {
+			if err := z.AddMetadata(m[0], m[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	a := readArchive(t, data)
+
+	if !slices.Equal(a.mimes, mimeTypes) {
+		t.Errorf("MIME types %q, want %q", a.mimes, mimeTypes)
+	}
+	if len(a.clusters) < 2 {
+		t.Errorf("%d clusters for 1.5 MB of pages, want several", len(a.clusters))
+	}
+	if want := len(pages) + len(meta); len(a.entries) != want {
+		t.Fatalf("%d entries, want %d", len(a.entries), want)
+	}
+	if a.uuid[6]>>4 != 3 || a.uuid[8]>>6 != 2 {
+		t.Errorf("UUID %x is not a version 3, RFC 4122 variant UUID", a.uuid)
+	}
+	urlKey := func(d dirent) string { return string(d.ns) + "/" + d.path }
+	titleKey := func(d dirent) string { return string(d.ns) + "/" + d.sortTitle() }
+	if !slices.IsSortedFunc(a.entries, func(x, y dirent) int { return strings.Compare(urlKey(x), urlKey(y)) }) {
+		t.Error("the URL pointer list is not in namespace and path order")
+	}
+	if !slices.IsSortedFunc(a.byTitle, func(x, y dirent) int { return strings.Compare(titleKey(x), titleKey(y)) }) {
+		t.Error("the title pointer list is not in namespace and title order")
+	}
+	if first := a.byTitle[0]; first.path != "Zebra" {
+		t.Errorf("the title pointer list starts with %q, want Zebra, titled Aardvark", first.path)
+	}
+	for path, content := range pages {
+		e, ok := a.content["A/"+path]
+		switch {
+		case !ok:
+			t.Errorf("no entry A/%s", path)
+		case e.mime != mimeHTML || e.title != titles[path]:
+			t.Errorf("A/%s: MIME type %d, title %q; want %d, %q", path, e.mime, e.title, mimeHTML, titles[path])
+		case string(a.blob(e)) != content:
+			t.Errorf("A/%s: content differs (%d bytes, want %d)", path, len(a.blob(e)), len(content))
+		}
+	}
+	for _, m := range meta {
+		e, ok := a.content["M/"+m[0]]
+		if !ok || e.mime != mimeText || string(a.blob(e)) != m[1] {
+			t.Errorf("M/%s is %q, want %q as text/plain", m[0], a.blob(e), m[1])
+		}
+	}
+}
+
+// TestWriterDuplicates checks that an entry whose path an earlier one has is
+// left out, so readers that binary-search the URL list find one entry
+func TestWriterDuplicates(t *testing.T) {
+	data, z := writeArchive(t, func(z *Writer) {
+		z.AddArticle("Foo", "Foo", []byte("first"))
+		z.AddArticle("Bar", "Bar", []byte("bar"))
+		z.AddArticle("Foo", "Foo (again)", []byte("second"))
+		z.AddMetadata("Foo", "metadata, not a duplicate")
+	})
+	a := readArchive(t, data)
+	if z.Duplicates() != 1 || len(a.entries) != 3 {
+		t.Fatalf("%d duplicates and %d entries, want 1 and 3", z.Duplicates(), len(a.entries))
+	}
+	if got := string(a.blob(a.content["A/Foo"])); got != "first" {
+		t.Errorf("A/Foo holds %q, want the first one", got)
+	}
+}
+
+// TestWriterDeterministic checks that the same entries give the same bytes,
+// the UUID included, and that other entries give another UUID
+func TestWriterDeterministic(t *testing.T) {
+	build := func(content string) []byte {
+		data, _ := writeArchive(t, func(z *Writer) {
+			z.AddArticle("Foo", "Foo", []byte(content))
+			z.AddMetadata("Title", "test")
+		})
+		return data
+	}
+	first, again, other := build("foo"), build("foo"), build("bar")
+	if !bytes.Equal(first, again) {
+		t.Error("the same entries give different archives")
+	}
+	if bytes.Equal(readArchive(t, first).uuid, readArchive(t, other).uuid) {
+		t.Error("different entries give the same UUID")
+	}
+}
+
+// TestWriterEmpty checks that an archive without entries is still well formed
+func TestWriterEmpty(t *testing.T) {
+	data, _ := writeArchive(t, func(*Writer) {})
+	if a := readArchive(t, data); len(a.entries) != 0 || len(a.clusters) != 0 {
+		t.Errorf("%d entries and %d clusters, want none", len(a.entries), len(a.clusters))
+	}
+}
+
+// TestLanguageCode checks the ISO 639-3 mapping for the Language metadata
+func TestLanguageCode(t *testing.T) {
+	for lang, want := range map[string]string{"en": "eng", "simple": "eng", "de": "deu", "xyz": "xyz"} {
+		if got := LanguageCode(lang); got != want {
+			t.Errorf("LanguageCode(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}